@@ -15,6 +15,31 @@ const (
 	// WrongNumberOfArgsFmt is a string that holds the normalized error message
 	// for when the number of arguments of a command is invalid.
 	WrongNumberOfArgsFmt = "ERR wrong number of arguments for '%s' command"
+
+	// InvalidExpireTimeFmt is a string that holds the normalized error message
+	// for when the expiration time of a command is invalid.
+	InvalidExpireTimeFmt = "ERR invalid expire time in '%s' command"
+
+	// NoGroupFmt is a string that holds the normalized error message for
+	// when the stream key or its consumer group does not exist.
+	NoGroupFmt = "NOGROUP No such key '%s' or consumer group '%s'"
+
+	// UnbalancedStreamsFmt is a string that holds the normalized error
+	// message for when the number of stream keys and IDs of a read command
+	// differ.
+	UnbalancedStreamsFmt = "ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified."
+
+	// UnknownClientTypeFmt is a string that holds the normalized error
+	// message for when the client type of a CLIENT subcommand is invalid.
+	UnknownClientTypeFmt = "ERR Unknown client type '%s'"
+
+	// UnknownConfigFmt is a string that holds the normalized error message
+	// for when the parameter of CONFIG SET does not exist.
+	UnknownConfigFmt = "ERR Unknown option or number of arguments for CONFIG SET - '%s'"
+
+	// ConfigSetFailedFmt is a string that holds the normalized error message
+	// for when the parameter of CONFIG SET cannot be set to the value.
+	ConfigSetFailedFmt = "ERR CONFIG SET failed (possibly related to argument '%s') - %s"
 )
 
 var (
@@ -25,6 +50,9 @@ var (
 	// response should be returned.
 	OKVal = resp.OK{}
 
+	// QueuedVal is the value returned when a command is queued in a transaction.
+	QueuedVal = resp.SimpleString("QUEUED")
+
 	// ErrNotInteger is returned when an argument that is expected to be an integer
 	// cannot be parsed as an integer.
 	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
@@ -39,6 +67,21 @@ var (
 	// ErrSyntax is returned when an argument doesn't have the expected allowed syntax.
 	ErrSyntax = errors.New("ERR syntax error")
 
+	// ErrInvalidCursor is returned when the cursor of a SCAN command is not
+	// a valid unsigned integer.
+	ErrInvalidCursor = errors.New("ERR invalid cursor")
+
+	// ErrSetExpireTime is returned when the expiration time of the SET command
+	// is not positive or too large.
+	ErrSetExpireTime = fmt.Errorf(InvalidExpireTimeFmt, "set")
+
+	// ErrGetExExpireTime is returned when the expiration time of the GETEX
+	// command is not positive or too large.
+	ErrGetExExpireTime = fmt.Errorf(InvalidExpireTimeFmt, "getex")
+
+	// ErrNotPositive is returned when a count must not be negative.
+	ErrNotPositive = errors.New("ERR value is out of range, must be positive")
+
 	// ErrNoSuchKey is returned when a command is attempted against a non-existing key.
 	ErrNoSuchKey = errors.New("ERR no such key")
 
@@ -48,6 +91,88 @@ var (
 	// ErrOfsOutOfRange is returned when an offset argument is out of range.
 	ErrOfsOutOfRange = errors.New("ERR offset is out of range")
 
+	// ErrBitOffset is returned when a bit offset argument is not an integer
+	// or is out of range.
+	ErrBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+	// ErrBitValue is returned when a bit argument is not 0 or 1.
+	ErrBitValue = errors.New("ERR bit is not an integer or out of range")
+
+	// ErrBitPosBit is returned when the bit argument of BITPOS is not 0 or 1.
+	ErrBitPosBit = errors.New("ERR The bit argument must be 1 or 0.")
+
+	// ErrBitOpNot is returned when BITOP NOT is called with more than one
+	// source key.
+	ErrBitOpNot = errors.New("ERR BITOP NOT must be called with a single source key.")
+
+	// ErrBitfieldType is returned when the type of a BITFIELD operation is
+	// invalid.
+	ErrBitfieldType = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+
+	// ErrOverflowType is returned when the OVERFLOW option of BITFIELD is
+	// invalid.
+	ErrOverflowType = errors.New("ERR Invalid OVERFLOW type specified")
+
+	// ErrNotHLL is returned when a key holds a string value that is not
+	// a HyperLogLog.
+	ErrNotHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+	// ErrStreamID is returned when a stream ID argument is invalid.
+	ErrStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+	// ErrXAddIDSmall is returned when the ID of a new stream entry is not
+	// greater than the last ID of the stream.
+	ErrXAddIDSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+
+	// ErrXAddIDZero is returned when the ID of a new stream entry is 0-0.
+	ErrXAddIDZero = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+
+	// ErrXSetIDSmall is returned when the last ID set by XSETID is smaller
+	// than the ID of the last entry of the stream.
+	ErrXSetIDSmall = errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+
+	// ErrTrimLimit is returned when the LIMIT option of a stream trimming
+	// is used without the ~ option.
+	ErrTrimLimit = errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+
+	// ErrBusyGroup is returned when a consumer group is created with the
+	// name of an existing group.
+	ErrBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+
+	// ErrXGroupNoKey is returned when XGROUP is called on a non-existing key.
+	ErrXGroupNoKey = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+	// ErrXReadGroupID is returned when the $ ID is used with XREADGROUP.
+	ErrXReadGroupID = errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+
+	// ErrXReadID is returned when the > ID is used with XREAD.
+	ErrXReadID = errors.New("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+
+	// ErrTimeoutNegative is returned when the timeout of a blocking command
+	// is negative.
+	ErrTimeoutNegative = errors.New("ERR timeout is negative")
+
+	// ErrTimeoutNotFloat is returned when the timeout of a blocking command
+	// is not a finite float, or is too large to be represented.
+	ErrTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+
+	// ErrRankZero is returned when the RANK option of LPOS is 0.
+	ErrRankZero = errors.New("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+
+	// ErrCountNegative is returned when the COUNT option of LPOS is negative.
+	ErrCountNegative = errors.New("ERR COUNT can't be negative")
+
+	// ErrMaxLenNegative is returned when the MAXLEN option of LPOS is negative.
+	ErrMaxLenNegative = errors.New("ERR MAXLEN can't be negative")
+
+	// ErrNumKeys is returned when the number of keys of a command is not
+	// positive.
+	ErrNumKeys = errors.New("ERR numkeys should be greater than 0")
+
+	// ErrCountZero is returned when the COUNT option of a pop command is
+	// not positive.
+	ErrCountZero = errors.New("ERR count should be greater than 0")
+
 	// ErrHashFieldNotInt is returned when an increment operation is attempted on
 	// a hash field that does not contain an integer value.
 	ErrHashFieldNotInt = errors.New("ERR hash value is not an integer")
@@ -56,13 +181,94 @@ var (
 	// a hash field that does not contain a float value.
 	ErrHashFieldNotFloat = errors.New("ERR hash value is not a valid float")
 
+	// ErrMinMaxNotFloat is returned when a score range bound is not a valid float.
+	ErrMinMaxNotFloat = errors.New("ERR min or max is not a float")
+
+	// ErrScoreNaN is returned when an increment operation on a sorted set
+	// member would result in a score that is not a number.
+	ErrScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+
+	// ErrXXAndNX is returned when both the XX and NX options are set.
+	ErrXXAndNX = errors.New("ERR XX and NX options at the same time are not compatible")
+
+	// ErrGTLTAndNX is returned when more than one of the GT, LT and NX options are set.
+	ErrGTLTAndNX = errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+
+	// ErrIncrPair is returned when ZADD is called with the INCR option and more
+	// than one score-member pair.
+	ErrIncrPair = errors.New("ERR INCR option supports a single increment-element pair")
+
 	// ErrQuit is a sentinel error value to indicate that the network connection
 	// should be closed, as requested by the client.
 	ErrQuit = errors.New("quit")
 
+	// ErrClientName is returned when the name set by CLIENT SETNAME contains
+	// spaces or special characters.
+	ErrClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+
+	// ErrInvalidClientID is returned when an ID of CLIENT LIST is invalid.
+	ErrInvalidClientID = errors.New("ERR Invalid client ID")
+
+	// ErrClientIDZero is returned when the ID of CLIENT KILL is not positive.
+	ErrClientIDZero = errors.New("ERR client-id should be greater than 0")
+
+	// ErrNoSuchClient is returned when the client to kill does not exist.
+	ErrNoSuchClient = errors.New("ERR No such client")
+
+	// ErrTimeoutNotInt is returned when the timeout of CLIENT PAUSE is not
+	// an integer.
+	ErrTimeoutNotInt = errors.New("ERR timeout is not an integer or out of range")
+
+	// ErrMaxClients is returned to a connection that is refused because the
+	// maximum number of clients is reached.
+	ErrMaxClients = errors.New("ERR max number of clients reached")
+
+	// ErrNoConfigFile is returned when CONFIG REWRITE is called while the
+	// server was started without a configuration file.
+	ErrNoConfigFile = errors.New("ERR The server is running without a config file")
+
 	// ErrInvalidDBIndex is returned when a DB index outside the bounds of
 	// available DBs is requested.
 	ErrInvalidDBIndex = errors.New("ERR invalid DB index")
+
+	// ErrSameObject is returned when the source and the destination of a
+	// command that moves or copies a key are the same.
+	ErrSameObject = errors.New("ERR source and destination objects are the same")
+
+	// ErrNestedMulti is returned when MULTI is called inside a transaction.
+	ErrNestedMulti = errors.New("ERR MULTI calls can not be nested")
+
+	// ErrExecWithoutMulti is returned when EXEC is called outside a transaction.
+	ErrExecWithoutMulti = errors.New("ERR EXEC without MULTI")
+
+	// ErrDiscardWithoutMulti is returned when DISCARD is called outside a transaction.
+	ErrDiscardWithoutMulti = errors.New("ERR DISCARD without MULTI")
+
+	// ErrWatchInMulti is returned when WATCH is called inside a transaction.
+	ErrWatchInMulti = errors.New("ERR WATCH inside MULTI is not allowed")
+
+	// ErrExecAbort is returned when EXEC is called on a transaction in which
+	// a command could not be queued.
+	ErrExecAbort = errors.New("EXECABORT Transaction discarded because of previous errors.")
+
+	// ErrSubscribedMode is returned when a command other than the allowed ones
+	// is called while the connection is subscribed to channels or patterns.
+	ErrSubscribedMode = errors.New("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+
+	// ErrSaveInProgress is returned when a save is requested while a
+	// background save is in progress.
+	ErrSaveInProgress = errors.New("ERR Background save already in progress")
+
+	// BGSaveStartedVal is the value returned when a background save is started.
+	BGSaveStartedVal = resp.SimpleString("Background saving started")
+
+	// ErrRewriteInProgress is returned when a rewrite of the append-only file
+	// is requested while one is in progress.
+	ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+	// BGRewriteAOFStartedVal is the value returned when a background rewrite
+	// of the append-only file is started.
+	BGRewriteAOFStartedVal = resp.SimpleString("Background append only file rewriting started")
 )
 
 // Commands holds the list of registered commands.
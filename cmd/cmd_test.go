@@ -3,6 +3,10 @@ package cmd
 import (
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
 )
 
 func TestArgDefParse(t *testing.T) {
@@ -106,3 +110,43 @@ func TestArgDefParse(t *testing.T) {
 		}
 	}
 }
+
+func TestLockKeys(t *testing.T) {
+	db := srv.NewDB(0)
+	for _, nm := range []string{"a", "b", "c"} {
+		db.Keys().Set(srv.NewKey(nm, types.NewString(nm)))
+	}
+
+	keys, unl := LockKeys(db, false, "c", "x", "a", "c")
+	var names []string
+	for _, k := range keys {
+		if k == nil {
+			names = append(names, "")
+			continue
+		}
+		names = append(names, k.Name())
+	}
+	if exp := []string{"c", "", "a", "c"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("expected keys %v, got %v", exp, names)
+	}
+	unl()
+
+	// Concurrent calls with the names in any order do not deadlock
+	done := make(chan bool)
+	for _, names := range [][]string{{"a", "b", "c"}, {"c", "b", "a"}, {"b", "b", "a"}} {
+		go func(names []string) {
+			for i := 0; i < 1000; i++ {
+				_, unl := LockKeys(db, i%2 == 0, names...)
+				unl()
+			}
+			done <- true
+		}(names)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlock")
+		}
+	}
+}
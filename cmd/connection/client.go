@@ -0,0 +1,230 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// clientArity is the number of arguments of the CLIENT subcommands that
+// have a fixed number of arguments, including the subcommand.
+var clientArity = map[string]int{
+	"getname": 1,
+	"id":      1,
+	"setname": 2,
+	"unpause": 1,
+}
+
+var client = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	clientFn)
+
+func clientFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sub := strings.ToLower(args[0])
+	if n, ok := clientArity[sub]; ok && len(args) != n {
+		return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "client|"+sub)
+	}
+
+	switch sub {
+	case "getname":
+		if name := conn.Name(); name != "" {
+			return name, nil
+		}
+		return nil, nil
+
+	case "id":
+		return conn.ID(), nil
+
+	case "kill":
+		return clientKill(conn, args[1:])
+
+	case "list":
+		return clientList(args[1:])
+
+	case "pause":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "client|pause")
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, cmd.ErrTimeoutNotInt
+		}
+		if ms < 0 {
+			return nil, cmd.ErrTimeoutNegative
+		}
+		var write bool
+		if len(args) == 3 {
+			switch strings.ToLower(args[2]) {
+			case "all":
+			case "write":
+				write = true
+			default:
+				return nil, cmd.ErrSyntax
+			}
+		}
+		srv.DefaultClients.Pause(time.Duration(ms)*time.Millisecond, write)
+		return cmd.OKVal, nil
+
+	case "setname":
+		for i := 0; i < len(args[1]); i++ {
+			// Only printable characters, without spaces
+			if c := args[1][i]; c < '!' || c > '~' {
+				return nil, cmd.ErrClientName
+			}
+		}
+		conn.SetName(args[1])
+		return cmd.OKVal, nil
+
+	case "unpause":
+		srv.DefaultClients.Unpause()
+		return cmd.OKVal, nil
+
+	default:
+		return nil, fmt.Errorf("ERR Unknown CLIENT subcommand '%s'", args[0])
+	}
+}
+
+// clientType returns the type of the client, as filtered by the TYPE
+// option of CLIENT LIST and CLIENT KILL.
+func clientType(ci srv.ClientInfo) string {
+	if ci.PubSub() {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// validType returns an error if typ is not a valid client type.
+func validType(typ string) error {
+	switch typ {
+	case "normal", "pubsub", "master", "replica", "slave":
+		return nil
+	}
+	return fmt.Errorf(cmd.UnknownClientTypeFmt, typ)
+}
+
+// clientList returns the description of the clients, one per line,
+// filtered by the options TYPE or ID.
+func clientList(opts []string) (interface{}, error) {
+	var typ string
+	var ids map[int64]bool
+	switch {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToLower(opts[0]) == "type":
+		typ = strings.ToLower(opts[1])
+		if err := validType(typ); err != nil {
+			return nil, err
+		}
+	case len(opts) >= 2 && strings.ToLower(opts[0]) == "id":
+		ids = make(map[int64]bool, len(opts)-1)
+		for _, s := range opts[1:] {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, cmd.ErrInvalidClientID
+			}
+			ids[id] = true
+		}
+	default:
+		return nil, cmd.ErrSyntax
+	}
+
+	var buf bytes.Buffer
+	now := time.Now()
+	for _, c := range srv.DefaultClients.List() {
+		ci := c.Info()
+		if (typ != "" && clientType(ci) != typ) || (ids != nil && !ids[ci.ID]) {
+			continue
+		}
+
+		var flags string
+		if ci.PubSub() {
+			flags += "P"
+		}
+		if ci.Blocked {
+			flags += "b"
+		}
+		if ci.Multi >= 0 {
+			flags += "x"
+		}
+		if flags == "" {
+			flags = "N"
+		}
+		fmt.Fprintf(&buf, "id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d cmd=%s\n",
+			ci.ID, ci.Addr, ci.LAddr, ci.Name, int64(now.Sub(ci.Created)/time.Second),
+			int64(now.Sub(ci.LastCmd)/time.Second), flags, ci.DB, ci.Sub, ci.PSub, ci.Multi, ci.Cmd)
+	}
+	return buf.String(), nil
+}
+
+// clientKill kills the client at the address opts[0], or the clients that
+// match the filters of opts, which are pairs of filter and value.
+func clientKill(conn srv.Conn, opts []string) (interface{}, error) {
+	// Old form, with the address of the client to kill
+	if len(opts) == 1 {
+		for _, c := range srv.DefaultClients.List() {
+			if c.Info().Addr == opts[0] {
+				c.Kill()
+				return cmd.OKVal, nil
+			}
+		}
+		return nil, cmd.ErrNoSuchClient
+	}
+	if len(opts) == 0 || len(opts)%2 != 0 {
+		return nil, cmd.ErrSyntax
+	}
+
+	var id int64
+	var addr, laddr, typ string
+	skipMe := true
+	for i := 0; i < len(opts); i += 2 {
+		val := opts[i+1]
+		switch strings.ToLower(opts[i]) {
+		case "id":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, cmd.ErrClientIDZero
+			}
+			id = n
+		case "addr":
+			addr = val
+		case "laddr":
+			laddr = val
+		case "type":
+			typ = strings.ToLower(val)
+			if err := validType(typ); err != nil {
+				return nil, err
+			}
+		case "skipme":
+			switch strings.ToLower(val) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return nil, cmd.ErrSyntax
+			}
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+
+	var n int64
+	for _, c := range srv.DefaultClients.List() {
+		ci := c.Info()
+		if (id != 0 && ci.ID != id) || (addr != "" && ci.Addr != addr) ||
+			(laddr != "" && ci.LAddr != laddr) || (typ != "" && clientType(ci) != typ) ||
+			(skipMe && ci.ID == conn.ID()) {
+			continue
+		}
+		c.Kill()
+		n++
+	}
+	return n, nil
+}
@@ -6,6 +6,7 @@ import (
 )
 
 func init() {
+	cmd.Register("client", client)
 	cmd.Register("echo", echo)
 	cmd.Register("ping", ping)
 	cmd.Register("quit", quit)
@@ -48,9 +49,6 @@ var selct = cmd.NewConnCmd(
 	selctFn)
 
 func selctFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
-	srv.DefaultServer.Lock()
-	defer srv.DefaultServer.Unlock()
-
 	_, ok := srv.DefaultServer.GetDB(int(ints[0]))
 	if !ok {
 		return nil, cmd.ErrInvalidDBIndex
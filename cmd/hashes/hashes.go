@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/glob"
 	"github.com/PuerkitoBio/gred/srv"
 	"github.com/PuerkitoBio/gred/types"
 )
@@ -19,6 +20,7 @@ func init() {
 	cmd.Register("hlen", hlen)
 	cmd.Register("hmget", hmget)
 	cmd.Register("hmset", hmset)
+	cmd.Register("hscan", hscan)
 	cmd.Register("hset", hset)
 	cmd.Register("hsetnx", hsetnx)
 	cmd.Register("hvals", hvals)
@@ -47,9 +49,11 @@ func hdelFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.Hash); ok {
 		ret := v.HDel(args[1:]...)
 		if ret > 0 {
+			db.Notify(srv.NotifyHash, "hdel", args[0])
 			// Is it now an empty hash?
 			if v.HLen() == 0 {
 				db.DelKey(args[0])
+				db.Notify(srv.NotifyGeneric, "del", args[0])
 			}
 		}
 		return ret, nil
@@ -118,16 +122,18 @@ func hgetallFn(k srv.Key, args []string, ints []int64, floats []float64) (interf
 	return nil, cmd.ErrInvalidValType
 }
 
-var hincrby = cmd.NewSingleKeyCmd(
+var hincrby = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:    3,
 		MaxArgs:    3,
 		IntIndices: []int{2},
 	},
-	srv.NoKeyCreateHash,
 	hincrbyFn)
 
-func hincrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func hincrbyFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateHash)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
@@ -135,6 +141,7 @@ func hincrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interf
 	if v, ok := v.(types.IncHash); ok {
 		val, ok := v.HIncrBy(args[1], ints[0])
 		if ok {
+			db.Notify(srv.NotifyHash, "hincrby", args[0])
 			return val, nil
 		}
 		return nil, cmd.ErrHashFieldNotInt
@@ -142,16 +149,18 @@ func hincrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interf
 	return nil, cmd.ErrInvalidValType
 }
 
-var hincrbyfloat = cmd.NewSingleKeyCmd(
+var hincrbyfloat = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:      3,
 		MaxArgs:      3,
 		FloatIndices: []int{2},
 	},
-	srv.NoKeyCreateHash,
 	hincrbyfloatFn)
 
-func hincrbyfloatFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func hincrbyfloatFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateHash)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
@@ -159,6 +168,7 @@ func hincrbyfloatFn(k srv.Key, args []string, ints []int64, floats []float64) (i
 	if v, ok := v.(types.IncHash); ok {
 		val, ok := v.HIncrByFloat(args[1], floats[0])
 		if ok {
+			db.Notify(srv.NotifyHash, "hincrbyfloat", args[0])
 			return val, nil
 		}
 		return nil, cmd.ErrHashFieldNotFloat
@@ -223,7 +233,7 @@ func hmgetFn(k srv.Key, args []string, ints []int64, floats []float64) (interfac
 	return nil, cmd.ErrInvalidValType
 }
 
-var hmset = cmd.NewSingleKeyCmd(
+var hmset = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 3,
 		MaxArgs: -1,
@@ -235,55 +245,108 @@ var hmset = cmd.NewSingleKeyCmd(
 			return nil
 		},
 	},
-	srv.NoKeyCreateHash,
 	hmsetFn)
 
-func hmsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func hmsetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateHash)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.Hash); ok {
 		v.HMSet(args[1:]...)
+		db.Notify(srv.NotifyHash, "hset", args[0])
 		return cmd.OKVal, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
 
-var hset = cmd.NewSingleKeyCmd(
+var hscan = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 6,
+	},
+	srv.NoKeyDefaultVal,
+	hscanFn)
+
+func hscanFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sa, err := cmd.ParseScanArgs(args[1], args[2:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v, ok := k.Val().(types.Hash)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	// Visit at least Count fields, unless the iteration is complete, and
+	// filter them once visited, like SCAN.
+	var pairs []string
+	cursor, visited := sa.Cursor, 0
+	for maxIter := sa.Count * 10; ; maxIter-- {
+		cursor = v.HScan(cursor, func(field, val string) {
+			visited++
+			if sa.Match == "" || glob.Match(sa.Match, field) {
+				pairs = append(pairs, field, val)
+			}
+		})
+		if cursor == 0 || visited >= sa.Count || maxIter <= 0 {
+			break
+		}
+	}
+	return cmd.ScanReply(cursor, pairs), nil
+}
+
+var hset = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 3,
 		MaxArgs: 3,
 	},
-	srv.NoKeyCreateHash,
 	hsetFn)
 
-func hsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func hsetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateHash)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.Hash); ok {
-		return v.HSet(args[1], args[2]), nil
+		ret := v.HSet(args[1], args[2])
+		db.Notify(srv.NotifyHash, "hset", args[0])
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
 
-var hsetnx = cmd.NewSingleKeyCmd(
+var hsetnx = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 3,
 		MaxArgs: 3,
 	},
-	srv.NoKeyCreateHash,
 	hsetnxFn)
 
-func hsetnxFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func hsetnxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateHash)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.Hash); ok {
-		return v.HSetNx(args[1], args[2]), nil
+		ret := v.HSetNx(args[1], args[2])
+		if ret {
+			db.Notify(srv.NotifyHash, "hset", args[0])
+		}
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -0,0 +1,157 @@
+package hyperloglog
+
+import (
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func init() {
+	cmd.Register("pfadd", pfadd)
+	cmd.Register("pfcount", pfcount)
+	cmd.Register("pfmerge", pfmerge)
+}
+
+// hllVal returns the HLL stored in the string value of the key.
+func hllVal(k srv.Key) (types.String, *types.HLL, error) {
+	v, ok := k.Val().(types.String)
+	if !ok {
+		return nil, nil, cmd.ErrInvalidValType
+	}
+	h, ok := types.ParseHLL(v.Get())
+	if !ok {
+		return nil, nil, cmd.ErrNotHLL
+	}
+	return v, h, nil
+}
+
+// newHLLKey creates and adds to the database a key holding an empty HLL.
+// The database must be exclusively locked.
+func newHLLKey(db srv.DB, name string) srv.Key {
+	k := srv.NewKey(name, types.NewIncString(types.NewHLL().String()))
+	db.SetKey(k)
+	return k
+}
+
+var pfadd = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	pfaddFn)
+
+func pfaddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// The key holds an empty HLL once created, not an empty string
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	created := k == nil
+	if created {
+		k = newHLLKey(db, args[0])
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v, h, err := hllVal(k)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Add(args[1:]...) && !created {
+		return false, nil
+	}
+	v.Set(h.String())
+	db.Notify(srv.NotifyString, "pfadd", args[0])
+	return true, nil
+}
+
+var pfcount = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	pfcountFn)
+
+func pfcountFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if len(args) == 1 {
+		return pfcountKey(db, args[0])
+	}
+
+	keys, unl := cmd.LockKeys(db, false, args...)
+	defer unl()
+
+	hs := make([]*types.HLL, 0, len(keys))
+	for _, k := range keys {
+		if k == nil {
+			continue
+		}
+		_, h, err := hllVal(k)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, h)
+	}
+	h := types.NewHLL()
+	h.Merge(hs...)
+	return h.Count(), nil
+}
+
+// pfcountKey returns the cardinality of a single HLL, and caches it in
+// the value if it was not already.
+func pfcountKey(db srv.DB, name string) (interface{}, error) {
+	k, unl := db.LockGetKey(name, srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return int64(0), nil
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v, h, err := hllVal(k)
+	if err != nil {
+		return nil, err
+	}
+	s := v.Get()
+	n := h.Count()
+	if s2 := h.String(); s2 != s {
+		v.Set(s2)
+	}
+	return n, nil
+}
+
+var pfmerge = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	pfmergeFn)
+
+func pfmergeFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// The destination may also be a source key
+	keys, unl := cmd.LockKeys(db, true, args...)
+	defer unl()
+
+	hs := make([]*types.HLL, 0, len(keys))
+	for _, k := range keys {
+		if k == nil {
+			continue
+		}
+		_, h, err := hllVal(k)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, h)
+	}
+
+	dst := keys[0]
+	if dst == nil {
+		// The key is not locked by LockKeys, but the database is
+		// exclusively locked.
+		dst = newHLLKey(db, args[0])
+	}
+	h := types.NewHLL()
+	h.Merge(hs...)
+	dst.Val().(types.String).Set(h.String())
+	db.Notify(srv.NotifyString, "pfadd", args[0])
+	return cmd.OKVal, nil
+}
@@ -1,25 +1,79 @@
 package dbcmds
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/glob"
 	"github.com/PuerkitoBio/gred/srv"
 )
 
 func init() {
+	cmd.Register("copy", copyƒ)
 	cmd.Register("del", del)
 	cmd.Register("exists", exists)
 	cmd.Register("expire", expire)
 	cmd.Register("expireat", expireat)
+	cmd.Register("keys", keys)
+	cmd.Register("move", move)
 	cmd.Register("persist", persist)
 	cmd.Register("pexpire", pexpire)
 	cmd.Register("pexpireat", pexpireat)
 	cmd.Register("psetex", psetex)
 	cmd.Register("pttl", pttl)
+	cmd.Register("rename", rename)
+	cmd.Register("renamenx", renamenx)
+	cmd.Register("scan", scan)
 	cmd.Register("setex", setex)
 	cmd.Register("ttl", ttl)
 	cmd.Register("type", typeƒ)
 }
 
+var copyƒ = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 5,
+	},
+	copyFn)
+
+func copyFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ix, replace := db.Index(), false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "db":
+			if i+1 >= len(args) {
+				return nil, cmd.ErrSyntax
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			ix = n
+			i++
+		case "replace":
+			replace = true
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+	if ix == db.Index() && args[0] == args[1] {
+		return nil, cmd.ErrSameObject
+	}
+
+	dbs, unl, ok := srv.DefaultServer.LockDBs(db.Index(), ix)
+	if !ok {
+		return nil, cmd.ErrInvalidDBIndex
+	}
+	defer unl()
+
+	if !db.Copy(args[0], dbs[1], args[1], replace) {
+		return false, nil
+	}
+	dbs[1].Notify(srv.NotifyGeneric, "copy_to", args[1])
+	return true, nil
+}
+
 var del = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
@@ -31,13 +85,23 @@ func delFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{
 	db.Lock()
 	defer db.Unlock()
 
-	return db.Del(args...), nil
+	var cnt int64
+	for _, nm := range args {
+		if db.Del(nm) > 0 {
+			db.Notify(srv.NotifyGeneric, "del", nm)
+			cnt++
+		}
+	}
+	return cnt, nil
 }
 
-func delExpFn(db srv.DB, nm string) {
-	db.Lock()
-	defer db.Unlock()
-	db.Del(nm)
+// expireRet notifies the expire event if the expiration was set on the key,
+// and returns the reply of the expire commands.
+func expireRet(db srv.DB, nm string, ok bool) bool {
+	if ok {
+		db.Notify(srv.NotifyGeneric, "expire", nm)
+	}
+	return ok
 }
 
 var exists = cmd.NewDBCmd(
@@ -66,7 +130,7 @@ func expireFn(db srv.DB, args []string, ints []int64, floats []float64) (interfa
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.Expire(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return expireRet(db, args[0], db.Expire(args[0], ints[0])), nil
 }
 
 var expireat = cmd.NewDBCmd(
@@ -81,7 +145,59 @@ func expireatFn(db srv.DB, args []string, ints []int64, floats []float64) (inter
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.ExpireAt(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return expireRet(db, args[0], db.ExpireAt(args[0], ints[0])), nil
+}
+
+var keys = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	keysFn)
+
+func keysFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	names := []string{}
+	db.Keys().Range(func(k srv.Key) bool {
+		nm := k.Name()
+		if glob.Match(args[0], nm) {
+			// Skip the expired keys that are not deleted yet
+			if _, ok := db.Key(nm); ok {
+				names = append(names, nm)
+			}
+		}
+		return true
+	})
+	return names, nil
+}
+
+var move = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    2,
+		IntIndices: []int{1},
+	},
+	moveFn)
+
+func moveFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ix := int(ints[0])
+	if ix == db.Index() {
+		return nil, cmd.ErrSameObject
+	}
+	dbs, unl, ok := srv.DefaultServer.LockDBs(db.Index(), ix)
+	if !ok {
+		return nil, cmd.ErrInvalidDBIndex
+	}
+	defer unl()
+
+	if !db.Move(args[0], dbs[1]) {
+		return false, nil
+	}
+	db.Notify(srv.NotifyGeneric, "move_from", args[0])
+	dbs[1].Notify(srv.NotifyGeneric, "move_to", args[0])
+	return true, nil
 }
 
 var persist = cmd.NewDBCmd(
@@ -110,7 +226,7 @@ func pexpireFn(db srv.DB, args []string, ints []int64, floats []float64) (interf
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.PExpire(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return expireRet(db, args[0], db.PExpire(args[0], ints[0])), nil
 }
 
 var pexpireat = cmd.NewDBCmd(
@@ -125,7 +241,7 @@ func pexpireatFn(db srv.DB, args []string, ints []int64, floats []float64) (inte
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.PExpireAt(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return expireRet(db, args[0], db.PExpireAt(args[0], ints[0])), nil
 }
 
 var psetex = cmd.NewDBCmd(
@@ -138,7 +254,9 @@ var psetex = cmd.NewDBCmd(
 
 func psetexFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
 	// db locking is done inside db.PSetEx
-	db.PSetEx(args[0], ints[0], args[2], func() { delExpFn(db, args[0]) })
+	db.PSetEx(args[0], ints[0], args[2])
+	db.Notify(srv.NotifyString, "set", args[0])
+	db.Notify(srv.NotifyGeneric, "expire", args[0])
 	return cmd.OKVal, nil
 }
 
@@ -156,6 +274,104 @@ func pttlFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	return db.PTTL(args[0]), nil
 }
 
+var rename = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	renameFn)
+
+func renameFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.Rename(args[0], args[1]) {
+		return nil, cmd.ErrNoSuchKey
+	}
+	renameNotify(db, args[0], args[1])
+	return cmd.OKVal, nil
+}
+
+var renamenx = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	renamenxFn)
+
+func renamenxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.Exists(args[0]) {
+		return nil, cmd.ErrNoSuchKey
+	}
+	if db.Exists(args[1]) {
+		return false, nil
+	}
+	db.Rename(args[0], args[1])
+	renameNotify(db, args[0], args[1])
+	return true, nil
+}
+
+// renameNotify notifies the events of the rename of the key src to dst.
+func renameNotify(db srv.DB, src, dst string) {
+	db.Notify(srv.NotifyGeneric, "rename_from", src)
+	db.Notify(srv.NotifyGeneric, "rename_to", dst)
+}
+
+var scan = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 7,
+	},
+	scanFn)
+
+func scanFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sa, err := cmd.ParseScanArgs(args[0], args[1:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	db.RLock()
+	defer db.RUnlock()
+
+	// Visit at least Count keys, unless the iteration is complete. Like
+	// Redis, the options filter the keys once visited, so that a call
+	// may return fewer keys than Count, or none at all.
+	var visited []srv.Key
+	cursor := sa.Cursor
+	for maxIter := sa.Count * 10; ; maxIter-- {
+		cursor = db.Keys().Scan(cursor, func(k srv.Key) {
+			visited = append(visited, k)
+		})
+		if cursor == 0 || len(visited) >= sa.Count || maxIter <= 0 {
+			break
+		}
+	}
+
+	var names []string
+	for _, k := range visited {
+		nm := k.Name()
+		if sa.Match != "" && !glob.Match(sa.Match, nm) {
+			continue
+		}
+		if _, ok := db.Key(nm); !ok {
+			continue
+		}
+		if sa.Type != "" {
+			k.RLock()
+			typ := k.Val().Type()
+			k.RUnlock()
+			if typ != sa.Type {
+				continue
+			}
+		}
+		names = append(names, nm)
+	}
+	return cmd.ScanReply(cursor, names), nil
+}
+
 var setex = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:    3,
@@ -166,7 +382,9 @@ var setex = cmd.NewDBCmd(
 
 func setexFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
 	// db locking is done inside db.SetEx
-	db.SetEx(args[0], ints[0], args[2], func() { delExpFn(db, args[0]) })
+	db.SetEx(args[0], ints[0], args[2])
+	db.Notify(srv.NotifyString, "set", args[0])
+	db.Notify(srv.NotifyGeneric, "expire", args[0])
 	return cmd.OKVal, nil
 }
 
@@ -1,6 +1,9 @@
 package lists
 
 import (
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/gred/cmd"
@@ -10,113 +13,265 @@ import (
 
 // unblock unblocks as many waiters as possible that are blocked waiting
 // for a value from this key. Both the DB and the key must be under an
-// exclusive lock.
+// exclusive lock. The pops are appended to the append-only file, so the
+// caller must append its own command first.
 func unblock(db srv.DB, k srv.Key, v types.List) int {
 	var cnt int
 
 	// While there are values...
 	for v.LLen() > 0 {
 		// Get a waiter
-		ch, r := db.NextWaiter(k.Name())
-		if ch == nil {
+		w, ok := db.NextWaiter(k.Name())
+		if !ok {
 			// No more waiter, return
 			return cnt
 		}
-		sendch, ok := <-ch
+		sendch, ok := <-w.Ch
 
-		// Was the waiting channel closed? If not, send it a value.
+		// Was the waiting channel closed? If not, send it the values.
 		if ok {
-			// Has to return a value, because LLen is checked first
-			var val string
-			if r {
-				val, _ = v.RPop()
-			} else {
-				val, _ = v.LPop()
+			vals, err := pop(db, k, v, w)
+			if err != nil {
+				// The destination is not a list, the waiter gets the error
+				sendch <- nil
+				continue
 			}
 			cnt++
-			sendch <- [2]string{k.Name(), val}
+			sendch <- append([]string{k.Name()}, vals...)
 		}
 	}
 	return cnt
 }
 
-func blockPop(db srv.DB, secs int64, rpop bool, lists ...string) ([]string, error) {
-	db.Lock()
-	unlocks := make([]func(), 0)
-	unlocks = append(unlocks, db.Unlock)
+// side returns the name of the side of a list, as used by the LMOVE and
+// LMPOP commands.
+func side(right bool) string {
+	if right {
+		return "right"
+	}
+	return "left"
+}
 
-	keys := db.Keys()
-	for _, nm := range lists {
-		k, ok := keys[nm]
-		// Ignore non-existing keys in non-blocking portion
-		if ok {
-			// Lock the key
-			k.Lock()
-			unlocks = append(unlocks, k.Unlock)
-
-			// Get the value, if possible
-			v := k.Val()
-			if v, ok := v.(types.List); ok {
-				var val string
-				if rpop {
-					val, ok = v.RPop()
-				} else {
-					val, ok = v.LPop()
-				}
-				if ok {
-					// Delete the key if there are no more values
-					if v.LLen() == 0 {
-						db.DelKey(k.Name())
-					}
-
-					// Unlock all keys in reverse order, and return
-					for i := len(unlocks) - 1; i >= 0; i-- {
-						unlocks[i]()
-					}
-					return []string{k.Name(), val}, nil
-				}
-			} else {
-				// Unlock all keys in reverse order
-				for i := len(unlocks) - 1; i >= 0; i-- {
-					unlocks[i]()
-				}
-				// Return invalid type error
+// parseSide parses the LEFT or RIGHT side of a list, and returns true for
+// the right side.
+func parseSide(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "left":
+		return false, nil
+	case "right":
+		return true, nil
+	}
+	return false, cmd.ErrSyntax
+}
+
+// pop pops values from the list v of the key k, as specified by the waiter
+// w, and pushes the value to the destination list of w, if any. The DB must
+// be exclusively locked. It returns the popped values, or nil if the list is
+// empty, and an error if the destination key does not hold a list, in which
+// case nothing is popped.
+func pop(db srv.DB, k srv.Key, v types.List, w srv.ListWaiter) ([]string, error) {
+	if w.Dst != "" {
+		return popPush(db, k, v, w)
+	}
+
+	n := w.Count
+	if n < 1 {
+		n = 1
+	}
+	var vals []string
+	for ; n > 0; n-- {
+		val, ok := popSide(v, w.RPop)
+		if !ok {
+			break
+		}
+		vals = append(vals, val)
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	event := "lpop"
+	if w.RPop {
+		event = "rpop"
+	}
+	db.Notify(srv.NotifyList, event, k.Name())
+	if len(vals) == 1 {
+		srv.DefaultServer.Propagate(db.Index(), event, k.Name())
+	} else {
+		srv.DefaultServer.Propagate(db.Index(), "lmpop", "1", k.Name(), side(w.RPop),
+			"count", strconv.Itoa(len(vals)))
+	}
+	return vals, nil
+}
+
+// popSide pops a value from the head of the list, or from its tail if right
+// is true.
+func popSide(v types.List, right bool) (string, bool) {
+	if right {
+		return v.RPop()
+	}
+	return v.LPop()
+}
+
+// popPush pops a value from the list v of the key k and pushes it to the
+// destination list of the waiter w, which is created if it does not exist.
+// Since the DB is exclusively locked, no other connection holds the lock of
+// the keys. They are not locked, as the caller may already hold their lock,
+// but their values are copied to the snapshots in progress before they are
+// modified. The waiters of the destination list are unblocked.
+func popPush(db srv.DB, k srv.Key, v types.List, w srv.ListWaiter) ([]string, error) {
+	dst, vdst := k, v
+	exists := true
+	if w.Dst != k.Name() {
+		dst, exists = db.Key(w.Dst)
+		if exists {
+			var ok bool
+			if vdst, ok = dst.Val().(types.List); !ok {
 				return nil, cmd.ErrInvalidValType
 			}
 		}
 	}
+	k.Modify()
+	if exists {
+		dst.Modify()
+	}
+
+	val, ok := popSide(v, w.RPop)
+	if !ok {
+		return nil, nil
+	}
+	if !exists {
+		vdst = types.NewList()
+		dst = srv.NewKey(w.Dst, vdst)
+		db.SetKey(dst)
+	}
+	event := "lpush"
+	if w.DstRPush {
+		vdst.RPush(val)
+		event = "rpush"
+	} else {
+		vdst.LPush(val)
+	}
+
+	if w.RPop {
+		db.Notify(srv.NotifyList, "rpop", k.Name())
+	} else {
+		db.Notify(srv.NotifyList, "lpop", k.Name())
+	}
+	db.Notify(srv.NotifyList, event, w.Dst)
+	// Append the move before the pops of the unblocked waiters
+	srv.DefaultServer.Propagate(db.Index(), "lmove", k.Name(), w.Dst, side(w.RPop), side(w.DstRPush))
+	if dst != k && unblock(db, dst, vdst) > 0 && vdst.LLen() == 0 {
+		// If the destination list is now empty, delete the key
+		db.DelKey(w.Dst)
+		db.Notify(srv.NotifyGeneric, "del", w.Dst)
+	}
+	return []string{val}, nil
+}
+
+// popFirst pops values from the first non-empty list of the locked keys, as
+// specified by the waiter w. It returns the name of the list followed by the
+// popped values, or nil if all lists are empty. The DB must be exclusively
+// locked.
+func popFirst(db srv.DB, keys []srv.Key, w srv.ListWaiter) ([]string, error) {
+	for _, k := range keys {
+		// Ignore non-existing keys
+		if k == nil {
+			continue
+		}
+
+		v, ok := k.Val().(types.List)
+		if !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+		vals, err := pop(db, k, v, w)
+		if err != nil {
+			return nil, err
+		}
+		if vals == nil {
+			continue
+		}
+		// Delete the key if there are no more values
+		if v.LLen() == 0 {
+			db.DelKey(k.Name())
+			db.Notify(srv.NotifyGeneric, "del", k.Name())
+		}
+		return append([]string{k.Name()}, vals...), nil
+	}
+	return nil, nil
+}
+
+// blockPop pops values from the first non-empty list as specified by the
+// waiter w, or blocks the connection until a value is available, the
+// timeout expires or the client disconnects, if the connection can block.
+// It returns the name of the list followed by the popped values, or nil if
+// no value was popped.
+func blockPop(conn srv.Conn, timeout time.Duration, w srv.ListWaiter, lists ...string) ([]string, error) {
+	db := conn.DB()
+	keys, unl := cmd.LockKeys(db, true, lists...)
+
+	vals, err := popFirst(db, keys, w)
+	if vals != nil || err != nil {
+		unl()
+		return vals, err
+	}
 
 	// If no value was readily available, now all keys are locked, enter
-	// the waiting workflow.
-	ch := make(chan chan<- [2]string)
+	// the waiting workflow, if the connection can block.
+	closed, done, ok := conn.Block()
+	if !ok {
+		unl()
+		return nil, nil
+	}
+
+	// The waiters are registered in the order the connections block, so
+	// that the client that blocked first is served first.
+	ch := make(chan chan<- []string)
+	w.Ch = ch
 	for _, nm := range lists {
-		if rpop {
-			db.WaitRPop(nm, ch)
-		} else {
-			db.WaitLPop(nm, ch)
-		}
+		db.WaitPop(nm, w)
 	}
 
 	// Prepare channels (timeout and receive values)
 	var timeoutCh <-chan time.Time
-	if secs > 0 {
-		timeoutCh = time.After(time.Duration(secs) * time.Second)
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutCh = t.C
 	}
-	recCh := make(chan [2]string)
+	recCh := make(chan []string)
 
 	// Unlock all locks so that other connections can proceed
-	for i := len(unlocks) - 1; i >= 0; i-- {
-		unlocks[i]()
-	}
+	unl()
 
 	// Wait for a value
 	select {
-	case ch <- (chan<- [2]string)(recCh):
-		close(ch)
-		vals := <-recCh
-		return vals[:], nil
+	case ch <- (chan<- []string)(recCh):
+		if vals = <-recCh; vals == nil {
+			err = cmd.ErrInvalidValType
+		}
 	case <-timeoutCh:
-		close(ch)
-		return nil, nil
+	case <-closed:
+	}
+	close(ch)
+	done()
+
+	// Remove the waiters that are still registered for the other lists
+	db.Lock()
+	db.Unwait(ch, lists...)
+	db.Unlock()
+	return vals, err
+}
+
+// parseTimeout returns the duration of the timeout in seconds of the
+// blocking commands, which may be fractional. A timeout of 0 blocks
+// indefinitely.
+func parseTimeout(secs float64) (time.Duration, error) {
+	if math.IsNaN(secs) || math.IsInf(secs, 0) || secs > math.MaxInt64/1e9 {
+		return 0, cmd.ErrTimeoutNotFloat
+	}
+	if secs < 0 {
+		return 0, cmd.ErrTimeoutNegative
 	}
+	return time.Duration(secs * float64(time.Second)), nil
 }
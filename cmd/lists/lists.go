@@ -1,6 +1,7 @@
 package lists
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/gred/cmd"
@@ -9,13 +10,18 @@ import (
 )
 
 func init() {
+	cmd.Register("blmove", blmove)
+	cmd.Register("blmpop", blmpop)
 	cmd.Register("blpop", blpop)
 	cmd.Register("brpop", brpop)
 	cmd.Register("brpoplpush", brpoplpush)
 	cmd.Register("lindex", lindex)
 	cmd.Register("linsert", linsert)
 	cmd.Register("llen", llen)
+	cmd.Register("lmove", lmove)
+	cmd.Register("lmpop", lmpop)
 	cmd.Register("lpop", lpop)
+	cmd.Register("lpos", lpos)
 	cmd.Register("lpush", lpush)
 	cmd.Register("lpushx", lpushx)
 	cmd.Register("lrange", lrange)
@@ -28,57 +34,114 @@ func init() {
 	cmd.Register("rpushx", rpushx)
 }
 
-var blpop = cmd.NewDBCmd(
+var blmove = cmd.NewConnCmd(
 	&cmd.ArgDef{
-		MinArgs:    2,
-		MaxArgs:    -1,
-		IntIndices: []int{-1},
+		MinArgs:      5,
+		MaxArgs:      5,
+		FloatIndices: []int{4},
+	},
+	blmoveFn)
+
+func blmoveFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	w, err := moveWaiter(args)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseTimeout(floats[0])
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := blockPop(conn, timeout, w, args[0])
+	if vals == nil {
+		// Return either an error, or the nil timeout value
+		return nil, err
+	}
+	return vals[1], nil
+}
+
+var blmpop = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs:      4,
+		MaxArgs:      -1,
+		IntIndices:   []int{1},
+		FloatIndices: []int{0},
+	},
+	blmpopFn)
+
+func blmpopFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	keys, w, err := mpopArgs(args[2:], ints[0])
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseTimeout(floats[0])
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := blockPop(conn, timeout, w, keys...)
+	if vals == nil {
+		return nil, err
+	}
+	return []interface{}{vals[0], vals[1:]}, nil
+}
+
+var blpop = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs:      2,
+		MaxArgs:      -1,
+		FloatIndices: []int{-1},
 	},
 	blpopFn)
 
-func blpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	ar, err := blockPop(db, ints[0], false, args[:len(args)-1]...)
+func blpopFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	timeout, err := parseTimeout(floats[0])
+	if err != nil {
+		return nil, err
+	}
+	ar, err := blockPop(conn, timeout, srv.ListWaiter{}, args[:len(args)-1]...)
 	if ar == nil {
 		return nil, err
 	}
 	return ar, nil
 }
 
-var brpop = cmd.NewDBCmd(
+var brpop = cmd.NewConnCmd(
 	&cmd.ArgDef{
-		MinArgs:    2,
-		MaxArgs:    -1,
-		IntIndices: []int{-1},
+		MinArgs:      2,
+		MaxArgs:      -1,
+		FloatIndices: []int{-1},
 	},
 	brpopFn)
 
-func brpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	ar, err := blockPop(db, ints[0], true, args[:len(args)-1]...)
+func brpopFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	timeout, err := parseTimeout(floats[0])
+	if err != nil {
+		return nil, err
+	}
+	ar, err := blockPop(conn, timeout, srv.ListWaiter{RPop: true}, args[:len(args)-1]...)
 	if ar == nil {
 		return nil, err
 	}
 	return ar, nil
 }
 
-var brpoplpush = cmd.NewDBCmd(
+var brpoplpush = cmd.NewConnCmd(
 	&cmd.ArgDef{
-		MinArgs:    3,
-		MaxArgs:    3,
-		IntIndices: []int{2},
+		MinArgs:      3,
+		MaxArgs:      3,
+		FloatIndices: []int{2},
 	},
 	brpoplpushFn)
 
-func brpoplpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	// First do the brpop part
-	vals, err := blockPop(db, ints[0], true, args[0])
-	if vals == nil {
-		// Return either an error, or the nil timeout value
+func brpoplpushFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	timeout, err := parseTimeout(floats[0])
+	if err != nil {
 		return nil, err
 	}
-
-	// Then proceed with lpush
-	_, err = lpushFn(db, []string{args[1], vals[1]}, nil, nil)
-	if err != nil {
+	vals, err := blockPop(conn, timeout, srv.ListWaiter{RPop: true, Dst: args[1]}, args[0])
+	if vals == nil {
+		// Return either an error, or the nil timeout value
 		return nil, err
 	}
 
@@ -110,7 +173,7 @@ func lindexFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 	return nil, cmd.ErrInvalidValType
 }
 
-var linsert = cmd.NewSingleKeyCmd(
+var linsert = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 4,
 		MaxArgs: 4,
@@ -123,19 +186,27 @@ var linsert = cmd.NewSingleKeyCmd(
 			return nil
 		},
 	},
-	srv.NoKeyDefaultVal,
 	linsertFn)
 
-func linsertFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func linsertFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
+		var ret int64
 		if args[1] == "before" {
-			return v.LInsertBefore(args[2], args[3]), nil
+			ret = v.LInsertBefore(args[2], args[3])
+		} else {
+			ret = v.LInsertAfter(args[2], args[3])
 		}
-		return v.LInsertAfter(args[2], args[3]), nil
+		if ret > 0 {
+			db.Notify(srv.NotifyList, "linsert", args[0])
+		}
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -159,6 +230,106 @@ func llenFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	return nil, cmd.ErrInvalidValType
 }
 
+// moveWaiter returns the waiter for the source, destination and sides
+// arguments of LMOVE and BLMOVE.
+func moveWaiter(args []string) (srv.ListWaiter, error) {
+	from, err := parseSide(args[2])
+	if err != nil {
+		return srv.ListWaiter{}, err
+	}
+	to, err := parseSide(args[3])
+	if err != nil {
+		return srv.ListWaiter{}, err
+	}
+	return srv.ListWaiter{RPop: from, Dst: args[1], DstRPush: to}, nil
+}
+
+var lmove = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 4,
+		MaxArgs: 4,
+	},
+	lmoveFn)
+
+func lmoveFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	w, err := moveWaiter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Since LMOVE may delete the source key and create the destination key,
+	// the DB must be exclusively locked.
+	keys, unl := cmd.LockKeys(db, true, args[0])
+	defer unl()
+
+	vals, err := popFirst(db, keys, w)
+	if vals == nil {
+		return nil, err
+	}
+	return vals[1], nil
+}
+
+// mpopArgs parses the arguments of LMPOP and BLMPOP that follow numkeys, and
+// returns the keys and the waiter.
+func mpopArgs(args []string, numkeys int64) ([]string, srv.ListWaiter, error) {
+	var w srv.ListWaiter
+	if numkeys <= 0 {
+		return nil, w, cmd.ErrNumKeys
+	}
+	if numkeys >= int64(len(args)) {
+		return nil, w, cmd.ErrSyntax
+	}
+	keys, rest := args[:numkeys], args[numkeys:]
+
+	var err error
+	if w.RPop, err = parseSide(rest[0]); err != nil {
+		return nil, w, err
+	}
+	switch len(rest) {
+	case 1:
+	case 3:
+		if strings.ToLower(rest[1]) != "count" {
+			return nil, w, cmd.ErrSyntax
+		}
+		n, err := strconv.ParseInt(rest[2], 10, 64)
+		if err != nil {
+			return nil, w, cmd.ErrNotInteger
+		}
+		if n <= 0 {
+			return nil, w, cmd.ErrCountZero
+		}
+		w.Count = n
+	default:
+		return nil, w, cmd.ErrSyntax
+	}
+	return keys, w, nil
+}
+
+var lmpop = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    3,
+		MaxArgs:    -1,
+		IntIndices: []int{0},
+	},
+	lmpopFn)
+
+func lmpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	keys, w, err := mpopArgs(args[1:], ints[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Since LMPOP may delete the key, the DB must be exclusively locked.
+	locked, unl := cmd.LockKeys(db, true, keys...)
+	defer unl()
+
+	vals, err := popFirst(db, locked, w)
+	if vals == nil {
+		return nil, err
+	}
+	return []interface{}{vals[0], vals[1:]}, nil
+}
+
 var lpop = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
@@ -182,9 +353,11 @@ func lpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.List); ok {
 		val, ok := v.LPop()
 		if ok {
+			db.Notify(srv.NotifyList, "lpop", args[0])
 			// If the list is now empty, delete the key
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
+				db.Notify(srv.NotifyGeneric, "del", args[0])
 			}
 			return val, nil
 		}
@@ -193,6 +366,67 @@ func lpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	return nil, cmd.ErrInvalidValType
 }
 
+var lpos = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 8,
+	},
+	srv.NoKeyDefaultVal,
+	lposFn)
+
+func lposFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return nil, cmd.ErrSyntax
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		switch strings.ToLower(args[i]) {
+		case "rank":
+			if n == 0 {
+				return nil, cmd.ErrRankZero
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				return nil, cmd.ErrCountNegative
+			}
+			count = n
+		case "maxlen":
+			if n < 0 {
+				return nil, cmd.ErrMaxLenNegative
+			}
+			maxLen = n
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.List); ok {
+		if count < 0 {
+			// Without COUNT, return the first match only
+			if pos := v.LPos(args[1], rank, 1, maxLen); len(pos) > 0 {
+				return pos[0], nil
+			}
+			return nil, nil
+		}
+		pos := v.LPos(args[1], rank, count, maxLen)
+		ret := make([]interface{}, len(pos))
+		for i, p := range pos {
+			ret[i] = p
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
 var lpush = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
@@ -212,11 +446,15 @@ func lpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
 		val := v.LPush(args[1:]...)
+		db.Notify(srv.NotifyList, "lpush", args[0])
+		// Append the command before the pops of the unblocked waiters
+		srv.DefaultServer.Propagate(db.Index(), append([]string{"lpush"}, args...)...)
 		// Unblock any waiters on this key
 		if unblock(db, k, v) > 0 {
 			// If the list is now empty, delete the key
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
+				db.Notify(srv.NotifyGeneric, "del", args[0])
 			}
 		}
 		return val, nil
@@ -224,17 +462,19 @@ func lpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	return nil, cmd.ErrInvalidValType
 }
 
-var lpushx = cmd.NewSingleKeyCmd(
+var lpushx = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
 		MaxArgs: 2,
 	},
-	srv.NoKeyNone,
 	lpushxFn)
 
 // LPUSHX can't have any waiters, because it only pushes if the key already
 // exists, and the key is removed if it doesn't have any value.
-func lpushxFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func lpushxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
 	if k == nil {
 		return int64(0), nil
 	}
@@ -244,7 +484,9 @@ func lpushxFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
-		return v.LPush(args[1:]...), nil
+		ret := v.LPush(args[1:]...)
+		db.Notify(srv.NotifyList, "lpush", args[0])
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -293,9 +535,11 @@ func lremFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.List); ok {
 		val := v.LRem(ints[0], args[2])
 		if val > 0 {
+			db.Notify(srv.NotifyList, "lrem", args[0])
 			// If the list is now empty, delete the key
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
+				db.Notify(srv.NotifyGeneric, "del", args[0])
 			}
 		}
 		return val, nil
@@ -303,16 +547,18 @@ func lremFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	return nil, cmd.ErrInvalidValType
 }
 
-var lset = cmd.NewSingleKeyCmd(
+var lset = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:    3,
 		MaxArgs:    3,
 		IntIndices: []int{1},
 	},
-	srv.NoKeyNone,
 	lsetFn)
 
-func lsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func lsetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
 	if k == nil {
 		return nil, cmd.ErrNoSuchKey
 	}
@@ -324,6 +570,7 @@ func lsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.List); ok {
 		ok := v.LSet(ints[0], args[2])
 		if ok {
+			db.Notify(srv.NotifyList, "lset", args[0])
 			return cmd.OKVal, nil
 		}
 		return nil, cmd.ErrOutOfRange
@@ -354,9 +601,11 @@ func ltrimFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
 		v.LTrim(ints[0], ints[1])
+		db.Notify(srv.NotifyList, "ltrim", args[0])
 		// If the list is now empty, delete the key
 		if v.LLen() == 0 {
 			db.DelKey(args[0])
+			db.Notify(srv.NotifyGeneric, "del", args[0])
 		}
 		return cmd.OKVal, nil
 	}
@@ -386,9 +635,11 @@ func rpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.List); ok {
 		val, ok := v.RPop()
 		if ok {
+			db.Notify(srv.NotifyList, "rpop", args[0])
 			// If the list is now empty, delete the key
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
+				db.Notify(srv.NotifyGeneric, "del", args[0])
 			}
 			return val, nil
 		}
@@ -412,8 +663,7 @@ func rpoplpushFn(db srv.DB, args []string, ints []int64, floats []float64) (inte
 	defer db.Unlock()
 
 	// Get the source key
-	keys := db.Keys()
-	src, ok := keys[args[0]]
+	src, ok := db.Key(args[0])
 	if !ok {
 		// Source key does not exist, return nil
 		return nil, nil
@@ -432,6 +682,9 @@ func rpoplpushFn(db srv.DB, args []string, ints []int64, floats []float64) (inte
 			val, ok := v.RPop()
 			if ok {
 				v.LPush(val)
+				db.Notify(srv.NotifyList, "rpop", args[0])
+				db.Notify(srv.NotifyList, "lpush", args[0])
+				srv.DefaultServer.Propagate(db.Index(), "rpoplpush", args[0], args[1])
 				return val, nil
 			}
 			return nil, nil
@@ -450,11 +703,11 @@ func rpoplpushFn(db srv.DB, args []string, ints []int64, floats []float64) (inte
 	}
 
 	// Otherwise get the destination key, and create it if it doesn't exist
-	dst, ok := keys[args[1]]
+	dst, ok := db.Key(args[1])
 	if !ok {
 		// Destination does not exist, create it
 		dst = srv.NewKey(args[1], types.NewList())
-		keys[args[1]] = dst
+		db.Keys().Set(dst)
 	}
 
 	dst.Lock()
@@ -469,16 +722,22 @@ func rpoplpushFn(db srv.DB, args []string, ints []int64, floats []float64) (inte
 	// Both are lists, proceed
 	val, ok := vsrc.RPop()
 	if ok {
+		db.Notify(srv.NotifyList, "rpop", args[0])
 		// Check if the src is now empty, if so delete the key
 		if vsrc.LLen() == 0 {
 			db.DelKey(args[0])
+			db.Notify(srv.NotifyGeneric, "del", args[0])
 		}
 		vdst.LPush(val)
+		db.Notify(srv.NotifyList, "lpush", args[1])
+		// Append the command before the pops of the unblocked waiters
+		srv.DefaultServer.Propagate(db.Index(), "rpoplpush", args[0], args[1])
 		// Unblock any waiters on the dst key
 		if unblock(db, dst, vdst) > 0 {
 			// If the list is now empty, delete the key
 			if vdst.LLen() == 0 {
 				db.DelKey(args[1])
+				db.Notify(srv.NotifyGeneric, "del", args[1])
 			}
 		}
 		return val, nil
@@ -505,11 +764,15 @@ func rpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
 		val := v.RPush(args[1:]...)
+		db.Notify(srv.NotifyList, "rpush", args[0])
+		// Append the command before the pops of the unblocked waiters
+		srv.DefaultServer.Propagate(db.Index(), append([]string{"rpush"}, args...)...)
 		// Unblock any waiters on this key
 		if unblock(db, k, v) > 0 {
 			// If the list is now empty, delete the key
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
+				db.Notify(srv.NotifyGeneric, "del", args[0])
 			}
 		}
 		return val, nil
@@ -517,17 +780,19 @@ func rpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	return nil, cmd.ErrInvalidValType
 }
 
-var rpushx = cmd.NewSingleKeyCmd(
+var rpushx = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
 		MaxArgs: 2,
 	},
-	srv.NoKeyNone,
 	rpushxFn)
 
 // RPUSHX can't have any waiters, because it only pushes if the key already
 // exists, and the key is removed if it doesn't have any value.
-func rpushxFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func rpushxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
 	if k == nil {
 		return int64(0), nil
 	}
@@ -537,7 +802,9 @@ func rpushxFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
-		return v.RPush(args[1:]...), nil
+		ret := v.RPush(args[1:]...)
+		db.Notify(srv.NotifyList, "rpush", args[0])
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"sort"
+
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// LockKeys locks the database and the existing keys with the specified
+// names, exclusively if excl is true, and read-locked otherwise. It returns
+// the keys in the order of the names, with nil for the keys that do not
+// exist, and the function that releases the locks. The lookups of the
+// keys are counted in the keyspace statistics if excl is false.
+//
+// Each key is locked once, even if its name is repeated, and the keys are
+// locked in the order of their names, so that concurrent calls do not
+// deadlock whatever the order of the names.
+func LockKeys(db srv.DB, excl bool, names ...string) ([]srv.Key, func()) {
+	if excl {
+		db.Lock()
+	} else {
+		db.RLock()
+	}
+
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	byName := make(map[string]srv.Key, len(sorted))
+	locked := make([]srv.Key, 0, len(sorted))
+	for i, nm := range sorted {
+		if i > 0 && nm == sorted[i-1] {
+			continue
+		}
+		k, ok := db.Key(nm)
+		if !excl {
+			srv.DefaultServer.CountLookup(ok)
+		}
+		if !ok {
+			continue
+		}
+		if excl {
+			k.Lock()
+		} else {
+			k.RLock()
+		}
+		byName[nm] = k
+		locked = append(locked, k)
+	}
+
+	keys := make([]srv.Key, len(names))
+	for i, nm := range names {
+		keys[i] = byName[nm]
+	}
+	return keys, func() {
+		// Unlock in reverse order
+		for i := len(locked) - 1; i >= 0; i-- {
+			if excl {
+				locked[i].Unlock()
+			} else {
+				locked[i].RUnlock()
+			}
+		}
+		if excl {
+			db.Unlock()
+		} else {
+			db.RUnlock()
+		}
+	}
+}
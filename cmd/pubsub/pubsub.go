@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+func init() {
+	cmd.Register("psubscribe", psubscribe)
+	cmd.Register("publish", publish)
+	cmd.Register("pubsub", pubsub)
+	cmd.Register("punsubscribe", punsubscribe)
+	cmd.Register("subscribe", subscribe)
+	cmd.Register("unsubscribe", unsubscribe)
+}
+
+var psubscribe = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	psubscribeFn)
+
+func psubscribeFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return conn.PSubscribe(args...), nil
+}
+
+var publish = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	publishFn)
+
+func publishFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	return srv.DefaultPubSub.Publish(args[0], args[1]), nil
+}
+
+var pubsub = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	pubsubFn)
+
+func pubsubFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	switch sub := strings.ToLower(args[0]); sub {
+	case "channels":
+		if len(args) > 2 {
+			return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "pubsub")
+		}
+		var pat string
+		if len(args) == 2 {
+			pat = args[1]
+		}
+		return srv.DefaultPubSub.Channels(pat), nil
+
+	case "numsub":
+		ret := make([]interface{}, 0, 2*(len(args)-1))
+		for _, ch := range args[1:] {
+			ret = append(ret, ch, srv.DefaultPubSub.NumSub(ch))
+		}
+		return ret, nil
+
+	case "numpat":
+		if len(args) > 1 {
+			return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "pubsub")
+		}
+		return srv.DefaultPubSub.NumPat(), nil
+
+	default:
+		return nil, fmt.Errorf("ERR Unknown PUBSUB subcommand '%s'", args[0])
+	}
+}
+
+var punsubscribe = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: -1,
+	},
+	punsubscribeFn)
+
+func punsubscribeFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return conn.PUnsubscribe(args...), nil
+}
+
+var subscribe = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	subscribeFn)
+
+func subscribeFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return conn.Subscribe(args...), nil
+}
+
+var unsubscribe = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: -1,
+	},
+	unsubscribeFn)
+
+func unsubscribeFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return conn.Unsubscribe(args...), nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DefaultScanCount is the number of elements visited by the SCAN family
+// of commands when the COUNT option is not set.
+const DefaultScanCount = 10
+
+// ScanArgs holds the arguments of the SCAN family of commands.
+type ScanArgs struct {
+	// Cursor is the cursor of the iteration.
+	Cursor uint64
+
+	// Match is the glob-style pattern that the returned elements must
+	// match, or an empty string if the MATCH option is not set.
+	Match string
+
+	// Count is the number of elements to visit.
+	Count int
+
+	// Type is the type of the keys to return, or an empty string if the
+	// TYPE option is not set.
+	Type string
+}
+
+// ParseScanArgs parses the cursor and the options of a command of the SCAN
+// family. allowType is true if the TYPE option is supported.
+func ParseScanArgs(cursor string, opts []string, allowType bool) (*ScanArgs, error) {
+	c, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sa := &ScanArgs{Cursor: c, Count: DefaultScanCount}
+
+	for i := 0; i < len(opts); i += 2 {
+		if i+1 >= len(opts) {
+			return nil, ErrSyntax
+		}
+		switch strings.ToLower(opts[i]) {
+		case "match":
+			sa.Match = opts[i+1]
+		case "count":
+			n, err := strconv.ParseInt(opts[i+1], 10, 64)
+			if err != nil {
+				return nil, ErrNotInteger
+			}
+			if n < 1 {
+				return nil, ErrSyntax
+			}
+			sa.Count = int(n)
+		case "type":
+			if !allowType {
+				return nil, ErrSyntax
+			}
+			sa.Type = strings.ToLower(opts[i+1])
+		default:
+			return nil, ErrSyntax
+		}
+	}
+	return sa, nil
+}
+
+// ScanReply returns the reply of the SCAN family of commands, made of the
+// cursor of the next call and the elements.
+func ScanReply(cursor uint64, elems []string) []interface{} {
+	if elems == nil {
+		elems = []string{}
+	}
+	return []interface{}{strconv.FormatUint(cursor, 10), elems}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+var config = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	configFn)
+
+func configFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	sub := strings.ToLower(args[0])
+	switch sub {
+	case "get":
+		if len(args) < 2 {
+			return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "config|"+sub)
+		}
+		return srv.GetConfig(args[1:]...), nil
+
+	case "resetstat":
+		if len(args) != 1 {
+			return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "config|"+sub)
+		}
+		srv.DefaultServer.ResetStats()
+		return cmd.OKVal, nil
+
+	case "rewrite":
+		if len(args) != 1 {
+			return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "config|"+sub)
+		}
+		if err := srv.RewriteConfig(); err != nil {
+			if err == srv.ErrNoConfigFile {
+				return nil, cmd.ErrNoConfigFile
+			}
+			return nil, fmt.Errorf("ERR Rewriting config file: %s", err)
+		}
+		return cmd.OKVal, nil
+
+	case "set":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "config|"+sub)
+		}
+		if err := srv.SetConfig(args[1:]...); err != nil {
+			ce := err.(*srv.ConfigError)
+			if ce.Err == srv.ErrUnknownConfig {
+				return nil, fmt.Errorf(cmd.UnknownConfigFmt, ce.Name)
+			}
+			return nil, fmt.Errorf(cmd.ConfigSetFailedFmt, ce.Name, ce.Err)
+		}
+		return cmd.OKVal, nil
+
+	default:
+		return nil, fmt.Errorf("ERR Unknown CONFIG subcommand '%s'", args[0])
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// infoSection is a section of the INFO reply, which writes its fields to
+// the buffer.
+type infoSection struct {
+	name string
+	fn   func(*bytes.Buffer)
+}
+
+// infoSections lists the sections of the INFO reply, in order.
+var infoSections = []infoSection{
+	{"Server", infoServer},
+	{"Clients", infoClients},
+	{"Memory", infoMemory},
+	{"Persistence", infoPersistence},
+	{"Stats", infoStats},
+	{"Keyspace", infoKeyspace},
+	{"Commandstats", infoCmdStats},
+}
+
+// defaultInfoSections lists the sections of the reply when INFO is called
+// without argument, or with the default section.
+var defaultInfoSections = map[string]bool{
+	"server":      true,
+	"clients":     true,
+	"memory":      true,
+	"persistence": true,
+	"stats":       true,
+	"keyspace":    true,
+}
+
+var info = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: -1,
+	},
+	infoFn)
+
+func infoFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	want := defaultInfoSections
+	if len(args) > 0 {
+		want = make(map[string]bool, len(args))
+		for _, arg := range args {
+			switch arg = strings.ToLower(arg); arg {
+			case "all", "everything":
+				for _, s := range infoSections {
+					want[strings.ToLower(s.name)] = true
+				}
+			case "default":
+				for nm := range defaultInfoSections {
+					want[nm] = true
+				}
+			default:
+				want[arg] = true
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, s := range infoSections {
+		if !want[strings.ToLower(s.name)] {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		fmt.Fprintf(&buf, "# %s\r\n", s.name)
+		s.fn(&buf)
+	}
+	return buf.String(), nil
+}
+
+// infoField writes the field and its value to the buffer.
+func infoField(buf *bytes.Buffer, field string, val interface{}) {
+	fmt.Fprintf(buf, "%s:%v\r\n", field, val)
+}
+
+func infoServer(buf *bytes.Buffer) {
+	up := time.Since(srv.DefaultServer.Stats().Start)
+	infoField(buf, "redis_version", srv.RedisVersion)
+	infoField(buf, "redis_mode", "standalone")
+	infoField(buf, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(buf, "arch_bits", strconv.IntSize)
+	infoField(buf, "go_version", runtime.Version())
+	infoField(buf, "process_id", os.Getpid())
+	infoField(buf, "tcp_port", srv.CurrentConfig().Port)
+	infoField(buf, "uptime_in_seconds", int64(up/time.Second))
+	infoField(buf, "uptime_in_days", int64(up/(24*time.Hour)))
+	infoField(buf, "config_file", srv.ConfigFile())
+}
+
+func infoClients(buf *bytes.Buffer) {
+	var blocked int
+	clients := srv.DefaultClients.List()
+	for _, c := range clients {
+		if c.Info().Blocked {
+			blocked++
+		}
+	}
+	infoField(buf, "connected_clients", len(clients))
+	infoField(buf, "blocked_clients", blocked)
+}
+
+func infoMemory(buf *bytes.Buffer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	infoField(buf, "used_memory", ms.HeapAlloc)
+	infoField(buf, "used_memory_human", humanBytes(ms.HeapAlloc))
+	infoField(buf, "used_memory_rss", ms.Sys)
+	infoField(buf, "used_memory_rss_human", humanBytes(ms.Sys))
+	infoField(buf, "mem_allocator", "go")
+}
+
+// humanBytes returns the number of bytes n in a human-readable form.
+func humanBytes(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n) / 1024
+	i := 0
+	for ; f >= 1024 && i < len(units)-1; i++ {
+		f /= 1024
+	}
+	return fmt.Sprintf("%.2f%c", f, units[i])
+}
+
+func infoPersistence(buf *bytes.Buffer) {
+	aof := 0
+	if srv.DefaultServer.AOFEnabled() {
+		aof = 1
+	}
+	infoField(buf, "rdb_changes_since_last_save", srv.DefaultServer.Changes())
+	infoField(buf, "rdb_last_save_time", srv.DefaultServer.LastSave())
+	infoField(buf, "aof_enabled", aof)
+}
+
+func infoStats(buf *bytes.Buffer) {
+	st := srv.DefaultServer.Stats()
+	exp := srv.DefaultServer.ExpireStats()
+	infoField(buf, "total_connections_received", st.Connections)
+	infoField(buf, "total_commands_processed", st.Commands)
+	infoField(buf, "expired_keys", exp.ExpiredKeys)
+	infoField(buf, "keyspace_hits", st.KeyspaceHits)
+	infoField(buf, "keyspace_misses", st.KeyspaceMisses)
+	infoField(buf, "pubsub_channels", len(srv.DefaultPubSub.Channels("")))
+	infoField(buf, "pubsub_patterns", srv.DefaultPubSub.NumPat())
+}
+
+// infoKeyspace writes the number of keys, the number of keys with an
+// expiration and their average time-to-live in milliseconds of the
+// databases that have keys. The keys of each database are visited.
+func infoKeyspace(buf *bytes.Buffer) {
+	for ix := 0; ; ix++ {
+		db, ok := srv.DefaultServer.GetDB(ix)
+		if !ok {
+			return
+		}
+
+		var expires int
+		var ttl time.Duration
+		db.RLock()
+		keys := db.Keys().Len()
+		db.Keys().Range(func(k srv.Key) bool {
+			k.RLock()
+			if d := k.TTL(); d >= 0 {
+				expires++
+				ttl += d
+			}
+			k.RUnlock()
+			return true
+		})
+		db.RUnlock()
+
+		if keys == 0 {
+			continue
+		}
+		var avg int64
+		if expires > 0 {
+			avg = int64(ttl/time.Millisecond) / int64(expires)
+		}
+		infoField(buf, "db"+strconv.Itoa(ix),
+			fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", keys, expires, avg))
+	}
+}
+
+func infoCmdStats(buf *bytes.Buffer) {
+	for _, c := range srv.DefaultServer.Stats().Cmds {
+		usec := int64(c.Duration / time.Microsecond)
+		infoField(buf, "cmdstat_"+c.Name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f",
+			c.Calls, usec, float64(usec)/float64(c.Calls)))
+	}
+}
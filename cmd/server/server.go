@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"strconv"
 
 	"github.com/PuerkitoBio/gred/cmd"
@@ -8,9 +9,47 @@ import (
 )
 
 func init() {
+	cmd.Register("bgrewriteaof", bgrewriteaof)
+	cmd.Register("bgsave", bgsave)
+	cmd.Register("config", config)
 	cmd.Register("flushdb", flushdb)
 	cmd.Register("flushall", flushall)
-	cmd.Register("time", time)
+	cmd.Register("info", info)
+	cmd.Register("lastsave", lastsave)
+	cmd.Register("save", save)
+	cmd.Register("swapdb", swapdb)
+	cmd.Register("time", timeƒ)
+}
+
+var bgrewriteaof = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	bgrewriteaofFn)
+
+func bgrewriteaofFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	if err := srv.DefaultServer.BGRewriteAOF(); err != nil {
+		if err == srv.ErrRewriteInProgress {
+			return nil, cmd.ErrRewriteInProgress
+		}
+		return nil, err
+	}
+	return cmd.BGRewriteAOFStartedVal, nil
+}
+
+var bgsave = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	bgsaveFn)
+
+func bgsaveFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	if err := srv.DefaultServer.BGSave(); err != nil {
+		return nil, saveErr(err)
+	}
+	return cmd.BGSaveStartedVal, nil
 }
 
 var flushdb = cmd.NewDBCmd(
@@ -36,14 +75,61 @@ var flushall = cmd.NewSrvCmd(
 	flushallFn)
 
 func flushallFn(args []string, ints []int64, floats []float64) (interface{}, error) {
-	srv.DefaultServer.Lock()
-	defer srv.DefaultServer.Unlock()
-
+	// db locking is done inside FlushAll
 	srv.DefaultServer.FlushAll()
 	return cmd.OKVal, nil
 }
 
-var time = cmd.NewSrvCmd(
+var lastsave = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	lastsaveFn)
+
+func lastsaveFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	return srv.DefaultServer.LastSave(), nil
+}
+
+var save = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	saveFn)
+
+func saveFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	if err := srv.DefaultServer.Save(); err != nil {
+		return nil, saveErr(err)
+	}
+	return cmd.OKVal, nil
+}
+
+// saveErr returns the error to reply when a save fails.
+func saveErr(err error) error {
+	if err == srv.ErrSaveInProgress {
+		return cmd.ErrSaveInProgress
+	}
+	return fmt.Errorf("ERR %s", err)
+}
+
+var swapdb = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    2,
+		IntIndices: []int{0, 1},
+	},
+	swapdbFn)
+
+func swapdbFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	// db locking is done inside SwapDB
+	if !srv.DefaultServer.SwapDB(int(ints[0]), int(ints[1])) {
+		return nil, cmd.ErrInvalidDBIndex
+	}
+	return cmd.OKVal, nil
+}
+
+var timeƒ = cmd.NewSrvCmd(
 	&cmd.ArgDef{
 		MinArgs: 0,
 		MaxArgs: 0,
@@ -1,7 +1,10 @@
 package sets
 
 import (
+	"strconv"
+
 	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/glob"
 	"github.com/PuerkitoBio/gred/srv"
 	"github.com/PuerkitoBio/gred/types"
 )
@@ -11,26 +14,123 @@ func init() {
 	cmd.Register("scard", scard)
 	cmd.Register("sdiff", sdiff)
 	cmd.Register("sdiffstore", sdiffstore)
+	cmd.Register("sinter", sinter)
+	cmd.Register("sinterstore", sinterstore)
 	cmd.Register("sismember", sismember)
 	cmd.Register("smembers", smembers)
+	cmd.Register("smove", smove)
+	cmd.Register("spop", spop)
+	cmd.Register("srandmember", srandmember)
 	cmd.Register("srem", srem)
+	cmd.Register("sscan", sscan)
+	cmd.Register("sunion", sunion)
+	cmd.Register("sunionstore", sunionstore)
+}
+
+// setOp is an operation on sets, such as types.Set.SDiff.
+type setOp func(types.Set, ...types.Set) []string
+
+// setVals returns the sets held by the keys, with an empty set for the
+// keys that do not exist.
+func setVals(keys []srv.Key) ([]types.Set, error) {
+	sets := make([]types.Set, len(keys))
+	for i, k := range keys {
+		if k == nil {
+			sets[i] = types.NewSet()
+			continue
+		}
+		v, ok := k.Val().(types.Set)
+		if !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+		sets[i] = v
+	}
+	return sets, nil
+}
+
+// execSetOp returns the result of the operation on the sets held by the
+// keys with the specified names.
+func execSetOp(db srv.DB, op setOp, names []string) (interface{}, error) {
+	keys, unl := cmd.LockKeys(db, false, names...)
+	defer unl()
+
+	sets, err := setVals(keys)
+	if err != nil {
+		return nil, err
+	}
+	return op(sets[0], sets[1:]...), nil
 }
 
-var sadd = cmd.NewSingleKeyCmd(
+// execSetOpStore stores the result of the operation on the sets held by
+// the keys with the specified names in the key dst, and returns the number
+// of members of the result. The event is notified for dst.
+func execSetOpStore(db srv.DB, op setOp, event, dst string, names []string) (interface{}, error) {
+	// The destination may also be a source key
+	keys, unl := cmd.LockKeys(db, true, append([]string{dst}, names...)...)
+	defer unl()
+
+	sets, err := setVals(keys[1:])
+	if err != nil {
+		return nil, err
+	}
+	vals := op(sets[0], sets[1:]...)
+
+	// The destination is replaced whatever its type, and deleted if the
+	// result is empty.
+	if keys[0] != nil {
+		db.DelKey(dst)
+		if len(vals) == 0 {
+			db.Notify(srv.NotifyGeneric, "del", dst)
+		}
+	}
+	if len(vals) == 0 {
+		return int64(0), nil
+	}
+	set := types.NewSet()
+	ret := set.SAdd(vals...)
+	db.SetKey(srv.NewKey(dst, set))
+	db.Notify(srv.NotifySet, event, dst)
+	return ret, nil
+}
+
+// parseCount parses the optional count argument of SPOP and SRANDMEMBER,
+// and returns false if there is none. A negative count is invalid unless
+// neg is true.
+func parseCount(args []string, neg bool) (int64, bool, error) {
+	if len(args) == 0 {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || n < -(1<<62) {
+		return 0, false, cmd.ErrNotInteger
+	}
+	if n < 0 && !neg {
+		return 0, false, cmd.ErrNotPositive
+	}
+	return n, true, nil
+}
+
+var sadd = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
 		MaxArgs: -1,
 	},
-	srv.NoKeyCreateSet,
 	saddFn)
 
-func saddFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func saddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateSet)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.Set); ok {
-		return v.SAdd(args[1:]...), nil
+		ret := v.SAdd(args[1:]...)
+		if ret > 0 {
+			db.Notify(srv.NotifySet, "sadd", args[0])
+		}
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -62,35 +162,7 @@ var sdiff = cmd.NewDBCmd(
 	sdiffFn)
 
 func sdiffFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	db.RLock()
-	defer db.RUnlock()
-
-	// Get and rlock all keys
-	keys := db.Keys()
-	diffSets := make([]types.Set, 0, len(args))
-	first := true
-	for _, nm := range args {
-		// Check if key exists
-		if k, ok := keys[nm]; ok {
-			// It does, rlock the key
-			k.RLock()
-			defer k.RUnlock()
-
-			// Get the value, make sure it is a Set
-			v := k.Val()
-			if v, ok := v.(types.Set); ok {
-				diffSets = append(diffSets, v)
-			} else {
-				return nil, cmd.ErrInvalidValType
-			}
-		} else if first {
-			// If first key does not exist, insert an empty set
-			diffSets = append(diffSets, types.NewSet())
-		}
-		first = false
-	}
-
-	return diffSets[0].SDiff(diffSets[1:]...), nil
+	return execSetOp(db, types.Set.SDiff, args)
 }
 
 var sdiffstore = cmd.NewDBCmd(
@@ -101,48 +173,29 @@ var sdiffstore = cmd.NewDBCmd(
 	sdiffstoreFn)
 
 func sdiffstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	// In every case, a new key is created at destination, so must have a lock
-	db.Lock()
-	defer db.Unlock()
-
-	keys := db.Keys()
-
-	diffSets := make([]types.Set, 0, len(args)-1)
-	first := true
-	for _, nm := range args[1:] {
-		// Check if key exists
-		if k, ok := keys[nm]; ok {
-			// It does, rlock the key
-			k.RLock()
-			defer k.RUnlock()
-
-			// Get the value, make sure it is a Set
-			v := k.Val()
-			if v, ok := v.(types.Set); ok {
-				diffSets = append(diffSets, v)
-			} else {
-				return nil, cmd.ErrInvalidValType
-			}
-		} else if first {
-			// If first key does not exist, insert an empty set
-			diffSets = append(diffSets, types.NewSet())
-		}
-		first = false
-	}
+	return execSetOpStore(db, types.Set.SDiff, "sdiffstore", args[0], args[1:])
+}
 
-	val := diffSets[0].SDiff(diffSets[1:]...)
-	// If destination exists, remove any expiration and delete
-	if dst, ok := keys[args[0]]; ok {
-		dst.Lock()
-		dst.Abort()
-		delete(keys, args[0])
-		dst.Unlock()
-	}
-	// Then create the destination key
-	newSet := types.NewSet()
-	dst := srv.NewKey(args[0], newSet)
-	keys[args[0]] = dst
-	return newSet.SAdd(val...), nil
+var sinter = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	sinterFn)
+
+func sinterFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return execSetOp(db, types.Set.SInter, args)
+}
+
+var sinterstore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	sinterstoreFn)
+
+func sinterstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return execSetOpStore(db, types.Set.SInter, "sinterstore", args[0], args[1:])
 }
 
 var sismember = cmd.NewSingleKeyCmd(
@@ -183,21 +236,224 @@ func smembersFn(k srv.Key, args []string, ints []int64, floats []float64) (inter
 	return nil, cmd.ErrInvalidValType
 }
 
-var srem = cmd.NewSingleKeyCmd(
+var smove = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	smoveFn)
+
+func smoveFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	keys, unl := cmd.LockKeys(db, true, args[0], args[1])
+	defer unl()
+
+	src, dst := keys[0], keys[1]
+	if src == nil {
+		return false, nil
+	}
+	sv, ok := src.Val().(types.Set)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	var dv types.Set
+	if dst != nil {
+		if dv, ok = dst.Val().(types.Set); !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+	}
+	if src == dst {
+		return sv.SIsMember(args[2]), nil
+	}
+
+	if sv.SRem(args[2]) == 0 {
+		return false, nil
+	}
+	db.Notify(srv.NotifySet, "srem", args[0])
+	if sv.SCard() == 0 {
+		db.DelKey(args[0])
+		db.Notify(srv.NotifyGeneric, "del", args[0])
+	}
+	if dv == nil {
+		dv = types.NewSet()
+		db.SetKey(srv.NewKey(args[1], dv))
+	}
+	if dv.SAdd(args[2]) > 0 {
+		db.Notify(srv.NotifySet, "sadd", args[1])
+	}
+	return true, nil
+}
+
+var spop = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
-		MaxArgs: -1,
+		MaxArgs: 2,
+	},
+	spopFn)
+
+func spopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	cnt, hasCnt, err := parseCount(args[1:], false)
+	if err != nil {
+		return nil, err
+	}
+	if !hasCnt {
+		cnt = 1
+	}
+
+	// Since SPOP may delete the key, get an exclusive DB lock
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v, ok := k.Val().(types.Set)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	vals := v.SPop(cnt)
+	if len(vals) > 0 {
+		db.Notify(srv.NotifySet, "spop", args[0])
+		if v.SCard() == 0 {
+			db.DelKey(args[0])
+			db.Notify(srv.NotifyGeneric, "del", args[0])
+		}
+	}
+
+	// Without a count, a single member is returned
+	if hasCnt {
+		return vals, nil
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	return vals[0], nil
+}
+
+var srandmember = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 2,
 	},
 	srv.NoKeyDefaultVal,
-	sremFn)
+	srandmemberFn)
+
+func srandmemberFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	cnt, hasCnt, err := parseCount(args[1:], true)
+	if err != nil {
+		return nil, err
+	}
+	if !hasCnt {
+		cnt = 1
+	}
 
-func sremFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
 	k.RLock()
 	defer k.RUnlock()
 
+	v, ok := k.Val().(types.Set)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	vals := v.SRandMember(cnt)
+
+	// Without a count, a single member is returned
+	if hasCnt {
+		return vals, nil
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	return vals[0], nil
+}
+
+var srem = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	sremFn)
+
+func sremFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Since SREM may delete the key (if the set is empty), get an exclusive
+	// DB lock.
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
 	v := k.Val()
 	if v, ok := v.(types.Set); ok {
-		return v.SRem(args[1:]...), nil
+		ret := v.SRem(args[1:]...)
+		if ret > 0 {
+			db.Notify(srv.NotifySet, "srem", args[0])
+			if v.SCard() == 0 {
+				db.DelKey(args[0])
+				db.Notify(srv.NotifyGeneric, "del", args[0])
+			}
+		}
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
+
+var sscan = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 6,
+	},
+	srv.NoKeyDefaultVal,
+	sscanFn)
+
+func sscanFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sa, err := cmd.ParseScanArgs(args[1], args[2:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v, ok := k.Val().(types.Set)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	// Visit at least Count members, unless the iteration is complete, and
+	// filter them once visited, like SCAN.
+	var mbrs []string
+	cursor, visited := sa.Cursor, 0
+	for maxIter := sa.Count * 10; ; maxIter-- {
+		cursor = v.SScan(cursor, func(mbr string) {
+			visited++
+			if sa.Match == "" || glob.Match(sa.Match, mbr) {
+				mbrs = append(mbrs, mbr)
+			}
+		})
+		if cursor == 0 || visited >= sa.Count || maxIter <= 0 {
+			break
+		}
+	}
+	return cmd.ScanReply(cursor, mbrs), nil
+}
+
+var sunion = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	sunionFn)
+
+func sunionFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return execSetOp(db, types.Set.SUnion, args)
+}
+
+var sunionstore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	sunionstoreFn)
+
+func sunionstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return execSetOpStore(db, types.Set.SUnion, "sunionstore", args[0], args[1:])
+}
@@ -0,0 +1,276 @@
+package streams
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// readOpts holds the options of XREAD and XREADGROUP.
+type readOpts struct {
+	group, consumer string
+	count           int64
+	block           bool
+	timeout         time.Duration
+	noack           bool
+	keys            []string
+	ids             []string
+}
+
+// parseRead parses the arguments of the read command name, which accepts
+// the GROUP and NOACK options if group is true.
+func parseRead(name string, args []string, group bool) (*readOpts, error) {
+	opts := &readOpts{count: -1}
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "streams":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, fmt.Errorf(cmd.UnbalancedStreamsFmt, name)
+			}
+			opts.keys, opts.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			if group && opts.group == "" {
+				return nil, cmd.ErrSyntax
+			}
+			return opts, nil
+
+		case opt == "noack" && group:
+			opts.noack = true
+
+		case opt == "group" && group && i+2 < len(args):
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			i += 2
+
+		case opt == "count" && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			if n > 0 {
+				opts.count = n
+			}
+
+		case opt == "block" && i+1 < len(args):
+			i++
+			ms, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			if ms < 0 {
+				return nil, cmd.ErrTimeoutNegative
+			}
+			opts.block = true
+			opts.timeout = time.Duration(ms) * time.Millisecond
+
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+	return nil, cmd.ErrSyntax
+}
+
+// readFn reads the locked stream keys, and returns a nil reply if there is
+// nothing to read.
+type readFn func(db srv.DB, keys []srv.Key) (interface{}, error)
+
+// blockRead reads the streams with the read function, or if there is nothing
+// to read and the options request it, blocks the connection until entries
+// are added to one of the streams or the timeout expires, if the connection
+// can block. The streams are read again each time entries are added.
+func blockRead(conn srv.Conn, opts *readOpts, excl bool, read readFn) (interface{}, error) {
+	db := conn.DB()
+	var timeoutCh <-chan time.Time
+	if opts.timeout > 0 {
+		t := time.NewTimer(opts.timeout)
+		defer t.Stop()
+		timeoutCh = t.C
+	}
+
+	for {
+		// The DB must be exclusively locked to register the waiters
+		keys, unl := cmd.LockKeys(db, excl || opts.block, opts.keys...)
+		res, err := read(db, keys)
+		if res != nil || err != nil || !opts.block {
+			unl()
+			return res, err
+		}
+
+		// Nothing to read, now all keys are locked, enter the waiting
+		// workflow, if the connection can block.
+		closed, done, ok := conn.Block()
+		if !ok {
+			unl()
+			return nil, nil
+		}
+		ch := make(chan chan<- []string)
+		for _, nm := range opts.keys {
+			db.WaitStream(nm, ch)
+		}
+		recCh := make(chan []string)
+
+		// Unlock all locks so that other connections can proceed
+		unl()
+
+		// Wait for new entries, and read again
+		woken := false
+		select {
+		case ch <- (chan<- []string)(recCh):
+			<-recCh
+			woken = true
+		case <-timeoutCh:
+		case <-closed:
+		}
+		close(ch)
+		done()
+
+		// Remove the waiters that are still registered for the other
+		// streams
+		db.Lock()
+		db.Unwait(ch, opts.keys...)
+		db.Unlock()
+		if !woken {
+			return nil, nil
+		}
+	}
+}
+
+var xread = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	xreadFn)
+
+func xreadFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := parseRead("xread", args, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// The $ IDs are the last IDs of the streams when first read
+	ids := make([]types.StreamID, len(opts.ids))
+	last := make([]bool, len(opts.ids))
+	for i, s := range opts.ids {
+		switch s {
+		case "$":
+			last[i] = true
+		case ">":
+			return nil, cmd.ErrXReadID
+		default:
+			if ids[i], err = parseID(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return blockRead(conn, opts, false, func(db srv.DB, keys []srv.Key) (interface{}, error) {
+		var ret []interface{}
+		for i, k := range keys {
+			if k == nil {
+				continue
+			}
+			v, err := streamVal(k)
+			if err != nil {
+				return nil, err
+			}
+			if last[i] {
+				ids[i] = v.LastID()
+			}
+			start, ok := ids[i].Next()
+			if !ok {
+				continue
+			}
+			if ents := v.XRange(start, types.MaxStreamID, opts.count); len(ents) > 0 {
+				ret = append(ret, []interface{}{opts.keys[i], entriesReply(ents)})
+			}
+		}
+		// Once read, the missing streams are read from the start
+		for i := range last {
+			last[i] = false
+		}
+		if ret == nil {
+			return nil, nil
+		}
+		return ret, nil
+	})
+}
+
+var xreadgroup = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 6,
+		MaxArgs: -1,
+	},
+	xreadgroupFn)
+
+func xreadgroupFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := parseRead("xreadgroup", args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The > IDs read the entries never delivered to the group, the others
+	// read the pending entries of the consumer.
+	ids := make([]types.StreamID, len(opts.ids))
+	next := make([]bool, len(opts.ids))
+	for i, s := range opts.ids {
+		switch s {
+		case "$":
+			return nil, cmd.ErrXReadGroupID
+		case ">":
+			next[i] = true
+		default:
+			if ids[i], err = parseID(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return blockRead(conn, opts, true, func(db srv.DB, keys []srv.Key) (interface{}, error) {
+		now := time.Now()
+		var ret []interface{}
+		for i, k := range keys {
+			nm := opts.keys[i]
+			_, g, err := groupVal(k, nm, opts.group)
+			if err == cmd.ErrInvalidValType {
+				return nil, err
+			}
+			if err != nil {
+				return nil, fmt.Errorf(cmd.NoGroupFmt+" in XREADGROUP with GROUP option", nm, opts.group)
+			}
+			createConsumer(db, nm, g, opts.consumer, now)
+
+			// The history is returned even if empty
+			if !next[i] {
+				ents := g.ReadPending(opts.consumer, ids[i], opts.count, now)
+				ret = append(ret, []interface{}{nm, entriesReply(ents)})
+				continue
+			}
+
+			ents := g.Read(opts.consumer, opts.count, opts.noack, now)
+			if len(ents) == 0 {
+				continue
+			}
+			// The reads are appended as the claims of the entries
+			if !opts.noack {
+				delivered := make([]types.StreamID, len(ents))
+				for j, e := range ents {
+					delivered[j] = e.ID
+				}
+				propagateClaims(db, nm, g, delivered)
+			}
+			srv.DefaultServer.Propagate(db.Index(), "xgroup", "setid", nm, opts.group, g.LastID().String())
+			ret = append(ret, []interface{}{nm, entriesReply(ents)})
+		}
+		if ret == nil {
+			return nil, nil
+		}
+		return ret, nil
+	})
+}
@@ -0,0 +1,486 @@
+package streams
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// groupVal returns the consumer group of the stream value of the key. The
+// key may be nil, in which case a NOGROUP error is returned.
+func groupVal(k srv.Key, name, group string) (types.Stream, types.StreamGroup, error) {
+	if k == nil {
+		return nil, nil, fmt.Errorf(cmd.NoGroupFmt, name, group)
+	}
+	v, err := streamVal(k)
+	if err != nil {
+		return nil, nil, err
+	}
+	g, ok := v.Group(group)
+	if !ok {
+		return nil, nil, fmt.Errorf(cmd.NoGroupFmt, name, group)
+	}
+	return v, g, nil
+}
+
+// unixMs returns the Unix time t in milliseconds.
+func unixMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// propagateClaims appends to the append-only file the pending entries ids
+// of the group, as XCLAIM commands that restore their consumer, delivery
+// time and delivery count.
+func propagateClaims(db srv.DB, name string, g types.StreamGroup, ids []types.StreamID) {
+	for _, id := range ids {
+		ps := g.Pending(id, id, 1, "", 0, time.Time{})
+		if len(ps) == 0 {
+			continue
+		}
+		p := ps[0]
+		srv.DefaultServer.Propagate(db.Index(), "xclaim", name, g.Name(), p.Consumer, "0", id.String(),
+			"time", strconv.FormatInt(unixMs(p.Delivered), 10),
+			"retrycount", strconv.FormatInt(p.Count, 10), "force", "justid")
+	}
+}
+
+// createConsumer creates the consumer of the group if it does not exist,
+// and appends its creation to the append-only file, since the commands
+// that create consumers implicitly may not be appended.
+func createConsumer(db srv.DB, name string, g types.StreamGroup, consumer string, now time.Time) {
+	if g.CreateConsumer(consumer, now) {
+		srv.DefaultServer.Propagate(db.Index(), "xgroup", "createconsumer", name, g.Name(), consumer)
+	}
+}
+
+var xack = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	xackFn)
+
+func xackFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ids, err := parseIDs(args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return int64(0), nil
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v, err := streamVal(k)
+	if err != nil {
+		return nil, err
+	}
+	g, ok := v.Group(args[1])
+	if !ok {
+		return int64(0), nil
+	}
+	return g.Ack(ids...), nil
+}
+
+var xgroup = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	xgroupFn)
+
+func xgroupFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sub := strings.ToLower(args[0])
+	var minArgs, maxArgs int
+	switch sub {
+	case "create":
+		minArgs, maxArgs = 4, 5
+	case "setid", "createconsumer", "delconsumer":
+		minArgs, maxArgs = 4, 4
+	case "destroy":
+		minArgs, maxArgs = 3, 3
+	default:
+		return nil, fmt.Errorf("ERR Unknown XGROUP subcommand '%s'", args[0])
+	}
+	if len(args) < minArgs || len(args) > maxArgs {
+		return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "xgroup|"+sub)
+	}
+	mkstream := false
+	if len(args) == 5 {
+		if strings.ToLower(args[4]) != "mkstream" {
+			return nil, cmd.ErrSyntax
+		}
+		mkstream = true
+	}
+
+	// The DB must be exclusively locked to create the key
+	name, group := args[1], args[2]
+	k, unl := db.XLockGetKey(name, srv.NoKeyNone)
+	defer unl()
+
+	var v types.Stream
+	if k != nil {
+		k.Lock()
+		defer k.Unlock()
+
+		var err error
+		if v, err = streamVal(k); err != nil {
+			return nil, err
+		}
+	} else if !mkstream {
+		return nil, cmd.ErrXGroupNoKey
+	}
+
+	// The commands are appended with the $ ID resolved
+	prop := append([]string{"xgroup"}, args...)
+	var ret interface{}
+	switch sub {
+	case "create", "setid":
+		var last types.StreamID
+		if v != nil {
+			last = v.LastID()
+		}
+		id := last
+		if args[3] != "$" {
+			var err error
+			if id, err = parseID(args[3]); err != nil {
+				return nil, err
+			}
+		}
+		prop[4] = id.String()
+
+		if sub == "create" {
+			if v == nil {
+				v = types.NewStream()
+				db.SetKey(srv.NewKey(name, v))
+			}
+			if !v.CreateGroup(group, id) {
+				return nil, cmd.ErrBusyGroup
+			}
+		} else {
+			g, ok := v.Group(group)
+			if !ok {
+				return nil, fmt.Errorf(cmd.NoGroupFmt, name, group)
+			}
+			g.SetLastID(id)
+		}
+		ret = cmd.OKVal
+
+	case "destroy":
+		if !v.DestroyGroup(group) {
+			return false, nil
+		}
+		ret = true
+
+	case "createconsumer", "delconsumer":
+		g, ok := v.Group(group)
+		if !ok {
+			return nil, fmt.Errorf(cmd.NoGroupFmt, name, group)
+		}
+		if sub == "delconsumer" {
+			ret = g.DelConsumer(args[3])
+			break
+		}
+		if !g.CreateConsumer(args[3], time.Now()) {
+			return false, nil
+		}
+		ret = true
+	}
+
+	db.Notify(srv.NotifyStream, "xgroup-"+sub, name)
+	srv.DefaultServer.Propagate(db.Index(), prop...)
+	return ret, nil
+}
+
+var xpending = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 8,
+	},
+	xpendingFn)
+
+func xpendingFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	var (
+		minIdle    time.Duration
+		start, end types.StreamID
+		count      int64
+		consumer   string
+		err        error
+	)
+	ext := len(args) > 2
+	if ext {
+		rest := args[2:]
+		if strings.ToLower(rest[0]) == "idle" {
+			if len(rest) < 2 {
+				return nil, cmd.ErrSyntax
+			}
+			ms, err := strconv.ParseInt(rest[1], 10, 64)
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			minIdle = time.Duration(ms) * time.Millisecond
+			rest = rest[2:]
+		}
+		if len(rest) < 3 || len(rest) > 4 {
+			return nil, cmd.ErrSyntax
+		}
+		if start, err = parseRangeID(rest[0], true); err != nil {
+			return nil, err
+		}
+		if end, err = parseRangeID(rest[1], false); err != nil {
+			return nil, err
+		}
+		if count, err = strconv.ParseInt(rest[2], 10, 64); err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		if count < 0 {
+			count = 0
+		}
+		if len(rest) == 4 {
+			consumer = rest[3]
+		}
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k != nil {
+		k.RLock()
+		defer k.RUnlock()
+	}
+	_, g, err := groupVal(k, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if ext {
+		ps := g.Pending(start, end, count, consumer, minIdle, now)
+		ret := make([]interface{}, len(ps))
+		for i, p := range ps {
+			ret[i] = []interface{}{p.ID.String(), p.Consumer, unixMs(now) - unixMs(p.Delivered), p.Count}
+		}
+		return ret, nil
+	}
+
+	// Summary form, with the number of pending entries, the smallest and
+	// greatest IDs, and the number of entries of each consumer.
+	ps := g.Pending(types.StreamID{}, types.MaxStreamID, -1, "", 0, now)
+	if len(ps) == 0 {
+		return []interface{}{int64(0), nil, nil, nil}, nil
+	}
+	var cons []interface{}
+	for _, c := range g.Consumers() {
+		if c.Pending > 0 {
+			cons = append(cons, []string{c.Name, strconv.FormatInt(c.Pending, 10)})
+		}
+	}
+	return []interface{}{int64(len(ps)), ps[0].ID.String(), ps[len(ps)-1].ID.String(), cons}, nil
+}
+
+// claimOpts parses the options of XCLAIM, after the IDs.
+func claimOpts(args []string, now time.Time) (types.StreamClaim, *types.StreamID, error) {
+	opts := types.StreamClaim{RetryCount: -1}
+	var lastID *types.StreamID
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch opt {
+		case "force":
+			opts.Force = true
+			continue
+		case "justid":
+			opts.JustID = true
+			continue
+		case "idle", "time", "retrycount", "lastid":
+		default:
+			return opts, nil, cmd.ErrSyntax
+		}
+
+		if i+1 >= len(args) {
+			return opts, nil, cmd.ErrSyntax
+		}
+		i++
+		if opt == "lastid" {
+			id, err := parseID(args[i])
+			if err != nil {
+				return opts, nil, err
+			}
+			lastID = &id
+			continue
+		}
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return opts, nil, cmd.ErrNotInteger
+		}
+		switch opt {
+		case "idle":
+			opts.Delivered = now.Add(-time.Duration(n) * time.Millisecond)
+		case "time":
+			opts.Delivered = time.Unix(0, n*int64(time.Millisecond))
+		case "retrycount":
+			if n < 0 {
+				return opts, nil, cmd.ErrNotPositive
+			}
+			opts.RetryCount = n
+		}
+	}
+	return opts, lastID, nil
+}
+
+var xclaim = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    5,
+		MaxArgs:    -1,
+		IntIndices: []int{3},
+	},
+	xclaimFn)
+
+func xclaimFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// The IDs are followed by the options
+	var ids []types.StreamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, ok := types.ParseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, cmd.ErrStreamID
+	}
+	now := time.Now()
+	opts, lastID, err := claimOpts(args[i:], now)
+	if err != nil {
+		return nil, err
+	}
+	if ints[0] > 0 {
+		opts.MinIdle = time.Duration(ints[0]) * time.Millisecond
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k != nil {
+		k.Lock()
+		defer k.Unlock()
+	}
+	v, g, err := groupVal(k, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if lastID != nil && g.LastID().Less(*lastID) {
+		g.SetLastID(*lastID)
+		srv.DefaultServer.Propagate(db.Index(), "xgroup", "setid", args[0], args[1], lastID.String())
+	}
+	createConsumer(db, args[0], g, args[2], now)
+	ents := g.Claim(args[2], ids, opts, now)
+
+	// The claims depend on the current time, so the claimed entries are
+	// appended with their delivery, and the pending entries of the deleted
+	// entries are acknowledged.
+	claimed := make([]types.StreamID, len(ents))
+	for i, e := range ents {
+		claimed[i] = e.ID
+	}
+	propagateClaims(db, args[0], g, claimed)
+	ack := []string{"xack", args[0], args[1]}
+	for _, id := range ids {
+		if len(v.XRange(id, id, 1)) == 0 {
+			ack = append(ack, id.String())
+		}
+	}
+	if len(ack) > 3 {
+		srv.DefaultServer.Propagate(db.Index(), ack...)
+	}
+
+	if opts.JustID {
+		return idsReply(ents), nil
+	}
+	return entriesReply(ents), nil
+}
+
+var xautoclaim = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    5,
+		MaxArgs:    8,
+		IntIndices: []int{3},
+	},
+	xautoclaimFn)
+
+func xautoclaimFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	start, err := parseRangeID(args[4], true)
+	if err != nil {
+		return nil, err
+	}
+	opts := types.StreamClaim{RetryCount: -1}
+	if ints[0] > 0 {
+		opts.MinIdle = time.Duration(ints[0]) * time.Millisecond
+	}
+	count := int64(100)
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "count":
+			if i+1 >= len(args) {
+				return nil, cmd.ErrSyntax
+			}
+			i++
+			if count, err = strconv.ParseInt(args[i], 10, 64); err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			if count <= 0 {
+				return nil, cmd.ErrNotPositive
+			}
+		case "justid":
+			opts.JustID = true
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k != nil {
+		k.Lock()
+		defer k.Unlock()
+	}
+	_, g, err := groupVal(k, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	createConsumer(db, args[0], g, args[2], now)
+	next, ents, deleted := g.AutoClaim(args[2], start, count, opts, now)
+
+	// Like XCLAIM, the claims are appended with their delivery
+	claimed := make([]types.StreamID, len(ents))
+	for i, e := range ents {
+		claimed[i] = e.ID
+	}
+	propagateClaims(db, args[0], g, claimed)
+	dels := make([]string, len(deleted))
+	for i, id := range deleted {
+		dels[i] = id.String()
+	}
+	if len(dels) > 0 {
+		srv.DefaultServer.Propagate(db.Index(), append([]string{"xack", args[0], args[1]}, dels...)...)
+	}
+
+	var claimedReply interface{} = entriesReply(ents)
+	if opts.JustID {
+		claimedReply = idsReply(ents)
+	}
+	return []interface{}{next.String(), claimedReply, dels}, nil
+}
@@ -0,0 +1,523 @@
+package streams
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func init() {
+	cmd.Register("xack", xack)
+	cmd.Register("xadd", xadd)
+	cmd.Register("xautoclaim", xautoclaim)
+	cmd.Register("xclaim", xclaim)
+	cmd.Register("xdel", xdel)
+	cmd.Register("xgroup", xgroup)
+	cmd.Register("xlen", xlen)
+	cmd.Register("xpending", xpending)
+	cmd.Register("xrange", xrange)
+	cmd.Register("xread", xread)
+	cmd.Register("xreadgroup", xreadgroup)
+	cmd.Register("xrevrange", xrevrange)
+	cmd.Register("xsetid", xsetid)
+	cmd.Register("xtrim", xtrim)
+}
+
+// streamVal returns the stream value of the key.
+func streamVal(k srv.Key) (types.Stream, error) {
+	v, ok := k.Val().(types.Stream)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	return v, nil
+}
+
+// parseID parses a stream ID argument, whose sequence number is 0 if it
+// is omitted.
+func parseID(s string) (types.StreamID, error) {
+	id, ok := types.ParseStreamID(s, 0)
+	if !ok {
+		return id, cmd.ErrStreamID
+	}
+	return id, nil
+}
+
+// parseIDs parses the stream ID arguments.
+func parseIDs(args []string) ([]types.StreamID, error) {
+	ids := make([]types.StreamID, len(args))
+	for i, s := range args {
+		id, err := parseID(s)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// parseRangeID parses the start or end of a range of IDs. The - and + IDs
+// are the smallest and greatest IDs, and an ID prefixed with ( is
+// exclusive. The sequence number of the start is 0 if it is omitted, and
+// that of the end is the greatest one.
+func parseRangeID(s string, start bool) (types.StreamID, error) {
+	switch s {
+	case "-":
+		return types.StreamID{}, nil
+	case "+":
+		return types.MaxStreamID, nil
+	}
+
+	excl := strings.HasPrefix(s, "(")
+	if excl {
+		s = s[1:]
+	}
+	var seq uint64
+	if !start {
+		seq = math.MaxUint64
+	}
+	id, ok := types.ParseStreamID(s, seq)
+	if ok && excl {
+		if start {
+			id, ok = id.Next()
+		} else {
+			id, ok = id.Prev()
+		}
+	}
+	if !ok {
+		return id, cmd.ErrStreamID
+	}
+	return id, nil
+}
+
+// entriesReply returns the reply for the stream entries, an array of ID
+// and fields pairs.
+func entriesReply(ents []types.StreamEntry) []interface{} {
+	ret := make([]interface{}, len(ents))
+	for i, e := range ents {
+		ret[i] = []interface{}{e.ID.String(), e.Fields}
+	}
+	return ret
+}
+
+// idsReply returns the reply for the IDs of the stream entries.
+func idsReply(ents []types.StreamEntry) []string {
+	ret := make([]string, len(ents))
+	for i, e := range ents {
+		ret[i] = e.ID.String()
+	}
+	return ret
+}
+
+// trimOpts holds the trimming options of XADD and XTRIM.
+type trimOpts struct {
+	minID  bool
+	maxLen int64
+	id     types.StreamID
+	limit  int64
+}
+
+// parseTrim parses the trimming options at the start of args: the MAXLEN
+// or MINID strategy, its threshold and the LIMIT option. It returns nil
+// options if args does not start with a strategy, and the remaining
+// arguments.
+func parseTrim(args []string) (*trimOpts, []string, error) {
+	if len(args) == 0 {
+		return nil, args, nil
+	}
+	opts := &trimOpts{}
+	switch strings.ToLower(args[0]) {
+	case "maxlen":
+	case "minid":
+		opts.minID = true
+	default:
+		return nil, args, nil
+	}
+	args = args[1:]
+
+	// The trimming is always exact, but LIMIT is only allowed with ~
+	var approx bool
+	if len(args) > 0 && (args[0] == "=" || args[0] == "~") {
+		approx = args[0] == "~"
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, nil, cmd.ErrSyntax
+	}
+	if opts.minID {
+		id, err := parseID(args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.id = id
+	} else {
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil, nil, cmd.ErrNotInteger
+		}
+		if n < 0 {
+			return nil, nil, cmd.ErrNotPositive
+		}
+		opts.maxLen = n
+	}
+	args = args[1:]
+
+	if len(args) >= 2 && strings.ToLower(args[0]) == "limit" {
+		if !approx {
+			return nil, nil, cmd.ErrTrimLimit
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, nil, cmd.ErrNotInteger
+		}
+		if n < 0 {
+			return nil, nil, cmd.ErrNotPositive
+		}
+		opts.limit = n
+		args = args[2:]
+	}
+	return opts, args, nil
+}
+
+// trim trims the stream as specified by the options, and returns the
+// number of entries removed.
+func (o *trimOpts) trim(v types.Stream) int64 {
+	if o.minID {
+		return v.XTrimMinID(o.id, o.limit)
+	}
+	return v.XTrimMaxLen(o.maxLen, o.limit)
+}
+
+// nextID returns the ID of the entry added by XADD to a stream whose last
+// ID is last, as specified by s: * for an automatic ID, ms-* for an
+// automatic sequence number, or an explicit ID.
+func nextID(s string, last types.StreamID, now time.Time) (types.StreamID, error) {
+	if s == "*" {
+		id := types.StreamID{Ms: uint64(now.UnixNano() / int64(time.Millisecond))}
+		if last.Less(id) {
+			return id, nil
+		}
+		id, ok := last.Next()
+		if !ok {
+			return id, cmd.ErrXAddIDSmall
+		}
+		return id, nil
+	}
+
+	if strings.HasSuffix(s, "-*") {
+		ms, err := strconv.ParseUint(s[:len(s)-2], 10, 64)
+		if err != nil {
+			return types.StreamID{}, cmd.ErrStreamID
+		}
+		id := types.StreamID{Ms: ms}
+		switch {
+		case ms < last.Ms || ms == last.Ms && last.Seq == math.MaxUint64:
+			return id, cmd.ErrXAddIDSmall
+		case ms == last.Ms:
+			id.Seq = last.Seq + 1
+		}
+		return id, nil
+	}
+
+	id, err := parseID(s)
+	if err != nil {
+		return id, err
+	}
+	if id == (types.StreamID{}) {
+		return id, cmd.ErrXAddIDZero
+	}
+	if !last.Less(id) {
+		return id, cmd.ErrXAddIDSmall
+	}
+	return id, nil
+}
+
+// wake wakes all the connections blocked reading the stream key, which
+// read it again once it is unlocked. The DB must be exclusively locked.
+func wake(db srv.DB, name string) {
+	for ch := db.NextStreamWaiter(name); ch != nil; ch = db.NextStreamWaiter(name) {
+		// Was the waiting channel closed? If not, signal the new entries.
+		if sendch, ok := <-ch; ok {
+			sendch <- []string{name}
+		}
+	}
+}
+
+var xadd = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 4,
+		MaxArgs: -1,
+	},
+	xaddFn)
+
+func xaddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	var nomk bool
+	var trim *trimOpts
+	rest := args[1:]
+	for len(rest) > 0 {
+		if strings.ToLower(rest[0]) == "nomkstream" {
+			nomk = true
+			rest = rest[1:]
+			continue
+		}
+		t, r, err := parseTrim(rest)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			break
+		}
+		trim, rest = t, r
+	}
+	if len(rest) < 3 || len(rest)%2 == 0 {
+		return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "xadd")
+	}
+
+	// The DB must be exclusively locked to create the key and to unblock
+	// the waiters.
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	var v types.Stream
+	var last types.StreamID
+	if k != nil {
+		k.Lock()
+		defer k.Unlock()
+
+		var err error
+		if v, err = streamVal(k); err != nil {
+			return nil, err
+		}
+		last = v.LastID()
+	}
+	id, err := nextID(rest[0], last, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		if nomk {
+			return nil, nil
+		}
+		v = types.NewStream()
+		db.SetKey(srv.NewKey(args[0], v))
+	}
+
+	v.XAdd(id, append([]string(nil), rest[1:]...)...)
+	db.Notify(srv.NotifyStream, "xadd", args[0])
+	if trim != nil && trim.trim(v) > 0 {
+		db.Notify(srv.NotifyStream, "xtrim", args[0])
+	}
+
+	// Append the command with the actual ID, before the reads of the
+	// unblocked waiters. The trimming is exact, so it can be replayed.
+	idix := len(args) - len(rest)
+	prop := make([]string, 0, len(args)+1)
+	prop = append(prop, "xadd")
+	prop = append(prop, args[:idix]...)
+	prop = append(prop, id.String())
+	prop = append(prop, rest[1:]...)
+	srv.DefaultServer.Propagate(db.Index(), prop...)
+
+	wake(db, args[0])
+	return id.String(), nil
+}
+
+var xdel = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	xdelFn)
+
+func xdelFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ids, err := parseIDs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return int64(0), nil
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v, err := streamVal(k)
+	if err != nil {
+		return nil, err
+	}
+	n := v.XDel(ids...)
+	if n > 0 {
+		db.Notify(srv.NotifyStream, "xdel", args[0])
+	}
+	return n, nil
+}
+
+var xlen = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	srv.NoKeyNone,
+	xlenFn)
+
+func xlenFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if k == nil {
+		return int64(0), nil
+	}
+	k.RLock()
+	defer k.RUnlock()
+
+	v, err := streamVal(k)
+	if err != nil {
+		return nil, err
+	}
+	return v.XLen(), nil
+}
+
+var xrange = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 5,
+	},
+	xrangeFn)
+
+func xrangeFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return xrangeGeneric(db, args, false)
+}
+
+var xrevrange = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 5,
+	},
+	xrevrangeFn)
+
+func xrevrangeFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return xrangeGeneric(db, args, true)
+}
+
+// xrangeGeneric implements XRANGE and XREVRANGE, whose arguments are the
+// key, the start and end IDs (in reverse order for XREVRANGE) and the
+// optional COUNT.
+func xrangeGeneric(db srv.DB, args []string, rev bool) (interface{}, error) {
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseRangeID(startArg, true)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseRangeID(endArg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int64(-1)
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToLower(args[3]) != "count" {
+			return nil, cmd.ErrSyntax
+		}
+		if count, err = strconv.ParseInt(args[4], 10, 64); err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		if count <= 0 {
+			return nil, nil
+		}
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return []interface{}{}, nil
+	}
+	k.RLock()
+	defer k.RUnlock()
+
+	v, err := streamVal(k)
+	if err != nil {
+		return nil, err
+	}
+	if rev {
+		return entriesReply(v.XRevRange(end, start, count)), nil
+	}
+	return entriesReply(v.XRange(start, end, count)), nil
+}
+
+var xsetid = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	xsetidFn)
+
+func xsetidFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	id, err := parseID(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return nil, cmd.ErrNoSuchKey
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v, err := streamVal(k)
+	if err != nil {
+		return nil, err
+	}
+	if !v.SetLastID(id) {
+		return nil, cmd.ErrXSetIDSmall
+	}
+	db.Notify(srv.NotifyStream, "xsetid", args[0])
+	return cmd.OKVal, nil
+}
+
+var xtrim = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 6,
+	},
+	xtrimFn)
+
+func xtrimFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	trim, rest, err := parseTrim(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if trim == nil || len(rest) > 0 {
+		return nil, cmd.ErrSyntax
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return int64(0), nil
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v, err := streamVal(k)
+	if err != nil {
+		return nil, err
+	}
+	n := trim.trim(v)
+	if n > 0 {
+		db.Notify(srv.NotifyStream, "xtrim", args[0])
+	}
+	return n, nil
+}
@@ -0,0 +1,473 @@
+package strings
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// maxBits is the maximum number of bits of a string, which is 512MB.
+const maxBits = 512 * 1024 * 1024 * 8
+
+// parseBitOffset parses a bit offset, which must be in the range of the
+// bits of a string.
+func parseBitOffset(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n >= maxBits {
+		return 0, cmd.ErrBitOffset
+	}
+	return n, nil
+}
+
+// parseBit parses a bit value, and returns false if it is not 0 or 1.
+func parseBit(s string) (int, bool) {
+	switch s {
+	case "0":
+		return 0, true
+	case "1":
+		return 1, true
+	}
+	return 0, false
+}
+
+// parseBitRange parses the start and end indices and the BYTE or BIT
+// unit of the BITCOUNT and BITPOS commands. It returns true if the indices
+// are bit indices.
+func parseBitRange(args []string, start, end *int64) (bool, error) {
+	for i, p := range []*int64{start, end} {
+		if i >= len(args) {
+			break
+		}
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return false, cmd.ErrNotInteger
+		}
+		*p = n
+	}
+	if len(args) < 3 {
+		return false, nil
+	}
+	switch strings.ToLower(args[2]) {
+	case "byte":
+		return false, nil
+	case "bit":
+		return true, nil
+	}
+	return false, cmd.ErrSyntax
+}
+
+var bitcount = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 4,
+	},
+	srv.NoKeyDefaultVal,
+	bitcountFn)
+
+func bitcountFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// The range must have both a start and an end
+	if len(args) == 2 {
+		return nil, cmd.ErrSyntax
+	}
+	start, end := int64(0), int64(-1)
+	bit, err := parseBitRange(args[1:], &start, &end)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		return v.BitCount(start, end, bit), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var bitpos = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 5,
+	},
+	srv.NoKeyDefaultVal,
+	bitposFn)
+
+func bitposFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	b, ok := parseBit(args[1])
+	if !ok {
+		return nil, cmd.ErrBitPosBit
+	}
+	start, end := int64(0), int64(-1)
+	bit, err := parseBitRange(args[2:], &start, &end)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		return v.BitPos(b, start, end, len(args) > 3, bit), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var bitop = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	bitopFn)
+
+func bitopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	op := strings.ToLower(args[0])
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 3 {
+			return nil, cmd.ErrBitOpNot
+		}
+	default:
+		return nil, cmd.ErrSyntax
+	}
+
+	// The destination may also be a source key
+	keys, unl := cmd.LockKeys(db, true, args[1:]...)
+	defer unl()
+
+	vals := make([]string, len(keys)-1)
+	for i, k := range keys[1:] {
+		if k == nil {
+			continue
+		}
+		v, ok := k.Val().(types.String)
+		if !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+		vals[i] = v.Get()
+	}
+	res := bitOp(op, vals)
+
+	// Like SDIFFSTORE, the destination is replaced, and deleted if the
+	// result is empty.
+	if keys[0] != nil {
+		db.DelKey(args[1])
+		if len(res) == 0 {
+			db.Notify(srv.NotifyGeneric, "del", args[1])
+		}
+	}
+	if len(res) > 0 {
+		db.SetKey(srv.NewKey(args[1], types.NewIncString(res)))
+		db.Notify(srv.NotifyString, "set", args[1])
+	}
+	return int64(len(res)), nil
+}
+
+// bitOp returns the result of the bitwise operation op on the values. The
+// shorter values are padded with 0 bytes.
+func bitOp(op string, vals []string) string {
+	var n int
+	for _, v := range vals {
+		if len(v) > n {
+			n = len(v)
+		}
+	}
+
+	b := make([]byte, n)
+	for i := range b {
+		var x byte
+		for j, v := range vals {
+			var c byte
+			if i < len(v) {
+				c = v[i]
+			}
+			switch {
+			case j == 0:
+				x = c
+			case op == "and":
+				x &= c
+			case op == "or":
+				x |= c
+			case op == "xor":
+				x ^= c
+			}
+		}
+		if op == "not" {
+			x = ^x
+		}
+		b[i] = x
+	}
+	return string(b)
+}
+
+var getbit = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	getbitFn)
+
+func getbitFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ofs, err := parseBitOffset(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		return int64(v.GetBit(ofs)), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var setbit = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	setbitFn)
+
+func setbitFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Validate the arguments before the key is created
+	ofs, err := parseBitOffset(args[1])
+	if err != nil {
+		return nil, err
+	}
+	bit, ok := parseBit(args[2])
+	if !ok {
+		return nil, cmd.ErrBitValue
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateString)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		old := v.SetBit(ofs, bit)
+		db.Notify(srv.NotifyString, "setbit", args[0])
+		return int64(old), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// bitfieldOp is an operation of the BITFIELD command.
+type bitfieldOp struct {
+	// op is get, set or incrby.
+	op string
+
+	// The integer type, and its offset in bits.
+	signed bool
+	width  uint
+	ofs    int64
+
+	// val is the value to set, or the increment.
+	val int64
+
+	// overflow is the overflow policy: wrap, sat or fail.
+	overflow string
+}
+
+// parseBitfield parses the operations of the BITFIELD command, and returns
+// true if some of them modify the value.
+func parseBitfield(args []string) ([]*bitfieldOp, bool, error) {
+	var ops []*bitfieldOp
+	var write bool
+	overflow := "wrap"
+	for i := 0; i < len(args); {
+		switch op := strings.ToLower(args[i]); op {
+		case "overflow":
+			if i+1 >= len(args) {
+				return nil, false, cmd.ErrSyntax
+			}
+			overflow = strings.ToLower(args[i+1])
+			if overflow != "wrap" && overflow != "sat" && overflow != "fail" {
+				return nil, false, cmd.ErrOverflowType
+			}
+			i += 2
+
+		case "get", "set", "incrby":
+			n := 3
+			if op == "get" {
+				n = 2
+			}
+			if i+n >= len(args) {
+				return nil, false, cmd.ErrSyntax
+			}
+			bop := &bitfieldOp{op: op, overflow: overflow}
+			if err := bop.parseType(args[i+1], args[i+2]); err != nil {
+				return nil, false, err
+			}
+			if op != "get" {
+				v, err := strconv.ParseInt(args[i+3], 10, 64)
+				if err != nil {
+					return nil, false, cmd.ErrNotInteger
+				}
+				bop.val = v
+				write = true
+			}
+			ops = append(ops, bop)
+			i += n + 1
+
+		default:
+			return nil, false, cmd.ErrSyntax
+		}
+	}
+	return ops, write, nil
+}
+
+// parseType parses the integer type of the operation, such as i16 or u8,
+// and its offset, in bits or in multiples of the width if it starts with
+// "#".
+func (op *bitfieldOp) parseType(typ, ofs string) error {
+	if len(typ) < 2 {
+		return cmd.ErrBitfieldType
+	}
+	maxWidth := uint64(63)
+	switch typ[0] {
+	case 'i', 'I':
+		op.signed = true
+		maxWidth = 64
+	case 'u', 'U':
+	default:
+		return cmd.ErrBitfieldType
+	}
+	w, err := strconv.ParseUint(typ[1:], 10, 8)
+	if err != nil || w < 1 || w > maxWidth {
+		return cmd.ErrBitfieldType
+	}
+	op.width = uint(w)
+
+	mul := int64(1)
+	if strings.HasPrefix(ofs, "#") {
+		mul = int64(op.width)
+		ofs = ofs[1:]
+	}
+	n, err := strconv.ParseInt(ofs, 10, 64)
+	if err != nil || n < 0 || n > maxBits {
+		return cmd.ErrBitOffset
+	}
+	op.ofs = n * mul
+	if op.ofs+int64(op.width) > maxBits {
+		return cmd.ErrBitOffset
+	}
+	return nil
+}
+
+// result returns the value v plus incr, handled according to the overflow
+// policy if it does not fit the integer type of the operation, and false
+// if the operation fails. Like Redis, the value of an unsigned SET is
+// interpreted as an unsigned 64-bit integer.
+func (op *bitfieldOp) result(v, incr int64) (int64, bool) {
+	one := big.NewInt(1)
+	min, max := new(big.Int), new(big.Int)
+	if op.signed {
+		max.Lsh(one, op.width-1)
+		min.Neg(max)
+		max.Sub(max, one)
+	} else {
+		max.Lsh(one, op.width)
+		max.Sub(max, one)
+	}
+
+	r := big.NewInt(v)
+	if op.op == "set" && !op.signed {
+		r.SetUint64(uint64(v))
+	}
+	r.Add(r, big.NewInt(incr))
+	if r.Cmp(min) >= 0 && r.Cmp(max) <= 0 {
+		return r.Int64(), true
+	}
+
+	switch op.overflow {
+	case "fail":
+		return 0, false
+	case "sat":
+		if r.Sign() > 0 {
+			return max.Int64(), true
+		}
+		return min.Int64(), true
+	}
+
+	// Wrap around, keeping the low bits
+	mod := new(big.Int).Lsh(one, op.width)
+	r.Mod(r, mod)
+	if r.Cmp(max) > 0 {
+		r.Sub(r, mod)
+	}
+	return r.Int64(), true
+}
+
+var bitfield = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	bitfieldFn)
+
+func bitfieldFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ops, write, err := parseBitfield(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The key is created only if an operation may modify it
+	noKey := srv.NoKeyDefaultVal
+	if write {
+		noKey = srv.NoKeyCreateString
+	}
+	k, unl := db.LockGetKey(args[0], noKey)
+	defer unl()
+
+	if write {
+		k.Lock()
+		defer k.Unlock()
+	} else {
+		k.RLock()
+		defer k.RUnlock()
+	}
+
+	v, ok := k.Val().(types.String)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	// Operations that fail because of an overflow return nil
+	ret := make([]interface{}, len(ops))
+	var changed bool
+	for i, op := range ops {
+		old := v.GetBits(op.ofs, op.width, op.signed)
+		switch op.op {
+		case "get":
+			ret[i] = old
+		case "set":
+			if nv, ok := op.result(op.val, 0); ok {
+				v.SetBits(op.ofs, op.width, nv)
+				ret[i] = old
+				changed = true
+			}
+		case "incrby":
+			if nv, ok := op.result(old, op.val); ok {
+				v.SetBits(op.ofs, op.width, nv)
+				ret[i] = nv
+				changed = true
+			}
+		}
+	}
+	if changed {
+		db.Notify(srv.NotifyString, "setbit", args[0])
+	}
+	return ret, nil
+}
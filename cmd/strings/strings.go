@@ -1,6 +1,12 @@
 package strings
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/srv"
 	"github.com/PuerkitoBio/gred/types"
@@ -8,47 +14,65 @@ import (
 
 func init() {
 	cmd.Register("append", appendƒ)
+	cmd.Register("bitcount", bitcount)
+	cmd.Register("bitfield", bitfield)
+	cmd.Register("bitop", bitop)
+	cmd.Register("bitpos", bitpos)
 	cmd.Register("decr", decr)
 	cmd.Register("decrby", decrby)
 	cmd.Register("get", get)
+	cmd.Register("getbit", getbit)
+	cmd.Register("getdel", getdel)
+	cmd.Register("getex", getex)
 	cmd.Register("getrange", getrange)
 	cmd.Register("getset", getset)
 	cmd.Register("incr", incr)
 	cmd.Register("incrby", incrby)
 	cmd.Register("incrbyfloat", incrbyfloat)
+	cmd.Register("mget", mget)
+	cmd.Register("mset", mset)
+	cmd.Register("msetnx", msetnx)
 	cmd.Register("set", set)
+	cmd.Register("setbit", setbit)
+	cmd.Register("setnx", setnx)
 	cmd.Register("setrange", setrange)
 	cmd.Register("strlen", strlen)
 }
 
-var appendƒ = cmd.NewSingleKeyCmd(
+var appendƒ = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
 		MaxArgs: 2,
 	},
-	srv.NoKeyCreateString,
 	appendFn)
 
-func appendFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func appendFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateString)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.String); ok {
-		return v.Append(args[1]), nil
+		ret := v.Append(args[1])
+		db.Notify(srv.NotifyString, "append", args[0])
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
 
-var decr = cmd.NewSingleKeyCmd(
+var decr = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
 		MaxArgs: 1,
 	},
-	srv.NoKeyCreateStringInt,
 	decrFn)
 
-func decrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func decrFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateStringInt)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
@@ -56,6 +80,7 @@ func decrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.IncString); ok {
 		val, ok := v.Decr()
 		if ok {
+			db.Notify(srv.NotifyString, "incrby", args[0])
 			return val, nil
 		}
 		return nil, cmd.ErrNotInteger
@@ -63,16 +88,18 @@ func decrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	return nil, cmd.ErrInvalidValType
 }
 
-var decrby = cmd.NewSingleKeyCmd(
+var decrby = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:    2,
 		MaxArgs:    2,
 		IntIndices: []int{1},
 	},
-	srv.NoKeyCreateStringInt,
 	decrbyFn)
 
-func decrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func decrbyFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateStringInt)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
@@ -80,6 +107,7 @@ func decrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 	if v, ok := v.(types.IncString); ok {
 		val, ok := v.DecrBy(ints[0])
 		if ok {
+			db.Notify(srv.NotifyString, "incrby", args[0])
 			return val, nil
 		}
 		return nil, cmd.ErrNotInteger
@@ -110,6 +138,71 @@ func getFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{
 	return nil, cmd.ErrInvalidValType
 }
 
+var getdel = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	getdelFn)
+
+func getdelFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+	if k == nil {
+		return nil, nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		ret := v.Get()
+		db.DelKey(args[0])
+		db.Notify(srv.NotifyGeneric, "del", args[0])
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var getex = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	getexFn)
+
+func getexFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sa, err := parseSetArgs(args[1:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+	if k == nil {
+		return nil, nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		switch {
+		case !sa.expAt.IsZero():
+			db.ExpireKey(k, sa.expAt)
+			db.Notify(srv.NotifyGeneric, "expire", args[0])
+		case sa.persist:
+			if k.Abort() {
+				db.Notify(srv.NotifyGeneric, "persist", args[0])
+			}
+		}
+		return v.Get(), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
 var getrange = cmd.NewSingleKeyCmd(
 	&cmd.ArgDef{
 		MinArgs:    3,
@@ -130,35 +223,41 @@ func getrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (inter
 	return nil, cmd.ErrInvalidValType
 }
 
-var getset = cmd.NewSingleKeyCmd(
+var getset = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
 		MaxArgs: 2,
 	},
-	srv.NoKeyCreateString,
 	getsetFn)
 
-func getsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func getsetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateString)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.String); ok {
 		k.Abort()
-		return v.GetSet(args[1]), nil
+		ret := v.GetSet(args[1])
+		db.Notify(srv.NotifyString, "set", args[0])
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
 
-var incr = cmd.NewSingleKeyCmd(
+var incr = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
 		MaxArgs: 1,
 	},
-	srv.NoKeyCreateStringInt,
 	incrFn)
 
-func incrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func incrFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateStringInt)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
@@ -166,6 +265,7 @@ func incrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.IncString); ok {
 		val, ok := v.Incr()
 		if ok {
+			db.Notify(srv.NotifyString, "incrby", args[0])
 			return val, nil
 		}
 		return nil, cmd.ErrNotInteger
@@ -173,16 +273,18 @@ func incrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	return nil, cmd.ErrInvalidValType
 }
 
-var incrby = cmd.NewSingleKeyCmd(
+var incrby = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:    2,
 		MaxArgs:    2,
 		IntIndices: []int{1},
 	},
-	srv.NoKeyCreateStringInt,
 	incrbyFn)
 
-func incrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func incrbyFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateStringInt)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
@@ -190,6 +292,7 @@ func incrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 	if v, ok := v.(types.IncString); ok {
 		val, ok := v.IncrBy(ints[0])
 		if ok {
+			db.Notify(srv.NotifyString, "incrby", args[0])
 			return val, nil
 		}
 		return nil, cmd.ErrNotInteger
@@ -197,16 +300,18 @@ func incrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 	return nil, cmd.ErrInvalidValType
 }
 
-var incrbyfloat = cmd.NewSingleKeyCmd(
+var incrbyfloat = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:      2,
 		MaxArgs:      2,
 		FloatIndices: []int{1},
 	},
-	srv.NoKeyCreateStringInt,
 	incrbyfloatFn)
 
-func incrbyfloatFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func incrbyfloatFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateStringInt)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
@@ -214,6 +319,7 @@ func incrbyfloatFn(k srv.Key, args []string, ints []int64, floats []float64) (in
 	if v, ok := v.(types.IncString); ok {
 		val, ok := v.IncrByFloat(floats[0])
 		if ok {
+			db.Notify(srv.NotifyString, "incrbyfloat", args[0])
 			return val, nil
 		}
 		return nil, cmd.ErrNotFloat
@@ -221,29 +327,291 @@ func incrbyfloatFn(k srv.Key, args []string, ints []int64, floats []float64) (in
 	return nil, cmd.ErrInvalidValType
 }
 
-// TODO : This doesn't handle the extra optional args (EX, NX, PX)
-var set = cmd.NewSingleKeyCmd(
+var mget = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	mgetFn)
+
+func mgetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	keys, unl := cmd.LockKeys(db, false, args...)
+	defer unl()
+
+	// Keys that do not exist or do not hold a string return nil
+	ret := make([]interface{}, len(keys))
+	for i, k := range keys {
+		if k == nil {
+			continue
+		}
+		if v, ok := k.Val().(types.String); ok {
+			ret[i] = v.Get()
+		}
+	}
+	return ret, nil
+}
+
+// validateMSet returns the function that makes sure the arguments of the
+// MSET and MSETNX commands are key-value pairs.
+func validateMSet(name string) cmd.ArgFn {
+	return func(args []string, ints []int64, floats []float64) error {
+		if len(args)%2 != 0 {
+			return fmt.Errorf(cmd.WrongNumberOfArgsFmt, name)
+		}
+		return nil
+	}
+}
+
+var mset = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    -1,
+		ValidateFn: validateMSet("mset"),
+	},
+	msetFn)
+
+func msetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	keys, unl := cmd.LockKeys(db, true, msetNames(args)...)
+	defer unl()
+
+	msetKeys(db, keys, args)
+	return cmd.OKVal, nil
+}
+
+var msetnx = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    -1,
+		ValidateFn: validateMSet("msetnx"),
+	},
+	msetnxFn)
+
+func msetnxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	keys, unl := cmd.LockKeys(db, true, msetNames(args)...)
+	defer unl()
+
+	// No key is set if any of them exists
+	for _, k := range keys {
+		if k != nil {
+			return false, nil
+		}
+	}
+	msetKeys(db, keys, args)
+	return true, nil
+}
+
+// msetNames returns the names of the keys of the key-value pairs.
+func msetNames(pairs []string) []string {
+	names := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		names = append(names, pairs[i])
+	}
+	return names
+}
+
+// msetKeys sets the values of the key-value pairs, for the keys returned
+// by cmd.LockKeys, creating the keys that do not exist and replacing the
+// keys that hold another type of value. The database and the existing keys
+// must be exclusively locked.
+func msetKeys(db srv.DB, keys []srv.Key, pairs []string) {
+	created := make(map[string]srv.Key)
+	for i, k := range keys {
+		nm, val := pairs[2*i], pairs[2*i+1]
+		if c := created[nm]; c != nil {
+			k = c
+		} else if k != nil {
+			if _, ok := k.Val().(types.String); !ok {
+				db.DelKey(nm)
+				k = nil
+			}
+		}
+		if k == nil {
+			// The new keys are not visible to other connections until the
+			// database is unlocked, and a name may be repeated.
+			k = srv.NewKey(nm, types.NewIncString(""))
+			db.SetKey(k)
+			created[nm] = k
+		}
+		k.Val().(types.String).Set(val)
+		k.Abort()
+		db.Notify(srv.NotifyString, "set", nm)
+	}
+}
+
+var set = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
-		MaxArgs: 2,
+		MaxArgs: -1,
 	},
-	srv.NoKeyCreateString,
 	setFn)
 
-func setFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
-	k.Lock()
-	defer k.Unlock()
+func setFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sa, err := parseSetArgs(args[2:], false)
+	if err != nil {
+		return nil, err
+	}
 
-	v := k.Val()
-	if v, ok := v.(types.String); ok {
+	// NX, XX and GET depend on the existence of the key, so it is created
+	// only once it is known that it does not exist. A key of another type
+	// is replaced, under the exclusive lock too.
+	var k srv.Key
+	var unl func()
+	if !sa.nx && !sa.xx && !sa.get {
+		k, unl = db.LockGetKey(args[0], srv.NoKeyCreateString)
+		if _, ok := k.Val().(types.String); !ok {
+			unl()
+			unl = nil
+		}
+	}
+	if unl == nil {
+		k, unl = db.XLockGetKey(args[0], srv.NoKeyNone)
+	}
+	defer unl()
+
+	var old interface{}
+	if k != nil {
+		if _, ok := k.Val().(types.String); !ok {
+			if sa.get {
+				return nil, cmd.ErrInvalidValType
+			}
+			if sa.nx {
+				return nil, nil
+			}
+			nk := srv.NewKey(args[0], types.NewIncString(""))
+			if t, ok := k.Deadline(); ok && sa.keepTTL {
+				nk.Expire(t)
+			}
+			db.SetKey(nk)
+			k = nk
+		}
+		k.Lock()
+		defer k.Unlock()
+
+		if sa.get {
+			old = k.Val().(types.String).Get()
+		}
+		if sa.nx {
+			return old, nil
+		}
+	} else {
+		if sa.xx {
+			return nil, nil
+		}
+		k = srv.NewKey(args[0], types.NewIncString(""))
+		db.SetKey(k)
+		k.Lock()
+		defer k.Unlock()
+	}
+
+	k.Val().(types.String).Set(args[1])
+	db.Notify(srv.NotifyString, "set", args[0])
+	switch {
+	case !sa.expAt.IsZero():
+		db.ExpireKey(k, sa.expAt)
+		db.Notify(srv.NotifyGeneric, "expire", args[0])
+	case !sa.keepTTL:
 		k.Abort()
-		v.Set(args[1])
-		return cmd.OKVal, nil
 	}
-	return nil, cmd.ErrInvalidValType
+	if sa.get {
+		return old, nil
+	}
+	return cmd.OKVal, nil
+}
+
+// setArgs holds the options of the SET and GETEX commands.
+type setArgs struct {
+	nx, xx, get, keepTTL, persist bool
+
+	// expAt is the expiration time set by the EX, PX, EXAT and PXAT
+	// options, or the zero time if none is set.
+	expAt time.Time
+}
+
+// parseSetArgs parses the options of the SET command, or of the GETEX
+// command if getex is true. The options that set the expiration are
+// mutually exclusive, and so are NX and XX.
+func parseSetArgs(opts []string, getex bool) (*setArgs, error) {
+	errExp := cmd.ErrSetExpireTime
+	if getex {
+		errExp = cmd.ErrGetExExpireTime
+	}
+
+	sa := &setArgs{}
+	for i := 0; i < len(opts); i++ {
+		ttl := sa.keepTTL || sa.persist || !sa.expAt.IsZero()
+		switch opt := strings.ToLower(opts[i]); {
+		case opt == "nx" && !getex && !sa.xx:
+			sa.nx = true
+		case opt == "xx" && !getex && !sa.nx:
+			sa.xx = true
+		case opt == "get" && !getex:
+			sa.get = true
+		case opt == "keepttl" && !getex && !ttl:
+			sa.keepTTL = true
+		case opt == "persist" && getex && !ttl:
+			sa.persist = true
+		case (opt == "ex" || opt == "px" || opt == "exat" || opt == "pxat") && !ttl && i+1 < len(opts):
+			t, err := expireTime(opt, opts[i+1], errExp)
+			if err != nil {
+				return nil, err
+			}
+			sa.expAt = t
+			i++
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+	return sa, nil
 }
 
-var setrange = cmd.NewSingleKeyCmd(
+// expireTime returns the expiration time set by the option opt, one of
+// EX, PX, EXAT and PXAT, with the value s. errExp is returned if the value
+// is not positive or too large.
+func expireTime(opt, s string, errExp error) (time.Time, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, cmd.ErrNotInteger
+	}
+	unit := time.Second
+	if opt[0] == 'p' {
+		unit = time.Millisecond
+	}
+	if n <= 0 || n > math.MaxInt64/int64(unit) {
+		return time.Time{}, errExp
+	}
+
+	dur := time.Duration(n) * unit
+	if strings.HasSuffix(opt, "at") {
+		return time.Unix(0, int64(dur)), nil
+	}
+	// The expiration time must be representable in Unix nanoseconds
+	now := time.Now()
+	if int64(dur) > math.MaxInt64-now.UnixNano() {
+		return time.Time{}, errExp
+	}
+	return now.Add(dur), nil
+}
+
+var setnx = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	setnxFn)
+
+func setnxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+	if k != nil {
+		return false, nil
+	}
+
+	db.SetKey(srv.NewKey(args[0], types.NewIncString(args[1])))
+	db.Notify(srv.NotifyString, "set", args[0])
+	return true, nil
+}
+
+var setrange = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:    3,
 		MaxArgs:    3,
@@ -255,16 +623,22 @@ var setrange = cmd.NewSingleKeyCmd(
 			return nil
 		},
 	},
-	srv.NoKeyDefaultVal,
 	setrangeFn)
 
-func setrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+func setrangeFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
 	k.Lock()
 	defer k.Unlock()
 
 	v := k.Val()
 	if v, ok := v.(types.String); ok {
-		return v.SetRange(ints[0], args[2]), nil
+		ret := v.SetRange(ints[0], args[2])
+		if ret > 0 {
+			db.Notify(srv.NotifyString, "setrange", args[0])
+		}
+		return ret, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
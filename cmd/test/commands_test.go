@@ -2,16 +2,21 @@ package test
 
 import (
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/PuerkitoBio/gred/cmd"
 	_ "github.com/PuerkitoBio/gred/cmd/connection"
 	_ "github.com/PuerkitoBio/gred/cmd/hashes"
+	_ "github.com/PuerkitoBio/gred/cmd/hyperloglog"
 	_ "github.com/PuerkitoBio/gred/cmd/keys"
 	_ "github.com/PuerkitoBio/gred/cmd/lists"
+	_ "github.com/PuerkitoBio/gred/cmd/pubsub"
 	_ "github.com/PuerkitoBio/gred/cmd/server"
 	_ "github.com/PuerkitoBio/gred/cmd/sets"
+	_ "github.com/PuerkitoBio/gred/cmd/streams"
 	_ "github.com/PuerkitoBio/gred/cmd/strings"
+	_ "github.com/PuerkitoBio/gred/cmd/zsets"
 	"github.com/PuerkitoBio/gred/srv"
 )
 
@@ -23,6 +28,11 @@ func TestCommand(t *testing.T) {
 		err  error
 	}{
 		// Server commands
+		{"config", []string{"set", "timeout", "10"}, cmd.OKVal, nil},
+		{"config", []string{"get", "timeout"}, []string{"timeout", "10"}, nil},
+		{"config", []string{"set", "timeout", "0"}, cmd.OKVal, nil},
+		{"config", []string{"get", "nosuchparam"}, []string{}, nil},
+		{"config", []string{"rewrite"}, nil, cmd.ErrNoConfigFile},
 		{"flushall", []string{}, cmd.OKVal, nil},
 		{"flushdb", []string{}, cmd.OKVal, nil},
 
@@ -31,6 +41,28 @@ func TestCommand(t *testing.T) {
 		{"ping", []string{}, cmd.PongVal, nil},
 		{"select", []string{"0"}, cmd.OKVal, nil},
 		{"quit", []string{}, nil, cmd.ErrQuit},
+		{"client", []string{"id"}, int64(1), nil},
+		{"client", []string{"getname"}, nil, nil},
+		{"client", []string{"setname", "conn 1"}, nil, cmd.ErrClientName},
+		{"client", []string{"setname", "conn1"}, cmd.OKVal, nil},
+		{"client", []string{"GETNAME"}, "conn1", nil},
+		{"client", []string{"setname", ""}, cmd.OKVal, nil},
+		{"client", []string{"getname"}, nil, nil},
+		{"client", []string{"list", "id", "0"}, nil, cmd.ErrInvalidClientID},
+		{"client", []string{"list", "size", "1"}, nil, cmd.ErrSyntax},
+		{"client", []string{"kill", "127.0.0.1:1"}, nil, cmd.ErrNoSuchClient},
+		{"client", []string{"kill", "id", "0"}, nil, cmd.ErrClientIDZero},
+		{"client", []string{"kill", "id", "1", "skipme"}, nil, cmd.ErrSyntax},
+		{"client", []string{"pause", "x"}, nil, cmd.ErrTimeoutNotInt},
+		{"client", []string{"pause", "-1"}, nil, cmd.ErrTimeoutNegative},
+		{"client", []string{"pause", "10", "read"}, nil, cmd.ErrSyntax},
+		{"client", []string{"unpause"}, cmd.OKVal, nil},
+
+		// Pub/Sub commands
+		{"publish", []string{"ch", "msg"}, int64(0), nil},
+		{"pubsub", []string{"channels"}, []string{}, nil},
+		{"pubsub", []string{"numsub", "ch"}, []interface{}{"ch", int64(0)}, nil},
+		{"pubsub", []string{"numpat"}, int64(0), nil},
 
 		// First create a key for all types
 		{"set", []string{"s", "val"}, cmd.OKVal, nil},
@@ -42,6 +74,51 @@ func TestCommand(t *testing.T) {
 		{"sadd", []string{"t", "v1"}, int64(1), nil},
 		{"type", []string{"t"}, "set", nil},
 
+		// Keys iteration
+		{"keys", []string{"[sx]"}, []string{"s"}, nil},
+		{"keys", []string{"nokey*"}, []string{}, nil},
+		{"scan", []string{"0", "match", "h", "count", "100"}, []interface{}{"0", []string{"h"}}, nil},
+		{"scan", []string{"0", "count", "100", "type", "list"}, []interface{}{"0", []string{"l"}}, nil},
+		{"scan", []string{"0", "match", "nokey*", "count", "100"}, []interface{}{"0", []string{}}, nil},
+		{"scan", []string{"x"}, nil, cmd.ErrInvalidCursor},
+		{"scan", []string{"0", "count", "0"}, nil, cmd.ErrSyntax},
+
+		// Keys that change name or database
+		{"set", []string{"rk", "v"}, cmd.OKVal, nil},
+		{"expire", []string{"rk", "100"}, true, nil},
+		{"rename", []string{"rk", "rk2"}, cmd.OKVal, nil},
+		{"exists", []string{"rk"}, false, nil},
+		{"get", []string{"rk2"}, "v", nil},
+		{"ttl", []string{"rk2"}, int64(99), nil},
+		{"rename", []string{"rk2", "rk2"}, cmd.OKVal, nil},
+		{"rename", []string{"nokey", "rk"}, nil, cmd.ErrNoSuchKey},
+		{"renamenx", []string{"rk2", "s"}, false, nil},
+		{"renamenx", []string{"rk2", "rk"}, true, nil},
+		{"renamenx", []string{"nokey", "rk"}, nil, cmd.ErrNoSuchKey},
+		{"copy", []string{"rk", "rk3"}, true, nil},
+		{"copy", []string{"rk", "rk3"}, false, nil},
+		{"copy", []string{"h", "rk3", "replace"}, true, nil},
+		{"type", []string{"rk3"}, "hash", nil},
+		{"copy", []string{"rk", "rk"}, nil, cmd.ErrSameObject},
+		{"copy", []string{"rk", "rk", "db", "16"}, nil, cmd.ErrInvalidDBIndex},
+		{"copy", []string{"rk", "rk", "db"}, nil, cmd.ErrSyntax},
+		{"copy", []string{"rk", "rk", "x"}, nil, cmd.ErrSyntax},
+		{"copy", []string{"rk", "rk", "db", "1"}, true, nil},
+		{"move", []string{"rk", "1"}, false, nil},
+		{"move", []string{"rk3", "1"}, true, nil},
+		{"exists", []string{"rk3"}, false, nil},
+		{"move", []string{"rk", "0"}, nil, cmd.ErrSameObject},
+		{"move", []string{"rk", "16"}, nil, cmd.ErrInvalidDBIndex},
+		{"swapdb", []string{"0", "1"}, cmd.OKVal, nil},
+		{"type", []string{"rk3"}, "hash", nil},
+		{"ttl", []string{"rk"}, int64(99), nil},
+		{"exists", []string{"s"}, false, nil},
+		{"swapdb", []string{"1", "0"}, cmd.OKVal, nil},
+		{"exists", []string{"s"}, true, nil},
+		{"swapdb", []string{"0", "16"}, nil, cmd.ErrInvalidDBIndex},
+		{"del", []string{"rk"}, int64(1), nil},
+		{"scan", []string{"0", "count"}, nil, cmd.ErrSyntax},
+
 		// Strings
 		{"append", []string{"k", "a"}, int64(1), nil},
 		{"append", []string{"k", "bcd"}, int64(4), nil},
@@ -58,7 +135,148 @@ func TestCommand(t *testing.T) {
 		{"getset", []string{"z", "efg"}, "", nil},
 		{"del", []string{"z"}, int64(1), nil},
 		{"getset", []string{"h", "efg"}, nil, cmd.ErrInvalidValType},
-		{"set", []string{"h", "efg"}, nil, cmd.ErrInvalidValType},
+		{"hset", []string{"sh", "f", "v"}, true, nil},
+		{"set", []string{"sh", "v", "nx"}, nil, nil},
+		{"set", []string{"sh", "v", "get"}, nil, cmd.ErrInvalidValType},
+		{"set", []string{"sh", "v", "xx"}, cmd.OKVal, nil},
+		{"type", []string{"sh"}, "string", nil},
+		{"hset", []string{"sh2", "f", "v"}, true, nil},
+		{"set", []string{"sh2", "v"}, cmd.OKVal, nil},
+		{"get", []string{"sh2"}, "v", nil},
+		{"hset", []string{"sh3", "f", "v"}, true, nil},
+		{"setex", []string{"sh3", "10", "v"}, cmd.OKVal, nil},
+		{"get", []string{"sh3"}, "v", nil},
+		{"del", []string{"sh", "sh2", "sh3"}, int64(3), nil},
+		{"set", []string{"sk", "v", "nx", "px", "30000"}, cmd.OKVal, nil},
+		{"ttl", []string{"sk"}, int64(29), nil},
+		{"set", []string{"sk", "w", "NX", "PX", "30000"}, nil, nil},
+		{"set", []string{"sk", "w", "nx", "get"}, "v", nil},
+		{"set", []string{"sk", "w", "xx", "get", "keepttl"}, "v", nil},
+		{"ttl", []string{"sk"}, int64(29), nil},
+		{"set", []string{"sk", "x"}, cmd.OKVal, nil},
+		{"ttl", []string{"sk"}, int64(-1), nil},
+		{"set", []string{"nosk", "x", "xx"}, nil, nil},
+		{"exists", []string{"nosk"}, false, nil},
+		{"set", []string{"nosk", "x", "get"}, nil, nil},
+		{"get", []string{"nosk"}, "x", nil},
+		{"del", []string{"nosk"}, int64(1), nil},
+		{"set", []string{"sk", "v", "nx", "xx"}, nil, cmd.ErrSyntax},
+		{"set", []string{"sk", "v", "ex", "10", "px", "10"}, nil, cmd.ErrSyntax},
+		{"set", []string{"sk", "v", "keepttl", "ex", "10"}, nil, cmd.ErrSyntax},
+		{"set", []string{"sk", "v", "ex"}, nil, cmd.ErrSyntax},
+		{"set", []string{"sk", "v", "persist"}, nil, cmd.ErrSyntax},
+		{"set", []string{"sk", "v", "ex", "0"}, nil, cmd.ErrSetExpireTime},
+		{"set", []string{"sk", "v", "ex", "9000000000"}, nil, cmd.ErrSetExpireTime},
+		{"set", []string{"sk", "v", "ex", "x"}, nil, cmd.ErrNotInteger},
+		{"set", []string{"h", "v", "get"}, nil, cmd.ErrInvalidValType},
+		{"get", []string{"sk"}, "x", nil},
+		{"set", []string{"sk", "v", "exat", "1"}, cmd.OKVal, nil},
+		{"exists", []string{"sk"}, false, nil},
+		{"setnx", []string{"sk", "a"}, true, nil},
+		{"setnx", []string{"sk", "b"}, false, nil},
+		{"get", []string{"sk"}, "a", nil},
+		{"getex", []string{"sk", "ex", "100"}, "a", nil},
+		{"ttl", []string{"sk"}, int64(99), nil},
+		{"getex", []string{"sk", "persist"}, "a", nil},
+		{"ttl", []string{"sk"}, int64(-1), nil},
+		{"getex", []string{"sk"}, "a", nil},
+		{"getex", []string{"sk", "nx"}, nil, cmd.ErrSyntax},
+		{"getex", []string{"sk", "ex", "10", "px", "10"}, nil, cmd.ErrSyntax},
+		{"getex", []string{"sk", "px", "0"}, nil, cmd.ErrGetExExpireTime},
+		{"getex", []string{"sk", "px", "9000000000000"}, nil, cmd.ErrGetExExpireTime},
+		{"getex", []string{"nosk", "ex", "10"}, nil, nil},
+		{"getex", []string{"h"}, nil, cmd.ErrInvalidValType},
+		{"getdel", []string{"sk"}, "a", nil},
+		{"getdel", []string{"sk"}, nil, nil},
+		{"getdel", []string{"h"}, nil, cmd.ErrInvalidValType},
+		{"mset", []string{"m1", "a", "m2", "b", "m1", "c"}, cmd.OKVal, nil},
+		{"mget", []string{"m1", "nokey", "m2", "h", "m1"}, []interface{}{"c", nil, "b", nil, "c"}, nil},
+		{"hset", []string{"mh", "f", "v"}, true, nil},
+		{"mset", []string{"m1", "d", "mh", "x", "mh", "y"}, cmd.OKVal, nil},
+		{"type", []string{"mh"}, "string", nil},
+		{"mget", []string{"m1", "mh"}, []interface{}{"d", "y"}, nil},
+		{"msetnx", []string{"m3", "a", "m1", "b"}, false, nil},
+		{"exists", []string{"m3"}, false, nil},
+		{"msetnx", []string{"m3", "a", "m4", "b", "m3", "c"}, true, nil},
+		{"mget", []string{"m3", "m4"}, []interface{}{"c", "b"}, nil},
+		{"del", []string{"m1", "m2", "m3", "m4", "mh"}, int64(5), nil},
+		{"set", []string{"bk", "foobar"}, cmd.OKVal, nil},
+		{"bitcount", []string{"bk"}, int64(26), nil},
+		{"bitcount", []string{"bk", "1", "1"}, int64(6), nil},
+		{"bitcount", []string{"bk", "5", "30", "bit"}, int64(17), nil},
+		{"bitcount", []string{"bk", "1"}, nil, cmd.ErrSyntax},
+		{"bitcount", []string{"bk", "0", "x"}, nil, cmd.ErrNotInteger},
+		{"bitcount", []string{"nobk"}, int64(0), nil},
+		{"bitcount", []string{"h"}, nil, cmd.ErrInvalidValType},
+		{"getbit", []string{"bk", "1"}, int64(1), nil},
+		{"getbit", []string{"bk", "0"}, int64(0), nil},
+		{"getbit", []string{"bk", "1000"}, int64(0), nil},
+		{"getbit", []string{"nobk", "1"}, int64(0), nil},
+		{"getbit", []string{"bk", "-1"}, nil, cmd.ErrBitOffset},
+		{"setbit", []string{"bk2", "7", "1"}, int64(0), nil},
+		{"setbit", []string{"bk2", "7", "0"}, int64(1), nil},
+		{"setbit", []string{"bk2", "15", "1"}, int64(0), nil},
+		{"get", []string{"bk2"}, "\x00\x01", nil},
+		{"setbit", []string{"bk2", "1", "2"}, nil, cmd.ErrBitValue},
+		{"setbit", []string{"bk2", "4294967296", "1"}, nil, cmd.ErrBitOffset},
+		{"setbit", []string{"h", "1", "1"}, nil, cmd.ErrInvalidValType},
+		{"bitpos", []string{"bk2", "1"}, int64(15), nil},
+		{"bitpos", []string{"bk2", "0"}, int64(0), nil},
+		{"bitpos", []string{"bk2", "1", "0", "0"}, int64(-1), nil},
+		{"bitpos", []string{"bk2", "1", "8", "15", "bit"}, int64(15), nil},
+		{"bitpos", []string{"nobk", "1"}, int64(-1), nil},
+		{"bitpos", []string{"nobk", "0"}, int64(0), nil},
+		{"bitpos", []string{"bk2", "2"}, nil, cmd.ErrBitPosBit},
+		{"set", []string{"bk3", "abc"}, cmd.OKVal, nil},
+		{"set", []string{"bk4", "\x0f\x0f"}, cmd.OKVal, nil},
+		{"bitop", []string{"and", "bkr", "bk3", "bk4"}, int64(3), nil},
+		{"get", []string{"bkr"}, "\x01\x02\x00", nil},
+		{"bitop", []string{"OR", "bkr", "bk3", "bk4", "nobk"}, int64(3), nil},
+		{"get", []string{"bkr"}, "ooc", nil},
+		{"bitop", []string{"xor", "bkr", "bkr", "bk3"}, int64(3), nil},
+		{"get", []string{"bkr"}, "\x0e\x0d\x00", nil},
+		{"bitop", []string{"not", "bkr", "bk4"}, int64(2), nil},
+		{"get", []string{"bkr"}, "\xf0\xf0", nil},
+		{"bitop", []string{"not", "bkr", "bk3", "bk4"}, nil, cmd.ErrBitOpNot},
+		{"bitop", []string{"nand", "bkr", "bk3"}, nil, cmd.ErrSyntax},
+		{"bitop", []string{"and", "bkr", "bk3", "h"}, nil, cmd.ErrInvalidValType},
+		{"bitop", []string{"and", "bkr", "nobk"}, int64(0), nil},
+		{"exists", []string{"bkr"}, false, nil},
+		{"bitfield", []string{"bf", "set", "u8", "0", "255", "get", "u8", "0", "get", "i8", "0"}, []interface{}{int64(0), int64(255), int64(-1)}, nil},
+		{"bitfield", []string{"bf", "incrby", "u8", "0", "10", "overflow", "sat", "incrby", "u8", "0", "300"}, []interface{}{int64(9), int64(255)}, nil},
+		{"bitfield", []string{"bf", "overflow", "fail", "incrby", "u8", "0", "1", "set", "i8", "#1", "-128", "incrby", "i8", "#1", "-1"}, []interface{}{nil, int64(0), nil}, nil},
+		{"bitfield", []string{"bf", "incrby", "i8", "8", "-1"}, []interface{}{int64(127)}, nil},
+		{"bitfield", []string{"bf", "get", "u4", "4", "get", "i64", "0"}, []interface{}{int64(15), int64(-36310271995674624)}, nil},
+		{"bitfield", []string{"bf", "get", "u64", "0"}, nil, cmd.ErrBitfieldType},
+		{"bitfield", []string{"bf", "get", "u8", "-1"}, nil, cmd.ErrBitOffset},
+		{"bitfield", []string{"bf", "overflow", "x"}, nil, cmd.ErrOverflowType},
+		{"bitfield", []string{"bf", "get", "u8"}, nil, cmd.ErrSyntax},
+		{"bitfield", []string{"nobf", "get", "u8", "0"}, []interface{}{int64(0)}, nil},
+		{"exists", []string{"nobf"}, false, nil},
+		{"bitfield", []string{"h", "get", "u8", "0"}, nil, cmd.ErrInvalidValType},
+		{"del", []string{"bk", "bk2", "bk3", "bk4", "bf"}, int64(5), nil},
+		{"pfadd", []string{"hll1"}, true, nil},
+		{"pfadd", []string{"hll1"}, false, nil},
+		{"pfadd", []string{"hll1", "a", "b", "c", "d", "e", "f", "g"}, true, nil},
+		{"pfadd", []string{"hll1", "a", "c"}, false, nil},
+		{"pfcount", []string{"hll1"}, int64(7), nil},
+		{"pfcount", []string{"hll1"}, int64(7), nil},
+		{"pfadd", []string{"hll2", "f", "g", "h", "i"}, true, nil},
+		{"pfcount", []string{"hll1", "hll2", "nohll"}, int64(9), nil},
+		{"pfcount", []string{"nohll"}, int64(0), nil},
+		{"pfmerge", []string{"hll3", "hll1", "hll2", "nohll"}, cmd.OKVal, nil},
+		{"pfcount", []string{"hll3"}, int64(9), nil},
+		{"pfmerge", []string{"hll1", "hll2"}, cmd.OKVal, nil},
+		{"pfcount", []string{"hll1"}, int64(9), nil},
+		{"pfmerge", []string{"hll4"}, cmd.OKVal, nil},
+		{"pfcount", []string{"hll4"}, int64(0), nil},
+		{"set", []string{"hllstr", "foo"}, cmd.OKVal, nil},
+		{"pfadd", []string{"hllstr", "a"}, nil, cmd.ErrNotHLL},
+		{"pfcount", []string{"hllstr"}, nil, cmd.ErrNotHLL},
+		{"pfcount", []string{"hll1", "hllstr"}, nil, cmd.ErrNotHLL},
+		{"pfmerge", []string{"hll1", "hllstr"}, nil, cmd.ErrNotHLL},
+		{"pfadd", []string{"h", "a"}, nil, cmd.ErrInvalidValType},
+		{"del", []string{"hll1", "hll2", "hll3", "hll4", "hllstr"}, int64(5), nil},
 		{"setrange", []string{"k", "1", "zzzz"}, int64(5), nil},
 		{"setrange", []string{"k", "10", "aa"}, int64(12), nil},
 		{"setrange", []string{"t", "10", "aa"}, nil, cmd.ErrInvalidValType},
@@ -133,6 +351,11 @@ func TestCommand(t *testing.T) {
 		{"hvals", []string{"z"}, []string{}, nil},
 		{"hvals", []string{"k"}, []string{"v1", "v2", "v3", "v4", "v5"}, nil},
 		{"hvals", []string{"s"}, nil, cmd.ErrInvalidValType},
+		{"hscan", []string{"k", "0", "match", "f[12]", "count", "100"}, []interface{}{"0", []string{"f1", "v1", "f2", "v2"}}, nil},
+		{"hscan", []string{"z", "0"}, []interface{}{"0", []string{}}, nil},
+		{"hscan", []string{"k", "x"}, nil, cmd.ErrInvalidCursor},
+		{"hscan", []string{"k", "0", "type", "hash"}, nil, cmd.ErrSyntax},
+		{"hscan", []string{"s", "0"}, nil, cmd.ErrInvalidValType},
 		{"hincrby", []string{"z", "i1", "3"}, int64(3), nil},
 		{"hincrby", []string{"k", "i1", "3"}, int64(3), nil},
 		{"hincrby", []string{"k", "i1", "-7"}, int64(-4), nil},
@@ -269,6 +492,53 @@ func TestCommand(t *testing.T) {
 		{"blpop", []string{"l1", "1"}, nil, nil},
 		{"brpop", []string{"l1", "1"}, nil, nil},
 		{"brpoplpush", []string{"l1", "l2", "1"}, nil, nil},
+		{"blpop", []string{"l1", "0.1"}, nil, nil},
+		{"brpop", []string{"l1", "-1"}, nil, cmd.ErrTimeoutNegative},
+		{"brpoplpush", []string{"l1", "l2", "-0.5"}, nil, cmd.ErrTimeoutNegative},
+		{"blpop", []string{"l1", "nan"}, nil, cmd.ErrTimeoutNotFloat},
+		{"brpop", []string{"l1", "inf"}, nil, cmd.ErrTimeoutNotFloat},
+		{"brpop", []string{"l1", "-inf"}, nil, cmd.ErrTimeoutNotFloat},
+		{"brpoplpush", []string{"l1", "l2", "9300000000"}, nil, cmd.ErrTimeoutNotFloat},
+		{"rpush", []string{"lp", "a", "b", "c", "a", "b", "c", "a"}, int64(7), nil},
+		{"lpos", []string{"lp", "a"}, int64(0), nil},
+		{"lpos", []string{"lp", "a", "rank", "2"}, int64(3), nil},
+		{"lpos", []string{"lp", "a", "RANK", "-1"}, int64(6), nil},
+		{"lpos", []string{"lp", "a", "count", "0"}, []interface{}{int64(0), int64(3), int64(6)}, nil},
+		{"lpos", []string{"lp", "a", "count", "2", "rank", "-1"}, []interface{}{int64(6), int64(3)}, nil},
+		{"lpos", []string{"lp", "a", "count", "0", "maxlen", "4"}, []interface{}{int64(0), int64(3)}, nil},
+		{"lpos", []string{"lp", "z"}, nil, nil},
+		{"lpos", []string{"lp", "z", "count", "1"}, []interface{}{}, nil},
+		{"lpos", []string{"z", "a"}, nil, nil},
+		{"lpos", []string{"lp", "a", "rank", "0"}, nil, cmd.ErrRankZero},
+		{"lpos", []string{"lp", "a", "count", "-1"}, nil, cmd.ErrCountNegative},
+		{"lpos", []string{"lp", "a", "maxlen", "-1"}, nil, cmd.ErrMaxLenNegative},
+		{"lpos", []string{"lp", "a", "size", "1"}, nil, cmd.ErrSyntax},
+		{"lpos", []string{"t", "a"}, nil, cmd.ErrInvalidValType},
+		{"lmove", []string{"lp", "lm", "left", "right"}, "a", nil},
+		{"lmove", []string{"lp", "lm", "RIGHT", "left"}, "a", nil},
+		{"lrange", []string{"lm", "0", "-1"}, []string{"a", "a"}, nil},
+		{"lmove", []string{"lp", "lp", "left", "right"}, "b", nil},
+		{"lrange", []string{"lp", "0", "-1"}, []string{"c", "a", "b", "c", "b"}, nil},
+		{"lmove", []string{"z", "lm", "left", "left"}, nil, nil},
+		{"lmove", []string{"lp", "t", "left", "left"}, nil, cmd.ErrInvalidValType},
+		{"lmove", []string{"lp", "lm", "up", "left"}, nil, cmd.ErrSyntax},
+		{"lrange", []string{"lp", "0", "-1"}, []string{"c", "a", "b", "c", "b"}, nil},
+		{"lmpop", []string{"2", "z", "lp", "left"}, []interface{}{"lp", []string{"c"}}, nil},
+		{"lmpop", []string{"2", "z", "lp", "right", "count", "3"}, []interface{}{"lp", []string{"b", "c", "b"}}, nil},
+		{"lmpop", []string{"1", "lp", "right", "COUNT", "5"}, []interface{}{"lp", []string{"a"}}, nil},
+		{"exists", []string{"lp"}, false, nil},
+		{"lmpop", []string{"1", "lp", "left"}, nil, nil},
+		{"lmpop", []string{"0", "lp", "left"}, nil, cmd.ErrNumKeys},
+		{"lmpop", []string{"1", "lp", "left", "count", "0"}, nil, cmd.ErrCountZero},
+		{"lmpop", []string{"3", "lp", "left"}, nil, cmd.ErrSyntax},
+		{"lmpop", []string{"1", "t", "left"}, nil, cmd.ErrInvalidValType},
+		{"blmove", []string{"lm", "l2", "left", "left", "0.5"}, "a", nil},
+		{"lrange", []string{"l2", "0", "-1"}, []string{"a", "d"}, nil},
+		{"blmpop", []string{"0.5", "2", "z", "lm", "left"}, []interface{}{"lm", []string{"a"}}, nil},
+		{"exists", []string{"lm"}, false, nil},
+		{"blmpop", []string{"0.1", "1", "lm", "left"}, nil, nil},
+		{"blmove", []string{"lm", "l2", "left", "left", "-1"}, nil, cmd.ErrTimeoutNegative},
+		{"blmpop", []string{"-1", "1", "lm", "left"}, nil, cmd.ErrTimeoutNegative},
 
 		// Sets
 		{"del", []string{"k"}, int64(1), nil},
@@ -286,10 +556,214 @@ func TestCommand(t *testing.T) {
 		{"sdiff", []string{"k", "k"}, []string{}, nil},
 		{"sdiff", []string{"s", "k2", "k3"}, nil, cmd.ErrInvalidValType},
 		{"sdiff", []string{"k", "l", "k3"}, nil, cmd.ErrInvalidValType},
+		{"sscan", []string{"k", "0", "match", "[ab]", "count", "100"}, []interface{}{"0", []string{"a", "b"}}, nil},
+		{"sscan", []string{"z", "0"}, []interface{}{"0", []string{}}, nil},
+		{"sscan", []string{"k", "0", "count", "x"}, nil, cmd.ErrNotInteger},
+		{"sscan", []string{"l", "0"}, nil, cmd.ErrInvalidValType},
 		{"srem", []string{"k","a","b"},int64(2),nil},
 		{"srem", []string{"k","j"},int64(0),nil},
-		{"sdiffstore", []string{"j", "k", "k2", "k3"}, int64(2), nil},
-		{"sdiffstore", []string{"k3", "k", "k2", "k3"}, int64(2), nil}, // TODO : Triggers deadlock
+		{"sdiffstore", []string{"j", "k", "k2", "k3"}, int64(1), nil},
+		{"sdiffstore", []string{"k3", "k", "k2", "k3"}, int64(1), nil},
+		{"sadd", []string{"s1", "a", "b", "c", "d"}, int64(4), nil},
+		{"sadd", []string{"s2", "c", "d", "e"}, int64(3), nil},
+		{"sadd", []string{"s3", "d", "x"}, int64(2), nil},
+		{"sinter", []string{"s1", "s2"}, []string{"c", "d"}, nil},
+		{"sinter", []string{"s1", "s2", "s3", "s1"}, []string{"d"}, nil},
+		{"sinter", []string{"s1", "nokey"}, []string{}, nil},
+		{"sinter", []string{"s1", "nokey", "l"}, nil, cmd.ErrInvalidValType},
+		{"sunion", []string{"s1", "s2", "s2"}, []string{"a", "b", "c", "d", "e"}, nil},
+		{"sunion", []string{"nokey"}, []string{}, nil},
+		{"sunion", []string{"s1", "l"}, nil, cmd.ErrInvalidValType},
+		{"sinterstore", []string{"sd", "s1", "s2"}, int64(2), nil},
+		{"smembers", []string{"sd"}, []string{"c", "d"}, nil},
+		{"sinterstore", []string{"sd", "s1", "nokey"}, int64(0), nil},
+		{"exists", []string{"sd"}, false, nil},
+		{"sinterstore", []string{"sd", "s1", "l"}, nil, cmd.ErrInvalidValType},
+		{"sunionstore", []string{"sd", "s2", "s3"}, int64(4), nil},
+		{"sunionstore", []string{"s3", "s3", "s2", "s3"}, int64(4), nil},
+		{"smembers", []string{"s3"}, []string{"c", "d", "e", "x"}, nil},
+		{"smove", []string{"s1", "s2", "a"}, true, nil},
+		{"smove", []string{"s1", "s2", "a"}, false, nil},
+		{"sismember", []string{"s2", "a"}, true, nil},
+		{"smove", []string{"nokey", "s2", "a"}, false, nil},
+		{"smove", []string{"s1", "l", "b"}, nil, cmd.ErrInvalidValType},
+		{"smove", []string{"l", "s1", "b"}, nil, cmd.ErrInvalidValType},
+		{"smove", []string{"s1", "s1", "b"}, true, nil},
+		{"smove", []string{"s1", "s1", "z"}, false, nil},
+		{"sadd", []string{"s4", "z"}, int64(1), nil},
+		{"smove", []string{"s4", "s5", "z"}, true, nil},
+		{"exists", []string{"s4"}, false, nil},
+		{"smembers", []string{"s5"}, []string{"z"}, nil},
+		{"srem", []string{"s5", "z"}, int64(1), nil},
+		{"exists", []string{"s5"}, false, nil},
+		{"spop", []string{"s1", "0"}, []string{}, nil},
+		{"spop", []string{"s1", "-1"}, nil, cmd.ErrNotPositive},
+		{"spop", []string{"s1", "x"}, nil, cmd.ErrNotInteger},
+		{"spop", []string{"s1", "10"}, []string{"b", "c", "d"}, nil},
+		{"exists", []string{"s1"}, false, nil},
+		{"spop", []string{"s1"}, nil, nil},
+		{"spop", []string{"s1", "2"}, []string{}, nil},
+		{"spop", []string{"l"}, nil, cmd.ErrInvalidValType},
+		{"sadd", []string{"s6", "q"}, int64(1), nil},
+		{"spop", []string{"s6"}, "q", nil},
+		{"exists", []string{"s6"}, false, nil},
+		{"srandmember", []string{"s2", "10"}, []string{"a", "c", "d", "e"}, nil},
+		{"srandmember", []string{"s2", "0"}, []string{}, nil},
+		{"srandmember", []string{"s2", "x"}, nil, cmd.ErrNotInteger},
+		{"srandmember", []string{"s6"}, nil, nil},
+		{"srandmember", []string{"s6", "-5"}, []string{}, nil},
+		{"srandmember", []string{"l"}, nil, cmd.ErrInvalidValType},
+		{"sadd", []string{"s6", "q"}, int64(1), nil},
+		{"srandmember", []string{"s6"}, "q", nil},
+		{"srandmember", []string{"s6", "-3"}, []string{"q", "q", "q"}, nil},
+		{"del", []string{"s2", "s3", "s6", "sd"}, int64(4), nil},
+
+		// Streams
+		{"xadd", []string{"xs", "1-1", "f1", "v1"}, "1-1", nil},
+		{"xadd", []string{"xs", "1-*", "f2", "v2"}, "1-2", nil},
+		{"xadd", []string{"xs", "2", "f3", "v3"}, "2-0", nil},
+		{"xadd", []string{"xs", "2-0", "f", "v"}, nil, cmd.ErrXAddIDSmall},
+		{"xadd", []string{"xs", "0-0", "f", "v"}, nil, cmd.ErrXAddIDZero},
+		{"xadd", []string{"xs", "x-1", "f", "v"}, nil, cmd.ErrStreamID},
+		{"xadd", []string{"xs", "maxlen", "2", "limit", "1", "3", "f", "v"}, nil, cmd.ErrTrimLimit},
+		{"xadd", []string{"noxs", "nomkstream", "1-1", "f", "v"}, nil, nil},
+		{"exists", []string{"noxs"}, false, nil},
+		{"xadd", []string{"s", "1-1", "f", "v"}, nil, cmd.ErrInvalidValType},
+		{"type", []string{"xs"}, "stream", nil},
+		{"xlen", []string{"xs"}, int64(3), nil},
+		{"xlen", []string{"noxs"}, int64(0), nil},
+		{"xlen", []string{"s"}, nil, cmd.ErrInvalidValType},
+		{"xrange", []string{"xs", "-", "+"}, []interface{}{
+			[]interface{}{"1-1", []string{"f1", "v1"}},
+			[]interface{}{"1-2", []string{"f2", "v2"}},
+			[]interface{}{"2-0", []string{"f3", "v3"}},
+		}, nil},
+		{"xrange", []string{"xs", "1", "1"}, []interface{}{
+			[]interface{}{"1-1", []string{"f1", "v1"}},
+			[]interface{}{"1-2", []string{"f2", "v2"}},
+		}, nil},
+		{"xrange", []string{"xs", "(1-1", "+", "count", "1"}, []interface{}{
+			[]interface{}{"1-2", []string{"f2", "v2"}},
+		}, nil},
+		{"xrange", []string{"xs", "-", "+", "count", "0"}, nil, nil},
+		{"xrange", []string{"xs", "-", "+", "count"}, nil, cmd.ErrSyntax},
+		{"xrange", []string{"xs", "x", "+"}, nil, cmd.ErrStreamID},
+		{"xrange", []string{"noxs", "-", "+"}, []interface{}{}, nil},
+		{"xrevrange", []string{"xs", "+", "-", "count", "2"}, []interface{}{
+			[]interface{}{"2-0", []string{"f3", "v3"}},
+			[]interface{}{"1-2", []string{"f2", "v2"}},
+		}, nil},
+		{"xread", []string{"count", "1", "streams", "xs", "noxs", "1-1", "0"}, []interface{}{
+			[]interface{}{"xs", []interface{}{[]interface{}{"1-2", []string{"f2", "v2"}}}},
+		}, nil},
+		{"xread", []string{"streams", "xs", "$"}, nil, nil},
+		{"xread", []string{"streams", "xs", ">"}, nil, cmd.ErrXReadID},
+		{"xread", []string{"block", "-1", "streams", "xs", "0"}, nil, cmd.ErrTimeoutNegative},
+		{"xread", []string{"streams", "s", "0"}, nil, cmd.ErrInvalidValType},
+		{"xgroup", []string{"create", "xs", "g1", "0"}, cmd.OKVal, nil},
+		{"xgroup", []string{"create", "xs", "g1", "$"}, nil, cmd.ErrBusyGroup},
+		{"xgroup", []string{"create", "noxs", "g1", "$"}, nil, cmd.ErrXGroupNoKey},
+		{"xreadgroup", []string{"group", "g1", "c1", "count", "2", "streams", "xs", ">"}, []interface{}{
+			[]interface{}{"xs", []interface{}{
+				[]interface{}{"1-1", []string{"f1", "v1"}},
+				[]interface{}{"1-2", []string{"f2", "v2"}},
+			}},
+		}, nil},
+		{"xreadgroup", []string{"group", "g1", "c2", "streams", "xs", ">"}, []interface{}{
+			[]interface{}{"xs", []interface{}{[]interface{}{"2-0", []string{"f3", "v3"}}}},
+		}, nil},
+		{"xreadgroup", []string{"group", "g1", "c2", "streams", "xs", ">"}, nil, nil},
+		{"xreadgroup", []string{"group", "g1", "c1", "streams", "xs", "0"}, []interface{}{
+			[]interface{}{"xs", []interface{}{
+				[]interface{}{"1-1", []string{"f1", "v1"}},
+				[]interface{}{"1-2", []string{"f2", "v2"}},
+			}},
+		}, nil},
+		{"xreadgroup", []string{"group", "g1", "c1", "streams", "xs", "$"}, nil, cmd.ErrXReadGroupID},
+		{"xpending", []string{"xs", "g1"}, []interface{}{int64(3), "1-1", "2-0", []interface{}{
+			[]string{"c1", "2"},
+			[]string{"c2", "1"},
+		}}, nil},
+		{"xack", []string{"xs", "g1", "1-1", "9-9"}, int64(1), nil},
+		{"xack", []string{"xs", "nog", "1-2"}, int64(0), nil},
+		{"xclaim", []string{"xs", "g1", "c2", "0", "1-2", "justid"}, []string{"1-2"}, nil},
+		{"xclaim", []string{"xs", "g1", "c2", "3600000", "1-2"}, []interface{}{}, nil},
+		{"xdel", []string{"xs", "1-2", "9-9"}, int64(1), nil},
+		{"xautoclaim", []string{"xs", "g1", "c1", "0", "0", "count", "10"}, []interface{}{
+			"0-0",
+			[]interface{}{[]interface{}{"2-0", []string{"f3", "v3"}}},
+			[]string{"1-2"},
+		}, nil},
+		{"xautoclaim", []string{"xs", "g1", "c1", "0", "0", "count", "0"}, nil, cmd.ErrNotPositive},
+		{"xreadgroup", []string{"group", "g1", "c1", "streams", "xs", "0"}, []interface{}{
+			[]interface{}{"xs", []interface{}{[]interface{}{"2-0", []string{"f3", "v3"}}}},
+		}, nil},
+		{"xgroup", []string{"delconsumer", "xs", "g1", "c1"}, int64(1), nil},
+		{"xgroup", []string{"createconsumer", "xs", "g1", "c3"}, true, nil},
+		{"xgroup", []string{"createconsumer", "xs", "g1", "c3"}, false, nil},
+		{"xgroup", []string{"setid", "xs", "g1", "0"}, cmd.OKVal, nil},
+		{"xgroup", []string{"destroy", "xs", "g1"}, true, nil},
+		{"xgroup", []string{"destroy", "xs", "g1"}, false, nil},
+		{"xtrim", []string{"xs", "maxlen", "=", "1"}, int64(1), nil},
+		{"xtrim", []string{"xs", "minid", "3"}, int64(1), nil},
+		{"xtrim", []string{"xs", "size", "1"}, nil, cmd.ErrSyntax},
+		{"xlen", []string{"xs"}, int64(0), nil},
+		{"xsetid", []string{"xs", "1-0"}, cmd.OKVal, nil},
+		{"xadd", []string{"xs", "1-*", "f", "v"}, "1-1", nil},
+		{"xsetid", []string{"xs", "0-5"}, nil, cmd.ErrXSetIDSmall},
+		{"xsetid", []string{"noxs", "1"}, nil, cmd.ErrNoSuchKey},
+		{"xgroup", []string{"create", "xs2", "g", "$", "mkstream"}, cmd.OKVal, nil},
+		{"xlen", []string{"xs2"}, int64(0), nil},
+		{"del", []string{"xs", "xs2"}, int64(2), nil},
+
+		// Sorted sets
+		{"zadd", []string{"zs", "1", "a", "2", "b", "2", "c"}, int64(3), nil},
+		{"type", []string{"zs"}, "zset", nil},
+		{"zadd", []string{"zs", "nx", "5", "a", "3", "d"}, int64(1), nil},
+		{"zadd", []string{"zs", "xx", "ch", "1.5", "a", "3", "e"}, int64(1), nil},
+		{"zadd", []string{"zs", "gt", "ch", "1", "a", "4", "b"}, int64(1), nil},
+		{"zadd", []string{"zs", "lt", "3", "c", "1", "c"}, int64(0), nil},
+		{"zadd", []string{"zs", "incr", "2", "a"}, "3.5", nil},
+		{"zadd", []string{"zs", "nx", "incr", "2", "a"}, nil, nil},
+		{"zadd", []string{"zs", "xx", "1", "a", "2"}, nil, cmd.ErrSyntax},
+		{"zadd", []string{"zs", "nx", "xx", "1", "a"}, nil, cmd.ErrXXAndNX},
+		{"zadd", []string{"zs", "nx", "gt", "1", "a"}, nil, cmd.ErrGTLTAndNX},
+		{"zadd", []string{"zs", "incr", "1", "a", "2", "b"}, nil, cmd.ErrIncrPair},
+		{"zadd", []string{"zs", "x", "a"}, nil, cmd.ErrNotFloat},
+		{"zadd", []string{"zs", "nan", "a"}, nil, cmd.ErrNotFloat},
+		{"zadd", []string{"zx", "xx", "1", "a"}, int64(0), nil},
+		{"exists", []string{"zx"}, false, nil},
+		{"zadd", []string{"t", "1", "a"}, nil, cmd.ErrInvalidValType},
+		{"zcard", []string{"zs"}, int64(4), nil},
+		{"zcard", []string{"zx"}, int64(0), nil},
+		{"zcard", []string{"t"}, nil, cmd.ErrInvalidValType},
+		{"zscore", []string{"zs", "a"}, "3.5", nil},
+		{"zscore", []string{"zs", "z"}, nil, nil},
+		{"zscore", []string{"zx", "a"}, nil, nil},
+		{"zrange", []string{"zs", "0", "-1"}, []string{"c", "d", "a", "b"}, nil},
+		{"zrange", []string{"zs", "1", "2", "WITHSCORES"}, []string{"d", "3", "a", "3.5"}, nil},
+		{"zrange", []string{"zx", "0", "-1"}, []string{}, nil},
+		{"zrevrange", []string{"zs", "0", "1", "withscores"}, []string{"b", "4", "a", "3.5"}, nil},
+		{"zrank", []string{"zs", "a"}, int64(2), nil},
+		{"zrank", []string{"zs", "z"}, nil, nil},
+		{"zrevrank", []string{"zs", "a"}, int64(1), nil},
+		{"zcount", []string{"zs", "-inf", "+inf"}, int64(4), nil},
+		{"zcount", []string{"zs", "(2", "3.5"}, int64(2), nil},
+		{"zcount", []string{"zs", "a", "3.5"}, nil, cmd.ErrMinMaxNotFloat},
+		{"zrangebyscore", []string{"zs", "2", "(4"}, []string{"d", "a"}, nil},
+		{"zrangebyscore", []string{"zs", "-inf", "inf", "withscores", "limit", "1", "2"}, []string{"d", "3", "a", "3.5"}, nil},
+		{"zrangebyscore", []string{"zs", "-inf", "inf", "limit", "1"}, nil, cmd.ErrSyntax},
+		{"zrevrangebyscore", []string{"zs", "+inf", "(3", "limit", "0", "1"}, []string{"b"}, nil},
+		{"zincrby", []string{"zs", "-10", "b"}, "-6", nil},
+		{"zincrby", []string{"zs", "1", "e"}, "1", nil},
+		{"zincrby", []string{"zs", "inf", "e"}, "inf", nil},
+		{"zincrby", []string{"zs", "-inf", "e"}, nil, cmd.ErrScoreNaN},
+		{"zrem", []string{"zs", "e", "z"}, int64(1), nil},
+		{"zremrangebyscore", []string{"zs", "-inf", "(0"}, int64(1), nil},
+		{"zremrangebyrank", []string{"zs", "0", "0"}, int64(1), nil},
+		{"zrange", []string{"zs", "0", "-1"}, []string{"d", "a"}, nil},
+		{"zrem", []string{"zs", "a", "d"}, int64(2), nil},
+		{"exists", []string{"zs"}, false, nil},
 	}
 
 	var got interface{}
@@ -314,6 +788,18 @@ func TestCommand(t *testing.T) {
 			got, gotErr = cd.ExecWithConn(&conn, args, ints, floats)
 		}
 
+		// The replies of these commands are in no particular order
+		switch c.name {
+		case "hgetall":
+			got = sortPairs(got)
+		case "hkeys", "hvals", "keys", "sdiff", "sinter", "smembers", "spop", "srandmember", "sunion":
+			got = sortVals(got)
+		case "hscan":
+			got = sortScanReply(got, sortPairs)
+		case "sscan":
+			got = sortScanReply(got, sortVals)
+		}
+
 		// Assert the results
 		if !reflect.DeepEqual(got, c.res) {
 			t.Errorf("%d [%s %v]: expected %v, got %v", i, c.name, c.args, c.res, got)
@@ -324,10 +810,68 @@ func TestCommand(t *testing.T) {
 	}
 }
 
+func sortVals(v interface{}) interface{} {
+	if vals, ok := v.([]string); ok {
+		sort.Strings(vals)
+	}
+	return v
+}
+
+func sortScanReply(v interface{}, sortFn func(interface{}) interface{}) interface{} {
+	if rep, ok := v.([]interface{}); ok && len(rep) == 2 {
+		rep[1] = sortFn(rep[1])
+	}
+	return v
+}
+
+func sortPairs(v interface{}) interface{} {
+	pairs, ok := v.([]string)
+	if !ok {
+		return v
+	}
+	vals := make(map[string]string, len(pairs)/2)
+	fields := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		vals[pairs[i]] = pairs[i+1]
+		fields = append(fields, pairs[i])
+	}
+	sort.Strings(fields)
+	ret := make([]string, 0, len(pairs))
+	for _, f := range fields {
+		ret = append(ret, f, vals[f])
+	}
+	return ret
+}
+
 type mockConn struct {
-	ix int
+	ix   int
+	name string
 }
 
 func (mc *mockConn) Select(ix int) {
 	mc.ix = ix
 }
+
+func (mc *mockConn) DB() srv.DB {
+	db, _ := srv.DefaultServer.GetDB(mc.ix)
+	return db
+}
+
+func (mc *mockConn) ID() int64           { return 1 }
+func (mc *mockConn) Name() string        { return mc.name }
+func (mc *mockConn) SetName(name string) { mc.name = name }
+
+func (mc *mockConn) Block() (<-chan struct{}, func(), bool) {
+	return nil, func() {}, true
+}
+
+func (mc *mockConn) Multi() error               { return nil }
+func (mc *mockConn) Exec() (interface{}, error) { return nil, nil }
+func (mc *mockConn) Discard() error             { return nil }
+func (mc *mockConn) Watch(_ ...string) error    { return nil }
+func (mc *mockConn) Unwatch()                   {}
+
+func (mc *mockConn) Subscribe(_ ...string) interface{}    { return nil }
+func (mc *mockConn) Unsubscribe(_ ...string) interface{}  { return nil }
+func (mc *mockConn) PSubscribe(_ ...string) interface{}   { return nil }
+func (mc *mockConn) PUnsubscribe(_ ...string) interface{} { return nil }
@@ -0,0 +1,67 @@
+package transactions
+
+import (
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+func init() {
+	cmd.Register("discard", discard)
+	cmd.Register("exec", exec)
+	cmd.Register("multi", multi)
+	cmd.Register("unwatch", unwatch)
+	cmd.Register("watch", watch)
+}
+
+var discard = cmd.NewConnCmd(
+	&cmd.ArgDef{},
+	discardFn)
+
+func discardFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if err := conn.Discard(); err != nil {
+		return nil, err
+	}
+	return cmd.OKVal, nil
+}
+
+var exec = cmd.NewConnCmd(
+	&cmd.ArgDef{},
+	execFn)
+
+func execFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return conn.Exec()
+}
+
+var multi = cmd.NewConnCmd(
+	&cmd.ArgDef{},
+	multiFn)
+
+func multiFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if err := conn.Multi(); err != nil {
+		return nil, err
+	}
+	return cmd.OKVal, nil
+}
+
+var unwatch = cmd.NewConnCmd(
+	&cmd.ArgDef{},
+	unwatchFn)
+
+func unwatchFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	conn.Unwatch()
+	return cmd.OKVal, nil
+}
+
+var watch = cmd.NewConnCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	watchFn)
+
+func watchFn(conn srv.Conn, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if err := conn.Watch(args...); err != nil {
+		return nil, err
+	}
+	return cmd.OKVal, nil
+}
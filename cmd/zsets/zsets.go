@@ -0,0 +1,593 @@
+package zsets
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func init() {
+	cmd.Register("zadd", zadd)
+	cmd.Register("zcard", zcard)
+	cmd.Register("zcount", zcount)
+	cmd.Register("zincrby", zincrby)
+	cmd.Register("zrange", zrange)
+	cmd.Register("zrangebyscore", zrangebyscore)
+	cmd.Register("zrank", zrank)
+	cmd.Register("zrem", zrem)
+	cmd.Register("zremrangebyrank", zremrangebyrank)
+	cmd.Register("zremrangebyscore", zremrangebyscore)
+	cmd.Register("zrevrange", zrevrange)
+	cmd.Register("zrevrangebyscore", zrevrangebyscore)
+	cmd.Register("zrevrank", zrevrank)
+	cmd.Register("zscore", zscore)
+}
+
+// parseScore parses a score argument, which may be -inf or +inf, but not NaN.
+func parseScore(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, cmd.ErrNotFloat
+	}
+	return v, nil
+}
+
+// parseBound parses a score range bound, which is exclusive if prefixed
+// with "(".
+func parseBound(s string) (types.ScoreBound, error) {
+	var b types.ScoreBound
+	if strings.HasPrefix(s, "(") {
+		b.Exclusive = true
+		s = s[1:]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return b, cmd.ErrMinMaxNotFloat
+	}
+	b.Value = v
+	return b, nil
+}
+
+// formatScore formats a score the way Redis does, e.g. "1.5", "inf" or "1e+21".
+func formatScore(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	if abs := math.Abs(v); v == 0 || (abs >= 1e-6 && abs < 1e21) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// scoredReply returns the members, followed by their score if withScores
+// is true.
+func scoredReply(sms []types.ScoredMember, withScores bool) []string {
+	n := len(sms)
+	if withScores {
+		n *= 2
+	}
+	ret := make([]string, 0, n)
+	for _, sm := range sms {
+		ret = append(ret, sm.Member)
+		if withScores {
+			ret = append(ret, formatScore(sm.Score))
+		}
+	}
+	return ret
+}
+
+// delIfEmpty deletes the key if the sorted set is now empty. Both the DB
+// and the key must be exclusively locked.
+func delIfEmpty(db srv.DB, name string, v types.SortedSet) {
+	if v.ZCard() == 0 {
+		db.DelKey(name)
+		db.Notify(srv.NotifyGeneric, "del", name)
+	}
+}
+
+var zadd = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	zaddFn)
+
+func zaddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Parse the options
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "ch":
+			ch = true
+		case "incr":
+			incr = true
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, cmd.ErrSyntax
+	}
+	if nx && xx {
+		return nil, cmd.ErrXXAndNX
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return nil, cmd.ErrGTLTAndNX
+	}
+	if incr && len(pairs) > 2 {
+		return nil, cmd.ErrIncrPair
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		sc, err := parseScore(pairs[2*j])
+		if err != nil {
+			return nil, err
+		}
+		scores[j] = sc
+	}
+
+	// With XX, the key is never created
+	flag := srv.NoKeyCreateSortedSet
+	if xx {
+		flag = srv.NoKeyNone
+	}
+	k, unl := db.LockGetKey(args[0], flag)
+	defer unl()
+	if k == nil {
+		if incr {
+			return nil, nil
+		}
+		return int64(0), nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v, ok := k.Val().(types.SortedSet)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	var added, changed int64
+	for j, sc := range scores {
+		member := pairs[2*j+1]
+		cur, exists := v.ZScore(member)
+		if (nx && exists) || (xx && !exists) {
+			if incr {
+				return nil, nil
+			}
+			continue
+		}
+		if incr {
+			sc += cur
+			if math.IsNaN(sc) {
+				return nil, cmd.ErrScoreNaN
+			}
+		}
+		if exists && ((gt && sc <= cur) || (lt && sc >= cur)) {
+			if incr {
+				return nil, nil
+			}
+			continue
+		}
+		if v.ZAdd(member, sc) {
+			added++
+		} else if sc != cur {
+			changed++
+		}
+		if incr {
+			db.Notify(srv.NotifySortedSet, "zincr", args[0])
+			return formatScore(sc), nil
+		}
+	}
+
+	if added+changed > 0 {
+		db.Notify(srv.NotifySortedSet, "zadd", args[0])
+	}
+	if ch {
+		return added + changed, nil
+	}
+	return added, nil
+}
+
+var zcard = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	srv.NoKeyDefaultVal,
+	zcardFn)
+
+func zcardFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return v.ZCard(), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zcount = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	srv.NoKeyDefaultVal,
+	zcountFn)
+
+func zcountFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	min, err := parseBound(args[1])
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return v.ZCount(min, max), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zincrby = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:      3,
+		MaxArgs:      3,
+		FloatIndices: []int{1},
+		ValidateFn: func(args []string, ints []int64, floats []float64) error {
+			if math.IsNaN(floats[0]) {
+				return cmd.ErrNotFloat
+			}
+			return nil
+		},
+	},
+	zincrbyFn)
+
+func zincrbyFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyCreateSortedSet)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		val, ok := v.ZIncrBy(args[2], floats[0])
+		if ok {
+			db.Notify(srv.NotifySortedSet, "zincr", args[0])
+			return formatScore(val), nil
+		}
+		return nil, cmd.ErrScoreNaN
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// rangeArgDef is the argument definition of ZRANGE and ZREVRANGE.
+var rangeArgDef = &cmd.ArgDef{
+	MinArgs:    3,
+	MaxArgs:    4,
+	IntIndices: []int{1, 2},
+	ValidateFn: func(args []string, ints []int64, floats []float64) error {
+		if len(args) == 4 && strings.ToLower(args[3]) != "withscores" {
+			return cmd.ErrSyntax
+		}
+		return nil
+	},
+}
+
+var zrange = cmd.NewSingleKeyCmd(
+	rangeArgDef,
+	srv.NoKeyDefaultVal,
+	zrangeFn)
+
+func zrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return scoredReply(v.ZRange(ints[0], ints[1]), len(args) == 4), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zrevrange = cmd.NewSingleKeyCmd(
+	rangeArgDef,
+	srv.NoKeyDefaultVal,
+	zrevrangeFn)
+
+func zrevrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return scoredReply(v.ZRevRange(ints[0], ints[1]), len(args) == 4), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// scoreRange holds the parsed arguments of ZRANGEBYSCORE and
+// ZREVRANGEBYSCORE.
+type scoreRange struct {
+	min, max      types.ScoreBound
+	withScores    bool
+	offset, count int64
+}
+
+// parseScoreRange parses the arguments of ZRANGEBYSCORE and
+// ZREVRANGEBYSCORE, the bounds are in args[1] (first) and args[2].
+func parseScoreRange(args []string, rev bool) (*scoreRange, error) {
+	sr := &scoreRange{count: -1}
+	first, err := parseBound(args[1])
+	if err != nil {
+		return nil, err
+	}
+	second, err := parseBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+	if rev {
+		sr.max, sr.min = first, second
+	} else {
+		sr.min, sr.max = first, second
+	}
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "withscores":
+			sr.withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return nil, cmd.ErrSyntax
+			}
+			if sr.offset, err = strconv.ParseInt(args[i+1], 10, 64); err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			if sr.count, err = strconv.ParseInt(args[i+2], 10, 64); err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			i += 2
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+	return sr, nil
+}
+
+// rangeByScoreArgDef is the argument definition of ZRANGEBYSCORE and
+// ZREVRANGEBYSCORE.
+var rangeByScoreArgDef = &cmd.ArgDef{
+	MinArgs: 3,
+	MaxArgs: 7,
+}
+
+var zrangebyscore = cmd.NewSingleKeyCmd(
+	rangeByScoreArgDef,
+	srv.NoKeyDefaultVal,
+	zrangebyscoreFn)
+
+func zrangebyscoreFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sr, err := parseScoreRange(args, false)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		if sr.offset < 0 {
+			return []string{}, nil
+		}
+		return scoredReply(v.ZRangeByScore(sr.min, sr.max, sr.offset, sr.count), sr.withScores), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zrevrangebyscore = cmd.NewSingleKeyCmd(
+	rangeByScoreArgDef,
+	srv.NoKeyDefaultVal,
+	zrevrangebyscoreFn)
+
+func zrevrangebyscoreFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sr, err := parseScoreRange(args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		if sr.offset < 0 {
+			return []string{}, nil
+		}
+		return scoredReply(v.ZRevRangeByScore(sr.max, sr.min, sr.offset, sr.count), sr.withScores), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zrank = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	zrankFn)
+
+func zrankFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		if r, ok := v.ZRank(args[1]); ok {
+			return r, nil
+		}
+		return nil, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zrevrank = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	zrevrankFn)
+
+func zrevrankFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		if r, ok := v.ZRevRank(args[1]); ok {
+			return r, nil
+		}
+		return nil, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zrem = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	zremFn)
+
+func zremFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Since ZREM may delete the key (if the sorted set is empty), must get an
+	// exclusive DB lock right away.
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		ret := v.ZRem(args[1:]...)
+		if ret > 0 {
+			db.Notify(srv.NotifySortedSet, "zrem", args[0])
+			delIfEmpty(db, args[0], v)
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zremrangebyrank = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    3,
+		MaxArgs:    3,
+		IntIndices: []int{1, 2},
+	},
+	zremrangebyrankFn)
+
+func zremrangebyrankFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		ret := v.ZRemRangeByRank(ints[0], ints[1])
+		if ret > 0 {
+			db.Notify(srv.NotifySortedSet, "zremrangebyrank", args[0])
+			delIfEmpty(db, args[0], v)
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zremrangebyscore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	zremrangebyscoreFn)
+
+func zremrangebyscoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	min, err := parseBound(args[1])
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		ret := v.ZRemRangeByScore(min, max)
+		if ret > 0 {
+			db.Notify(srv.NotifySortedSet, "zremrangebyscore", args[0])
+			delIfEmpty(db, args[0], v)
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zscore = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	zscoreFn)
+
+func zscoreFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		if sc, ok := v.ZScore(args[1]); ok {
+			return formatScore(sc), nil
+		}
+		return nil, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
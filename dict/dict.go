@@ -0,0 +1,271 @@
+// Package dict implements a hash table of strings with chained buckets, in
+// the spirit of the Redis dict. It holds the keys of the databases, and
+// the fields and members of the hash and set values.
+//
+// The number of buckets is a power of two, and the table grows and shrinks
+// incrementally: while resizing, both tables are in use and each
+// modification moves a bucket of the old table to the new one. This allows
+// Scan to use the Redis reverse binary cursor, which guarantees that a full
+// iteration returns all entries, even if the table is resized between
+// calls.
+package dict
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+// seed is the seed used to hash the keys.
+var seed = maphash.MakeSeed()
+
+// minBuckets is the minimum number of buckets of a dict.
+const minBuckets = 4
+
+// entry is an entry of a bucket of the dict.
+type entry struct {
+	key  string
+	val  interface{}
+	h    uint64
+	next *entry
+}
+
+// Dict is a hash table of values by string key. It is not safe for
+// concurrent use.
+type Dict struct {
+	tables [2][]*entry
+	used   [2]int
+
+	// rehash is the index of the next bucket of tables[0] to move to
+	// tables[1], or -1 if the table is not resizing.
+	rehash int
+}
+
+// New creates a new, empty Dict.
+func New() *Dict {
+	return &Dict{
+		tables: [2][]*entry{make([]*entry, minBuckets)},
+		rehash: -1,
+	}
+}
+
+// find returns the entry of key, or nil if it does not exist.
+func (d *Dict) find(key string, h uint64) *entry {
+	for t := 0; t < 2; t++ {
+		tbl := d.tables[t]
+		if len(tbl) == 0 {
+			break
+		}
+		for e := tbl[h&uint64(len(tbl)-1)]; e != nil; e = e.next {
+			if e.h == h && e.key == key {
+				return e
+			}
+		}
+		if d.rehash < 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// Get returns the value of key, and true if it exists.
+func (d *Dict) Get(key string) (interface{}, bool) {
+	if e := d.find(key, maphash.String(seed, key)); e != nil {
+		return e.val, true
+	}
+	return nil, false
+}
+
+// Set sets the value of key, and returns true if it was added.
+func (d *Dict) Set(key string, val interface{}) bool {
+	d.step()
+	h := maphash.String(seed, key)
+	if e := d.find(key, h); e != nil {
+		e.val = val
+		return false
+	}
+
+	// New entries go to the new table while resizing
+	t := 0
+	if d.rehash >= 0 {
+		t = 1
+	}
+	tbl := d.tables[t]
+	i := h & uint64(len(tbl)-1)
+	tbl[i] = &entry{key: key, val: val, h: h, next: tbl[i]}
+	d.used[t]++
+	d.resize()
+	return true
+}
+
+// Del deletes key, and returns true if it existed.
+func (d *Dict) Del(key string) bool {
+	d.step()
+	h := maphash.String(seed, key)
+	for t := 0; t < 2; t++ {
+		tbl := d.tables[t]
+		if len(tbl) == 0 {
+			break
+		}
+		i := h & uint64(len(tbl)-1)
+		for p := &tbl[i]; *p != nil; p = &(*p).next {
+			if e := *p; e.h == h && e.key == key {
+				*p = e.next
+				d.used[t]--
+				d.resize()
+				return true
+			}
+		}
+		if d.rehash < 0 {
+			break
+		}
+	}
+	return false
+}
+
+// Len returns the number of entries.
+func (d *Dict) Len() int {
+	return d.used[0] + d.used[1]
+}
+
+// Each calls fn for each entry, until fn returns false.
+func (d *Dict) Each(fn func(key string, val interface{}) bool) {
+	for t := 0; t < 2; t++ {
+		for _, e := range d.tables[t] {
+			for ; e != nil; e = e.next {
+				if !fn(e.key, e.val) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Random returns the key of a random entry, which must exist. Like Redis,
+// it picks a random non-empty bucket, then a random entry of the bucket,
+// so the entries of longer chains are less likely to be returned.
+func (d *Dict) Random() string {
+	n0, n1 := len(d.tables[0]), len(d.tables[1])
+	var e *entry
+	for e == nil {
+		if i := rand.Intn(n0 + n1); i < n0 {
+			e = d.tables[0][i]
+		} else {
+			e = d.tables[1][i-n0]
+		}
+	}
+	var n int
+	for c := e; c != nil; c = c.next {
+		n++
+	}
+	for i := rand.Intn(n); i > 0; i-- {
+		e = e.next
+	}
+	return e.key
+}
+
+// Scan calls fn for the entries of the buckets at cursor, and returns the
+// cursor of the next call, which is 0 once all buckets have been visited.
+// A full iteration starts and ends with a cursor of 0, and returns every
+// entry that is present for the whole iteration. Entries may be returned
+// more than once.
+//
+// The cursor is a bucket index whose bits are incremented from the most
+// significant one, so that the buckets already visited in a table of some
+// size map to buckets already visited in a table of any other size.
+func (d *Dict) Scan(cursor uint64, fn func(key string, val interface{})) uint64 {
+	emit := func(e *entry) {
+		for ; e != nil; e = e.next {
+			fn(e.key, e.val)
+		}
+	}
+
+	if d.rehash < 0 {
+		m := uint64(len(d.tables[0]) - 1)
+		emit(d.tables[0][cursor&m])
+		return nextCursor(cursor, m)
+	}
+
+	// While resizing, visit the bucket of the smaller table, then all the
+	// buckets of the larger table that it expands to.
+	small, large := d.tables[0], d.tables[1]
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	m0, m1 := uint64(len(small)-1), uint64(len(large)-1)
+	emit(small[cursor&m0])
+	for {
+		emit(large[cursor&m1])
+		cursor = nextCursor(cursor, m1)
+		if cursor&(m0^m1) == 0 {
+			return cursor
+		}
+	}
+}
+
+// nextCursor increments the reversed bits of the cursor, for a table
+// with the mask m.
+func nextCursor(cursor, m uint64) uint64 {
+	cursor |= ^m
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// step moves a bucket of the old table to the new table, if the table is
+// resizing.
+func (d *Dict) step() {
+	if d.rehash < 0 {
+		return
+	}
+	old, tbl := d.tables[0], d.tables[1]
+	// Skip a bounded number of empty buckets
+	for n := 0; d.rehash < len(old) && old[d.rehash] == nil && n < 10; n++ {
+		d.rehash++
+	}
+	if d.rehash < len(old) {
+		for e := old[d.rehash]; e != nil; {
+			next := e.next
+			i := e.h & uint64(len(tbl)-1)
+			e.next = tbl[i]
+			tbl[i] = e
+			d.used[0]--
+			d.used[1]++
+			e = next
+		}
+		old[d.rehash] = nil
+		d.rehash++
+	}
+	if d.rehash >= len(old) {
+		d.tables = [2][]*entry{tbl}
+		d.used = [2]int{d.used[1]}
+		d.rehash = -1
+	}
+}
+
+// resize starts resizing the table if it is too full or too empty, and
+// not already resizing.
+func (d *Dict) resize() {
+	if d.rehash >= 0 {
+		return
+	}
+	size, used := len(d.tables[0]), d.used[0]
+	var n int
+	switch {
+	case used >= size:
+		n = used * 2
+	case size > minBuckets && used < size/10:
+		n = used
+	default:
+		return
+	}
+	newSize := minBuckets
+	for newSize < n {
+		newSize *= 2
+	}
+	if newSize == size {
+		return
+	}
+	d.tables[1] = make([]*entry, newSize)
+	d.rehash = 0
+}
@@ -0,0 +1,97 @@
+package dict
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestDict(t *testing.T) {
+	d := New()
+	const n = 1000
+	for i := 0; i < n; i++ {
+		nm := strconv.Itoa(i)
+		if !d.Set(nm, nm) {
+			t.Fatalf("%d: expected entry to be added", i)
+		}
+	}
+	if d.Set("1", "x") {
+		t.Errorf("expected entry to be replaced")
+	}
+	if v, ok := d.Get("1"); !ok || v != "x" {
+		t.Errorf("expected value x, got %s", v)
+	}
+	if l := d.Len(); l != n {
+		t.Errorf("expected %d entries, got %d", n, l)
+	}
+
+	// Delete most entries, so that the table shrinks
+	for i := 0; i < n-10; i++ {
+		if !d.Del(strconv.Itoa(i)) {
+			t.Fatalf("%d: expected entry to be deleted", i)
+		}
+	}
+	if d.Del("0") {
+		t.Errorf("expected deleted entry to not exist")
+	}
+	cnt := 0
+	d.Each(func(k string, v interface{}) bool {
+		if k != v {
+			t.Errorf("expected value %s, got %s", k, v)
+		}
+		cnt++
+		return true
+	})
+	if cnt != 10 {
+		t.Errorf("expected 10 entries, got %d", cnt)
+	}
+}
+
+func TestDictScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for run := 0; run < 10; run++ {
+		d := New()
+
+		// Entries that are present for the whole iteration, and entries that
+		// are added and deleted while iterating, so that the table grows and
+		// shrinks.
+		const n = 500
+		for i := 0; i < n; i++ {
+			d.Set("stable"+strconv.Itoa(i), "")
+		}
+		var temp []string
+		seen := make(map[string]bool)
+		var cursor uint64
+		for i := 0; ; i++ {
+			cursor = d.Scan(cursor, func(k string, _ interface{}) {
+				seen[k] = true
+			})
+			if cursor == 0 {
+				break
+			}
+			if i > 100000 {
+				t.Fatal("scan does not terminate")
+			}
+
+			switch op := rnd.Intn(3); {
+			case len(temp) < 5000 && (op == 0 || op == 1 && run%2 == 0):
+				for j := 0; j < 50; j++ {
+					nm := "temp" + strconv.Itoa(rnd.Int())
+					d.Set(nm, "")
+					temp = append(temp, nm)
+				}
+			default:
+				for j := 0; j < 50 && len(temp) > 0; j++ {
+					d.Del(temp[len(temp)-1])
+					temp = temp[:len(temp)-1]
+				}
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			if nm := "stable" + strconv.Itoa(i); !seen[nm] {
+				t.Fatalf("%d: entry %s not returned by scan", run, nm)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package glob implements the glob-style pattern matching used by Redis,
+// e.g. for the KEYS and PSUBSCRIBE commands. Supported patterns are:
+//
+//	h?llo    matches hello, hallo and hxllo
+//	h*llo    matches hllo and heeeello
+//	h[ae]llo matches hello and hallo, but not hillo
+//	h[^e]llo matches hallo, hbllo, ... but not hello
+//	h[a-b]llo matches hallo and hbllo
+//
+// Special characters can be escaped with a backslash.
+package glob
+
+// Match returns true if the string s matches the pattern.
+func Match(pattern, s string) bool {
+	p, i := pattern, 0
+	for ; len(p) > 0; p = p[1:] {
+		switch p[0] {
+		case '*':
+			// Consecutive stars are the same as one
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for j := i; j <= len(s); j++ {
+				if Match(p[1:], s[j:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if i >= len(s) {
+				return false
+			}
+			i++
+
+		case '[':
+			if i >= len(s) {
+				return false
+			}
+			p = p[1:]
+			not := len(p) > 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			var match bool
+			for len(p) > 0 && p[0] != ']' {
+				switch {
+				case p[0] == '\\' && len(p) >= 2:
+					p = p[1:]
+					if p[0] == s[i] {
+						match = true
+					}
+				case len(p) >= 3 && p[1] == '-':
+					start, end := p[0], p[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[i] >= start && s[i] <= end {
+						match = true
+					}
+					p = p[2:]
+				case p[0] == s[i]:
+					match = true
+				}
+				p = p[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			i++
+			if len(p) == 0 {
+				// Unterminated class, end of pattern
+				return i == len(s)
+			}
+
+		case '\\':
+			if len(p) >= 2 {
+				p = p[1:]
+			}
+			fallthrough
+
+		default:
+			if i >= len(s) || p[0] != s[i] {
+				return false
+			}
+			i++
+		}
+	}
+	return i == len(s)
+}
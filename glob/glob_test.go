@@ -0,0 +1,47 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pat string
+		s   string
+		exp bool
+	}{
+		0:  {"", "", true},
+		1:  {"", "a", false},
+		2:  {"*", "", true},
+		3:  {"*", "abc", true},
+		4:  {"a*", "abc", true},
+		5:  {"a*", "bac", false},
+		6:  {"*c", "abc", true},
+		7:  {"a*c", "ac", true},
+		8:  {"a*c", "abbbc", true},
+		9:  {"a*c", "abbbcd", false},
+		10: {"a**c*", "abbbcd", true},
+		11: {"h?llo", "hello", true},
+		12: {"h?llo", "hllo", false},
+		13: {"h[ae]llo", "hallo", true},
+		14: {"h[ae]llo", "hillo", false},
+		15: {"h[^e]llo", "hallo", true},
+		16: {"h[^e]llo", "hello", false},
+		17: {"h[a-b]llo", "hbllo", true},
+		18: {"h[b-a]llo", "hbllo", true},
+		19: {"h[a-b]llo", "hcllo", false},
+		20: {"h\\*llo", "h*llo", true},
+		21: {"h\\*llo", "hello", false},
+		22: {"h[\\]]llo", "h]llo", true},
+		23: {"key:*:name", "key:123:name", true},
+		24: {"key:*:name", "key:123:names", false},
+		25: {"ab[c", "abc", true},
+		26: {"ab[c", "abcd", false},
+		27: {"*?", "", false},
+		28: {"__keyspace@0__:*", "__keyspace@0__:mykey", true},
+	}
+	for i, c := range cases {
+		got := Match(c.pat, c.s)
+		if got != c.exp {
+			t.Errorf("%d: %q %q: expected %t, got %t", i, c.pat, c.s, c.exp, got)
+		}
+	}
+}
@@ -2,18 +2,27 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net"
+	"os"
+	"strconv"
 
 	"github.com/PuerkitoBio/gred/cmd"
 	_ "github.com/PuerkitoBio/gred/cmd/connection"
 	_ "github.com/PuerkitoBio/gred/cmd/hashes"
+	_ "github.com/PuerkitoBio/gred/cmd/hyperloglog"
 	_ "github.com/PuerkitoBio/gred/cmd/keys"
 	_ "github.com/PuerkitoBio/gred/cmd/lists"
+	_ "github.com/PuerkitoBio/gred/cmd/pubsub"
 	_ "github.com/PuerkitoBio/gred/cmd/server"
 	_ "github.com/PuerkitoBio/gred/cmd/sets"
+	_ "github.com/PuerkitoBio/gred/cmd/streams"
 	_ "github.com/PuerkitoBio/gred/cmd/strings"
+	_ "github.com/PuerkitoBio/gred/cmd/transactions"
+	_ "github.com/PuerkitoBio/gred/cmd/zsets"
 	gnet "github.com/PuerkitoBio/gred/net"
+	"github.com/PuerkitoBio/gred/srv"
 	"github.com/golang/glog"
 )
 
@@ -26,16 +35,97 @@ const (
 )
 
 var (
-	addr  = flag.String("addr", ":6379", "network address to listen to")
+	addr  = flag.String("addr", ":6379", "network address to listen to, overrides the bind and port directives")
 	iface = flag.String("net", "tcp", "network interface to use")
+	aof   = flag.Bool("appendonly", false, "log write commands to the append-only file")
+
+	// The flags of the configuration directives, which override the
+	// configuration file.
+	_ = flag.String("notify-keyspace-events", "", "classes of keyspace events to notify")
+	_ = flag.String("dir", ".", "directory of the database and append-only files")
+	_ = flag.String("dbfilename", srv.DefaultRDBPath, "name of the database file")
+	_ = flag.String("appendfilename", srv.DefaultAOFPath, "name of the append-only file")
+	_ = flag.String("appendfsync", "everysec", "fsync policy of the append-only file: always, everysec or no")
 )
 
+// configFlags lists the flags that set a configuration directive, in
+// addition to appendonly.
+var configFlags = map[string]bool{
+	"appendfilename":         true,
+	"appendfsync":            true,
+	"dbfilename":             true,
+	"dir":                    true,
+	"notify-keyspace-events": true,
+}
+
 func main() {
 	//defer profile.Start(profile.CPUProfile).Stop()
 
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [config-file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	defer glog.Flush()
 
+	// Load the configuration file, if any, and the directives of the flags
+	// that are set.
+	var args []string
+	listen := ""
+	flag.Visit(func(f *flag.Flag) {
+		switch {
+		case f.Name == "addr":
+			listen = *addr
+		case f.Name == "appendonly":
+			val := "no"
+			if *aof {
+				val = "yes"
+			}
+			args = append(args, f.Name, val)
+		case configFlags[f.Name]:
+			args = append(args, f.Name, f.Value.String())
+		}
+	})
+	if err := srv.LoadConfig(flag.Arg(0), args...); err != nil {
+		log.Fatal(err)
+	}
+	cfg := srv.CurrentConfig()
+	if listen == "" {
+		listen = net.JoinHostPort(cfg.Bind, strconv.Itoa(cfg.Port))
+	}
+
+	// Load the databases saved on disk, from the append-only file if it is
+	// enabled and exists, from the database file otherwise.
+	var rewrite bool
+	if cfg.AppendOnly {
+		err := gnet.LoadAOF(srv.AOFPath())
+		if err == nil {
+			glog.V(1).Infof("databases loaded from %s", srv.AOFPath())
+		} else if os.IsNotExist(err) {
+			// Create the append-only file from the database file
+			rewrite = true
+		} else {
+			log.Fatalf("load %s: %s", srv.AOFPath(), err)
+		}
+	}
+	if !cfg.AppendOnly || rewrite {
+		if err := srv.DefaultServer.Load(); err != nil {
+			log.Fatalf("load %s: %s", srv.RDBPath(), err)
+		}
+		glog.V(1).Infof("databases loaded from %s", srv.RDBPath())
+	}
+	if cfg.AppendOnly {
+		if err := srv.DefaultServer.OpenAOF(cfg.AppendFsync); err != nil {
+			log.Fatal(err)
+		}
+		defer srv.DefaultServer.CloseAOF()
+		if rewrite {
+			if err := srv.DefaultServer.BGRewriteAOF(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
 	// Print registered commands
 	if glog.V(2) {
 		for k := range cmd.Commands {
@@ -43,12 +133,12 @@ func main() {
 		}
 	}
 
-	l, err := net.Listen(*iface, *addr)
+	l, err := net.Listen(*iface, listen)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer l.Close()
-	glog.V(1).Infof("listening on %s://%s", *iface, *addr)
+	glog.V(1).Infof("listening on %s://%s", *iface, listen)
 
 	var errcnt int
 	for {
@@ -0,0 +1,310 @@
+package net
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/golang/glog"
+)
+
+// aofFn returns the commands to append to the append-only file for the
+// request ar, which returned the value res.
+type aofFn func(ar []string, res interface{}) [][]string
+
+// aofCmds lists the commands that modify the databases. While the
+// append-only file is enabled, they are executed under the exclusive server
+// lock and appended to the file. A nil function appends the request as
+// received.
+var aofCmds = map[string]aofFn{
+	"append":           nil,
+	"bitfield":         nil,
+	"bitop":            nil,
+	"copy":             nil,
+	"decr":             nil,
+	"decrby":           nil,
+	"del":              nil,
+	"expire":           aofExpire(time.Second, false),
+	"expireat":         aofExpire(time.Second, true),
+	"flushall":         nil,
+	"flushdb":          nil,
+	"getdel":           nil,
+	"getex":            aofGetEx,
+	"getset":           nil,
+	"hdel":             nil,
+	"hincrby":          nil,
+	"hincrbyfloat":     aofHIncrByFloat,
+	"hmset":            nil,
+	"hset":             nil,
+	"hsetnx":           nil,
+	"incr":             nil,
+	"incrby":           nil,
+	"incrbyfloat":      aofIncrByFloat,
+	"linsert":          nil,
+	"lmove":            aofSelf,
+	"lmpop":            aofSelf,
+	"lpop":             nil,
+	"lpush":            aofSelf,
+	"lpushx":           nil,
+	"lrem":             nil,
+	"lset":             nil,
+	"ltrim":            nil,
+	"move":             nil,
+	"mset":             nil,
+	"msetnx":           nil,
+	"persist":          nil,
+	"pfadd":            nil,
+	"pfmerge":          nil,
+	"pexpire":          aofExpire(time.Millisecond, false),
+	"pexpireat":        nil,
+	"psetex":           aofSetEx(time.Millisecond),
+	"rename":           nil,
+	"renamenx":         nil,
+	"rpop":             nil,
+	"rpoplpush":        aofSelf,
+	"rpush":            aofSelf,
+	"rpushx":           nil,
+	"sadd":             nil,
+	"sdiffstore":       nil,
+	"sinterstore":      nil,
+	"smove":            nil,
+	"spop":             aofSPop,
+	"set":              aofSet,
+	"setbit":           nil,
+	"setex":            aofSetEx(time.Second),
+	"setnx":            nil,
+	"setrange":         nil,
+	"srem":             nil,
+	"sunionstore":      nil,
+	"swapdb":           nil,
+	"xack":             nil,
+	"xadd":             aofSelf,
+	"xautoclaim":       aofSelf,
+	"xclaim":           aofSelf,
+	"xdel":             nil,
+	"xgroup":           aofSelf,
+	"xsetid":           nil,
+	"xtrim":            nil,
+	"zadd":             nil,
+	"zincrby":          nil,
+	"zrem":             nil,
+	"zremrangebyrank":  nil,
+	"zremrangebyscore": nil,
+}
+
+// aofSelf is the function of the commands that append themselves to the
+// append-only file, because they may serve clients blocked on a list or a
+// stream, whose reads must be appended after the command, or because they
+// depend on the current time.
+func aofSelf(ar []string, res interface{}) [][]string {
+	return nil
+}
+
+// aofExpire returns the function that appends an expiration command, with a
+// relative or absolute time in the specified unit, as PEXPIREAT, so that
+// the file can be replayed at any time.
+func aofExpire(unit time.Duration, abs bool) aofFn {
+	return func(ar []string, res interface{}) [][]string {
+		if ok, _ := res.(bool); !ok {
+			return nil
+		}
+		return [][]string{{"pexpireat", ar[1], pexpireAt(ar[2], unit, abs)}}
+	}
+}
+
+// aofSetEx returns the function that appends SETEX and PSETEX as SET and
+// PEXPIREAT.
+func aofSetEx(unit time.Duration) aofFn {
+	return func(ar []string, res interface{}) [][]string {
+		return [][]string{
+			{"set", ar[1], ar[3]},
+			{"pexpireat", ar[1], pexpireAt(ar[2], unit, false)},
+		}
+	}
+}
+
+// aofSet appends SET with its relative expiration options converted to
+// PXAT. The conditional options are kept, since they have the same outcome
+// when the file is replayed.
+func aofSet(ar []string, res interface{}) [][]string {
+	set := make([]string, 0, len(ar))
+	for i := 0; i < len(ar); i++ {
+		switch opt := strings.ToLower(ar[i]); {
+		case i > 2 && i+1 < len(ar) && (opt == "ex" || opt == "px" || opt == "exat"):
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+			set = append(set, "pxat", pexpireAt(ar[i+1], unit, opt == "exat"))
+			i++
+		default:
+			set = append(set, ar[i])
+		}
+	}
+	return [][]string{set}
+}
+
+// aofGetEx appends the expiration set by GETEX as PEXPIREAT, or its
+// removal as PERSIST. Nothing is appended if the key does not exist, or
+// without option.
+func aofGetEx(ar []string, res interface{}) [][]string {
+	if res == nil || len(ar) < 3 {
+		return nil
+	}
+	switch opt := strings.ToLower(ar[2]); opt {
+	case "persist":
+		return [][]string{{"persist", ar[1]}}
+	case "ex", "px", "exat", "pxat":
+		unit := time.Second
+		if opt[0] == 'p' {
+			unit = time.Millisecond
+		}
+		return [][]string{{"pexpireat", ar[1], pexpireAt(ar[3], unit, strings.HasSuffix(opt, "at"))}}
+	}
+	return nil
+}
+
+// aofIncrByFloat appends INCRBYFLOAT as SET of the resulting value, with
+// KEEPTTL, so that the float arithmetic is not replayed.
+func aofIncrByFloat(ar []string, res interface{}) [][]string {
+	return [][]string{{"set", ar[1], res.(string), "keepttl"}}
+}
+
+// aofHIncrByFloat appends HINCRBYFLOAT as HSET of the resulting value, so
+// that the float arithmetic is not replayed.
+func aofHIncrByFloat(ar []string, res interface{}) [][]string {
+	return [][]string{{"hset", ar[1], ar[2], res.(string)}}
+}
+
+// aofSPop appends the members removed by SPOP as SREM, since they are
+// picked at random.
+func aofSPop(ar []string, res interface{}) [][]string {
+	var mbrs []string
+	switch res := res.(type) {
+	case string:
+		mbrs = []string{res}
+	case []string:
+		mbrs = res
+	}
+	if len(mbrs) == 0 {
+		return nil
+	}
+	return [][]string{append([]string{"srem", ar[1]}, mbrs...)}
+}
+
+// pexpireAt returns the Unix time in milliseconds of the expiration time
+// s, in the specified unit, relative to now if abs is false.
+func pexpireAt(s string, unit time.Duration, abs bool) string {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	ms := n * int64(unit/time.Millisecond)
+	if !abs {
+		ms += time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// execAppend executes the command, and appends it to the append-only file
+// if it is a write command that succeeded.
+func (c *netConn) execAppend(ar []string, cd cmd.Cmd, args []string, ints []int64, floats []float64) (interface{}, error) {
+	res, err := c.execCmd(cd, args, ints, floats)
+	if err != nil {
+		return res, err
+	}
+	fn, ok := aofCmds[strings.ToLower(ar[0])]
+	if !ok {
+		return res, err
+	}
+	cmds := [][]string{ar}
+	if fn != nil {
+		cmds = fn(ar, res)
+	}
+	for _, args := range cmds {
+		srv.DefaultServer.Propagate(c.dbix, args...)
+	}
+	return res, err
+}
+
+// ErrTruncatedAOF is returned by LoadAOF if the append-only file ends with
+// an incomplete command, or an incomplete transaction.
+var ErrTruncatedAOF = errors.New("net: truncated append-only file")
+
+// LoadAOF replays the commands of the append-only file at path. If the
+// file ends with an incomplete command or transaction, it is truncated to
+// the last complete one, and a warning is logged.
+func LoadAOF(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	ofs, err := ReplayAOF(f)
+	f.Close()
+	if err == ErrTruncatedAOF {
+		glog.Warningf("append-only file %s truncated at offset %d", path, ofs)
+		return os.Truncate(path, ofs)
+	}
+	return err
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReplayAOF executes the commands read from r, as a client connection
+// would, except that the replies are discarded. It returns the offset
+// following the last complete command. If r ends with an incomplete
+// command or transaction, it returns ErrTruncatedAOF and the offset
+// where it starts.
+func ReplayAOF(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	c := &netConn{}
+	defer c.Unwatch()
+
+	var ofs, multiOfs int64
+	for {
+		ar, err := resp.DecodeRequest(br)
+		if err != nil {
+			if err == io.EOF && cr.n-int64(br.Buffered()) == ofs {
+				break
+			}
+			if _, perr := br.Peek(1); perr == io.EOF {
+				// Incomplete command at the end of the file
+				return ofs, ErrTruncatedAOF
+			}
+			return ofs, fmt.Errorf("net: invalid append-only file at offset %d: %s", ofs, err)
+		}
+
+		name := strings.ToLower(ar[0])
+		if _, ok := cmd.Commands[name]; !ok {
+			return ofs, fmt.Errorf("net: unknown command '%s' in append-only file at offset %d", ar[0], ofs)
+		}
+		if name == "multi" {
+			multiOfs = ofs
+		}
+		if _, err := c.dispatch(ar); err != nil {
+			glog.Warningf("append-only file: %s at offset %d: %s", ar[0], ofs, err)
+		}
+		ofs = cr.n - int64(br.Buffered())
+	}
+
+	if c.tx.multi {
+		return multiOfs, ErrTruncatedAOF
+	}
+	return ofs, nil
+}
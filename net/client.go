@@ -0,0 +1,163 @@
+package net
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// pauseWriteCmds lists the commands that are paused by CLIENT PAUSE WRITE
+// in addition to the commands that modify the databases listed in
+// aofCmds: the blocking commands, which append themselves to the
+// append-only file, and PUBLISH.
+var pauseWriteCmds = map[string]bool{
+	"blmove":     true,
+	"blmpop":     true,
+	"blpop":      true,
+	"brpop":      true,
+	"brpoplpush": true,
+	"publish":    true,
+	"xreadgroup": true,
+}
+
+var _ srv.Client = (*netConn)(nil)
+
+// clientState holds the state of a connection reported by CLIENT LIST,
+// which is read by the other connections.
+type clientState struct {
+	mu   sync.Mutex
+	info srv.ClientInfo
+
+	// killed is 1 once the connection is killed, it is accessed
+	// atomically.
+	killed int32
+}
+
+// register registers the connection in the registry of clients. It returns
+// srv.ErrMaxClients if the maximum number of clients is reached.
+func (c *netConn) register() error {
+	c.cl.mu.Lock()
+	defer c.cl.mu.Unlock()
+
+	now := time.Now()
+	c.cl.info = srv.ClientInfo{
+		Addr:    c.RemoteAddr().String(),
+		LAddr:   c.LocalAddr().String(),
+		Created: now,
+		LastCmd: now,
+		Multi:   -1,
+	}
+	id, err := srv.DefaultClients.Register(c, srv.CurrentConfig().MaxClients)
+	c.cl.info.ID = id
+	return err
+}
+
+// ID returns the unique ID of the connection.
+func (c *netConn) ID() int64 {
+	return c.cl.info.ID
+}
+
+// Name returns the name of the connection.
+func (c *netConn) Name() string {
+	c.cl.mu.Lock()
+	defer c.cl.mu.Unlock()
+	return c.cl.info.Name
+}
+
+// SetName sets the name of the connection.
+func (c *netConn) SetName(name string) {
+	c.cl.mu.Lock()
+	defer c.cl.mu.Unlock()
+	c.cl.info.Name = name
+}
+
+// Info returns the current state of the connection.
+func (c *netConn) Info() srv.ClientInfo {
+	c.cl.mu.Lock()
+	defer c.cl.mu.Unlock()
+	return c.cl.info
+}
+
+// Kill closes the connection once its current command, if any, is
+// executed. The pending read is interrupted, which cancels a blocked
+// command.
+func (c *netConn) Kill() {
+	atomic.StoreInt32(&c.cl.killed, 1)
+	if c.Conn.SetReadDeadline(time.Now()) != nil {
+		c.Conn.Close()
+	}
+}
+
+// killed returns true if the connection was killed.
+func (c *netConn) killed() bool {
+	return atomic.LoadInt32(&c.cl.killed) == 1
+}
+
+// startCmd records the command that the connection starts executing.
+func (c *netConn) startCmd(name string) {
+	c.cl.mu.Lock()
+	defer c.cl.mu.Unlock()
+	c.cl.info.Cmd = name
+	c.cl.info.LastCmd = time.Now()
+}
+
+// endCmd records the state of the connection once a command is executed.
+func (c *netConn) endCmd() {
+	c.cl.mu.Lock()
+	defer c.cl.mu.Unlock()
+	ci := &c.cl.info
+	ci.DB = c.dbix
+	ci.Sub, ci.PSub = len(c.ps.channels), len(c.ps.patterns)
+	ci.Multi = -1
+	if c.tx.multi {
+		ci.Multi = len(c.tx.queue)
+	}
+}
+
+// setBlocked records whether the connection is blocked.
+func (c *netConn) setBlocked(blocked bool) {
+	c.cl.mu.Lock()
+	defer c.cl.mu.Unlock()
+	c.cl.info.Blocked = blocked
+}
+
+// writes returns the number of write commands that the command name
+// executes: the commands that modify the databases listed in aofCmds, and
+// the commands of pauseWriteCmds. EXEC executes the queued write commands,
+// and a command queued in a transaction executes none.
+func (c *netConn) writes(name string) int64 {
+	if c.tx.multi && !txImmediate[name] {
+		return 0
+	}
+	if name == "exec" {
+		var n int64
+		for _, qc := range c.tx.queue {
+			if writeCmd(strings.ToLower(qc.ar[0])) {
+				n++
+			}
+		}
+		return n
+	}
+	if writeCmd(name) {
+		return 1
+	}
+	return 0
+}
+
+// writeCmd returns true if the command name is a write command.
+func writeCmd(name string) bool {
+	_, ok := aofCmds[name]
+	return ok || pauseWriteCmds[name]
+}
+
+// waitPause waits while the clients are paused for the command name. The
+// CLIENT command is never paused, so that the clients can be unpaused.
+func (c *netConn) waitPause(name string) {
+	if name == "client" {
+		return
+	}
+	srv.DefaultClients.WaitPause(c.writes(name) > 0)
+}
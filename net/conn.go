@@ -7,6 +7,8 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/resp"
@@ -28,6 +30,29 @@ var _ srv.Conn = (*netConn)(nil)
 type netConn struct {
 	net.Conn
 	dbix int
+
+	// transaction state
+	tx txState
+
+	// publish/subscribe state
+	ps pubSubState
+
+	// state reported to the other clients
+	cl clientState
+
+	// replies are buffered in bw and flushed only when no more requests
+	// are readily available, so that pipelined requests are answered with
+	// a single write. Published messages are written to bw too, wmu
+	// serializes the writes.
+	wmu sync.Mutex
+	bw  *bufio.Writer
+
+	// requests are read from br, which is read ahead while the connection
+	// is blocked to detect a disconnection, if watch is true. The read
+	// ahead is interrupted by a read deadline, the connections that don't
+	// support it are not watched.
+	br    *bufio.Reader
+	watch bool
 }
 
 // NewNetConn creates a new NetConn for the underlying net.Conn network
@@ -36,32 +61,159 @@ func NewNetConn(c net.Conn) NetConn {
 	conn := &netConn{
 		Conn: c,
 	}
+	conn.bw = bufio.NewWriter(c)
 	return conn
 }
 
-// Select sets the connection's DB index to ix.
+// flushReader is an io.Reader that flushes the pending replies before
+// reading from the network, so that replies are sent once all requests
+// already received have been processed, and before blocking for more.
+type flushReader struct {
+	c *netConn
+}
+
+// Read flushes the buffered replies, if any, and reads from the
+// underlying network connection.
+func (f flushReader) Read(p []byte) (int, error) {
+	if err := f.c.flush(); err != nil {
+		return 0, err
+	}
+	return f.c.Conn.Read(p)
+}
+
+// flush writes the buffered replies to the network connection.
+func (c *netConn) flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.bw.Buffered() > 0 {
+		return c.bw.Flush()
+	}
+	return nil
+}
+
+// Select sets the connection's DB index to ix. The index is reported to
+// the other clients immediately, so that the selected databases are not
+// removed.
 func (c *netConn) Select(ix int) {
 	c.dbix = ix
+	c.cl.mu.Lock()
+	c.cl.info.DB = ix
+	c.cl.mu.Unlock()
+}
+
+// DB returns the connection's current database.
+func (c *netConn) DB() srv.DB {
+	db, ok := srv.DefaultServer.GetDB(c.dbix)
+	if !ok {
+		panic(fmt.Sprintf("invalid database index: %d", c.dbix))
+	}
+	return db
+}
+
+// Block releases the shared server lock held by the connection while the
+// command executes, so that transactions of other connections are not
+// stalled by a blocked client. It returns the channel closed if the client
+// disconnects while blocked, and the function that re-acquires the lock.
+// The connection cannot block while it executes a transaction.
+func (c *netConn) Block() (<-chan struct{}, func(), bool) {
+	if c.tx.exec {
+		return nil, nil, false
+	}
+	srv.DefaultServer.RUnlock()
+	c.wmu.Unlock()
+	c.setBlocked(true)
+
+	closed := make(chan struct{})
+	if !c.watch {
+		return closed, func() {
+			c.setBlocked(false)
+			c.wmu.Lock()
+			srv.DefaultServer.RLock()
+		}, true
+	}
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go c.watchClose(closed, stop, stopped)
+	return closed, func() {
+		// Interrupt the pending read of the watcher, and wait for it to
+		// return before the requests are read again.
+		close(stop)
+		c.Conn.SetReadDeadline(time.Now())
+		<-stopped
+		if !c.killed() {
+			c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		c.setBlocked(false)
+		c.wmu.Lock()
+		srv.DefaultServer.RLock()
+	}, true
+}
+
+// watchClose reads ahead from the connection while it is blocked, and closes
+// the closed channel if the client disconnects, until stop is closed. The
+// requests sent meanwhile are kept in the read buffer, once it is full the
+// connection is not watched anymore.
+func (c *netConn) watchClose(closed chan<- struct{}, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	for n := c.br.Buffered() + 1; n <= c.br.Size(); n++ {
+		if _, err := c.br.Peek(n); err != nil {
+			select {
+			case <-stop:
+			default:
+				if err != bufio.ErrBufferFull {
+					close(closed)
+				}
+			}
+			return
+		}
+	}
 }
 
 // Handle handles a connection to the server, and processes its requests.
 func (c *netConn) Handle() error {
 	defer c.Close()
+	defer c.flush()
+	defer c.Unwatch()
+	defer c.closePubSub()
+
+	c.watch = c.Conn.SetReadDeadline(time.Time{}) == nil
+
+	// Refuse the connection once the maximum number of clients is reached
+	if err := c.register(); err != nil {
+		c.wmu.Lock()
+		defer c.wmu.Unlock()
+		return c.writeResponse(nil, cmd.ErrMaxClients)
+	}
+	defer srv.DefaultClients.Unregister(c.ID())
+	srv.DefaultServer.CountConn()
 
-	br := bufio.NewReader(c)
+	// The reader only hits the network once all buffered requests are
+	// decoded, which is when the pending replies get flushed.
+	c.br = bufio.NewReader(flushReader{c})
 	for {
 		// Get the request
-		ar, err := resp.DecodeRequest(br)
+		ar, err := resp.DecodeRequest(c.br)
 		if err != nil {
+			// Connection closed by the client or killed, return
+			if err == io.EOF || c.killed() {
+				return nil
+			}
 			// Network error, return
 			if _, ok := err.(net.Error); ok {
 				return err
 			}
 			// Write the error to the client
-			err = resp.Encode(c, resp.Error(err.Error()))
-			if err != nil {
+			c.wmu.Lock()
+			werr := c.writeResponse(nil, err)
+			c.wmu.Unlock()
+			if werr != nil {
 				// If write failed, return
-				return errors.New("db.Conn.Handle: write failed: " + err.Error())
+				return errors.New("db.Conn.Handle: write failed: " + werr.Error())
+			}
+			// The rest of a request that is too big is not decoded
+			if err == resp.ErrInlineTooBig {
+				return nil
 			}
 			continue
 		}
@@ -70,53 +222,131 @@ func (c *netConn) Handle() error {
 			glog.Infof("[%s] command received: %v", c.RemoteAddr(), ar)
 		}
 
-		// Run the command
-		var res interface{}
-		var rerr error
-		if cd, ok := cmd.Commands[strings.ToLower(ar[0])]; ok {
-			args, ints, floats, err := cd.Parse(ar[0], ar[1:])
-			if err != nil {
-				rerr = err
-			} else {
-				switch cd := cd.(type) {
-				case cmd.DBCmd:
-					// Get the connection's current database
-					db, ok := srv.DefaultServer.GetDB(c.dbix)
-					if !ok {
-						panic(fmt.Sprintf("invalid database index: %d", c.dbix))
-					}
-					res, rerr = cd.ExecWithDB(db, args, ints, floats)
-				case cmd.SrvCmd:
-					res, rerr = cd.Exec(args, ints, floats)
-				case cmd.ConnCmd:
-					res, rerr = cd.ExecWithConn(c, args, ints, floats)
-				default:
-					panic(fmt.Sprintf("unsupported command type: %T", cd))
-				}
-			}
-		} else {
-			rerr = fmt.Errorf("ERR unknown command '%s'", ar[0])
-		}
-		err = c.writeResponse(res, rerr)
+		name := strings.ToLower(ar[0])
+		c.startCmd(name)
+		rerr, err := c.run(name, ar)
+		c.endCmd()
 		if err != nil {
 			return err
 		}
-		if rerr == cmd.ErrQuit {
+		if rerr == cmd.ErrQuit || c.killed() {
 			return nil
 		}
 	}
 }
 
-// writeResponse writes the response to the network connection.
+// run runs the command requested by ar and writes the reply, holding the
+// write lock so that no published message gets written before the reply to
+// a subscription. The lock is released even if the command panics. It
+// returns the error of the command, and the error of the write. The unknown
+// commands are only counted in the total of the statistics.
+func (c *netConn) run(name string, ar []string) (rerr, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	start, writes := time.Now(), c.writes(name)
+	res, rerr := c.dispatch(ar)
+	if _, ok := cmd.Commands[name]; ok {
+		srv.DefaultServer.CountCmd(name, time.Since(start))
+	} else {
+		srv.DefaultServer.CountCmd("", 0)
+	}
+	if rerr == nil && writes > 0 {
+		srv.DefaultServer.AddChanges(writes)
+	}
+	// A killed connection gets no more replies, except to the CLIENT
+	// command that killed it.
+	if c.killed() && name != "client" {
+		return rerr, nil
+	}
+	return rerr, c.writeResponse(res, rerr)
+}
+
+// dispatch looks up the command requested by ar, parses its arguments and
+// executes it, or queues it if a transaction is started.
+func (c *netConn) dispatch(ar []string) (interface{}, error) {
+	name := strings.ToLower(ar[0])
+	cd, ok := cmd.Commands[name]
+	if !ok {
+		c.tx.abortQueue()
+		return nil, fmt.Errorf("ERR unknown command '%s'", ar[0])
+	}
+	args, ints, floats, err := cd.Parse(ar[0], ar[1:])
+	if err != nil {
+		c.tx.abortQueue()
+		return nil, err
+	}
+
+	// Only a few commands are allowed once subscribed
+	if c.subscribed() {
+		if !subscribedCmds[name] {
+			return nil, cmd.ErrSubscribedMode
+		}
+		if name == "ping" {
+			return subscribedPong, nil
+		}
+	}
+
+	// Queue the command if a transaction is started
+	if c.tx.multi && !txImmediate[name] {
+		c.tx.queue = append(c.tx.queue, &queuedCmd{ar, cd, args, ints, floats})
+		return cmd.QueuedVal, nil
+	}
+
+	// Wait while the clients are paused
+	c.waitPause(name)
+
+	// EXEC acquires the exclusive server lock itself
+	if name == "exec" {
+		return c.execCmd(cd, args, ints, floats)
+	}
+
+	// CONFIG is executed under the exclusive server lock, so that no
+	// command uses the databases while their number changes.
+	if name == "config" {
+		srv.DefaultServer.Lock()
+		defer srv.DefaultServer.Unlock()
+		return c.execCmd(cd, args, ints, floats)
+	}
+
+	// Write commands are executed under the exclusive server lock while
+	// the append-only file is enabled, so that they are appended in the
+	// order they are executed.
+	if _, ok := aofCmds[name]; ok && srv.DefaultServer.AOFEnabled() {
+		srv.DefaultServer.Lock()
+		defer srv.DefaultServer.Unlock()
+		return c.execAppend(ar, cd, args, ints, floats)
+	}
+	srv.DefaultServer.RLock()
+	defer srv.DefaultServer.RUnlock()
+	return c.execCmd(cd, args, ints, floats)
+}
+
+// execCmd executes the command with the parsed arguments.
+func (c *netConn) execCmd(cd cmd.Cmd, args []string, ints []int64, floats []float64) (interface{}, error) {
+	switch cd := cd.(type) {
+	case cmd.DBCmd:
+		return cd.ExecWithDB(c.DB(), args, ints, floats)
+	case cmd.SrvCmd:
+		return cd.Exec(args, ints, floats)
+	case cmd.ConnCmd:
+		return cd.ExecWithConn(c, args, ints, floats)
+	default:
+		panic(fmt.Sprintf("unsupported command type: %T", cd))
+	}
+}
+
+// writeResponse writes the response to the connection's reply buffer. The
+// caller must hold the write lock.
 func (c *netConn) writeResponse(res interface{}, err error) error {
 	if err != nil {
 		if glog.V(2) {
 			glog.Infof("[%s] response sent: %v", c.RemoteAddr(), err)
 		}
-		return resp.Encode(c, resp.Error(err.Error()))
+		return resp.Encode(c.bw, resp.Error(err.Error()))
 	}
 	if glog.V(2) {
 		glog.Infof("[%s] response sent: %v", c.RemoteAddr(), res)
 	}
-	return resp.Encode(c, res)
+	return resp.Encode(c.bw, res)
 }
@@ -0,0 +1,980 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	_ "github.com/PuerkitoBio/gred/cmd/connection"
+	_ "github.com/PuerkitoBio/gred/cmd/hashes"
+	_ "github.com/PuerkitoBio/gred/cmd/hyperloglog"
+	_ "github.com/PuerkitoBio/gred/cmd/keys"
+	_ "github.com/PuerkitoBio/gred/cmd/lists"
+	_ "github.com/PuerkitoBio/gred/cmd/pubsub"
+	_ "github.com/PuerkitoBio/gred/cmd/server"
+	_ "github.com/PuerkitoBio/gred/cmd/streams"
+	_ "github.com/PuerkitoBio/gred/cmd/strings"
+	_ "github.com/PuerkitoBio/gred/cmd/transactions"
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// genLoad returns the same payload as the tools/genload command, n SET
+// commands encoded as RESP arrays.
+func genLoad(n int) []byte {
+	var buf bytes.Buffer
+	ar := make(resp.Array, 3)
+	for i := 0; i < n; i++ {
+		ar[0] = resp.BulkString("SET")
+		ar[1] = resp.BulkString(fmt.Sprintf("key_%d", i))
+		ar[2] = resp.BulkString(fmt.Sprintf("this is the string value for key #%d", i))
+		if err := resp.Encode(&buf, ar); err != nil {
+			panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// mockNetConn is a net.Conn that reads from a reader and counts the
+// writes, discarding the data unless a buffer is set.
+type mockNetConn struct {
+	r      io.Reader
+	out    io.Writer
+	writes int
+	closed bool
+}
+
+func (m *mockNetConn) Read(p []byte) (int, error) { return m.r.Read(p) }
+func (m *mockNetConn) Write(p []byte) (int, error) {
+	m.writes++
+	if m.out != nil {
+		return m.out.Write(p)
+	}
+	return len(p), nil
+}
+func (m *mockNetConn) Close() error                       { m.closed = true; return nil }
+func (m *mockNetConn) LocalAddr() net.Addr                { return mockAddr{} }
+func (m *mockNetConn) RemoteAddr() net.Addr               { return mockAddr{} }
+func (m *mockNetConn) SetDeadline(_ time.Time) error      { return nil }
+func (m *mockNetConn) SetReadDeadline(_ time.Time) error  { return errNoDeadline }
+func (m *mockNetConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+// errNoDeadline is returned by the mock connection, which doesn't support
+// read deadlines.
+var errNoDeadline = errors.New("deadline not supported")
+
+type mockAddr struct{}
+
+func (m mockAddr) Network() string { return "mock" }
+func (m mockAddr) String() string  { return "mock" }
+
+// oneReqReader returns a single request per Read call, like a client that
+// waits for each reply before sending the next request.
+type oneReqReader struct {
+	reqs [][]byte
+}
+
+func (o *oneReqReader) Read(p []byte) (int, error) {
+	if len(o.reqs) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, o.reqs[0])
+	o.reqs[0] = o.reqs[0][n:]
+	if len(o.reqs[0]) == 0 {
+		o.reqs = o.reqs[1:]
+	}
+	return n, nil
+}
+
+func TestHandlePipeline(t *testing.T) {
+	// Small enough to fit in the read buffer
+	const n = 50
+	var out bytes.Buffer
+	c := &mockNetConn{r: bytes.NewReader(genLoad(n)), out: &out}
+	if err := NewNetConn(c).Handle(); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 1 {
+		t.Errorf("expected 1 write, got %d", c.writes)
+	}
+	exp := bytes.Repeat([]byte("+OK\r\n"), n)
+	if !bytes.Equal(out.Bytes(), exp) {
+		t.Errorf("expected %d OK replies, got %q", n, out.String())
+	}
+}
+
+func TestHandleNoPipeline(t *testing.T) {
+	const n = 10
+	var out bytes.Buffer
+	r := &oneReqReader{}
+	for i := 0; i < n; i++ {
+		r.reqs = append(r.reqs, []byte("*2\r\n$3\r\nGET\r\n$1\r\nz\r\n"))
+	}
+	c := &mockNetConn{r: r, out: &out}
+	if err := NewNetConn(c).Handle(); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != n {
+		t.Errorf("expected %d writes, got %d", n, c.writes)
+	}
+	exp := bytes.Repeat([]byte("$-1\r\n"), n)
+	if !bytes.Equal(out.Bytes(), exp) {
+		t.Errorf("expected %d nil replies, got %q", n, out.String())
+	}
+}
+
+func TestHandlePanic(t *testing.T) {
+	cmd.Register("testpanic", cmd.NewSrvCmd(&cmd.ArgDef{}, func(_ []string, _ []int64, _ []float64) (interface{}, error) {
+		panic("test panic")
+	}))
+	defer delete(cmd.Commands, "testpanic")
+
+	// The connection is closed once the panic unwinds the handler
+	c := &mockNetConn{r: strings.NewReader("testpanic\r\n")}
+	done := make(chan interface{})
+	go func() {
+		defer func() { done <- recover() }()
+		NewNetConn(c).Handle()
+	}()
+	select {
+	case v := <-done:
+		if v == nil {
+			t.Error("expected a panic")
+		}
+		if !c.closed {
+			t.Error("expected the connection to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler blocked after the panic")
+	}
+}
+
+func TestHandleInlineTooBig(t *testing.T) {
+	// The connection is closed, the rest of the request is not decoded
+	var out bytes.Buffer
+	req := strings.Repeat("a", resp.MaxInlineSize) + "\r\nPING\r\n"
+	c := &mockNetConn{r: strings.NewReader(req), out: &out}
+	if err := NewNetConn(c).Handle(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "-" + resp.ErrInlineTooBig.Error() + "\r\n"; out.String() != exp {
+		t.Errorf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestHandleTransaction(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		0: {"MULTI\r\nSET tx 1\r\nGET tx\r\nEXEC\r\n",
+			"+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n$1\r\n1\r\n"},
+		1: {"MULTI\r\nEXEC\r\n", "+OK\r\n*0\r\n"},
+		2: {"MULTI\r\nSET tx 2\r\nDISCARD\r\nGET tx\r\n",
+			"+OK\r\n+QUEUED\r\n+OK\r\n$1\r\n1\r\n"},
+		3: {"MULTI\r\nSET tx\r\nSET tx 3\r\nEXEC\r\nGET tx\r\n",
+			"+OK\r\n-ERR wrong number of arguments for 'SET' command\r\n+QUEUED\r\n" +
+				"-EXECABORT Transaction discarded because of previous errors.\r\n$1\r\n1\r\n"},
+		4: {"MULTI\r\nNOTACMD\r\nEXEC\r\n",
+			"+OK\r\n-ERR unknown command 'NOTACMD'\r\n" +
+				"-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		5: {"MULTI\r\nMULTI\r\nHSET tx f v\r\nGET tx\r\nEXEC\r\n",
+			"+OK\r\n-ERR MULTI calls can not be nested\r\n+QUEUED\r\n+QUEUED\r\n" +
+				"*2\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n$1\r\n1\r\n"},
+		6: {"EXEC\r\nDISCARD\r\n", "-ERR EXEC without MULTI\r\n-ERR DISCARD without MULTI\r\n"},
+		7: {"MULTI\r\nBLPOP txlist 0\r\nEXEC\r\n", "+OK\r\n+QUEUED\r\n*1\r\n$-1\r\n"},
+
+		// Watched keys modified by the connection itself
+		8: {"WATCH wa\r\nSET wa 1\r\nMULTI\r\nGET wa\r\nEXEC\r\n",
+			"+OK\r\n+OK\r\n+OK\r\n+QUEUED\r\n*-1\r\n"},
+		9: {"WATCH wa wb\r\nGET wa\r\nMULTI\r\nGET wa\r\nEXEC\r\n",
+			"+OK\r\n$1\r\n1\r\n+OK\r\n+QUEUED\r\n*1\r\n$1\r\n1\r\n"},
+		10: {"WATCH wa\r\nDEL wa\r\nSET wa 1\r\nMULTI\r\nEXEC\r\n",
+			"+OK\r\n:1\r\n+OK\r\n+OK\r\n*-1\r\n"},
+		11: {"WATCH wc\r\nSET wc 1\r\nDEL wc\r\nMULTI\r\nEXEC\r\n",
+			"+OK\r\n+OK\r\n:1\r\n+OK\r\n*-1\r\n"},
+		12: {"WATCH wa\r\nSET wa 2\r\nUNWATCH\r\nMULTI\r\nEXEC\r\n",
+			"+OK\r\n+OK\r\n+OK\r\n+OK\r\n*0\r\n"},
+		13: {"WATCH wa\r\nFLUSHALL\r\nMULTI\r\nEXEC\r\n",
+			"+OK\r\n+OK\r\n+OK\r\n*-1\r\n"},
+		14: {"MULTI\r\nWATCH wa\r\nEXEC\r\n",
+			"+OK\r\n-ERR WATCH inside MULTI is not allowed\r\n*0\r\n"},
+
+		// Watched keys written to without change
+		15: {"SET wa 1\r\nWATCH wa wl\r\nSET wa 2 NX\r\nHSET wa f v\r\nLPOP wl\r\nMULTI\r\nGET wa\r\nEXEC\r\n",
+			"+OK\r\n+OK\r\n$-1\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" +
+				"$-1\r\n+OK\r\n+QUEUED\r\n*1\r\n$1\r\n1\r\n"},
+	}
+	for i, c := range cases {
+		var out bytes.Buffer
+		conn := &mockNetConn{r: bytes.NewReader([]byte(c.in)), out: &out}
+		if err := NewNetConn(conn).Handle(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.out {
+			t.Errorf("%d: expected %q, got %q", i, c.out, out.String())
+		}
+	}
+}
+
+func TestHandleWatch(t *testing.T) {
+	// Watch a key, then modify it from another connection before EXEC
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	conn := &mockNetConn{r: inr, out: outw}
+	go NewNetConn(conn).Handle()
+	br := bufio.NewReader(outr)
+
+	send := func(req string, exp string) {
+		io.WriteString(inw, req)
+		got := make([]byte, len(exp))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != exp {
+			t.Errorf("%q: expected %q, got %q", req, exp, got)
+		}
+	}
+
+	send("WATCH wx\r\n", "+OK\r\n")
+	other := &mockNetConn{r: bytes.NewReader([]byte("SET wx 1\r\n"))}
+	if err := NewNetConn(other).Handle(); err != nil {
+		t.Fatal(err)
+	}
+	send("MULTI\r\nSET wx 2\r\nEXEC\r\n", "+OK\r\n+QUEUED\r\n*-1\r\n")
+	send("GET wx\r\n", "$1\r\n1\r\n")
+	inw.Close()
+}
+
+func TestHandleConfigDatabases(t *testing.T) {
+	pipeConn := func() (func(string, string), io.Closer) {
+		inr, inw := io.Pipe()
+		outr, outw := io.Pipe()
+		go NewNetConn(&mockNetConn{r: inr, out: outw}).Handle()
+		br := bufio.NewReader(outr)
+		return func(req string, exp string) {
+			io.WriteString(inw, req)
+			got := make([]byte, len(exp))
+			if _, err := io.ReadFull(br, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != exp {
+				t.Errorf("%q: expected %q, got %q", req, exp, got)
+			}
+		}, inw
+	}
+	errDecrease := "-" + fmt.Sprintf(cmd.ConfigSetFailedFmt, "databases",
+		"can't decrease the number of databases while the removed databases are selected or hold keys") + "\r\n"
+
+	send, closer := pipeConn()
+	defer closer.Close()
+	send("CONFIG SET databases 20\r\nSELECT 19\r\nSET dk v\r\n", "+OK\r\n+OK\r\n+OK\r\n")
+
+	// Another client selected a removed database
+	other, otherCloser := pipeConn()
+	other("SELECT 18\r\n", "+OK\r\n")
+	send("SELECT 0\r\nCONFIG SET databases 16\r\n", "+OK\r\n"+errDecrease)
+	other("SELECT 1\r\n", "+OK\r\n")
+	otherCloser.Close()
+
+	// A removed database holds keys, then the client selects a removed
+	// database itself
+	send("CONFIG SET databases 16\r\n", errDecrease)
+	send("SELECT 19\r\nDEL dk\r\nCONFIG SET databases 16\r\n", "+OK\r\n:1\r\n"+errDecrease)
+	send("MULTI\r\nSELECT 0\r\nCONFIG SET databases 16\r\nEXEC\r\n", "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n+OK\r\n")
+	if n := srv.DefaultServer.Databases(); n != 16 {
+		t.Errorf("expected 16 databases, got %d", n)
+	}
+	send("SELECT 19\r\nCONFIG GET databases\r\n", "-ERR invalid DB index\r\n*2\r\n$9\r\ndatabases\r\n$2\r\n16\r\n")
+}
+
+func TestHandlePubSub(t *testing.T) {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	conn := &mockNetConn{r: inr, out: outw}
+	go NewNetConn(conn).Handle()
+	br := bufio.NewReader(outr)
+
+	expect := func(exp string) {
+		got := make([]byte, len(exp))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != exp {
+			t.Fatalf("expected %q, got %q", exp, got)
+		}
+	}
+	send := func(req string, exp string) {
+		io.WriteString(inw, req)
+		expect(exp)
+	}
+	publish := func(ch, msg string, exp string) {
+		var out bytes.Buffer
+		other := &mockNetConn{r: bytes.NewReader([]byte("PUBLISH " + ch + " " + msg + "\r\n")), out: &out}
+		if err := NewNetConn(other).Handle(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != exp {
+			t.Errorf("publish %s: expected %q, got %q", ch, exp, out.String())
+		}
+	}
+
+	send("SUBSCRIBE psa psb\r\n",
+		"*3\r\n$9\r\nsubscribe\r\n$3\r\npsa\r\n:1\r\n"+
+			"*3\r\n$9\r\nsubscribe\r\n$3\r\npsb\r\n:2\r\n")
+	send("GET psa\r\n",
+		"-ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context\r\n")
+	send("PING\r\n", "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+
+	publish("psa", "hello", ":1\r\n")
+	expect("*3\r\n$7\r\nmessage\r\n$3\r\npsa\r\n$5\r\nhello\r\n")
+	publish("psz", "hello", ":0\r\n")
+
+	send("PSUBSCRIBE ps*\r\n", "*3\r\n$10\r\npsubscribe\r\n$3\r\nps*\r\n:3\r\n")
+	publish("psb", "x", ":2\r\n")
+	expect("*3\r\n$7\r\nmessage\r\n$3\r\npsb\r\n$1\r\nx\r\n" +
+		"*4\r\n$8\r\npmessage\r\n$3\r\nps*\r\n$3\r\npsb\r\n$1\r\nx\r\n")
+
+	send("PUBSUB CHANNELS\r\n",
+		"-ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context\r\n")
+	send("UNSUBSCRIBE\r\n",
+		"*3\r\n$11\r\nunsubscribe\r\n$3\r\npsa\r\n:2\r\n"+
+			"*3\r\n$11\r\nunsubscribe\r\n$3\r\npsb\r\n:1\r\n")
+	send("UNSUBSCRIBE\r\n", "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:1\r\n")
+	send("PUNSUBSCRIBE ps*\r\n", "*3\r\n$12\r\npunsubscribe\r\n$3\r\nps*\r\n:0\r\n")
+
+	// No longer subscribed, all commands are allowed
+	send("PING\r\n", "+PONG\r\n")
+	send("PUBSUB NUMSUB psa\r\n", "*2\r\n$3\r\npsa\r\n:0\r\n")
+	inw.Close()
+}
+
+func TestHandleKeyspaceEvents(t *testing.T) {
+	defer srv.SetNotifyFlags(srv.NotifyFlags())
+	srv.SetNotifyFlags(srv.NotifyKeyevent | srv.NotifyGeneric | srv.NotifyExpired)
+
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	conn := &mockNetConn{r: inr, out: outw}
+	go NewNetConn(conn).Handle()
+	br := bufio.NewReader(outr)
+
+	expect := func(exp string) {
+		got := make([]byte, len(exp))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != exp {
+			t.Fatalf("expected %q, got %q", exp, got)
+		}
+	}
+
+	io.WriteString(inw, "SUBSCRIBE __keyevent@0__:del __keyevent@0__:expired\r\n")
+	expect("*3\r\n$9\r\nsubscribe\r\n$18\r\n__keyevent@0__:del\r\n:1\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$22\r\n__keyevent@0__:expired\r\n:2\r\n")
+
+	// The string event is not enabled, only del and expired are notified
+	other := &mockNetConn{r: bytes.NewReader([]byte("SET kse1 a\r\nSET kse2 b\r\n" +
+		"DEL kse1 kse3\r\nPEXPIRE kse2 1\r\n"))}
+	if err := NewNetConn(other).Handle(); err != nil {
+		t.Fatal(err)
+	}
+	expect("*3\r\n$7\r\nmessage\r\n$18\r\n__keyevent@0__:del\r\n$4\r\nkse1\r\n")
+	expect("*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:expired\r\n$4\r\nkse2\r\n")
+	inw.Close()
+}
+
+// blockedClient connects a client that selects the database 3 and sends
+// the request, and returns the client once the request is blocked, with the
+// reader of its replies and the channel that receives the result of
+// Handle.
+func blockedClient(t *testing.T, req string) (net.Conn, *bufio.Reader, <-chan error) {
+	client, server := net.Pipe()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	done := make(chan error, 1)
+	go func() { done <- NewNetConn(server).Handle() }()
+	go io.WriteString(client, "SELECT 3\r\n"+req)
+
+	// The reply to SELECT is only flushed once the connection is blocked
+	br := bufio.NewReader(client)
+	expectReply(t, br, "+OK\r\n")
+	return client, br, done
+}
+
+// expectReply reads the reply exp from br.
+func expectReply(t *testing.T, br *bufio.Reader, exp string) {
+	got := make([]byte, len(exp))
+	if _, err := io.ReadFull(br, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != exp {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+}
+
+// hasWaiter returns true if a client waits for values pushed to the list
+// key of the database 3. The waiter is removed.
+func hasWaiter(key string) bool {
+	db, _ := srv.DefaultServer.GetDB(3)
+	db.Lock()
+	defer db.Unlock()
+	_, ok := db.NextWaiter(key)
+	return ok
+}
+
+func TestHandleBlockDisconnect(t *testing.T) {
+	client, _, done := blockedClient(t, "BLPOP bdl 0\r\n")
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the blocked command to be cancelled")
+	}
+	if hasWaiter("bdl") {
+		t.Error("expected the waiter to be removed")
+	}
+
+	// The values pushed later are not lost
+	other := &mockNetConn{r: strings.NewReader("SELECT 3\r\nRPUSH bdl a\r\nLLEN bdl\r\nDEL bdl\r\n")}
+	var out bytes.Buffer
+	other.out = &out
+	if err := NewNetConn(other).Handle(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "+OK\r\n:1\r\n:1\r\n:1\r\n"; out.String() != exp {
+		t.Errorf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestHandleBlockOrder(t *testing.T) {
+	// The clients are served in the order they blocked, whatever the lists
+	// they wait for.
+	c1, br1, _ := blockedClient(t, "BLPOP bol1 bol2 0\r\n")
+	defer c1.Close()
+	c2, br2, _ := blockedClient(t, "BRPOP bol2 0\r\n")
+	defer c2.Close()
+	c3, br3, _ := blockedClient(t, "BLPOP bol2 0.1\r\n")
+	defer c3.Close()
+
+	// The fractional timeout expires first
+	start := time.Now()
+	expectReply(t, br3, "$-1\r\n")
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected a timeout of 100ms, got %s", d)
+	}
+
+	other := &mockNetConn{r: strings.NewReader("SELECT 3\r\nRPUSH bol2 a b c\r\n")}
+	if err := NewNetConn(other).Handle(); err != nil {
+		t.Fatal(err)
+	}
+	expectReply(t, br1, "*2\r\n$4\r\nbol2\r\n$1\r\na\r\n")
+	expectReply(t, br2, "*2\r\n$4\r\nbol2\r\n$1\r\nc\r\n")
+
+	// The waiters are removed from all lists once served
+	for _, key := range []string{"bol1", "bol2"} {
+		if hasWaiter(key) {
+			t.Errorf("%s: expected no waiter", key)
+		}
+	}
+	db, _ := srv.DefaultServer.GetDB(3)
+	db.Lock()
+	db.DelKey("bol2")
+	db.Unlock()
+}
+
+// readBulk reads a bulk string reply from br.
+func readBulk(t *testing.T, br *bufio.Reader) string {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if line[0] != '$' || err != nil {
+		t.Fatalf("expected a bulk string, got %q", line)
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+// clientFields returns the fields of the client named name in the reply
+// list of CLIENT LIST, or nil if there is no such client.
+func clientFields(list, name string) map[string]string {
+	for _, line := range strings.Split(list, "\n") {
+		fields := make(map[string]string)
+		for _, f := range strings.Fields(line) {
+			if i := strings.IndexByte(f, '='); i >= 0 {
+				fields[f[:i]] = f[i+1:]
+			}
+		}
+		if len(fields) > 0 && fields["name"] == name {
+			return fields
+		}
+	}
+	return nil
+}
+
+func TestHandleClient(t *testing.T) {
+	c1, br1, done := blockedClient(t, "CLIENT SETNAME blocked\r\nBLPOP hcl 0\r\n")
+	defer c1.Close()
+	expectReply(t, br1, "+OK\r\n")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go NewNetConn(server).Handle()
+	br := bufio.NewReader(client)
+	send := func(req string) {
+		if _, err := io.WriteString(client, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The blocked client is listed
+	send("SELECT 3\r\nCLIENT LIST\r\n")
+	expectReply(t, br, "+OK\r\n")
+	fields := clientFields(readBulk(t, br), "blocked")
+	if fields == nil {
+		t.Fatal("expected the blocked client to be listed")
+	}
+	for k, v := range map[string]string{"flags": "b", "db": "3", "cmd": "blpop", "multi": "-1"} {
+		if fields[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, fields[k])
+		}
+	}
+
+	// Killing it cancels the blocked command, without a reply
+	send("CLIENT KILL ID " + fields["id"] + "\r\n")
+	expectReply(t, br, ":1\r\n")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the killed client to be closed")
+	}
+	if _, err := br1.ReadByte(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if hasWaiter("hcl") {
+		t.Error("expected the waiter to be removed")
+	}
+
+	// The write commands are paused, not the read commands
+	send("CLIENT PAUSE 5000 WRITE\r\n")
+	expectReply(t, br, "+OK\r\n")
+	c3, s3 := net.Pipe()
+	defer c3.Close()
+	c3.SetDeadline(time.Now().Add(5 * time.Second))
+	go NewNetConn(s3).Handle()
+	got := make(chan string, 1)
+	go func() {
+		io.WriteString(c3, "SELECT 3\r\nSET hcl v\r\n")
+		buf := make([]byte, 10)
+		io.ReadFull(c3, buf)
+		got <- string(buf)
+	}()
+	select {
+	case s := <-got:
+		t.Fatalf("expected the SET to be paused, got %q", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+	send("GET hcl\r\n")
+	expectReply(t, br, "$-1\r\n")
+
+	send("CLIENT UNPAUSE\r\n")
+	expectReply(t, br, "+OK\r\n")
+	select {
+	case s := <-got:
+		if exp := "+OK\r\n+OK\r\n"; s != exp {
+			t.Errorf("expected %q, got %q", exp, s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the SET to be executed once unpaused")
+	}
+	send("DEL hcl\r\n")
+	expectReply(t, br, ":1\r\n")
+}
+
+func TestHandleInfo(t *testing.T) {
+	conn := &mockNetConn{r: strings.NewReader("CONFIG RESETSTAT\r\nSELECT 4\r\nSET hi 1\r\n" +
+		"GET hi\r\nGET hi2\r\nMGET hi hi2\r\nNOSUCHCMD\r\nINFO\r\nINFO commandstats\r\n" +
+		"INFO nosuchsection\r\nDEL hi\r\n")}
+	var out bytes.Buffer
+	conn.out = &out
+	if err := NewNetConn(conn).Handle(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection was received before the statistics were reset, and
+	// INFO is counted once executed.
+	got := out.String()
+	for _, exp := range []string{
+		"# Server\r\nredis_version:" + srv.RedisVersion + "\r\n",
+		"\r\n\r\n# Clients\r\n",
+		"# Stats\r\ntotal_connections_received:0\r\ntotal_commands_processed:7\r\n",
+		"keyspace_hits:2\r\nkeyspace_misses:2\r\n",
+		"# Keyspace\r\n",
+		"db4:keys=1,expires=0,avg_ttl=0\r\n",
+		"# Commandstats\r\ncmdstat_config:calls=1,",
+		"cmdstat_get:calls=2,",
+		"cmdstat_info:calls=1,",
+		"\r\n$0\r\n\r\n:1\r\n",
+	} {
+		if !strings.Contains(got, exp) {
+			t.Errorf("expected %q in %q", exp, got)
+		}
+	}
+	if strings.Count(got, "# Commandstats") != 1 || strings.Contains(got, "cmdstat_nosuchcmd") {
+		t.Errorf("expected the command statistics of the known commands once, got %q", got)
+	}
+}
+
+func TestNotifySlowSubscriber(t *testing.T) {
+	conn := &mockNetConn{}
+	c := NewNetConn(conn).(*netConn)
+	// Do not start the goroutine that pushes the messages
+	c.ps.msgs = make(chan []string, maxPendingMsgs)
+	for i := 0; i < maxPendingMsgs; i++ {
+		c.Notify([]string{"message", "ch", "msg"})
+	}
+	if conn.closed {
+		t.Fatal("expected connection to be open")
+	}
+	c.Notify([]string{"message", "ch", "msg"})
+	if !conn.closed {
+		t.Error("expected connection to be closed")
+	}
+}
+
+func benchmarkHandle(b *testing.B, pipeline bool) {
+	const n = 1000
+	load := genLoad(n)
+
+	// Split the load in individual requests for the non-pipelined case
+	var reqs [][]byte
+	if !pipeline {
+		for i, start := 0, 0; i < n; i++ {
+			ar := fmt.Sprintf("this is the string value for key #%d\r\n", i)
+			end := bytes.Index(load[start:], []byte(ar)) + start + len(ar)
+			reqs = append(reqs, load[start:end])
+			start = end
+		}
+	}
+
+	var writes int
+	b.SetBytes(int64(len(load)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var r io.Reader
+		if pipeline {
+			r = bytes.NewReader(load)
+		} else {
+			r = &oneReqReader{reqs: append([][]byte(nil), reqs...)}
+		}
+		c := &mockNetConn{r: r}
+		if err := NewNetConn(c).Handle(); err != nil {
+			b.Fatal(err)
+		}
+		writes += c.writes
+	}
+	b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+}
+
+func BenchmarkHandlePipelined(b *testing.B) {
+	benchmarkHandle(b, true)
+}
+
+func BenchmarkHandleNotPipelined(b *testing.B) {
+	benchmarkHandle(b, false)
+}
+
+func TestAOFFuncs(t *testing.T) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	cases := []struct {
+		fn  aofFn
+		ar  []string
+		res interface{}
+		exp [][]string
+	}{
+		0:  {aofSet, []string{"set", "k", "ex"}, cmd.OKVal, [][]string{{"set", "k", "ex"}}},
+		1:  {aofSet, []string{"set", "k", "v", "NX", "EX", "10"}, nil, [][]string{{"set", "k", "v", "NX", "pxat", "future"}}},
+		2:  {aofSet, []string{"set", "k", "v", "px", "10", "get"}, "old", [][]string{{"set", "k", "v", "pxat", "future", "get"}}},
+		3:  {aofSet, []string{"set", "k", "v", "exat", "10"}, cmd.OKVal, [][]string{{"set", "k", "v", "pxat", "10000"}}},
+		4:  {aofSet, []string{"set", "k", "v", "pxat", "10", "keepttl"}, cmd.OKVal, [][]string{{"set", "k", "v", "pxat", "10", "keepttl"}}},
+		5:  {aofGetEx, []string{"getex", "k"}, "v", nil},
+		6:  {aofGetEx, []string{"getex", "k", "ex", "10"}, nil, nil},
+		7:  {aofGetEx, []string{"getex", "k", "EX", "10"}, "v", [][]string{{"pexpireat", "k", "future"}}},
+		8:  {aofGetEx, []string{"getex", "k", "pxat", "10"}, "v", [][]string{{"pexpireat", "k", "10"}}},
+		9:  {aofGetEx, []string{"getex", "k", "persist"}, "v", [][]string{{"persist", "k"}}},
+		10: {aofSPop, []string{"spop", "k"}, nil, nil},
+		11: {aofSPop, []string{"spop", "k"}, "a", [][]string{{"srem", "k", "a"}}},
+		12: {aofSPop, []string{"spop", "k", "3"}, []string{"a", "b"}, [][]string{{"srem", "k", "a", "b"}}},
+		13: {aofSPop, []string{"spop", "k", "0"}, []string{}, nil},
+		14: {aofIncrByFloat, []string{"incrbyfloat", "k", "0.1"}, "1.1", [][]string{{"set", "k", "1.1", "keepttl"}}},
+		15: {aofHIncrByFloat, []string{"hincrbyfloat", "k", "f", "0.1"}, "1.1", [][]string{{"hset", "k", "f", "1.1"}}},
+	}
+	for i, c := range cases {
+		got := c.fn(c.ar, c.res)
+		// Relative expirations are appended as absolute times in the future
+		for _, ar := range got {
+			for j, s := range ar {
+				if ms, err := strconv.ParseInt(s, 10, 64); err == nil && ms > now {
+					ar[j] = "future"
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
+func TestHandleAOF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer srv.SetAOFPath(srv.AOFPath())
+	srv.SetAOFPath(filepath.Join(dir, "appendonly.aof"))
+	if err := srv.DefaultServer.OpenAOF(srv.FsyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.DefaultServer.CloseAOF()
+
+	// A client blocked on the list is served by the RPUSH
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	conn := &mockNetConn{r: inr, out: outw}
+	go NewNetConn(conn).Handle()
+	br := bufio.NewReader(outr)
+	io.WriteString(inw, "SELECT 2\r\nBLPOP aofl 0\r\n")
+
+	handle := func(in string) {
+		other := &mockNetConn{r: bytes.NewReader([]byte(in))}
+		if err := NewNetConn(other).Handle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handle("SELECT 2\r\nSET aofk v\r\nEXPIRE aofk 100\r\nEXPIRE nokey 100\r\nGET aofk\r\n" +
+		"SETEX aofk2 10 x\r\nINCR aofk\r\nRPUSH aofl a b\r\n")
+	exp := "+OK\r\n*2\r\n$4\r\naofl\r\n$1\r\na\r\n"
+	got := make([]byte, len(exp))
+	if _, err := io.ReadFull(br, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != exp {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+	inw.Close()
+	handle("SELECT 2\r\nMULTI\r\nINCR aofn\r\nEXEC\r\nMULTI\r\nGET aofn\r\nEXEC\r\n" +
+		"DEL aofk aofk2 aofl aofn\r\n")
+	if err := srv.DefaultServer.CloseAOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(srv.AOFPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var cmds [][]string
+	fr := bufio.NewReader(f)
+	for {
+		ar, err := resp.DecodeRequest(fr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, ar)
+	}
+
+	expCmds := [][]string{
+		{"select", "2"},
+		{"SET", "aofk", "v"},
+		{"pexpireat", "aofk", ""},
+		{"set", "aofk2", "x"},
+		{"pexpireat", "aofk2", ""},
+		{"rpush", "aofl", "a", "b"},
+		{"lpop", "aofl"},
+		{"multi"},
+		{"INCR", "aofn"},
+		{"exec"},
+		{"DEL", "aofk", "aofk2", "aofl", "aofn"},
+	}
+	if len(cmds) != len(expCmds) {
+		t.Fatalf("expected %d commands, got %d: %v", len(expCmds), len(cmds), cmds)
+	}
+	// Relative expirations are appended as absolute times in the future
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, i := range []int{2, 4} {
+		ms, _ := strconv.ParseInt(cmds[i][2], 10, 64)
+		if ms <= now {
+			t.Errorf("%d: expected expiration in the future, got %d", i, ms)
+		}
+		cmds[i][2] = ""
+	}
+	if !reflect.DeepEqual(cmds, expCmds) {
+		t.Errorf("expected %v, got %v", expCmds, cmds)
+	}
+}
+
+func TestHandleAOFStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer srv.SetAOFPath(srv.AOFPath())
+	srv.SetAOFPath(filepath.Join(dir, "appendonly.aof"))
+	if err := srv.DefaultServer.OpenAOF(srv.FsyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.DefaultServer.CloseAOF()
+
+	handle := func(in string) string {
+		var out bytes.Buffer
+		other := &mockNetConn{r: bytes.NewReader([]byte(in)), out: &out}
+		if err := NewNetConn(other).Handle(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	handle("SELECT 2\r\nXGROUP CREATE aofx g $ MKSTREAM\r\nXGROUP CREATECONSUMER aofx g c\r\n")
+
+	// A client blocked on the stream is served by the XADD
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	conn := &mockNetConn{r: inr, out: outw}
+	go NewNetConn(conn).Handle()
+	br := bufio.NewReader(outr)
+	io.WriteString(inw, "SELECT 2\r\nXREADGROUP GROUP g c BLOCK 0 STREAMS aofx >\r\n")
+	handle("SELECT 2\r\nXADD aofx * f v\r\n")
+
+	// +OK, then the array of the stream with its entry
+	var lines []string
+	for len(lines) < 14 {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+	}
+	id := lines[8]
+	inw.Close()
+	if err := srv.DefaultServer.CloseAOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(srv.AOFPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var cmds [][]string
+	fr := bufio.NewReader(f)
+	for {
+		ar, err := resp.DecodeRequest(fr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, ar)
+	}
+
+	// The automatic ID and the delivery time are appended as absolute values
+	expCmds := [][]string{
+		{"select", "2"},
+		{"xgroup", "CREATE", "aofx", "g", "0-0", "MKSTREAM"},
+		{"xgroup", "CREATECONSUMER", "aofx", "g", "c"},
+		{"xadd", "aofx", id, "f", "v"},
+		{"xclaim", "aofx", "g", "c", "0", id, "time", "", "retrycount", "1", "force", "justid"},
+		{"xgroup", "setid", "aofx", "g", id},
+	}
+	if len(cmds) == len(expCmds) {
+		cmds[4][7] = ""
+	}
+	if !reflect.DeepEqual(cmds, expCmds) {
+		t.Fatalf("expected %v, got %v", expCmds, cmds)
+	}
+
+	// Replaying the file restores the pending entry
+	handle("SELECT 2\r\nDEL aofx\r\n")
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReplayAOF(f); err != nil {
+		t.Fatal(err)
+	}
+	got := handle("SELECT 2\r\nXPENDING aofx g\r\nDEL aofx\r\n")
+	exp := fmt.Sprintf("+OK\r\n*4\r\n:1\r\n$%d\r\n%[2]s\r\n$%[1]d\r\n%[2]s\r\n*1\r\n*2\r\n$1\r\nc\r\n$1\r\n1\r\n:1\r\n", len(id), id)
+	if got != exp {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestReplayAOF(t *testing.T) {
+	const (
+		set   = "*3\r\n$3\r\nset\r\n$4\r\nrpk1\r\n$1\r\n1\r\n"
+		sel   = "*2\r\n$6\r\nselect\r\n$1\r\n3\r\n"
+		rpush = "*4\r\n$5\r\nrpush\r\n$4\r\nrpkl\r\n$1\r\na\r\n$1\r\nb\r\n"
+		multi = "*1\r\n$5\r\nmulti\r\n"
+		incr  = "*2\r\n$4\r\nincr\r\n$4\r\nrpk1\r\n"
+		exec  = "*1\r\n$4\r\nexec\r\n"
+	)
+	cases := []struct {
+		in  string
+		ofs int
+		err error
+		val string
+	}{
+		0: {sel + set + rpush, len(sel + set + rpush), nil, "1"},
+		1: {sel + set + multi + incr + exec, len(sel + set + multi + incr + exec), nil, "2"},
+		2: {sel + set + multi + incr, len(sel + set), ErrTruncatedAOF, "1"},
+		3: {sel + set + incr[:10], len(sel + set), ErrTruncatedAOF, "1"},
+	}
+	for i, c := range cases {
+		ofs, err := ReplayAOF(bytes.NewReader([]byte(c.in)))
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+		}
+		if ofs != int64(c.ofs) {
+			t.Errorf("%d: expected offset %d, got %d", i, c.ofs, ofs)
+		}
+
+		var out bytes.Buffer
+		conn := &mockNetConn{r: bytes.NewReader([]byte("SELECT 3\r\nGET rpk1\r\nDEL rpk1 rpkl\r\n")), out: &out}
+		if err := NewNetConn(conn).Handle(); err != nil {
+			t.Fatal(err)
+		}
+		exp := fmt.Sprintf("+OK\r\n$1\r\n%s\r\n", c.val)
+		if !strings.HasPrefix(out.String(), exp) {
+			t.Errorf("%d: expected %q, got %q", i, exp, out.String())
+		}
+	}
+
+	// Unknown commands are not skipped
+	if _, err := ReplayAOF(bytes.NewReader([]byte("*1\r\n$3\r\nfoo\r\n"))); err == nil {
+		t.Errorf("expected unknown command error")
+	}
+}
@@ -0,0 +1,180 @@
+package net
+
+import (
+	"sort"
+
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/golang/glog"
+)
+
+// maxPendingMsgs is the maximum number of published messages waiting to be
+// sent to a subscriber. A subscriber that falls further behind is
+// disconnected, so that PUBLISH never blocks on a slow client.
+const maxPendingMsgs = 1024
+
+// subscribedCmds is the set of commands allowed when the connection is
+// subscribed to at least one channel or pattern.
+var subscribedCmds = map[string]bool{
+	"ping":         true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"quit":         true,
+	"subscribe":    true,
+	"unsubscribe":  true,
+}
+
+// subscribedPong is the reply to PING when the connection is subscribed.
+var subscribedPong = resp.Array{"pong", ""}
+
+var _ srv.Subscriber = (*netConn)(nil)
+
+// pubSubState holds the subscriptions of a connection.
+type pubSubState struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// published messages waiting to be sent, created on the first
+	// subscription.
+	msgs chan []string
+}
+
+// subscribed returns true if the connection is subscribed to at least one
+// channel or pattern.
+func (c *netConn) subscribed() bool {
+	return len(c.ps.channels)+len(c.ps.patterns) > 0
+}
+
+// Notify queues a published message to send to the connection. It never
+// blocks: if the connection has too many pending messages, it is closed.
+func (c *netConn) Notify(m []string) {
+	select {
+	case c.ps.msgs <- m:
+	default:
+		glog.Errorf("[%s] subscriber too slow, closing connection", c.RemoteAddr())
+		c.Conn.Close()
+	}
+}
+
+// pushMessages writes the published messages to the connection, flushing
+// once all pending messages are written. It returns when the messages
+// channel is closed.
+func (c *netConn) pushMessages(msgs <-chan []string) {
+	for m := range msgs {
+		ar := make(resp.Array, len(m))
+		for i, s := range m {
+			ar[i] = s
+		}
+
+		c.wmu.Lock()
+		err := resp.Encode(c.bw, ar)
+		if err == nil && len(msgs) == 0 {
+			err = c.bw.Flush()
+		}
+		c.wmu.Unlock()
+
+		if err != nil {
+			// The reading side of the connection gets the error and
+			// terminates the connection, keep draining the messages.
+			glog.V(2).Infof("[%s] push message failed: %s", c.RemoteAddr(), err)
+		}
+	}
+}
+
+// initPubSub initializes the subscriptions state and starts the goroutine
+// that pushes the published messages, if it is not already started.
+func (c *netConn) initPubSub() {
+	if c.ps.msgs != nil {
+		return
+	}
+	c.ps.channels = make(map[string]struct{})
+	c.ps.patterns = make(map[string]struct{})
+	c.ps.msgs = make(chan []string, maxPendingMsgs)
+	go c.pushMessages(c.ps.msgs)
+}
+
+// closePubSub removes all subscriptions of the connection and stops the
+// goroutine that pushes the published messages.
+func (c *netConn) closePubSub() {
+	if c.ps.msgs == nil {
+		return
+	}
+	for ch := range c.ps.channels {
+		srv.DefaultPubSub.Unsubscribe(c, ch)
+	}
+	for pat := range c.ps.patterns {
+		srv.DefaultPubSub.PUnsubscribe(c, pat)
+	}
+	c.ps.channels, c.ps.patterns = nil, nil
+	// No more messages can be published to this connection once it is
+	// removed from the registry.
+	close(c.ps.msgs)
+}
+
+// Subscribe subscribes the connection to the channels.
+func (c *netConn) Subscribe(channels ...string) interface{} {
+	c.initPubSub()
+	return c.subscribe("subscribe", c.ps.channels, srv.DefaultPubSub.Subscribe, channels)
+}
+
+// PSubscribe subscribes the connection to the patterns.
+func (c *netConn) PSubscribe(patterns ...string) interface{} {
+	c.initPubSub()
+	return c.subscribe("psubscribe", c.ps.patterns, srv.DefaultPubSub.PSubscribe, patterns)
+}
+
+// Unsubscribe unsubscribes the connection from the channels, or from
+// all channels if none is specified.
+func (c *netConn) Unsubscribe(channels ...string) interface{} {
+	return c.unsubscribe("unsubscribe", c.ps.channels, srv.DefaultPubSub.Unsubscribe, channels)
+}
+
+// PUnsubscribe unsubscribes the connection from the patterns, or from
+// all patterns if none is specified.
+func (c *netConn) PUnsubscribe(patterns ...string) interface{} {
+	return c.unsubscribe("punsubscribe", c.ps.patterns, srv.DefaultPubSub.PUnsubscribe, patterns)
+}
+
+func (c *netConn) subscribe(kind string, subs map[string]struct{},
+	fn func(srv.Subscriber, string) bool, names []string) interface{} {
+
+	ret := make(resp.Replies, 0, len(names))
+	for _, nm := range names {
+		if _, ok := subs[nm]; !ok {
+			fn(c, nm)
+			subs[nm] = struct{}{}
+		}
+		ret = append(ret, c.subscriptionReply(kind, nm))
+	}
+	return ret
+}
+
+func (c *netConn) unsubscribe(kind string, subs map[string]struct{},
+	fn func(srv.Subscriber, string) bool, names []string) interface{} {
+
+	if len(names) == 0 {
+		if len(subs) == 0 {
+			return resp.Replies{resp.Array{kind, nil, int64(len(c.ps.channels) + len(c.ps.patterns))}}
+		}
+		for nm := range subs {
+			names = append(names, nm)
+		}
+		sort.Strings(names)
+	}
+
+	ret := make(resp.Replies, 0, len(names))
+	for _, nm := range names {
+		if _, ok := subs[nm]; ok {
+			fn(c, nm)
+			delete(subs, nm)
+		}
+		ret = append(ret, c.subscriptionReply(kind, nm))
+	}
+	return ret
+}
+
+// subscriptionReply returns the reply to a (un)subscription, which holds
+// the total number of subscriptions of the connection.
+func (c *netConn) subscriptionReply(kind, name string) resp.Array {
+	return resp.Array{kind, name, int64(len(c.ps.channels) + len(c.ps.patterns))}
+}
@@ -0,0 +1,178 @@
+package net
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// txImmediate lists the commands that are executed immediately instead
+// of being queued when a transaction is started.
+var txImmediate = map[string]bool{
+	"discard": true,
+	"exec":    true,
+	"multi":   true,
+	"quit":    true,
+	"watch":   true,
+}
+
+// queuedCmd holds a command queued in a transaction, with the request and
+// its parsed arguments.
+type queuedCmd struct {
+	ar     []string
+	cd     cmd.Cmd
+	args   []string
+	ints   []int64
+	floats []float64
+}
+
+// watchedKey holds a key watched by a connection, with its version at
+// the time WATCH was called.
+type watchedKey struct {
+	db   srv.DB
+	name string
+	ver  uint64
+}
+
+// txState holds the transaction state of a connection.
+type txState struct {
+	// multi is true once MULTI is called, until EXEC or DISCARD.
+	multi bool
+
+	// exec is true while the queued commands are executed.
+	exec bool
+
+	// aborted is true if a command could not be queued.
+	aborted bool
+
+	queue   []*queuedCmd
+	watched []watchedKey
+}
+
+// abortQueue marks the transaction as aborted, if one is started.
+func (t *txState) abortQueue() {
+	if t.multi {
+		t.aborted = true
+	}
+}
+
+// reset clears the transaction state.
+func (t *txState) reset() {
+	t.multi = false
+	t.aborted = false
+	t.queue = nil
+}
+
+// Multi starts a transaction.
+func (c *netConn) Multi() error {
+	if c.tx.multi {
+		return cmd.ErrNestedMulti
+	}
+	c.tx.multi = true
+	return nil
+}
+
+// Discard aborts the transaction.
+func (c *netConn) Discard() error {
+	if !c.tx.multi {
+		return cmd.ErrDiscardWithoutMulti
+	}
+	c.tx.reset()
+	c.Unwatch()
+	return nil
+}
+
+// Watch watches the keys in the connection's current database, so that
+// the next transaction is aborted if one of them is modified.
+func (c *netConn) Watch(names ...string) error {
+	if c.tx.multi {
+		return cmd.ErrWatchInMulti
+	}
+	db := c.DB()
+	db.Lock()
+	defer db.Unlock()
+	for _, nm := range names {
+		c.tx.watched = append(c.tx.watched, watchedKey{db, nm, db.Watch(nm)})
+	}
+	return nil
+}
+
+// Unwatch forgets about all watched keys.
+func (c *netConn) Unwatch() {
+	for _, wk := range c.tx.watched {
+		wk.db.Lock()
+		wk.db.Unwatch(wk.name)
+		wk.db.Unlock()
+	}
+	c.tx.watched = nil
+}
+
+// watchedChanged returns true if a watched key was modified since it was
+// watched.
+func (c *netConn) watchedChanged() bool {
+	for _, wk := range c.tx.watched {
+		wk.db.RLock()
+		ver := wk.db.Version(wk.name)
+		wk.db.RUnlock()
+		if ver != wk.ver {
+			return true
+		}
+	}
+	return false
+}
+
+// Exec executes the commands queued in the transaction, while holding the
+// exclusive server lock, and returns the array of their results. It returns
+// a nil array if a watched key was modified.
+func (c *netConn) Exec() (interface{}, error) {
+	if !c.tx.multi {
+		return nil, cmd.ErrExecWithoutMulti
+	}
+	queue, aborted := c.tx.queue, c.tx.aborted
+	c.tx.reset()
+	if aborted {
+		c.Unwatch()
+		return nil, cmd.ErrExecAbort
+	}
+
+	srv.DefaultServer.Lock()
+	defer srv.DefaultServer.Unlock()
+
+	changed := c.watchedChanged()
+	c.Unwatch()
+	if changed {
+		return resp.Array(nil), nil
+	}
+
+	c.tx.exec = true
+	defer func() { c.tx.exec = false }()
+
+	// Append the transaction's write commands to the append-only file
+	// between MULTI and EXEC, so that it is replayed atomically.
+	var writes bool
+	for _, q := range queue {
+		if _, ok := aofCmds[strings.ToLower(q.ar[0])]; ok {
+			writes = true
+			break
+		}
+	}
+	if writes {
+		srv.DefaultServer.Propagate(c.dbix, "multi")
+	}
+
+	res := make([]interface{}, len(queue))
+	for i, q := range queue {
+		v, err := c.execAppend(q.ar, q.cd, q.args, q.ints, q.floats)
+		if err != nil {
+			v = resp.Error(err.Error())
+		}
+		res[i] = v
+	}
+
+	if writes {
+		srv.DefaultServer.Propagate(c.dbix, "exec")
+	}
+	return res, nil
+}
@@ -0,0 +1,30 @@
+package rdb
+
+// crcPoly is the reflected Jones polynomial used by Redis to compute the
+// checksum of RDB files.
+const crcPoly = 0x95AC9329AC4BC9B5
+
+var crcTable [256]uint64
+
+func init() {
+	for i := range crcTable {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = (crc >> 1) ^ crcPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		crcTable[i] = crc
+	}
+}
+
+// crc64 updates the checksum crc with the bytes of p. Unlike the hash/crc64
+// package, the checksum is neither inverted on input nor on output.
+func crc64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crcTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
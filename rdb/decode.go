@@ -0,0 +1,465 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// Decode reads the RDB file from r, and calls fn for each entry. It stops
+// and returns the error if fn returns an error.
+func Decode(r io.Reader, fn func(*Entry) error) error {
+	d := &decoder{r: bufio.NewReader(r)}
+	return d.decode(fn)
+}
+
+// decoder holds the state of the decoding of an RDB file.
+type decoder struct {
+	r   *bufio.Reader
+	crc uint64
+	buf [8]byte
+}
+
+func (d *decoder) decode(fn func(*Entry) error) error {
+	b, err := d.readN(len(magic) + 4)
+	if err != nil {
+		return ErrInvalidHeader
+	}
+	if string(b[:len(magic)]) != magic {
+		return ErrInvalidHeader
+	}
+	ver, err := strconv.Atoi(string(b[len(magic):]))
+	if err != nil {
+		return ErrInvalidHeader
+	}
+	if ver < 1 || ver > Version {
+		return ErrUnsupportedVersion
+	}
+
+	var db int
+	var exp int64
+	for {
+		typ, err := d.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case opEOF:
+			if ver < 5 {
+				return nil
+			}
+			return d.checksum()
+
+		case opSelectDB:
+			n, err := d.readLen()
+			if err != nil {
+				return err
+			}
+			db = int(n)
+			continue
+
+		case opResizeDB:
+			if _, err := d.readLen(); err != nil {
+				return err
+			}
+			if _, err := d.readLen(); err != nil {
+				return err
+			}
+			continue
+
+		case opAux:
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+			continue
+
+		case opIdle:
+			if _, err := d.readLen(); err != nil {
+				return err
+			}
+			continue
+
+		case opFreq:
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+			continue
+
+		case opExpireTimeMs:
+			b, err := d.readN(8)
+			if err != nil {
+				return err
+			}
+			exp = int64(binary.LittleEndian.Uint64(b))
+			continue
+
+		case opExpireTime:
+			b, err := d.readN(4)
+			if err != nil {
+				return err
+			}
+			exp = int64(binary.LittleEndian.Uint32(b)) * 1000
+			continue
+
+		case opModuleAux:
+			return ErrUnsupportedType
+		}
+
+		name, err := d.readString()
+		if err != nil {
+			return err
+		}
+		v, err := d.readValue(typ)
+		if err != nil {
+			return err
+		}
+		if err := fn(&Entry{DB: db, Key: name, Value: v, ExpireAt: exp}); err != nil {
+			return err
+		}
+		exp = 0
+	}
+}
+
+// checksum reads the checksum at the end of the file, and validates it.
+// A checksum of 0 means that the checksum was not computed.
+func (d *decoder) checksum() error {
+	crc := d.crc
+	if _, err := io.ReadFull(d.r, d.buf[:8]); err != nil {
+		return err
+	}
+	if sum := binary.LittleEndian.Uint64(d.buf[:8]); sum != 0 && sum != crc {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// readValue reads a value of type typ.
+func (d *decoder) readValue(typ byte) (types.Value, error) {
+	switch typ {
+	case typeString:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return types.NewIncString(s), nil
+
+	case typeList:
+		vals, err := d.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		l := types.NewList()
+		l.RPush(vals...)
+		return l, nil
+
+	case typeSet:
+		vals, err := d.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		s := types.NewSet()
+		s.SAdd(vals...)
+		return s, nil
+
+	case typeHash:
+		vals, err := d.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		h := types.NewIncHash()
+		h.HMSet(vals...)
+		return h, nil
+
+	case typeZSet, typeZSet2:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		z := types.NewSortedSet()
+		for i := uint64(0); i < n; i++ {
+			m, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			var sc float64
+			if typ == typeZSet2 {
+				b, err := d.readN(8)
+				if err != nil {
+					return nil, err
+				}
+				sc = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			} else if sc, err = d.readFloat(); err != nil {
+				return nil, err
+			}
+			z.ZAdd(m, sc)
+		}
+		return z, nil
+
+	case typeListZiplist:
+		vals, err := d.readZiplist()
+		if err != nil {
+			return nil, err
+		}
+		l := types.NewList()
+		l.RPush(vals...)
+		return l, nil
+
+	case typeListQuicklist:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		l := types.NewList()
+		for i := uint64(0); i < n; i++ {
+			vals, err := d.readZiplist()
+			if err != nil {
+				return nil, err
+			}
+			l.RPush(vals...)
+		}
+		return l, nil
+
+	case typeSetIntset:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		vals, err := decodeIntset([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		set := types.NewSet()
+		set.SAdd(vals...)
+		return set, nil
+
+	case typeHashZiplist:
+		vals, err := d.readZiplist()
+		if err != nil {
+			return nil, err
+		}
+		if len(vals)%2 != 0 {
+			return nil, ErrInvalidEncoding
+		}
+		h := types.NewIncHash()
+		h.HMSet(vals...)
+		return h, nil
+
+	case typeZSetZiplist:
+		vals, err := d.readZiplist()
+		if err != nil {
+			return nil, err
+		}
+		if len(vals)%2 != 0 {
+			return nil, ErrInvalidEncoding
+		}
+		z := types.NewSortedSet()
+		for i := 0; i < len(vals); i += 2 {
+			sc, err := strconv.ParseFloat(vals[i+1], 64)
+			if err != nil {
+				return nil, ErrInvalidEncoding
+			}
+			z.ZAdd(vals[i], sc)
+		}
+		return z, nil
+
+	case typeStream:
+		return d.readStream()
+
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+// readZiplist reads a string holding a ziplist, and returns its entries.
+func (d *decoder) readZiplist() ([]string, error) {
+	s, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	return decodeZiplist([]byte(s))
+}
+
+// readStrings reads a length followed by mul times that number of strings.
+func (d *decoder) readStrings(mul int) ([]string, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]string, 0, int(n)*mul)
+	for i := 0; i < int(n)*mul; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, s)
+	}
+	return vals, nil
+}
+
+// readFloat reads a score encoded as a string prefixed by its length on
+// a single byte, with special lengths for NaN and the infinities.
+func (d *decoder) readFloat() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := d.readN(int(n))
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, ErrInvalidEncoding
+	}
+	return f, nil
+}
+
+// readString reads a string, which may be encoded as an integer or
+// compressed.
+func (d *decoder) readString() (string, error) {
+	n, enc, err := d.readLenEnc()
+	if err != nil {
+		return "", err
+	}
+	if !enc {
+		b, err := d.readN(int(n))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	switch n {
+	case encInt8:
+		b, err := d.readN(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(b[0]))), nil
+	case encInt16:
+		b, err := d.readN(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case encInt32:
+		b, err := d.readN(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case encLZF:
+		clen, err := d.readLen()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := d.readLen()
+		if err != nil {
+			return "", err
+		}
+		b, err := d.readN(int(clen))
+		if err != nil {
+			return "", err
+		}
+		ub, err := lzfDecompress(b, int(ulen))
+		if err != nil {
+			return "", err
+		}
+		return string(ub), nil
+	default:
+		return "", ErrInvalidEncoding
+	}
+}
+
+// readLen reads a length, which may not be a special encoding.
+func (d *decoder) readLen() (uint64, error) {
+	n, enc, err := d.readLenEnc()
+	if err != nil {
+		return 0, err
+	}
+	if enc {
+		return 0, ErrInvalidEncoding
+	}
+	return n, nil
+}
+
+// readLenEnc reads a length. If enc is true, the length is the special
+// encoding of the string that follows.
+func (d *decoder) readLenEnc() (n uint64, enc bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case len6Bit:
+		return uint64(b & 0x3F), false, nil
+	case len14Bit:
+		b2, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(b2), false, nil
+	case lenEnc:
+		return uint64(b & 0x3F), true, nil
+	}
+
+	switch b {
+	case len32Bit:
+		p, err := d.readN(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(p)), false, nil
+	case len64Bit:
+		p, err := d.readN(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(p), false, nil
+	default:
+		return 0, false, ErrInvalidEncoding
+	}
+}
+
+func (d *decoder) readByte() (byte, error) {
+	b, err := d.readN(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readN reads n bytes and updates the checksum. The returned slice is
+// only valid until the next read.
+func (d *decoder) readN(n int) ([]byte, error) {
+	var b []byte
+	if n <= len(d.buf) {
+		b = d.buf[:n]
+	} else {
+		b = make([]byte, n)
+	}
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	d.crc = crc64(d.crc, b)
+	return b, nil
+}
@@ -0,0 +1,224 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// Encoder writes entries to an RDB file.
+type Encoder struct {
+	w      *bufio.Writer
+	crc    uint64
+	buf    [9]byte
+	header bool
+	db     int
+}
+
+// NewEncoder creates an Encoder that writes to w. The header is written
+// with the first entry, and the file is terminated by a call to Close.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:  bufio.NewWriter(w),
+		db: -1,
+	}
+}
+
+// Encode writes the entry to the file. Entries of the same database must
+// be encoded consecutively.
+func (e *Encoder) Encode(ent *Entry) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	if ent.DB != e.db {
+		if err := e.writeByte(opSelectDB); err != nil {
+			return err
+		}
+		if err := e.writeLen(uint64(ent.DB)); err != nil {
+			return err
+		}
+		e.db = ent.DB
+	}
+	if ent.ExpireAt > 0 {
+		if err := e.writeByte(opExpireTimeMs); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(e.buf[:8], uint64(ent.ExpireAt))
+		if err := e.write(e.buf[:8]); err != nil {
+			return err
+		}
+	}
+
+	switch v := ent.Value.(type) {
+	case types.String:
+		return e.writeKey(typeString, ent.Key, func() error {
+			return e.writeString(v.Get())
+		})
+
+	case types.List:
+		return e.writeKey(typeList, ent.Key, func() error {
+			return e.writeStrings(v.LRange(0, -1))
+		})
+
+	case types.Set:
+		return e.writeKey(typeSet, ent.Key, func() error {
+			return e.writeStrings(v.SMembers())
+		})
+
+	case types.Hash:
+		return e.writeKey(typeHash, ent.Key, func() error {
+			vals := v.HGetAll()
+			if err := e.writeLen(uint64(len(vals) / 2)); err != nil {
+				return err
+			}
+			for _, s := range vals {
+				if err := e.writeString(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	case types.SortedSet:
+		return e.writeKey(typeZSet2, ent.Key, func() error {
+			sms := v.ZRange(0, -1)
+			if err := e.writeLen(uint64(len(sms))); err != nil {
+				return err
+			}
+			for _, sm := range sms {
+				if err := e.writeString(sm.Member); err != nil {
+					return err
+				}
+				binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(sm.Score))
+				if err := e.write(e.buf[:8]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	case types.Stream:
+		return e.writeKey(typeStream, ent.Key, func() error {
+			return e.writeStream(v)
+		})
+
+	default:
+		return ErrInvalidValue
+	}
+}
+
+// Close terminates the file with the EOF opcode and the checksum, and
+// flushes the buffered data. It does not close the underlying writer.
+func (e *Encoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	if err := e.writeByte(opEOF); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	if _, err := e.w.Write(e.buf[:8]); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// writeHeader writes the magic string and the version, if it wasn't
+// written yet.
+func (e *Encoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.write([]byte(fmt.Sprintf("%s%04d", magic, Version)))
+}
+
+// writeKey writes the type of the value, the name of the key and the
+// value, using fn.
+func (e *Encoder) writeKey(typ byte, name string, fn func() error) error {
+	if err := e.writeByte(typ); err != nil {
+		return err
+	}
+	if err := e.writeString(name); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// writeStrings writes the number of strings, followed by the strings.
+func (e *Encoder) writeStrings(vals []string) error {
+	if err := e.writeLen(uint64(len(vals))); err != nil {
+		return err
+	}
+	for _, s := range vals {
+		if err := e.writeString(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeString writes the string s, using the integer encoding if it is the
+// canonical representation of a 32-bit integer.
+func (e *Encoder) writeString(s string) error {
+	if len(s) > 0 && len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			return e.writeInt(n)
+		}
+	}
+	if err := e.writeLen(uint64(len(s))); err != nil {
+		return err
+	}
+	return e.write([]byte(s))
+}
+
+// writeInt writes the integer n using the smallest integer encoding.
+func (e *Encoder) writeInt(n int64) error {
+	var b []byte
+	switch {
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		b = append(e.buf[:0], lenEnc<<6|encInt8, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		b = append(e.buf[:0], lenEnc<<6|encInt16, 0, 0)
+		binary.LittleEndian.PutUint16(b[1:], uint16(n))
+	default:
+		b = append(e.buf[:0], lenEnc<<6|encInt32, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[1:], uint32(n))
+	}
+	return e.write(b)
+}
+
+// writeLen writes the length n using the smallest length encoding.
+func (e *Encoder) writeLen(n uint64) error {
+	var b []byte
+	switch {
+	case n < 1<<6:
+		b = append(e.buf[:0], len6Bit<<6|byte(n))
+	case n < 1<<14:
+		b = append(e.buf[:0], len14Bit<<6|byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		b = append(e.buf[:0], len32Bit, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+	default:
+		b = append(e.buf[:0], len64Bit, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[1:], n)
+	}
+	return e.write(b)
+}
+
+func (e *Encoder) writeByte(b byte) error {
+	e.buf[0] = b
+	return e.write(e.buf[:1])
+}
+
+// write writes p and updates the checksum.
+func (e *Encoder) write(p []byte) error {
+	e.crc = crc64(e.crc, p)
+	_, err := e.w.Write(p)
+	return err
+}
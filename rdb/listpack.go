@@ -0,0 +1,200 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// listpackHeaderLen is the length of the listpack header: the total number
+// of bytes and the number of elements.
+const listpackHeaderLen = 6
+
+// listpackEnd is the byte that terminates a listpack.
+const listpackEnd = 0xFF
+
+// listpackMaxCount is the number of elements stored in the header when it
+// is too large to be represented.
+const listpackMaxCount = 0xFFFF
+
+// decodeListpack returns the elements of the listpack b. Integer elements
+// are returned in their string representation.
+func decodeListpack(b []byte) ([]string, error) {
+	if len(b) < listpackHeaderLen+1 {
+		return nil, ErrInvalidEncoding
+	}
+	var vals []string
+
+	i := listpackHeaderLen
+	for {
+		if i >= len(b) {
+			return nil, ErrInvalidEncoding
+		}
+		enc := b[i]
+		if enc == listpackEnd {
+			return vals, nil
+		}
+
+		start := i
+		i++
+		var (
+			str  bool
+			slen int
+			ilen int
+		)
+		switch {
+		case enc>>7 == 0:
+			vals = append(vals, strconv.Itoa(int(enc)))
+		case enc>>6 == 2:
+			str, slen = true, int(enc&0x3F)
+		case enc>>5 == 6:
+			if i >= len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			v := int(enc&0x1F)<<8 | int(b[i])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			vals = append(vals, strconv.Itoa(v))
+			i++
+		case enc>>4 == 0xE:
+			if i >= len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			str, slen = true, int(enc&0x0F)<<8|int(b[i])
+			i++
+		case enc == 0xF0:
+			if i+4 > len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			str, slen = true, int(binary.LittleEndian.Uint32(b[i:]))
+			i += 4
+		case enc == 0xF1:
+			ilen = 2
+		case enc == 0xF2:
+			ilen = 3
+		case enc == 0xF3:
+			ilen = 4
+		case enc == 0xF4:
+			ilen = 8
+		default:
+			return nil, ErrInvalidEncoding
+		}
+
+		switch {
+		case ilen > 0:
+			if i+ilen > len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			vals = append(vals, strconv.FormatInt(leInt(b[i:i+ilen]), 10))
+			i += ilen
+		case str:
+			if slen < 0 || i+slen > len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			vals = append(vals, string(b[i:i+slen]))
+			i += slen
+		}
+
+		// Skip the length of the element, used to traverse it backward
+		i += backlenSize(i - start)
+	}
+}
+
+// listpack builds a listpack.
+type listpack struct {
+	b []byte
+	n int
+}
+
+func newListpack() *listpack {
+	return &listpack{b: make([]byte, listpackHeaderLen, 256)}
+}
+
+// appendString appends the string s, using the integer encoding if it is
+// the canonical representation of a 64-bit integer.
+func (lp *listpack) appendString(s string) {
+	if len(s) > 0 && len(s) <= 20 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+			lp.appendInt(n)
+			return
+		}
+	}
+
+	start := len(lp.b)
+	switch n := len(s); {
+	case n < 1<<6:
+		lp.b = append(lp.b, 0x80|byte(n))
+	case n < 1<<12:
+		lp.b = append(lp.b, 0xE0|byte(n>>8), byte(n))
+	default:
+		lp.b = append(lp.b, 0xF0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(lp.b[len(lp.b)-4:], uint32(n))
+	}
+	lp.b = append(lp.b, s...)
+	lp.appendBacklen(len(lp.b) - start)
+}
+
+// appendInt appends the integer v using the smallest integer encoding.
+func (lp *listpack) appendInt(v int64) {
+	start := len(lp.b)
+	switch {
+	case v >= 0 && v <= 127:
+		lp.b = append(lp.b, byte(v))
+	case v >= -1<<12 && v < 1<<12:
+		u := uint64(v) & 0x1FFF
+		lp.b = append(lp.b, 0xC0|byte(u>>8), byte(u))
+	case v >= -1<<15 && v < 1<<15:
+		lp.b = append(lp.b, 0xF1, byte(v), byte(v>>8))
+	case v >= -1<<23 && v < 1<<23:
+		lp.b = append(lp.b, 0xF2, byte(v), byte(v>>8), byte(v>>16))
+	case v >= -1<<31 && v < 1<<31:
+		lp.b = append(lp.b, 0xF3, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(lp.b[len(lp.b)-4:], uint32(v))
+	default:
+		lp.b = append(lp.b, 0xF4, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(lp.b[len(lp.b)-8:], uint64(v))
+	}
+	lp.appendBacklen(len(lp.b) - start)
+}
+
+// appendBacklen appends the length l of the element, and counts the
+// element. The length is encoded so that it can be read backward, 7 bits
+// per byte, the most significant bits first.
+func (lp *listpack) appendBacklen(l int) {
+	for i := backlenSize(l) - 1; i >= 0; i-- {
+		c := byte(l>>uint(7*i)) & 127
+		if i < backlenSize(l)-1 {
+			c |= 128
+		}
+		lp.b = append(lp.b, c)
+	}
+	lp.n++
+}
+
+// bytes terminates the listpack, and returns its bytes.
+func (lp *listpack) bytes() []byte {
+	lp.b = append(lp.b, listpackEnd)
+	binary.LittleEndian.PutUint32(lp.b[0:4], uint32(len(lp.b)))
+	n := lp.n
+	if n > listpackMaxCount {
+		n = listpackMaxCount
+	}
+	binary.LittleEndian.PutUint16(lp.b[4:6], uint16(n))
+	return lp.b
+}
+
+// backlenSize returns the number of bytes of the encoded length l of an
+// element.
+func backlenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
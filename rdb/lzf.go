@@ -0,0 +1,49 @@
+package rdb
+
+// lzfDecompress decompresses the LZF-compressed data in, whose
+// decompressed length is ulen.
+func lzfDecompress(in []byte, ulen int) ([]byte, error) {
+	out := make([]byte, 0, ulen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			// Literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > ulen {
+				return nil, ErrInvalidEncoding
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// Back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, ErrInvalidEncoding
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, ErrInvalidEncoding
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > ulen {
+			return nil, ErrInvalidEncoding
+		}
+		// Copy byte by byte, the reference may overlap the output
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != ulen {
+		return nil, ErrInvalidEncoding
+	}
+	return out, nil
+}
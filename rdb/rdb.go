@@ -0,0 +1,110 @@
+// Package rdb implements an encoder and a decoder for the Redis RDB file
+// format, used to persist the databases on disk.
+//
+// The encoder writes files of version 9, using the plain encodings of the
+// values, and listpacks for the streams, which have no plain encoding. The
+// decoder reads files up to version 9, including the compact encodings
+// (ziplists, listpacks, intsets and LZF-compressed strings).
+//
+// See https://github.com/sripathikrishnan/redis-rdb-tools/wiki/Redis-RDB-Dump-File-Format
+// for a description of the format.
+package rdb
+
+import (
+	"errors"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// Version is the version of the RDB files written by the Encoder. It is
+// also the highest version supported by the decoder.
+const Version = 9
+
+// magic is the signature at the start of an RDB file, followed by the
+// version on 4 digits.
+const magic = "REDIS"
+
+// Opcodes of the RDB format.
+const (
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+)
+
+// Value types of the RDB format.
+const (
+	typeString        = 0
+	typeList          = 1
+	typeSet           = 2
+	typeZSet          = 3
+	typeHash          = 4
+	typeZSet2         = 5
+	typeListZiplist   = 10
+	typeSetIntset     = 11
+	typeZSetZiplist   = 12
+	typeHashZiplist   = 13
+	typeListQuicklist = 14
+	typeStream        = 15
+)
+
+// Length encodings, identified by the 2 most significant bits of the first
+// byte, and the special string encodings.
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	lenEnc   = 3
+
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+var (
+	// ErrInvalidHeader is returned if the data does not start with a valid
+	// RDB header.
+	ErrInvalidHeader = errors.New("rdb: invalid header")
+
+	// ErrUnsupportedVersion is returned if the version of the file is not
+	// supported.
+	ErrUnsupportedVersion = errors.New("rdb: unsupported version")
+
+	// ErrUnsupportedType is returned if the file contains a value type or an
+	// opcode that is not supported.
+	ErrUnsupportedType = errors.New("rdb: unsupported value type")
+
+	// ErrInvalidEncoding is returned if an encoded value is corrupted.
+	ErrInvalidEncoding = errors.New("rdb: invalid encoding")
+
+	// ErrChecksum is returned if the checksum of the file does not match
+	// its content.
+	ErrChecksum = errors.New("rdb: checksum mismatch")
+
+	// ErrInvalidValue is returned if the value to encode is not supported.
+	ErrInvalidValue = errors.New("rdb: invalid value")
+)
+
+// Entry is a key stored in an RDB file.
+type Entry struct {
+	// DB is the index of the database of the key.
+	DB int
+
+	// Key is the name of the key.
+	Key string
+
+	// Value is the value of the key. Decoded strings are IncString
+	// values and decoded hashes are IncHash values.
+	Value types.Value
+
+	// ExpireAt is the expiration time of the key, as a Unix timestamp
+	// in milliseconds. It is 0 if the key does not expire.
+	ExpireAt int64
+}
@@ -0,0 +1,401 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func TestCRC64(t *testing.T) {
+	// Test vector of the Redis implementation
+	if got := crc64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("expected %x, got %x", uint64(0xe9c6d914c4b8d9ca), got)
+	}
+}
+
+func newList(vals ...string) types.List {
+	l := types.NewList()
+	l.RPush(vals...)
+	return l
+}
+
+func newSet(vals ...string) types.Set {
+	s := types.NewSet()
+	s.SAdd(vals...)
+	return s
+}
+
+func newHash(vals ...string) types.Hash {
+	h := types.NewHash()
+	h.HMSet(vals...)
+	return h
+}
+
+func newSortedSet(sms ...types.ScoredMember) types.SortedSet {
+	z := types.NewSortedSet()
+	for _, sm := range sms {
+		z.ZAdd(sm.Member, sm.Score)
+	}
+	return z
+}
+
+func newStream(n int) types.Stream {
+	s := types.NewStream()
+	for i := 1; i <= n; i++ {
+		// Change the fields every 30 entries, and the ms every 7 entries
+		id := types.StreamID{Ms: uint64(1000 + i/7), Seq: uint64(i % 7)}
+		if i%30 == 0 {
+			s.XAdd(id, "g", strconv.Itoa(i), "h", "")
+			continue
+		}
+		s.XAdd(id, "f", strconv.Itoa(i*1000), "v", "x")
+	}
+	s.SetLastID(types.StreamID{Ms: 5000})
+
+	s.CreateGroup("g1", types.StreamID{})
+	g, _ := s.Group("g1")
+	now := time.Unix(1600000000, 123000000)
+	g.CreateConsumer("idle", now)
+	g.Read("c1", 3, false, now)
+	g.Read("c2", 2, false, now.Add(time.Second))
+	g.Claim("c2", []types.StreamID{{Ms: 1000, Seq: 1}}, types.StreamClaim{RetryCount: 5}, now.Add(time.Minute))
+	s.XDel(types.StreamID{Ms: 1000, Seq: 2})
+	s.CreateGroup("g0", types.StreamID{Ms: 1001})
+	return s
+}
+
+type streamDump struct {
+	Entries   []types.StreamEntry
+	Last      types.StreamID
+	Groups    []string
+	Pending   [][]types.StreamPending
+	Consumers [][]types.StreamConsumer
+}
+
+// dump returns a comparable representation of the value.
+func dump(v types.Value) interface{} {
+	switch v := v.(type) {
+	case types.String:
+		return v.Get()
+	case types.List:
+		return v.LRange(0, -1)
+	case types.Set:
+		m := make(map[string]bool)
+		for _, s := range v.SMembers() {
+			m[s] = true
+		}
+		return m
+	case types.Hash:
+		m := make(map[string]string)
+		vals := v.HGetAll()
+		for i := 0; i < len(vals); i += 2 {
+			m[vals[i]] = vals[i+1]
+		}
+		return m
+	case types.SortedSet:
+		return v.ZRange(0, -1)
+	case types.Stream:
+		sd := streamDump{
+			Entries: v.XRange(types.StreamID{}, types.MaxStreamID, -1),
+			Last:    v.LastID(),
+		}
+		for _, g := range v.Groups() {
+			pel := g.Pending(types.StreamID{}, types.MaxStreamID, -1, "", 0, time.Time{})
+			for i := range pel {
+				pel[i].Delivered = timeMs(msTime(pel[i].Delivered))
+			}
+			cons := g.Consumers()
+			for i := range cons {
+				cons[i].Seen = timeMs(msTime(cons[i].Seen))
+			}
+			sd.Groups = append(sd.Groups, g.Name()+" "+g.LastID().String())
+			sd.Pending = append(sd.Pending, pel)
+			sd.Consumers = append(sd.Consumers, cons)
+		}
+		return sd
+	}
+	return nil
+}
+
+func TestEncodeDecode(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 20000))
+	ents := []*Entry{
+		{0, "s", types.NewString("value"), 0},
+		{0, "i", types.NewString("-123456"), 1500000000123},
+		{0, "i8", types.NewString("12"), 0},
+		{0, "notint", types.NewString("0012"), 0},
+		{0, "empty", types.NewString(""), 0},
+		{0, "long", types.NewString(long), 0},
+		{0, "l", newList("a", "1", "", "c"), 0},
+		{3, "set", newSet("a", "b", "300"), 0},
+		{3, "h", newHash("f1", "v1", "f2", "2"), 1600000000000},
+		{3, "z", newSortedSet(types.ScoredMember{Member: "a", Score: 1.5}, types.ScoredMember{Member: "b", Score: math.Inf(-1)}, types.ScoredMember{Member: "c", Score: 2}), 0},
+		{15, "s", types.NewString("db15"), 0},
+		{15, "x", newStream(250), 0},
+		{15, "xempty", types.NewStream(), 0},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i, ent := range ents {
+		if err := enc.Encode(ent); err != nil {
+			t.Fatalf("%d: encode failed: %s", i, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("close failed: %s", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0009")) {
+		t.Fatalf("expected REDIS0009 header, got %q", buf.Bytes()[:9])
+	}
+
+	var got []*Entry
+	err := Decode(bytes.NewReader(buf.Bytes()), func(ent *Entry) error {
+		got = append(got, ent)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(got) != len(ents) {
+		t.Fatalf("expected %d entries, got %d", len(ents), len(got))
+	}
+	for i, exp := range ents {
+		g := got[i]
+		if g.DB != exp.DB || g.Key != exp.Key || g.ExpireAt != exp.ExpireAt {
+			t.Errorf("%d: expected %d %s %d, got %d %s %d", i, exp.DB, exp.Key, exp.ExpireAt, g.DB, g.Key, g.ExpireAt)
+		}
+		if g.Value.Type() != exp.Value.Type() {
+			t.Errorf("%d: expected type %s, got %s", i, exp.Value.Type(), g.Value.Type())
+		}
+		if !reflect.DeepEqual(dump(g.Value), dump(exp.Value)) {
+			t.Errorf("%d: expected %v, got %v", i, dump(exp.Value), dump(g.Value))
+		}
+	}
+
+	// Decoded strings and hashes support increments
+	if _, ok := got[0].Value.(types.IncString); !ok {
+		t.Errorf("expected decoded string to be an IncString")
+	}
+	if _, ok := got[8].Value.(types.IncHash); !ok {
+		t.Errorf("expected decoded hash to be an IncHash")
+	}
+
+	// Corrupt the first string value
+	b := buf.Bytes()
+	b[16] ^= 0xFF
+	err = Decode(bytes.NewReader(b), func(ent *Entry) error { return nil })
+	if err != ErrChecksum {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	err := Decode(&buf, func(ent *Entry) error {
+		t.Errorf("unexpected entry %s", ent.Key)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	cases := []struct {
+		data string
+		err  error
+	}{
+		0: {"", ErrInvalidHeader},
+		1: {"REDIX0009", ErrInvalidHeader},
+		2: {"REDIS00a9", ErrInvalidHeader},
+		3: {"REDIS0010\xff", ErrUnsupportedVersion},
+		4: {"REDIS0009\x07\x01k", ErrUnsupportedType},
+		5: {"REDIS0009\x00\x01k\x01v\xff\x00\x00\x00\x00\x00\x00\x00\x01", ErrChecksum},
+	}
+	for i, c := range cases {
+		err := Decode(bytes.NewBufferString(c.data), func(ent *Entry) error { return nil })
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+// rdbFile builds an RDB file holding the specified raw data, with a
+// valid checksum.
+func rdbFile(ver string, data ...[]byte) []byte {
+	b := []byte("REDIS" + ver)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	b = append(b, opEOF)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], crc64(0, b))
+	return append(b, sum[:]...)
+}
+
+// rawString encodes p as a string with a 6-bit length.
+func rawString(p []byte) []byte {
+	return append([]byte{byte(len(p))}, p...)
+}
+
+func TestDecodeCompact(t *testing.T) {
+	ziplist := func(entries ...[]byte) []byte {
+		var b []byte
+		for _, e := range entries {
+			b = append(b, 0) // previous entry length, ignored
+			b = append(b, e...)
+		}
+		hdr := make([]byte, ziplistHeaderLen)
+		binary.LittleEndian.PutUint16(hdr[8:], uint16(len(entries)))
+		b = append(hdr, b...)
+		return append(b, ziplistEnd)
+	}
+	intset := []byte{2, 0, 0, 0, 3, 0, 0, 0, 0xFF, 0xFF, 5, 0, 0x00, 0x01}
+
+	data := rdbFile("0006",
+		[]byte{opAux}, rawString([]byte("redis-ver")), rawString([]byte("3.2.0")),
+		[]byte{opSelectDB, 2, opResizeDB, 4, 0},
+		// LZF-compressed string of 10 'a'
+		[]byte{typeString, 1, 's', 0xC3, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00},
+		// Ziplist of strings and integers
+		[]byte{opExpireTime, 0x10, 0, 0, 0, typeListZiplist, 1, 'l'},
+		rawString(ziplist([]byte{0x02, 'a', 'b'}, []byte{0xF1}, []byte{0xFE, 0x9C}, []byte{0xC0, 0x00, 0x01}, []byte{0xF0, 0xFF, 0xFF, 0xFF})),
+		[]byte{typeSetIntset, 1, 'i'}, rawString(intset),
+		[]byte{typeHashZiplist, 1, 'h'}, rawString(ziplist([]byte{0x01, 'f'}, []byte{0x01, 'v'})),
+		[]byte{typeZSetZiplist, 1, 'z'}, rawString(ziplist([]byte{0x01, 'm'}, []byte{0x03, '1', '.', '5'})),
+		[]byte{typeZSet, 2, 'z', '2', 2, 1, 'a', 254, 1, 'b', 3, '0', '.', '5'},
+		[]byte{opFreq, 3, typeListQuicklist, 1, 'q', 2},
+		rawString(ziplist([]byte{0x01, 'a'})), rawString(ziplist([]byte{0xF3})),
+	)
+
+	got := make(map[string]*Entry)
+	err := Decode(bytes.NewReader(data), func(ent *Entry) error {
+		got[ent.Key] = ent
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	cases := []struct {
+		key string
+		exp interface{}
+	}{
+		0: {"s", "aaaaaaaaaa"},
+		1: {"l", []string{"ab", "0", "-100", "256", "-1"}},
+		2: {"i", map[string]bool{"-1": true, "5": true, "256": true}},
+		3: {"h", map[string]string{"f": "v"}},
+		4: {"z", []types.ScoredMember{{Member: "m", Score: 1.5}}},
+		5: {"z2", []types.ScoredMember{{Member: "b", Score: 0.5}, {Member: "a", Score: math.Inf(1)}}},
+		6: {"q", []string{"a", "2"}},
+	}
+	for i, c := range cases {
+		ent, ok := got[c.key]
+		if !ok {
+			t.Errorf("%d: key %s not found", i, c.key)
+			continue
+		}
+		if ent.DB != 2 {
+			t.Errorf("%d: expected db 2, got %d", i, ent.DB)
+		}
+		if v := dump(ent.Value); !reflect.DeepEqual(v, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, v)
+		}
+	}
+	if exp := got["l"].ExpireAt; exp != 16000 {
+		t.Errorf("expected expiration 16000, got %d", exp)
+	}
+	if exp := got["i"].ExpireAt; exp != 0 {
+		t.Errorf("expected no expiration, got %d", exp)
+	}
+}
+
+func TestListpack(t *testing.T) {
+	lp := newListpack()
+	lp.appendString("a")
+	lp.appendString("-1")
+	lp.appendString("01")
+	lp.appendInt(4096)
+	lp.appendInt(-1 << 20)
+	lp.appendInt(1 << 40)
+	lp.appendString(string(bytes.Repeat([]byte("y"), 200)))
+	lp.appendString(string(bytes.Repeat([]byte("z"), 5000)))
+	b := lp.bytes()
+
+	if n := binary.LittleEndian.Uint32(b); int(n) != len(b) {
+		t.Errorf("expected %d bytes in header, got %d", len(b), n)
+	}
+	if n := binary.LittleEndian.Uint16(b[4:]); n != 8 {
+		t.Errorf("expected 8 elements in header, got %d", n)
+	}
+	// "a" is a 6-bit string, -1 a 13-bit integer
+	if exp := []byte{0x81, 'a', 2, 0xDF, 0xFF, 2}; !bytes.Equal(b[6:12], exp) {
+		t.Errorf("expected %x, got %x", exp, b[6:12])
+	}
+
+	vals, err := decodeListpack(b)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	exp := []string{"a", "-1", "01", "4096", "-1048576", "1099511627776",
+		string(bytes.Repeat([]byte("y"), 200)), string(bytes.Repeat([]byte("z"), 5000))}
+	if !reflect.DeepEqual(vals, exp) {
+		t.Errorf("expected %v, got %v", exp, vals)
+	}
+
+	if _, err := decodeListpack(b[:len(b)-1]); err != ErrInvalidEncoding {
+		t.Errorf("expected invalid encoding for truncated listpack, got %v", err)
+	}
+}
+
+func TestDecodeStreamNode(t *testing.T) {
+	// Master entry 5-3 with fields a and b, followed by an entry with the
+	// same fields, a deleted entry and an entry with other fields, whose
+	// sequence is smaller than the master's.
+	lp := newListpack()
+	for _, v := range []int64{2, 1, 2} {
+		lp.appendInt(v)
+	}
+	lp.appendString("a")
+	lp.appendString("b")
+	lp.appendInt(0)
+	for _, v := range []string{"2", "0", "0", "1", "2", "5"} {
+		lp.appendString(v)
+	}
+	for _, v := range []string{"3", "0", "1", "x", "y", "5"} {
+		lp.appendString(v)
+	}
+	for _, v := range []string{"0", "1", "-3", "1", "c", "3", "6"} {
+		lp.appendString(v)
+	}
+	vals, err := decodeListpack(lp.bytes())
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	s := types.NewStream()
+	if err := decodeStreamNode(s, types.StreamID{Ms: 5, Seq: 3}, vals); err != nil {
+		t.Fatalf("decode node failed: %s", err)
+	}
+	exp := []types.StreamEntry{
+		{ID: types.StreamID{Ms: 5, Seq: 3}, Fields: []string{"a", "1", "b", "2"}},
+		{ID: types.StreamID{Ms: 6}, Fields: []string{"c", "3"}},
+	}
+	if got := s.XRange(types.StreamID{}, types.MaxStreamID, -1); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+
+	if err := decodeStreamNode(types.NewStream(), types.StreamID{Ms: 5, Seq: 3}, vals[:len(vals)-1]); err != ErrInvalidEncoding {
+		t.Errorf("expected invalid encoding for truncated node, got %v", err)
+	}
+}
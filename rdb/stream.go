@@ -0,0 +1,434 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// streamNodeMaxEntries is the maximum number of entries of the listpacks
+// of an encoded stream, like the default stream-node-max-entries of Redis.
+const streamNodeMaxEntries = 100
+
+// Flags of the entries of the listpacks of a stream.
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// streamIDLen is the length of an encoded stream ID.
+const streamIDLen = 16
+
+// encodeStreamID returns the stream ID encoded as 2 big-endian 64-bit
+// integers, so that the encoded IDs sort like the IDs.
+func encodeStreamID(id types.StreamID) []byte {
+	b := make([]byte, streamIDLen)
+	binary.BigEndian.PutUint64(b, id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Seq)
+	return b
+}
+
+func decodeStreamID(b []byte) types.StreamID {
+	return types.StreamID{
+		Ms:  binary.BigEndian.Uint64(b),
+		Seq: binary.BigEndian.Uint64(b[8:]),
+	}
+}
+
+// msTime converts t to Unix milliseconds.
+func msTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// timeMs converts the Unix milliseconds ms to a time.
+func timeMs(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+// writeStream writes the entries of the stream in listpacks, followed by
+// its length, its last ID and its consumer groups.
+func (e *Encoder) writeStream(s types.Stream) error {
+	ents := s.XRange(types.StreamID{}, types.MaxStreamID, -1)
+	nodes := (len(ents) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	if err := e.writeLen(uint64(nodes)); err != nil {
+		return err
+	}
+	for i := 0; i < len(ents); i += streamNodeMaxEntries {
+		j := i + streamNodeMaxEntries
+		if j > len(ents) {
+			j = len(ents)
+		}
+		if err := e.writeString(string(encodeStreamID(ents[i].ID))); err != nil {
+			return err
+		}
+		if err := e.writeString(string(encodeStreamNode(ents[i:j]))); err != nil {
+			return err
+		}
+	}
+
+	last := s.LastID()
+	for _, n := range []uint64{uint64(len(ents)), last.Ms, last.Seq} {
+		if err := e.writeLen(n); err != nil {
+			return err
+		}
+	}
+
+	groups := s.Groups()
+	if err := e.writeLen(uint64(len(groups))); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := e.writeStreamGroup(g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStreamGroup writes the name and the last ID of the group, its
+// pending entries and its consumers, with the IDs of their pending
+// entries.
+func (e *Encoder) writeStreamGroup(g types.StreamGroup) error {
+	if err := e.writeString(g.Name()); err != nil {
+		return err
+	}
+	last := g.LastID()
+	if err := e.writeLen(last.Ms); err != nil {
+		return err
+	}
+	if err := e.writeLen(last.Seq); err != nil {
+		return err
+	}
+
+	pel := g.Pending(types.StreamID{}, types.MaxStreamID, -1, "", 0, time.Time{})
+	if err := e.writeLen(uint64(len(pel))); err != nil {
+		return err
+	}
+	ids := make(map[string][]types.StreamID)
+	for _, p := range pel {
+		ids[p.Consumer] = append(ids[p.Consumer], p.ID)
+		if err := e.write(encodeStreamID(p.ID)); err != nil {
+			return err
+		}
+		if err := e.writeMs(msTime(p.Delivered)); err != nil {
+			return err
+		}
+		if err := e.writeLen(uint64(p.Count)); err != nil {
+			return err
+		}
+	}
+
+	cons := g.Consumers()
+	if err := e.writeLen(uint64(len(cons))); err != nil {
+		return err
+	}
+	for _, c := range cons {
+		if err := e.writeString(c.Name); err != nil {
+			return err
+		}
+		if err := e.writeMs(msTime(c.Seen)); err != nil {
+			return err
+		}
+		if err := e.writeLen(uint64(len(ids[c.Name]))); err != nil {
+			return err
+		}
+		for _, id := range ids[c.Name] {
+			if err := e.write(encodeStreamID(id)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeMs writes the time in milliseconds as a little-endian 64-bit
+// integer.
+func (e *Encoder) writeMs(ms int64) error {
+	binary.LittleEndian.PutUint64(e.buf[:8], uint64(ms))
+	return e.write(e.buf[:8])
+}
+
+// encodeStreamNode returns the listpack of the entries, which starts with
+// the master entry: the number of entries, the number of deleted entries
+// and the fields of the first entry. The IDs of the entries are stored as
+// the difference with the ID of the first entry, and the entries that have
+// the same fields as the master entry only store their values.
+func encodeStreamNode(ents []types.StreamEntry) []byte {
+	lp := newListpack()
+	master := ents[0]
+	nf := len(master.Fields) / 2
+	lp.appendInt(int64(len(ents)))
+	lp.appendInt(0)
+	lp.appendInt(int64(nf))
+	for i := 0; i < len(master.Fields); i += 2 {
+		lp.appendString(master.Fields[i])
+	}
+	lp.appendInt(0)
+
+	for _, ent := range ents {
+		same := sameFields(ent.Fields, master.Fields)
+		flags := int64(0)
+		if same {
+			flags = streamItemSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(ent.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(ent.ID.Seq - master.ID.Seq))
+
+		n := len(ent.Fields) / 2
+		if same {
+			for i := 1; i < len(ent.Fields); i += 2 {
+				lp.appendString(ent.Fields[i])
+			}
+			lp.appendInt(int64(n + 3))
+			continue
+		}
+		lp.appendInt(int64(n))
+		for _, f := range ent.Fields {
+			lp.appendString(f)
+		}
+		lp.appendInt(int64(2*n + 4))
+	}
+	return lp.bytes()
+}
+
+// sameFields returns true if the fields of the field-value pairs fv are
+// the same as the fields of master, in the same order.
+func sameFields(fv, master []string) bool {
+	if len(fv) != len(master) {
+		return false
+	}
+	for i := 0; i < len(fv); i += 2 {
+		if fv[i] != master[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readStream reads a stream encoded in listpacks.
+func (d *decoder) readStream() (types.Stream, error) {
+	s := types.NewStream()
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != streamIDLen {
+			return nil, ErrInvalidEncoding
+		}
+		lp, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		vals, err := decodeListpack([]byte(lp))
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeStreamNode(s, decodeStreamID([]byte(key)), vals); err != nil {
+			return nil, err
+		}
+	}
+
+	// The length is the number of entries added, which is already known
+	if _, err := d.readLen(); err != nil {
+		return nil, err
+	}
+	last, err := d.readStreamIDLen()
+	if err != nil {
+		return nil, err
+	}
+	if !s.SetLastID(last) {
+		return nil, ErrInvalidEncoding
+	}
+
+	n, err = d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		if err := d.readStreamGroup(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// readStreamGroup reads a consumer group of the stream s.
+func (d *decoder) readStreamGroup(s types.Stream) error {
+	name, err := d.readString()
+	if err != nil {
+		return err
+	}
+	last, err := d.readStreamIDLen()
+	if err != nil {
+		return err
+	}
+	if !s.CreateGroup(name, last) {
+		return ErrInvalidEncoding
+	}
+	g, _ := s.Group(name)
+
+	n, err := d.readLen()
+	if err != nil {
+		return err
+	}
+	pel := make(map[types.StreamID]types.StreamPending, n)
+	for i := uint64(0); i < n; i++ {
+		id, err := d.readStreamIDRaw()
+		if err != nil {
+			return err
+		}
+		ms, err := d.readMs()
+		if err != nil {
+			return err
+		}
+		cnt, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		pel[id] = types.StreamPending{ID: id, Delivered: timeMs(ms), Count: int64(cnt)}
+	}
+
+	n, err = d.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		cname, err := d.readString()
+		if err != nil {
+			return err
+		}
+		ms, err := d.readMs()
+		if err != nil {
+			return err
+		}
+		g.CreateConsumer(cname, timeMs(ms))
+		np, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < np; j++ {
+			id, err := d.readStreamIDRaw()
+			if err != nil {
+				return err
+			}
+			p, ok := pel[id]
+			if !ok {
+				return ErrInvalidEncoding
+			}
+			p.Consumer = cname
+			g.SetPending(p)
+			delete(pel, id)
+		}
+	}
+
+	// Every pending entry belongs to a consumer
+	if len(pel) > 0 {
+		return ErrInvalidEncoding
+	}
+	return nil
+}
+
+// readStreamIDLen reads a stream ID encoded as 2 lengths.
+func (d *decoder) readStreamIDLen() (types.StreamID, error) {
+	ms, err := d.readLen()
+	if err != nil {
+		return types.StreamID{}, err
+	}
+	seq, err := d.readLen()
+	if err != nil {
+		return types.StreamID{}, err
+	}
+	return types.StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// readStreamIDRaw reads a stream ID encoded as 2 big-endian integers.
+func (d *decoder) readStreamIDRaw() (types.StreamID, error) {
+	b, err := d.readN(streamIDLen)
+	if err != nil {
+		return types.StreamID{}, err
+	}
+	return decodeStreamID(b), nil
+}
+
+// readMs reads a time in milliseconds, encoded as a little-endian 64-bit
+// integer.
+func (d *decoder) readMs() (int64, error) {
+	b, err := d.readN(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+// decodeStreamNode adds to s the entries of the listpack elements vals,
+// whose IDs are relative to the ID master. The deleted entries are
+// skipped.
+func decodeStreamNode(s types.Stream, master types.StreamID, vals []string) error {
+	var bad bool
+	next := func(n int) []string {
+		if n < 0 || n > len(vals) {
+			bad = true
+			n = len(vals)
+		}
+		v := vals[:n]
+		vals = vals[n:]
+		return v
+	}
+	nextInt := func() int64 {
+		v := next(1)
+		if len(v) == 0 {
+			bad = true
+			return 0
+		}
+		n, err := strconv.ParseInt(v[0], 10, 64)
+		if err != nil {
+			bad = true
+		}
+		return n
+	}
+
+	cnt, del, nf := nextInt(), nextInt(), nextInt()
+	mfields := next(int(nf))
+	nextInt()
+	for i := int64(0); i < cnt+del && !bad; i++ {
+		flags := nextInt()
+		id := types.StreamID{
+			Ms:  master.Ms + uint64(nextInt()),
+			Seq: master.Seq + uint64(nextInt()),
+		}
+
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			vs := next(len(mfields))
+			fields = make([]string, 0, 2*len(vs))
+			for j, v := range vs {
+				fields = append(fields, mfields[j], v)
+			}
+		} else {
+			fields = append([]string(nil), next(2*int(nextInt()))...)
+		}
+		nextInt() // number of elements of the entry
+
+		if bad {
+			break
+		}
+		if flags&streamItemDeleted == 0 && !s.XAdd(id, fields...) {
+			return ErrInvalidEncoding
+		}
+	}
+	if bad || len(vals) > 0 {
+		return ErrInvalidEncoding
+	}
+	return nil
+}
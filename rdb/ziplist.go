@@ -0,0 +1,124 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// ziplistHeaderLen is the length of the ziplist header: the total number
+// of bytes, the offset of the tail and the number of entries.
+const ziplistHeaderLen = 10
+
+// ziplistEnd is the byte that terminates a ziplist.
+const ziplistEnd = 0xFF
+
+// decodeZiplist returns the entries of the ziplist b. Integer entries are
+// returned in their string representation.
+func decodeZiplist(b []byte) ([]string, error) {
+	if len(b) < ziplistHeaderLen+1 {
+		return nil, ErrInvalidEncoding
+	}
+	n := int(binary.LittleEndian.Uint16(b[8:10]))
+	vals := make([]string, 0, n)
+
+	i := ziplistHeaderLen
+	for {
+		if i >= len(b) {
+			return nil, ErrInvalidEncoding
+		}
+		if b[i] == ziplistEnd {
+			return vals, nil
+		}
+
+		// Skip the length of the previous entry
+		if b[i] == 0xFE {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(b) {
+			return nil, ErrInvalidEncoding
+		}
+
+		enc := b[i]
+		i++
+		var (
+			slen int
+			ilen int
+		)
+		switch {
+		case enc>>6 == 0:
+			slen = int(enc & 0x3F)
+		case enc>>6 == 1:
+			if i >= len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			slen = int(enc&0x3F)<<8 | int(b[i])
+			i++
+		case enc == 0x80:
+			if i+4 > len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			slen = int(binary.BigEndian.Uint32(b[i:]))
+			i += 4
+		case enc == 0xC0:
+			ilen = 2
+		case enc == 0xD0:
+			ilen = 4
+		case enc == 0xE0:
+			ilen = 8
+		case enc == 0xF0:
+			ilen = 3
+		case enc == 0xFE:
+			ilen = 1
+		case enc >= 0xF1 && enc <= 0xFD:
+			vals = append(vals, strconv.Itoa(int(enc&0x0F)-1))
+			continue
+		default:
+			return nil, ErrInvalidEncoding
+		}
+
+		if ilen > 0 {
+			if i+ilen > len(b) {
+				return nil, ErrInvalidEncoding
+			}
+			vals = append(vals, strconv.FormatInt(leInt(b[i:i+ilen]), 10))
+			i += ilen
+			continue
+		}
+		if slen < 0 || i+slen > len(b) {
+			return nil, ErrInvalidEncoding
+		}
+		vals = append(vals, string(b[i:i+slen]))
+		i += slen
+	}
+}
+
+// decodeIntset returns the integers of the intset b, in their string
+// representation.
+func decodeIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, ErrInvalidEncoding
+	}
+	w := int(binary.LittleEndian.Uint32(b[0:4]))
+	n := int(binary.LittleEndian.Uint32(b[4:8]))
+	if (w != 2 && w != 4 && w != 8) || len(b) != 8+w*n {
+		return nil, ErrInvalidEncoding
+	}
+	vals := make([]string, n)
+	for i := range vals {
+		vals[i] = strconv.FormatInt(leInt(b[8+i*w:8+(i+1)*w]), 10)
+	}
+	return vals, nil
+}
+
+// leInt decodes the signed little-endian integer b, of 1 to 8 bytes.
+func leInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	// Sign-extend
+	shift := uint(64 - 8*len(b))
+	return int64(u<<shift) >> shift
+}
@@ -33,6 +33,14 @@ var (
 	// ErrInvalidRequest is returned if the DecodeRequest function is called and
 	// the decoded value is not an array containing only bulk strings, and at least 1 element.
 	ErrInvalidRequest = errors.New("resp: invalid request, must be an array of bulk strings with at least one element")
+
+	// ErrUnbalancedQuotes is returned if an inline request contains an unterminated
+	// quoted string, or a closing quote that is not followed by a space.
+	ErrUnbalancedQuotes = errors.New("resp: unbalanced quotes in inline request")
+
+	// ErrInlineTooBig is returned if an inline request is longer than
+	// MaxInlineSize.
+	ErrInlineTooBig = errors.New("resp: too big inline request")
 )
 
 // BytesReader defines the methods required for the Decode* family of methods.
@@ -58,19 +66,48 @@ func (a Array) String() string {
 // DecodeRequest decodes the provided byte slice and returns the array
 // representing the request. If the encoded value is not an array, it
 // returns ErrNotAnArray, and if it is not a valid request, it returns ErrInvalidRequest.
+//
+// Inline commands (a line of space-separated arguments, as sent by telnet)
+// are also supported. Empty inline lines are skipped.
 func DecodeRequest(r BytesReader) ([]string, error) {
-	// Decode the value
-	val, err := Decode(r)
-	if err != nil {
-		return nil, err
-	}
+	for {
+		ch, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
 
-	// Must be an array
-	ar, ok := val.(Array)
-	if !ok {
-		return nil, ErrNotAnArray
+		switch ch {
+		case '*':
+			// Array, the normal request
+			ar, err := decodeArray(r)
+			if err != nil {
+				return nil, err
+			}
+			return arrayToRequest(ar)
+
+		case '+', '-', ':', '$':
+			// Other RESP values, consume and reject
+			if _, err := decodePrefixed(r, ch); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotAnArray
+
+		default:
+			// Inline command
+			strs, err := decodeInline(r, ch)
+			if err != nil {
+				return nil, err
+			}
+			if len(strs) > 0 {
+				return strs, nil
+			}
+		}
 	}
+}
 
+// arrayToRequest validates that the array is a valid request and returns
+// it as a slice of strings.
+func arrayToRequest(ar Array) ([]string, error) {
 	// Must have at least one element
 	if len(ar) < 1 {
 		return nil, ErrInvalidRequest
@@ -100,8 +137,14 @@ func decodeValue(r BytesReader) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	return decodePrefixed(r, ch)
+}
 
+// decodePrefixed decodes the value identified by the prefix ch, which
+// is assumed to be already consumed.
+func decodePrefixed(r BytesReader, ch byte) (interface{}, error) {
 	var val interface{}
+	var err error
 	switch ch {
 	case '+':
 		// Simple string
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -75,6 +76,19 @@ var decodeRequestCases = []struct {
 	4: {[]byte("*1\r\n$2\r\nab\r\n"), []string{"ab"}, nil},
 	5: {[]byte("*3\r\n$3\r\nSET\r\n$5\r\nmykey\r\n$24\r\nceci n'est pas un string\r\n"),
 		[]string{"SET", "mykey", "ceci n'est pas un string"}, nil},
+
+	// Inline requests
+	6:  {[]byte("PING\r\n"), []string{"PING"}, nil},
+	7:  {[]byte("set  mykey\tval\n"), []string{"set", "mykey", "val"}, nil},
+	8:  {[]byte("\r\n\r\nget k\r\n"), []string{"get", "k"}, nil},
+	9:  {[]byte("\r\n*1\r\n$4\r\nPING\r\n"), []string{"PING"}, nil},
+	10: {[]byte("set k \"a b\\\"c\\n\\x41\"\r\n"), []string{"set", "k", "a b\"c\nA"}, nil},
+	11: {[]byte("set k 'it\\'s' \"\"\r\n"), []string{"set", "k", "it's", ""}, nil},
+	12: {[]byte("set k \"abc\r\n"), nil, ErrUnbalancedQuotes},
+	13: {[]byte("set k 'abc'd\r\n"), nil, ErrUnbalancedQuotes},
+	14: {[]byte("PING"), nil, io.EOF},
+	15: {append(bytes.Repeat([]byte("a"), MaxInlineSize-1), '\n'), []string{strings.Repeat("a", MaxInlineSize-1)}, nil},
+	16: {append(bytes.Repeat([]byte("a"), MaxInlineSize), '\n'), nil, ErrInlineTooBig},
 }
 
 func TestDecode(t *testing.T) {
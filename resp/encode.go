@@ -42,6 +42,10 @@ type SimpleString string
 // as a BulkString, but this is the default encoding for a normal Go string.
 type BulkString string
 
+// Replies represents a sequence of values that are encoded one after the
+// other, for commands that send more than one reply, such as SUBSCRIBE.
+type Replies []interface{}
+
 // Encode encode the value v and writes the serialized data to w.
 func Encode(w io.Writer, v interface{}) error {
 	return encodeValue(w, v)
@@ -88,6 +92,13 @@ func encodeValue(w io.Writer, v interface{}) error {
 		return encodeArray(w, Array(v))
 	case Array:
 		return encodeArray(w, v)
+	case Replies:
+		for _, el := range v {
+			if err := encodeValue(w, el); err != nil {
+				return err
+			}
+		}
+		return nil
 	case nil:
 		return encodeNil(w)
 	default:
@@ -33,6 +33,9 @@ var encodeValidCases = []struct {
 	19: {[]byte("*5\r\n+string\r\n-error\r\n:-2345\r\n$4\r\nallo\r\n*2\r\n$0\r\n\r\n$-1\r\n"),
 		Array{SimpleString("string"), Error("error"), int64(-2345), "allo",
 			Array{"", nil}}, nil},
+	20: {[]byte{}, Replies{}, nil},
+	21: {[]byte("*2\r\n$1\r\na\r\n:1\r\n*2\r\n$1\r\nb\r\n:2\r\n"),
+		Replies{Array{"a", int64(1)}, Array{"b", int64(2)}}, nil},
 }
 
 func TestEncode(t *testing.T) {
@@ -0,0 +1,142 @@
+package resp
+
+// MaxInlineSize is the maximum length of an inline request, including its
+// terminating newline, like in Redis.
+const MaxInlineSize = 64 * 1024
+
+// decodeInline decodes an inline request, which is a single line of
+// space-separated arguments, as sent by telnet. The first byte of the
+// line, ch, is assumed to be already consumed. It returns ErrInlineTooBig
+// once MaxInlineSize bytes are read without reaching the end of the line.
+func decodeInline(r BytesReader, ch byte) ([]string, error) {
+	line := []byte{ch}
+	for ch != '\n' {
+		if len(line) >= MaxInlineSize {
+			return nil, ErrInlineTooBig
+		}
+		var err error
+		if ch, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		line = append(line, ch)
+	}
+	return splitInline(line)
+}
+
+// splitInline splits the line in arguments, the same way Redis does it.
+// Arguments are separated by spaces, and may be enclosed in double quotes,
+// in which case escape sequences such as "\n" and "\x2a" are supported,
+// or in single quotes, in which case only "\'" is supported.
+func splitInline(line []byte) ([]string, error) {
+	var args []string
+	i, n := 0, len(line)
+	for {
+		// Skip blanks
+		for i < n && isSpace(line[i]) {
+			i++
+		}
+		if i == n {
+			return args, nil
+		}
+
+		var arg []byte
+		var inDq, inSq, done bool
+		for !done {
+			if i == n {
+				if inDq || inSq {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+
+			c := line[i]
+			switch {
+			case inDq:
+				switch {
+				case c == '\\' && i+3 < n && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					arg = append(arg, unhex(line[i+2])<<4|unhex(line[i+3]))
+					i += 3
+				case c == '\\' && i+1 < n:
+					i++
+					c = line[i]
+					switch c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+					arg = append(arg, c)
+				case c == '"':
+					// Closing quote must be followed by a space or nothing
+					if i+1 < n && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+
+			case inSq:
+				switch {
+				case c == '\\' && i+1 < n && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case c == '\'':
+					// Closing quote must be followed by a space or nothing
+					if i+1 < n && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+
+			default:
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDq = true
+				case c == '\'':
+					inSq = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			i++
+		}
+		args = append(args, string(arg))
+	}
+}
+
+// isSpace returns true if c is a blank character.
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+// isHex returns true if c is an hexadecimal digit.
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// unhex returns the value of the hexadecimal digit c.
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
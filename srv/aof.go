@@ -0,0 +1,406 @@
+package srv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/gred/rdb"
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/types"
+	"github.com/golang/glog"
+)
+
+// ErrRewriteInProgress is returned when a rewrite of the append-only file
+// is requested while one is in progress.
+var ErrRewriteInProgress = errors.New("srv: append-only file rewrite already in progress")
+
+// FsyncPolicy defines when the append-only file is flushed to disk.
+type FsyncPolicy int
+
+const (
+	// FsyncEverySec flushes the file to disk every second.
+	FsyncEverySec FsyncPolicy = iota
+
+	// FsyncAlways flushes the file to disk after each command.
+	FsyncAlways
+
+	// FsyncNo leaves it to the operating system to flush the file.
+	FsyncNo
+)
+
+var fsyncNames = [...]string{
+	FsyncEverySec: "everysec",
+	FsyncAlways:   "always",
+	FsyncNo:       "no",
+}
+
+// ParseFsyncPolicy parses an appendfsync value, which is one of "always",
+// "everysec" or "no".
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	for i, nm := range fsyncNames {
+		if strings.EqualFold(s, nm) {
+			return FsyncPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid appendfsync policy %q", s)
+}
+
+// String returns the appendfsync value of the policy.
+func (p FsyncPolicy) String() string {
+	return fsyncNames[p]
+}
+
+// DefaultAOFPath is the default path of the append-only file.
+const DefaultAOFPath = "appendonly.aof"
+
+// aofPath holds the path of the append-only file.
+var aofPath atomic.Value
+
+// SetAOFPath sets the path of the append-only file.
+func SetAOFPath(path string) {
+	aofPath.Store(path)
+}
+
+// AOFPath returns the path of the append-only file.
+func AOFPath() string {
+	if p, ok := aofPath.Load().(string); ok {
+		return p
+	}
+	return DefaultAOFPath
+}
+
+// aofItemsPerCmd is the maximum number of items of a value written by a
+// single command when the append-only file is rewritten.
+const aofItemsPerCmd = 64
+
+// aofState holds the state of the append-only file.
+type aofState struct {
+	mu    sync.Mutex
+	f     *os.File
+	fsync FsyncPolicy
+	stop  chan struct{}
+
+	// db is the database selected by the last command appended to the
+	// file, buf is the buffer used to encode the commands.
+	db  int
+	buf bytes.Buffer
+
+	// rewriteBuf holds the commands appended while the file is rewritten.
+	rewriteBuf *bytes.Buffer
+
+	// rewriting is 1 while the file is rewritten. It is accessed atomically.
+	rewriting int32
+}
+
+// OpenAOF opens the append-only file, so that write commands are appended
+// to it, using the specified fsync policy.
+func (s *server) OpenAOF(policy FsyncPolicy) error {
+	a := &s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f != nil {
+		return nil
+	}
+	f, err := os.OpenFile(AOFPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	a.f = f
+	a.fsync = policy
+	a.db = -1
+	if policy == FsyncEverySec {
+		a.stop = make(chan struct{})
+		go a.syncEverySec(a.stop)
+	}
+	return nil
+}
+
+// CloseAOF flushes and closes the append-only file. Write commands are not
+// appended anymore.
+func (s *server) CloseAOF() error {
+	a := &s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return nil
+	}
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+	err := a.f.Sync()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	a.f = nil
+	return err
+}
+
+// AOFEnabled returns true if write commands are appended to the
+// append-only file.
+func (s *server) AOFEnabled() bool {
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+	return s.aof.f != nil
+}
+
+// Propagate appends the command args, executed on the database at index
+// ix, to the append-only file. It is a no-op if the file is not open.
+//
+// The caller is responsible for the order of the appended commands: the
+// command must be appended before any other command may act on the same
+// keys.
+func (s *server) Propagate(ix int, args ...string) {
+	a := &s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return
+	}
+	a.buf.Reset()
+	if ix != a.db {
+		resp.Encode(&a.buf, []string{"select", strconv.Itoa(ix)})
+		a.db = ix
+	}
+	resp.Encode(&a.buf, args)
+
+	if a.rewriteBuf != nil {
+		a.rewriteBuf.Write(a.buf.Bytes())
+	}
+	if _, err := a.f.Write(a.buf.Bytes()); err != nil {
+		glog.Errorf("append-only file: write failed: %s", err)
+		return
+	}
+	if a.fsync == FsyncAlways {
+		if err := a.f.Sync(); err != nil {
+			glog.Errorf("append-only file: fsync failed: %s", err)
+		}
+	}
+}
+
+// syncEverySec flushes the append-only file to disk every second, until
+// stop is closed.
+func (a *aofState) syncEverySec(stop <-chan struct{}) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			a.mu.Lock()
+			f := a.f
+			a.mu.Unlock()
+			if f == nil {
+				continue
+			}
+			// The file may be replaced by a rewrite in the meantime
+			if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				glog.Errorf("append-only file: fsync failed: %s", err)
+			}
+		}
+	}
+}
+
+// BGRewriteAOF rewrites the append-only file in the background, with the
+// minimal set of commands required to rebuild the current databases. The
+// commands appended while the rewrite is in progress are added at the end
+// of the new file, which then replaces the current one.
+//
+// The point-in-time snapshot of the keys is taken while holding the exclusive
+// server lock, so that no write command is executed but not yet appended
+// at that time.
+func (s *server) BGRewriteAOF() error {
+	if !atomic.CompareAndSwapInt32(&s.aof.rewriting, 0, 1) {
+		return ErrRewriteInProgress
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&s.aof.rewriting, 0)
+
+		s.Lock()
+		snap := s.snapshot()
+		n := len(snap)
+		s.aof.mu.Lock()
+		if s.aof.f != nil {
+			s.aof.rewriteBuf = new(bytes.Buffer)
+			// Make sure the first command appended selects its database
+			s.aof.db = -1
+		}
+		s.aof.mu.Unlock()
+		s.Unlock()
+
+		if err := s.rewriteAOF(snap); err != nil {
+			glog.Errorf("append-only file rewrite: %s", err)
+			return
+		}
+		glog.V(1).Infof("append-only file rewrite: %d keys written to %s", n, AOFPath())
+	}()
+	return nil
+}
+
+// rewriteAOF writes the commands to rebuild the snapshot to a temporary
+// file, appends the commands buffered since the snapshot was taken, and
+// replaces the append-only file.
+func (s *server) rewriteAOF(snap snapshot) error {
+	path := AOFPath()
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	f, err := os.Create(tmp)
+	if err != nil {
+		s.aof.mu.Lock()
+		s.aof.rewriteBuf = nil
+		s.aof.mu.Unlock()
+		snap.release()
+		return err
+	}
+
+	err = writeAOFEntries(f, snap)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	// Hold the lock until the file is replaced, so that no command is
+	// appended in the meantime.
+	a := &s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err == nil && a.rewriteBuf != nil {
+		_, err = f.Write(a.rewriteBuf.Bytes())
+		if err == nil {
+			err = f.Sync()
+		}
+	}
+	a.rewriteBuf = nil
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	// Switch to the new file if the append-only file is open
+	if a.f != nil {
+		a.f.Close()
+		a.f = f
+		return nil
+	}
+	return f.Close()
+}
+
+// writeAOFEntries writes the commands required to rebuild the entries of
+// the snapshot.
+func writeAOFEntries(w io.Writer, snap snapshot) error {
+	bw := bufio.NewWriter(w)
+	db := -1
+	err := snap.each(func(ent *rdb.Entry) error {
+		if ent.DB != db {
+			if err := resp.Encode(bw, []string{"select", strconv.Itoa(ent.DB)}); err != nil {
+				return err
+			}
+			db = ent.DB
+		}
+		for _, args := range entryCmds(ent) {
+			if err := resp.Encode(bw, args); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// entryCmds returns the commands that rebuild the entry.
+func entryCmds(ent *rdb.Entry) [][]string {
+	var cmds [][]string
+
+	// batch appends the commands to add the items, aofItemsPerCmd at a time.
+	// Each item is made of n strings.
+	batch := func(name string, n int, items []string) {
+		for len(items) > 0 {
+			cnt := aofItemsPerCmd * n
+			if cnt > len(items) {
+				cnt = len(items)
+			}
+			args := make([]string, 0, 2+cnt)
+			args = append(args, name, ent.Key)
+			cmds = append(cmds, append(args, items[:cnt]...))
+			items = items[cnt:]
+		}
+	}
+
+	switch v := ent.Value.(type) {
+	case types.String:
+		cmds = append(cmds, []string{"set", ent.Key, v.Get()})
+	case types.Hash:
+		batch("hmset", 2, v.HGetAll())
+	case types.List:
+		batch("rpush", 1, v.LRange(0, -1))
+	case types.Set:
+		batch("sadd", 1, v.SMembers())
+	case types.SortedSet:
+		sms := v.ZRange(0, -1)
+		items := make([]string, 0, 2*len(sms))
+		for _, sm := range sms {
+			items = append(items, strconv.FormatFloat(sm.Score, 'g', -1, 64), sm.Member)
+		}
+		batch("zadd", 2, items)
+	case types.Stream:
+		cmds = append(cmds, streamCmds(ent.Key, v)...)
+	default:
+		panic(fmt.Sprintf("unsupported value type: %T", v))
+	}
+
+	if ent.ExpireAt > 0 {
+		cmds = append(cmds, []string{"pexpireat", ent.Key, strconv.FormatInt(ent.ExpireAt, 10)})
+	}
+	return cmds
+}
+
+// streamCmds returns the commands that rebuild the stream v at key, with
+// its last ID and its consumer groups.
+func streamCmds(key string, v types.Stream) [][]string {
+	var cmds [][]string
+	ents := v.XRange(types.StreamID{}, types.MaxStreamID, -1)
+	for _, e := range ents {
+		args := make([]string, 0, 3+len(e.Fields))
+		args = append(args, "xadd", key, e.ID.String())
+		cmds = append(cmds, append(args, e.Fields...))
+	}
+	if len(ents) == 0 {
+		// Create the empty stream with an entry that is trimmed right away
+		cmds = append(cmds, []string{"xadd", key, "maxlen", "0", "0-1", "x", "y"})
+	}
+	cmds = append(cmds, []string{"xsetid", key, v.LastID().String()})
+
+	for _, g := range v.Groups() {
+		cmds = append(cmds, []string{"xgroup", "create", key, g.Name(), g.LastID().String()})
+		for _, c := range g.Consumers() {
+			cmds = append(cmds, []string{"xgroup", "createconsumer", key, g.Name(), c.Name})
+		}
+		for _, p := range g.Pending(types.StreamID{}, types.MaxStreamID, -1, "", 0, time.Now()) {
+			cmds = append(cmds, []string{"xclaim", key, g.Name(), p.Consumer, "0", p.ID.String(),
+				"time", strconv.FormatInt(unixMs(p.Delivered), 10),
+				"retrycount", strconv.FormatInt(p.Count, 10), "force", "justid"})
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,220 @@
+package srv
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClientInfo holds the state of a connected client, as reported by
+// CLIENT LIST.
+type ClientInfo struct {
+	ID    int64
+	Addr  string
+	LAddr string
+	Name  string
+
+	// Created is the time the client connected, LastCmd the time it sent
+	// its last command, named Cmd.
+	Created time.Time
+	LastCmd time.Time
+	Cmd     string
+
+	DB   int
+	Sub  int
+	PSub int
+
+	// Multi is the number of commands queued in a transaction, or -1 if no
+	// transaction is started.
+	Multi int
+
+	// Blocked is true while the client waits in a blocking command.
+	Blocked bool
+}
+
+// PubSub returns true if the client is subscribed to at least one channel
+// or pattern.
+func (ci ClientInfo) PubSub() bool {
+	return ci.Sub+ci.PSub > 0
+}
+
+// Client defines the methods required to register a connected client.
+type Client interface {
+	// Info returns the current state of the client.
+	Info() ClientInfo
+
+	// Kill closes the connection of the client once its current command,
+	// if any, has been executed. A blocked command is cancelled.
+	Kill()
+}
+
+// Clients defines the methods required to implement the registry of the
+// connected clients.
+type Clients interface {
+	Register(Client, int) (int64, error)
+	Unregister(int64)
+	Get(int64) (Client, bool)
+	List() []Client
+	Len() int
+	KillIdle(time.Duration) int
+
+	// Pausing the clients
+	Pause(time.Duration, bool)
+	Unpause()
+	WaitPause(bool)
+}
+
+// ErrMaxClients is returned when a client is registered while the maximum
+// number of clients is reached.
+var ErrMaxClients = errors.New("srv: max number of clients reached")
+
+// Static check to make sure *clients implements the Clients interface.
+var _ Clients = (*clients)(nil)
+
+// The one and only registry of clients.
+var DefaultClients Clients = NewClients()
+
+// clients is the internal implementation of Clients.
+type clients struct {
+	sync.RWMutex
+
+	lastID int64
+	m      map[int64]Client
+
+	// pause state: the clients are paused until pauseEnd, only for the
+	// write commands if pauseWrite is true. unpaused is closed when the
+	// pause is over.
+	pauseEnd   time.Time
+	pauseWrite bool
+	unpaused   chan struct{}
+}
+
+// NewClients creates a new Clients value.
+func NewClients() Clients {
+	return &clients{
+		m: make(map[int64]Client),
+	}
+}
+
+// Register registers the client, and returns its unique ID. It returns
+// ErrMaxClients if max clients are already registered.
+func (cs *clients) Register(c Client, max int) (int64, error) {
+	cs.Lock()
+	defer cs.Unlock()
+	if len(cs.m) >= max {
+		return 0, ErrMaxClients
+	}
+	cs.lastID++
+	cs.m[cs.lastID] = c
+	return cs.lastID, nil
+}
+
+// Unregister removes the client identified by id from the registry.
+func (cs *clients) Unregister(id int64) {
+	cs.Lock()
+	defer cs.Unlock()
+	delete(cs.m, id)
+}
+
+// Get returns the client identified by id, and false if there is no such
+// client.
+func (cs *clients) Get(id int64) (Client, bool) {
+	cs.RLock()
+	defer cs.RUnlock()
+	c, ok := cs.m[id]
+	return c, ok
+}
+
+// List returns the registered clients, in ID order.
+func (cs *clients) List() []Client {
+	cs.RLock()
+	ids := make([]int64, 0, len(cs.m))
+	for id := range cs.m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	ret := make([]Client, len(ids))
+	for i, id := range ids {
+		ret[i] = cs.m[id]
+	}
+	cs.RUnlock()
+	return ret
+}
+
+// Len returns the number of registered clients.
+func (cs *clients) Len() int {
+	cs.RLock()
+	defer cs.RUnlock()
+	return len(cs.m)
+}
+
+// KillIdle kills the clients that did not send a command for longer than
+// timeout, except the blocked clients and the clients subscribed to
+// channels or patterns. It returns the number of killed clients.
+func (cs *clients) KillIdle(timeout time.Duration) int {
+	var n int
+	now := time.Now()
+	for _, c := range cs.List() {
+		ci := c.Info()
+		if ci.Blocked || ci.PubSub() || now.Sub(ci.LastCmd) <= timeout {
+			continue
+		}
+		c.Kill()
+		n++
+	}
+	return n
+}
+
+// Pause pauses the clients for the duration d, only for the write commands
+// if write is true. If the clients are already paused, the pause lasts
+// until the latest end, and pausing all commands prevails.
+func (cs *clients) Pause(d time.Duration, write bool) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	end := time.Now().Add(d)
+	if cs.unpaused == nil || !time.Now().Before(cs.pauseEnd) {
+		if cs.unpaused != nil {
+			close(cs.unpaused)
+		}
+		cs.unpaused = make(chan struct{})
+		cs.pauseEnd, cs.pauseWrite = end, write
+		return
+	}
+	if end.After(cs.pauseEnd) {
+		cs.pauseEnd = end
+	}
+	cs.pauseWrite = cs.pauseWrite && write
+}
+
+// Unpause ends the pause of the clients, if any.
+func (cs *clients) Unpause() {
+	cs.Lock()
+	defer cs.Unlock()
+	if cs.unpaused != nil {
+		close(cs.unpaused)
+		cs.unpaused = nil
+	}
+}
+
+// WaitPause blocks while the clients are paused for the command to execute,
+// which is a write command if write is true.
+func (cs *clients) WaitPause(write bool) {
+	for {
+		cs.RLock()
+		ch, end := cs.unpaused, cs.pauseEnd
+		paused := ch != nil && time.Now().Before(end) && (write || !cs.pauseWrite)
+		cs.RUnlock()
+		if !paused {
+			return
+		}
+
+		t := time.NewTimer(time.Until(end))
+		select {
+		case <-ch:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
@@ -0,0 +1,93 @@
+package srv
+
+import (
+	"testing"
+	"time"
+)
+
+type mockClient struct {
+	id     int64
+	killed bool
+}
+
+func (m *mockClient) Info() ClientInfo { return ClientInfo{ID: m.id} }
+func (m *mockClient) Kill()            { m.killed = true }
+
+func TestClients(t *testing.T) {
+	cs := NewClients()
+	c1, c2 := &mockClient{}, &mockClient{}
+	c1.id, _ = cs.Register(c1, 2)
+	c2.id, _ = cs.Register(c2, 2)
+	if c1.id <= 0 || c2.id <= c1.id {
+		t.Fatalf("expected increasing IDs, got %d and %d", c1.id, c2.id)
+	}
+	if _, err := cs.Register(&mockClient{}, 2); err != ErrMaxClients {
+		t.Errorf("expected error %v, got %v", ErrMaxClients, err)
+	}
+	if l := cs.List(); len(l) != 2 || l[0] != c1 || l[1] != c2 {
+		t.Errorf("expected both clients in ID order, got %v", l)
+	}
+
+	cs.Unregister(c1.id)
+	if _, ok := cs.Get(c1.id); ok {
+		t.Error("expected the client to be unregistered")
+	}
+	if c, ok := cs.Get(c2.id); !ok || c != c2 {
+		t.Errorf("expected the client %d, got %v", c2.id, c)
+	}
+	if l := cs.List(); len(l) != 1 {
+		t.Errorf("expected 1 client, got %d", len(l))
+	}
+	if id, err := cs.Register(c1, 2); err != nil || id <= c2.id {
+		t.Errorf("expected a new ID, got %d and error %v", id, err)
+	}
+}
+
+// paused returns true if WaitPause blocks for at least d.
+func paused(cs Clients, write bool, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		cs.WaitPause(write)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func TestClientsPause(t *testing.T) {
+	cs := NewClients()
+	if paused(cs, true, 10*time.Millisecond) {
+		t.Fatal("expected the clients not to be paused")
+	}
+
+	// Only the write commands are paused
+	cs.Pause(time.Hour, true)
+	if paused(cs, false, 10*time.Millisecond) {
+		t.Error("expected the read commands not to be paused")
+	}
+	if !paused(cs, true, 10*time.Millisecond) {
+		t.Error("expected the write commands to be paused")
+	}
+
+	// Pausing all commands prevails, until the latest end
+	cs.Pause(time.Millisecond, false)
+	if !paused(cs, false, 10*time.Millisecond) {
+		t.Error("expected the read commands to be paused")
+	}
+	cs.Unpause()
+	if paused(cs, true, 100*time.Millisecond) {
+		t.Error("expected the clients to be unpaused")
+	}
+
+	// The pause expires
+	cs.Pause(50*time.Millisecond, false)
+	start := time.Now()
+	cs.WaitPause(false)
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("expected a pause of 50ms, got %s", d)
+	}
+}
@@ -0,0 +1,676 @@
+package srv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/gred/glob"
+	"github.com/golang/glog"
+)
+
+var (
+	// ErrUnknownConfig is the error of a ConfigError when the parameter
+	// does not exist.
+	ErrUnknownConfig = errors.New("unknown parameter")
+
+	// ErrImmutableConfig is the error of a ConfigError when the parameter
+	// cannot be set at runtime.
+	ErrImmutableConfig = errors.New("can't set immutable config")
+
+	// ErrDuplicateConfig is the error of a ConfigError when the parameter
+	// is set more than once by the same call.
+	ErrDuplicateConfig = errors.New("duplicate parameter")
+
+	// ErrNoConfigFile is returned when the configuration is rewritten while
+	// the server was started without a configuration file.
+	ErrNoConfigFile = errors.New("srv: the server is running without a config file")
+)
+
+// ConfigError is the error returned when a configuration parameter cannot
+// be set.
+type ConfigError struct {
+	Name string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("srv: config %s: %s", e.Name, e.Err)
+}
+
+// Config holds the values of the configuration parameters.
+type Config struct {
+	Bind           string
+	Port           int
+	Dir            string
+	DBFilename     string
+	AppendOnly     bool
+	AppendFilename string
+	AppendFsync    FsyncPolicy
+	Databases      int
+	Timeout        time.Duration
+	MaxClients     int
+	Notify         NotifyFlag
+	Save           []SavePoint
+}
+
+// defaultConfig returns the configuration of the server when no parameter
+// is set.
+func defaultConfig() Config {
+	return Config{
+		Port:           6379,
+		Dir:            ".",
+		DBFilename:     DefaultRDBPath,
+		AppendFilename: DefaultAOFPath,
+		AppendFsync:    FsyncEverySec,
+		Databases:      DefaultDatabases,
+		MaxClients:     10000,
+	}
+}
+
+// configParam defines a configuration parameter.
+type configParam struct {
+	// get returns the value of the parameter in the configuration.
+	get func(*Config) string
+
+	// set parses the value and sets the parameter in the configuration.
+	set func(*Config, string) error
+
+	// apply, if set, applies the parameter of the configuration to the
+	// component of the server that it configures. It is called once the
+	// configuration is loaded, and each time the parameter is set.
+	apply func(*Config) error
+
+	// mutable is true if the parameter can be set at runtime.
+	mutable bool
+}
+
+// configParams holds the configuration parameters, by name.
+var configParams = map[string]*configParam{
+	"appendfilename": {
+		get: func(c *Config) string { return c.AppendFilename },
+		set: func(c *Config, v string) (err error) {
+			c.AppendFilename, err = parseFilename(v)
+			return err
+		},
+		apply: applyAOFPath,
+	},
+	"appendfsync": {
+		get: func(c *Config) string { return c.AppendFsync.String() },
+		set: func(c *Config, v string) (err error) {
+			if c.AppendFsync, err = ParseFsyncPolicy(strings.ToLower(v)); err != nil {
+				return errors.New("argument(s) must be one of the following: always, everysec, no")
+			}
+			return nil
+		},
+	},
+	"appendonly": {
+		get: func(c *Config) string { return formatBool(c.AppendOnly) },
+		set: func(c *Config, v string) (err error) {
+			c.AppendOnly, err = parseBool(v)
+			return err
+		},
+	},
+	"bind": {
+		get: func(c *Config) string { return c.Bind },
+		set: func(c *Config, v string) error {
+			c.Bind = v
+			return nil
+		},
+	},
+	"databases": {
+		get: func(c *Config) string { return strconv.Itoa(c.Databases) },
+		set: func(c *Config, v string) (err error) {
+			c.Databases, err = parseInt(v, 1, 1<<16)
+			return err
+		},
+		apply: func(c *Config) error {
+			if err := DefaultServer.SetDatabases(c.Databases); err != nil {
+				return errors.New("can't decrease the number of databases while the removed databases are selected or hold keys")
+			}
+			return nil
+		},
+		mutable: true,
+	},
+	"dbfilename": {
+		get: func(c *Config) string { return c.DBFilename },
+		set: func(c *Config, v string) (err error) {
+			c.DBFilename, err = parseFilename(v)
+			return err
+		},
+		apply:   applyRDBPath,
+		mutable: true,
+	},
+	"dir": {
+		get: func(c *Config) string { return c.Dir },
+		set: func(c *Config, v string) error {
+			if fi, err := os.Stat(v); err != nil || !fi.IsDir() {
+				return errors.New("no such directory")
+			}
+			c.Dir = v
+			return nil
+		},
+		apply: func(c *Config) error {
+			applyRDBPath(c)
+			return applyAOFPath(c)
+		},
+	},
+	"maxclients": {
+		get: func(c *Config) string { return strconv.Itoa(c.MaxClients) },
+		set: func(c *Config, v string) (err error) {
+			c.MaxClients, err = parseInt(v, 1, 1<<31-1)
+			return err
+		},
+		mutable: true,
+	},
+	"notify-keyspace-events": {
+		get: func(c *Config) string { return c.Notify.String() },
+		set: func(c *Config, v string) (err error) {
+			if c.Notify, err = ParseNotifyFlags(v); err != nil {
+				return errors.New("invalid event class character, use 'Ag$lshzxeKEtmd'")
+			}
+			return nil
+		},
+		apply: func(c *Config) error {
+			SetNotifyFlags(c.Notify)
+			return nil
+		},
+		mutable: true,
+	},
+	"port": {
+		get: func(c *Config) string { return strconv.Itoa(c.Port) },
+		set: func(c *Config, v string) (err error) {
+			c.Port, err = parseInt(v, 0, 65535)
+			return err
+		},
+	},
+	"save": {
+		get: func(c *Config) string {
+			vals := make([]string, 0, 2*len(c.Save))
+			for _, p := range c.Save {
+				vals = append(vals, strconv.FormatInt(p.Secs, 10), strconv.FormatInt(p.Changes, 10))
+			}
+			return strings.Join(vals, " ")
+		},
+		set: func(c *Config, v string) (err error) {
+			c.Save, err = parseSavePoints(strings.Fields(v))
+			return err
+		},
+		mutable: true,
+	},
+	"timeout": {
+		get: func(c *Config) string { return strconv.FormatInt(int64(c.Timeout/time.Second), 10) },
+		set: func(c *Config, v string) error {
+			secs, err := parseInt(v, 0, 1<<31-1)
+			c.Timeout = time.Duration(secs) * time.Second
+			return err
+		},
+		mutable: true,
+	},
+}
+
+// applyRDBPath sets the path of the RDB file of the configuration.
+func applyRDBPath(c *Config) error {
+	SetRDBPath(filepath.Join(c.Dir, c.DBFilename))
+	return nil
+}
+
+// applyAOFPath sets the path of the append-only file of the configuration.
+func applyAOFPath(c *Config) error {
+	SetAOFPath(filepath.Join(c.Dir, c.AppendFilename))
+	return nil
+}
+
+// parseInt parses the integer v, which must be between min and max.
+func parseInt(v string, min, max int) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	return n, nil
+}
+
+// parseBool parses the boolean v, which is yes or no.
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+// formatBool returns the value of the boolean b in the configuration.
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseFilename parses the name of a file of the directory of the
+// configuration.
+func parseFilename(v string) (string, error) {
+	if v == "" || filepath.Base(v) != v {
+		return "", errors.New("argument must be a filename, not a path")
+	}
+	return v, nil
+}
+
+// parseSavePoints parses the save points, which are pairs of seconds and
+// number of changes.
+func parseSavePoints(vals []string) ([]SavePoint, error) {
+	if len(vals)%2 != 0 {
+		return nil, errors.New("invalid save parameters")
+	}
+	var points []SavePoint
+	for i := 0; i < len(vals); i += 2 {
+		secs, err1 := strconv.ParseInt(vals[i], 10, 64)
+		changes, err2 := strconv.ParseInt(vals[i+1], 10, 64)
+		if err1 != nil || err2 != nil || secs < 1 || changes < 0 {
+			return nil, errors.New("invalid save parameters")
+		}
+		points = append(points, SavePoint{Secs: secs, Changes: changes})
+	}
+	return points, nil
+}
+
+// The current configuration and the path of its file, protected by
+// configMu. The Save slice of the configuration is replaced, never
+// modified, so that the copies returned by CurrentConfig can share it.
+var (
+	configMu   sync.RWMutex
+	config     = defaultConfig()
+	configFile string
+)
+
+// CurrentConfig returns the current configuration.
+func CurrentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// ConfigFile returns the path of the configuration file, or an empty string
+// if the server was started without a configuration file.
+func ConfigFile() string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return configFile
+}
+
+// LoadConfig loads the configuration file at path, if path is not empty,
+// followed by the directives of args, which are pairs of parameter name and
+// value that override the file, and applies the configuration. The unknown
+// directives of the file are ignored, with a warning.
+func LoadConfig(path string, args ...string) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	c := defaultConfig()
+	firstSave := true
+	load := func(vals []string) error {
+		name := strings.ToLower(vals[0])
+		p, ok := configParams[name]
+		if !ok {
+			return &ConfigError{name, ErrUnknownConfig}
+		}
+
+		// The save points of multiple directives are added, and replace
+		// the default save points. A single value holds all the points,
+		// and an empty one removes the previous points.
+		if name == "save" {
+			pts := vals[1:]
+			if len(pts) == 1 {
+				pts = strings.Fields(pts[0])
+			}
+			points, err := parseSavePoints(pts)
+			if err != nil {
+				return &ConfigError{name, err}
+			}
+			if firstSave || len(pts) == 0 {
+				c.Save, firstSave = nil, false
+			}
+			c.Save = append(c.Save, points...)
+			return nil
+		}
+
+		if len(vals) != 2 {
+			return &ConfigError{name, errors.New("wrong number of arguments")}
+		}
+		if err := p.set(&c, vals[1]); err != nil {
+			return &ConfigError{name, err}
+		}
+		return nil
+	}
+
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for n := 1; s.Scan(); n++ {
+			vals, err := splitConfigLine(s.Text())
+			if err == nil && len(vals) > 0 {
+				err = load(vals)
+			}
+			if ce, ok := err.(*ConfigError); ok && ce.Err == ErrUnknownConfig {
+				glog.Warningf("%s:%d: unknown directive %s ignored", path, n, ce.Name)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("srv: %s:%d: %s", path, n, err)
+			}
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+	if len(args)%2 != 0 {
+		return fmt.Errorf("srv: config: no value for %s", args[len(args)-1])
+	}
+	for i := 0; i < len(args); i += 2 {
+		if err := load(args[i : i+2]); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range configNames() {
+		if apply := configParams[name].apply; apply != nil {
+			if err := apply(&c); err != nil {
+				return &ConfigError{name, err}
+			}
+		}
+	}
+	config, configFile = c, path
+	return nil
+}
+
+// configNames returns the sorted names of the configuration parameters.
+func configNames() []string {
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetConfig returns the names and values of the configuration parameters
+// that match one of the glob patterns, sorted by name.
+func GetConfig(patterns ...string) []string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	ret := []string{}
+	for _, name := range configNames() {
+		for _, pat := range patterns {
+			if glob.Match(strings.ToLower(pat), name) {
+				ret = append(ret, name, configParams[name].get(&config))
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// SetConfig sets the configuration parameters at runtime. The args are
+// pairs of parameter name and value. Either all parameters are set, or
+// none is, and a *ConfigError is returned.
+func SetConfig(args ...string) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	c := config
+	var applied []func(*Config) error
+	seen := make(map[string]bool, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		p, ok := configParams[name]
+		switch {
+		case !ok:
+			return &ConfigError{name, ErrUnknownConfig}
+		case !p.mutable:
+			return &ConfigError{name, ErrImmutableConfig}
+		case seen[name]:
+			return &ConfigError{name, ErrDuplicateConfig}
+		}
+		seen[name] = true
+		if err := p.set(&c, args[i+1]); err != nil {
+			return &ConfigError{name, err}
+		}
+	}
+
+	// Apply the new values, and restore the previous ones if one of them
+	// cannot be applied.
+	for i := 0; i+1 < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		apply := configParams[name].apply
+		if apply == nil {
+			continue
+		}
+		if err := apply(&c); err != nil {
+			for j := len(applied) - 1; j >= 0; j-- {
+				applied[j](&config)
+			}
+			return &ConfigError{name, err}
+		}
+		applied = append(applied, apply)
+	}
+	config = c
+	return nil
+}
+
+// configHeader is the comment that precedes the directives added to the
+// configuration file by RewriteConfig.
+const configHeader = "# Generated by CONFIG REWRITE"
+
+// RewriteConfig rewrites the configuration file with the current values
+// of the parameters. The lines of the parameters are replaced in place, the
+// comments and the unknown directives are kept, and the parameters that
+// are not in the file and differ from their default value are added at
+// the end. The file is replaced once complete.
+func RewriteConfig() error {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if configFile == "" {
+		return ErrNoConfigFile
+	}
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	var header bool
+	done := make(map[string]bool)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		header = header || line == configHeader
+		vals, err := splitConfigLine(line)
+		if err != nil || len(vals) == 0 {
+			lines = append(lines, line)
+			continue
+		}
+		name := strings.ToLower(vals[0])
+		if _, ok := configParams[name]; !ok {
+			lines = append(lines, line)
+			continue
+		}
+		// The first directive of the parameter is replaced, the others
+		// are removed.
+		if !done[name] {
+			lines = append(lines, configLines(name, &config)...)
+			done[name] = true
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	def := defaultConfig()
+	for _, name := range configNames() {
+		p := configParams[name]
+		if done[name] || p.get(&config) == p.get(&def) {
+			continue
+		}
+		if !header {
+			lines = append(lines, configHeader)
+			header = true
+		}
+		lines = append(lines, configLines(name, &config)...)
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	tmp := filepath.Join(filepath.Dir(configFile), fmt.Sprintf("temp-%d.conf", os.Getpid()))
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, configFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// configLines returns the lines of the configuration file that set the
+// parameter name to its value in the configuration c.
+func configLines(name string, c *Config) []string {
+	if name == "save" {
+		if len(c.Save) == 0 {
+			return []string{`save ""`}
+		}
+		lines := make([]string, len(c.Save))
+		for i, p := range c.Save {
+			lines[i] = fmt.Sprintf("save %d %d", p.Secs, p.Changes)
+		}
+		return lines
+	}
+	return []string{name + " " + quoteConfigValue(configParams[name].get(c))}
+}
+
+// quoteConfigValue returns the value v as written in the configuration
+// file, in double quotes if it is empty or has special characters.
+func quoteConfigValue(v string) string {
+	quote := v == ""
+	for i := 0; i < len(v) && !quote; i++ {
+		quote = v[i] <= ' ' || v[i] > '~' || v[i] == '"' || v[i] == '\'' || v[i] == '\\'
+	}
+	if !quote {
+		return v
+	}
+
+	buf := []byte{'"'}
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, `\n`...)
+		case c == '\r':
+			buf = append(buf, `\r`...)
+		case c == '\t':
+			buf = append(buf, `\t`...)
+		case c < ' ' || c > '~':
+			buf = append(buf, fmt.Sprintf(`\x%02x`, c)...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return string(append(buf, '"'))
+}
+
+// splitConfigLine splits a line of the configuration file in arguments,
+// separated by spaces. An argument may be in double quotes, with the
+// escape sequences \n, \r, \t, \\, \" and \xHH, or in single quotes, with
+// the escape sequence \'. A comment line returns no argument.
+func splitConfigLine(line string) ([]string, error) {
+	var args []string
+	for i := 0; ; {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) || (len(args) == 0 && line[i] == '#') {
+			return args, nil
+		}
+
+		var arg []byte
+		switch q := line[i]; q {
+		case '"', '\'':
+			i++
+			for {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes")
+				}
+				c := line[i]
+				if c == q {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					if q == '\'' {
+						if line[i+1] == '\'' {
+							c = '\''
+							i++
+						}
+					} else {
+						switch e := line[i+1]; e {
+						case 'n':
+							c = '\n'
+						case 'r':
+							c = '\r'
+						case 't':
+							c = '\t'
+						case 'x':
+							if i+4 > len(line) {
+								return nil, errors.New("invalid escape sequence")
+							}
+							n, err := strconv.ParseUint(line[i+2:i+4], 16, 8)
+							if err != nil {
+								return nil, errors.New("invalid escape sequence")
+							}
+							c = byte(n)
+							i += 2
+						default:
+							c = e
+						}
+						i++
+					}
+				}
+				arg = append(arg, c)
+				i++
+			}
+			// The closing quote must be followed by a space
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil, errors.New("closing quote must be followed by a space")
+			}
+		default:
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				arg = append(arg, line[i])
+				i++
+			}
+		}
+		args = append(args, string(arg))
+	}
+}
@@ -0,0 +1,262 @@
+package srv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitConfigLine(t *testing.T) {
+	cases := []struct {
+		line string
+		exp  []string
+		err  bool
+	}{
+		{"", nil, false},
+		{"   ", nil, false},
+		{"# comment", nil, false},
+		{"  # comment", nil, false},
+		{"port 6379", []string{"port", "6379"}, false},
+		{"\tsave  900 1 ", []string{"save", "900", "1"}, false},
+		{"dir a#b", []string{"dir", "a#b"}, false},
+		{`save ""`, []string{"save", ""}, false},
+		{`dbfilename "a b"`, []string{"dbfilename", "a b"}, false},
+		{`x "\n\r\t\\\"\x41"`, []string{"x", "\n\r\t\\\"A"}, false},
+		{`x 'a\'b\n'`, []string{"x", `a'b\n`}, false},
+		{`x "a`, nil, true},
+		{`x "a"b`, nil, true},
+		{`x "\x4"`, nil, true},
+		{`x "\xzz"`, nil, true},
+	}
+	for i, c := range cases {
+		got, err := splitConfigLine(c.line)
+		if (err != nil) != c.err {
+			t.Errorf("%d: expected error %t, got %v", i, c.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %q, got %q", i, c.exp, got)
+		}
+	}
+}
+
+func TestQuoteConfigValue(t *testing.T) {
+	for i, v := range []string{"", "a", "a b", `a"b`, "a'b", `a\b`, "a\nb\r\t", "\x00\xff", "KEA"} {
+		args, err := splitConfigLine("x " + quoteConfigValue(v))
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if len(args) != 2 || args[1] != v {
+			t.Errorf("%d: expected %q, got %q", i, v, args)
+		}
+	}
+}
+
+// writeConfig writes the configuration file content in a temporary
+// directory, and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "gred-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "gred.conf")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	defer LoadConfig("")
+
+	path := writeConfig(t, `# gred configuration
+port 7000
+unknown directive
+save 900 1
+save 300 10
+timeout 30
+maxclients 10
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if err := LoadConfig(path, "maxclients", "20"); err != nil {
+		t.Fatal(err)
+	}
+	c := CurrentConfig()
+	if c.Port != 7000 || c.Timeout != 30*time.Second || c.MaxClients != 20 || c.Databases != DefaultDatabases {
+		t.Errorf("unexpected configuration: %+v", c)
+	}
+	if exp := []SavePoint{{900, 1}, {300, 10}}; !reflect.DeepEqual(c.Save, exp) {
+		t.Errorf("expected save points %v, got %v", exp, c.Save)
+	}
+	if ConfigFile() != path {
+		t.Errorf("expected config file %s, got %s", path, ConfigFile())
+	}
+
+	if err := LoadConfig(path, "save", ""); err != nil {
+		t.Fatal(err)
+	}
+	if c := CurrentConfig(); len(c.Save) != 0 {
+		t.Errorf("expected no save point, got %v", c.Save)
+	}
+
+	bad := writeConfig(t, "port 7000\nport x\n")
+	defer os.RemoveAll(filepath.Dir(bad))
+	err := LoadConfig(bad)
+	if err == nil || !strings.Contains(err.Error(), bad+":2:") {
+		t.Errorf("expected an error at line 2, got %v", err)
+	}
+	if err := LoadConfig("", "timeout"); err == nil {
+		t.Error("expected an error for a directive without value")
+	}
+	if err := LoadConfig("", "unknown", "1"); err == nil {
+		t.Error("expected an error for an unknown directive")
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	defer LoadConfig("")
+	if err := LoadConfig("", "timeout", "5", "save", "60 100"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		pats []string
+		exp  []string
+	}{
+		{[]string{"none"}, []string{}},
+		{[]string{"TIMEOUT"}, []string{"timeout", "5"}},
+		{[]string{"save", "timeout", "t*"}, []string{"save", "60 100", "timeout", "5"}},
+		{[]string{"max*", "databases"}, []string{"databases", "16", "maxclients", "10000"}},
+		{[]string{"append*"}, []string{"appendfilename", DefaultAOFPath, "appendfsync", "everysec", "appendonly", "no"}},
+	}
+	for i, c := range cases {
+		got := GetConfig(c.pats...)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %q, got %q", i, c.exp, got)
+		}
+	}
+	if got := GetConfig("*"); len(got) != 2*len(configParams) {
+		t.Errorf("expected %d values, got %d", 2*len(configParams), len(got))
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	defer LoadConfig("")
+	LoadConfig("")
+
+	cases := []struct {
+		args []string
+		err  error
+		name string
+	}{
+		{[]string{"timeout", "10", "maxclients", "50"}, nil, ""},
+		{[]string{"unknown", "1"}, ErrUnknownConfig, "unknown"},
+		{[]string{"port", "7000"}, ErrImmutableConfig, "port"},
+		{[]string{"timeout", "1", "TIMEOUT", "2"}, ErrDuplicateConfig, "timeout"},
+		{[]string{"maxclients", "1", "timeout", "x"}, nil, "timeout"},
+		{[]string{"save", "60"}, nil, "save"},
+		{[]string{"dbfilename", "a/b.rdb"}, nil, "dbfilename"},
+	}
+	for i, c := range cases {
+		err := SetConfig(c.args...)
+		if c.name == "" {
+			if err != nil {
+				t.Errorf("%d: expected no error, got %v", i, err)
+			}
+			continue
+		}
+		ce, ok := err.(*ConfigError)
+		if !ok {
+			t.Errorf("%d: expected a config error, got %v", i, err)
+			continue
+		}
+		if ce.Name != c.name || (c.err != nil && ce.Err != c.err) {
+			t.Errorf("%d: expected error %v for %s, got %v", i, c.err, c.name, err)
+		}
+	}
+
+	// The failed calls did not set any parameter
+	c := CurrentConfig()
+	if c.Timeout != 10*time.Second || c.MaxClients != 50 {
+		t.Errorf("unexpected configuration: %+v", c)
+	}
+
+	if err := SetConfig("databases", "20"); err != nil {
+		t.Fatal(err)
+	}
+	if n := DefaultServer.Databases(); n != 20 {
+		t.Errorf("expected 20 databases, got %d", n)
+	}
+	if err := SetConfig("databases", "16"); err != nil {
+		t.Fatal(err)
+	}
+	if n := DefaultServer.Databases(); n != 16 {
+		t.Errorf("expected 16 databases, got %d", n)
+	}
+}
+
+func TestRewriteConfig(t *testing.T) {
+	defer LoadConfig("")
+	LoadConfig("")
+	if err := RewriteConfig(); err != ErrNoConfigFile {
+		t.Errorf("expected %v, got %v", ErrNoConfigFile, err)
+	}
+
+	path := writeConfig(t, `# gred configuration
+timeout 30
+unknown directive
+save 900 1
+
+# Snapshots
+save 300 10
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if err := LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("timeout", "0", "save", "60 5", "maxclients", "100", "dbfilename", "my dump.rdb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RewriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `# gred configuration
+timeout 0
+unknown directive
+save 60 5
+
+# Snapshots
+# Generated by CONFIG REWRITE
+dbfilename "my dump.rdb"
+maxclients 100
+`
+	if string(b) != exp {
+		t.Errorf("expected\n%s\ngot\n%s", exp, b)
+	}
+
+	// The rewritten file loads the same configuration
+	want := CurrentConfig()
+	if err := LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := CurrentConfig(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if err := RewriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != exp {
+		t.Errorf("expected the rewrite to be stable, got\n%s", b)
+	}
+}
@@ -2,5 +2,35 @@ package srv
 
 // Conn defines the methods required to implement a Connection.
 type Conn interface {
+	// Select sets the connection's current database index.
 	Select(int)
+
+	// DB returns the connection's current database.
+	DB() DB
+
+	// ID returns the unique ID of the connection, and Name the name set by
+	// SetName, if any.
+	ID() int64
+	Name() string
+	SetName(string)
+
+	// Block must be called by blocking commands before they wait for a value.
+	// It returns false if the connection cannot block (e.g. when it executes
+	// a transaction), otherwise it returns a channel that is closed if the
+	// client disconnects while blocked, in which case the wait must be
+	// cancelled, and a function that must be called once the wait is over.
+	Block() (<-chan struct{}, func(), bool)
+
+	// Transactions
+	Multi() error
+	Exec() (interface{}, error)
+	Discard() error
+	Watch(...string) error
+	Unwatch()
+
+	// Publish/Subscribe, the methods return the replies to send.
+	Subscribe(...string) interface{}
+	Unsubscribe(...string) interface{}
+	PSubscribe(...string) interface{}
+	PUnsubscribe(...string) interface{}
 }
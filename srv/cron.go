@@ -0,0 +1,24 @@
+package srv
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// cronLoop runs the periodic tasks of the server every second: it kills
+// the clients that are idle for longer than the timeout, and saves the
+// databases when a save point is reached.
+func (s *server) cronLoop() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for now := range tick.C {
+		cfg := CurrentConfig()
+		if cfg.Timeout > 0 {
+			if n := DefaultClients.KillIdle(cfg.Timeout); n > 0 {
+				glog.V(1).Infof("%d idle clients killed", n)
+			}
+		}
+		s.autoSave(now, cfg.Save)
+	}
+}
@@ -47,8 +47,28 @@ const (
 	NoKeyCreateSortedSet
 )
 
-// WaitChan is the channel type required for the blocking operations on Lists.
-type WaitChan <-chan chan<- [2]string
+// WaitChan is the channel type required for the blocking operations on
+// lists and streams. The waker receives from it the channel on which to send
+// the name of the key, followed by the values popped for the waiter, if any.
+type WaitChan <-chan chan<- []string
+
+// ListWaiter is a client blocked popping values from lists, with the way
+// the values must be popped.
+type ListWaiter struct {
+	Ch WaitChan
+
+	// RPop is true if the values are popped from the tail of the list.
+	RPop bool
+
+	// Count is the maximum number of values popped. At least one value is
+	// popped.
+	Count int64
+
+	// Dst is the list that the popped value is pushed to, if set, at its
+	// tail if DstRPush is true.
+	Dst      string
+	DstRPush bool
+}
 
 // DB represents a Database, and defines the methods required to manipulate
 // its keys.
@@ -56,31 +76,60 @@ type DB interface {
 	// Sync mutex interface
 	RWLocker
 
+	// Index returns the index of the database
+	Index() int
+
 	// DB-level commands
 	Del(...string) int64
 	Exists(string) bool
-	Expire(string, int64, func()) bool
-	ExpireAt(string, int64, func()) bool
+	Expire(string, int64) bool
+	ExpireAt(string, int64) bool
+	ExpireKey(Key, time.Time)
 	FlushDB()
 	Persist(string) bool
-	PExpire(string, int64, func()) bool
-	PExpireAt(string, int64, func()) bool
-	PSetEx(string, int64, string, func())
+	PExpire(string, int64) bool
+	PExpireAt(string, int64) bool
+	PSetEx(string, int64, string)
 	PTTL(string) int64
-	SetEx(string, int64, string, func())
+	SetEx(string, int64, string)
 	TTL(string) int64
 	Type(string) string
 
 	// Keys access
-	Keys() map[string]Key
+	Keys() Keyspace
+	Key(string) (Key, bool)
+	SetKey(Key)
 	DelKey(string)
+
+	// Keys that change name or database. The databases must be exclusively
+	// locked.
+	Rename(string, string) bool
+	Move(string, DB) bool
+	Copy(string, DB, string, bool) bool
 	LockGetKey(string, NoKeyFlag) (Key, func())
 	XLockGetKey(string, NoKeyFlag) (Key, func())
 
 	// Blocking list waiters
-	WaitLPop(string, WaitChan)
-	WaitRPop(string, WaitChan)
-	NextWaiter(string) (WaitChan, bool)
+	WaitPop(string, ListWaiter)
+	NextWaiter(string) (ListWaiter, bool)
+
+	// Blocking stream waiters, which are all woken when entries are added
+	WaitStream(string, WaitChan)
+	NextStreamWaiter(string) WaitChan
+
+	// Unwait removes the list and stream waiters of a channel from the keys
+	Unwait(WaitChan, ...string)
+
+	// Watched keys
+	Watch(string) uint64
+	Unwatch(string)
+	Version(string) uint64
+
+	// Keyspace notifications
+	Notify(NotifyFlag, string, string)
+
+	// Active expiration
+	ExpireKeys(time.Time, int) time.Time
 }
 
 // Static check to make sure *db implements the DB interface.
@@ -94,60 +143,209 @@ type db struct {
 	ix int
 
 	// the keys held by the database
-	keys map[string]Key
+	keys Keyspace
 
 	// Block list waiters
-	waitersChans  map[string][]WaitChan
-	waitersPopPos map[string][]bool
+	waiters map[string][]ListWaiter
+
+	// Block stream waiters
+	streamWaiters map[string][]WaitChan
+
+	// Watched keys, with the number of watchers, and the version
+	// assigned when a watched key is deleted.
+	watchers map[string]int
+	tombs    map[string]uint64
+
+	// Expiration index, and the channel used to wake the expiration
+	// scheduler, if any.
+	expMu sync.Mutex
+	exps  expHeap
+	wake  chan<- struct{}
 }
 
-// NewDB creates a new DB value, with the specified index.
+// NewDB creates a new DB value, with the specified index. Its expired keys
+// are deleted only when accessed, or when ExpireKeys is called.
 func NewDB(ix int) DB {
+	return newDB(ix, nil)
+}
+
+// newDB creates a new DB value, with the specified index, that wakes the
+// expiration scheduler on wake.
+func newDB(ix int, wake chan<- struct{}) *db {
 	return &db{
 		ix:            ix,
-		keys:          make(map[string]Key),
-		waitersChans:  make(map[string][]WaitChan),
-		waitersPopPos: make(map[string][]bool),
+		wake:          wake,
+		keys:          NewKeyspace(),
+		waiters:       make(map[string][]ListWaiter),
+		streamWaiters: make(map[string][]WaitChan),
+		watchers:      make(map[string]int),
+		tombs:         make(map[string]uint64),
 	}
 }
 
-func (d *db) WaitLPop(key string, ch WaitChan) {
-	d.waitPop(key, ch, false)
+func (d *db) Index() int {
+	return d.ix
 }
 
-func (d *db) WaitRPop(key string, ch WaitChan) {
-	d.waitPop(key, ch, true)
+// WaitPop registers a waiter for values pushed to the list key. The DB
+// must be exclusively locked.
+func (d *db) WaitPop(key string, w ListWaiter) {
+	d.waiters[key] = append(d.waiters[key], w)
 }
 
-func (d *db) NextWaiter(key string) (WaitChan, bool) {
-	slch, slbl := d.waitersChans[key], d.waitersPopPos[key]
-	if len(slch) == 0 {
-		return nil, false
+// NextWaiter removes and returns the next waiter for values pushed to the
+// list key, and false if there is none. The DB must be exclusively locked.
+func (d *db) NextWaiter(key string) (ListWaiter, bool) {
+	ws := d.waiters[key]
+	if len(ws) == 0 {
+		return ListWaiter{}, false
 	}
-	ch, bl := slch[0], slbl[0]
-	slch, slbl = slch[1:], slbl[1:]
-	d.waitersChans[key] = slch
-	d.waitersPopPos[key] = slbl
+	w := ws[0]
+	if len(ws) == 1 {
+		delete(d.waiters, key)
+	} else {
+		ws[0] = ListWaiter{}
+		d.waiters[key] = ws[1:]
+	}
+	return w, true
+}
 
-	return ch, bl
+// WaitStream registers a waiter for entries added to the stream key. The
+// DB must be exclusively locked.
+func (d *db) WaitStream(key string, ch WaitChan) {
+	d.streamWaiters[key] = append(d.streamWaiters[key], ch)
 }
 
-func (d *db) waitPop(key string, ch WaitChan, rpop bool) {
-	slch := d.waitersChans[key]
-	slch = append(slch, ch)
-	d.waitersChans[key] = slch
-	slbl := d.waitersPopPos[key]
-	slbl = append(slbl, rpop)
-	d.waitersPopPos[key] = slbl
+// NextStreamWaiter removes and returns the next waiter for entries added
+// to the stream key, or nil if there is none. The DB must be exclusively
+// locked.
+func (d *db) NextStreamWaiter(key string) WaitChan {
+	chs := d.streamWaiters[key]
+	if len(chs) == 0 {
+		return nil
+	}
+	ch := chs[0]
+	if len(chs) == 1 {
+		delete(d.streamWaiters, key)
+	} else {
+		chs[0] = nil
+		d.streamWaiters[key] = chs[1:]
+	}
+	return ch
 }
 
+// Unwait removes the list and stream waiters that wait on the channel ch
+// from the keys, so that the waiters of a client that stopped waiting do
+// not accumulate. The DB must be exclusively locked.
+func (d *db) Unwait(ch WaitChan, keys ...string) {
+	for _, key := range keys {
+		if ws, ok := d.waiters[key]; ok {
+			kept := ws[:0]
+			for _, w := range ws {
+				if w.Ch != ch {
+					kept = append(kept, w)
+				}
+			}
+			if len(kept) == 0 {
+				delete(d.waiters, key)
+			} else {
+				clearListWaiters(ws[len(kept):])
+				d.waiters[key] = kept
+			}
+		}
+		if chs, ok := d.streamWaiters[key]; ok {
+			kept := chs[:0]
+			for _, c := range chs {
+				if c != ch {
+					kept = append(kept, c)
+				}
+			}
+			if len(kept) == 0 {
+				delete(d.streamWaiters, key)
+			} else {
+				for i := len(kept); i < len(chs); i++ {
+					chs[i] = nil
+				}
+				d.streamWaiters[key] = kept
+			}
+		}
+	}
+}
+
+// clearListWaiters sets the waiters to the zero value, so that their
+// channels can be garbage-collected.
+func clearListWaiters(ws []ListWaiter) {
+	for i := range ws {
+		ws[i] = ListWaiter{}
+	}
+}
+
+// Watch registers a watcher for the key, and returns its current version.
+// The DB must be exclusively locked.
+func (d *db) Watch(name string) uint64 {
+	d.watchers[name]++
+	return d.version(name)
+}
+
+// Unwatch unregisters a watcher for the key. The DB must be exclusively
+// locked.
+func (d *db) Unwatch(name string) {
+	if n := d.watchers[name]; n > 1 {
+		d.watchers[name] = n - 1
+		return
+	}
+	delete(d.watchers, name)
+	delete(d.tombs, name)
+}
+
+// Version returns the current version of the key. It changes each time
+// the key is modified, created or deleted, and is unique across all keys.
+func (d *db) Version(name string) uint64 {
+	return d.version(name)
+}
+
+func (d *db) version(name string) uint64 {
+	if k, ok := d.keys.Get(name); ok {
+		k.RLock()
+		defer k.RUnlock()
+		return k.Version()
+	}
+	return d.tombs[name]
+}
+
+// touch assigns a new version to the key if it exists. The DB must be
+// locked.
+func (d *db) touch(name string) {
+	if k, ok := d.keys.Get(name); ok {
+		if k, ok := k.(*key); ok {
+			k.touch()
+		}
+	}
+}
+
+// deleted records the deletion of the key if it is watched.
+func (d *db) deleted(name string) {
+	if d.watchers[name] > 0 {
+		d.tombs[name] = nextVersion()
+	}
+}
+
+// Del deletes the keys, and returns the number of keys deleted. Keys that
+// are expired are deleted as such, and are not counted. The DB must be
+// exclusively locked.
 func (d *db) Del(names ...string) int64 {
 	var cnt int64
+	now := time.Now()
 	for _, nm := range names {
-		if k, ok := d.keys[nm]; ok {
+		if k, ok := d.keys.Get(nm); ok {
+			if expired(k, now) {
+				d.expire(nm, true)
+				continue
+			}
 			k.Lock()
 			k.Abort()
-			delete(d.keys, nm)
+			d.keys.Del(nm)
+			d.deleted(nm)
 			cnt++
 			k.Unlock()
 		}
@@ -156,52 +354,75 @@ func (d *db) Del(names ...string) int64 {
 }
 
 func (d *db) Exists(name string) bool {
-	_, ok := d.keys[name]
+	_, ok := d.Key(name)
 	return ok
 }
 
-func (d *db) Expire(name string, secs int64, fn func()) bool {
-	return d.expireDuration(name, time.Duration(secs)*time.Second, fn)
+func (d *db) Expire(name string, secs int64) bool {
+	return d.expireAt(name, time.Now().Add(time.Duration(secs)*time.Second))
 }
 
-func (d *db) ExpireAt(name string, uxts int64, fn func()) bool {
-	secs := uxts - time.Now().Unix()
-	return d.expireDuration(name, time.Duration(secs)*time.Second, fn)
+func (d *db) ExpireAt(name string, uxts int64) bool {
+	return d.expireAt(name, time.Unix(uxts, 0))
 }
 
 func (d *db) FlushDB() {
-	d.keys = make(map[string]Key)
+	for nm := range d.watchers {
+		if _, ok := d.keys.Get(nm); ok {
+			d.deleted(nm)
+		}
+	}
+	d.keys = NewKeyspace()
+	d.expMu.Lock()
+	d.exps = nil
+	d.expMu.Unlock()
 }
 
-func (d *db) PExpire(name string, ms int64, fn func()) bool {
-	return d.expireDuration(name, time.Duration(ms)*time.Millisecond, fn)
+func (d *db) PExpire(name string, ms int64) bool {
+	return d.expireAt(name, time.Now().Add(time.Duration(ms)*time.Millisecond))
 }
 
-func (d *db) PExpireAt(name string, uxts int64, fn func()) bool {
-	dur := (time.Duration(uxts) * time.Millisecond) - time.Duration(time.Now().UnixNano())
-	return d.expireDuration(name, dur, fn)
+func (d *db) PExpireAt(name string, uxts int64) bool {
+	return d.expireAt(name, time.Unix(0, uxts*int64(time.Millisecond)))
 }
 
-func (d *db) expireDuration(name string, dur time.Duration, fn func()) bool {
-	if k, ok := d.keys[name]; ok {
+func (d *db) expireAt(name string, t time.Time) bool {
+	if k, ok := d.Key(name); ok {
 		k.Lock()
 		defer k.Unlock()
-		return k.Expire(dur, fn)
+		d.ExpireKey(k, t)
+		return true
 	}
 	return false
 }
 
-func (d *db) PSetEx(name string, ms int64, v string, fn func()) {
-	d.setExDuration(name, time.Duration(ms)*time.Millisecond, v, fn)
+// ExpireKey sets the key to expire at time t. The DB must be locked, and
+// the key must be exclusively locked by the caller.
+func (d *db) ExpireKey(k Key, t time.Time) {
+	k.Expire(t)
+	d.scheduleExpire(k, t)
 }
 
-func (d *db) SetEx(name string, secs int64, v string, fn func()) {
-	d.setExDuration(name, time.Duration(secs)*time.Second, v, fn)
+func (d *db) PSetEx(name string, ms int64, v string) {
+	d.setExDuration(name, time.Duration(ms)*time.Millisecond, v)
 }
 
-func (d *db) setExDuration(name string, dur time.Duration, v string, fn func()) {
-	// Get or create the key
+func (d *db) SetEx(name string, secs int64, v string) {
+	d.setExDuration(name, time.Duration(secs)*time.Second, v)
+}
+
+func (d *db) setExDuration(name string, dur time.Duration, v string) {
+	// Get or create the key. A key of another type is replaced, under the
+	// exclusive lock.
 	k, def := d.LockGetKey(name, NoKeyCreateString)
+	if _, ok := k.Val().(types.String); !ok {
+		def()
+		k, def = d.XLockGetKey(name, NoKeyCreateString)
+		if _, ok := k.Val().(types.String); !ok {
+			k = NewKey(name, types.NewIncString(""))
+			d.SetKey(k)
+		}
+	}
 	defer def()
 
 	// Set its value
@@ -211,11 +432,11 @@ func (d *db) setExDuration(name string, dur time.Duration, v string, fn func())
 	kv.Set(v)
 
 	// Expire the key
-	k.Expire(dur, fn)
+	d.ExpireKey(k, time.Now().Add(dur))
 }
 
 func (d *db) Persist(name string) bool {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.Key(name); ok {
 		k.Lock()
 		defer k.Unlock()
 		return k.Abort()
@@ -224,7 +445,7 @@ func (d *db) Persist(name string) bool {
 }
 
 func (d *db) PTTL(name string) int64 {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.Key(name); ok {
 		k.RLock()
 		defer k.RUnlock()
 		ttl := k.TTL()
@@ -237,7 +458,7 @@ func (d *db) PTTL(name string) int64 {
 }
 
 func (d *db) TTL(name string) int64 {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.Key(name); ok {
 		k.RLock()
 		defer k.RUnlock()
 		ttl := k.TTL()
@@ -250,7 +471,7 @@ func (d *db) TTL(name string) int64 {
 }
 
 func (d *db) Type(name string) string {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.Key(name); ok {
 		k.RLock()
 		defer k.RUnlock()
 		return k.Val().Type()
@@ -258,17 +479,124 @@ func (d *db) Type(name string) string {
 	return "none"
 }
 
-func (d *db) Keys() map[string]Key {
+// swap swaps the keys and the expiration index of the databases, which
+// must be exclusively locked. The blocked clients and the watched keys
+// stay with their database, the versions of the keys tell the watchers
+// that they changed.
+func (d *db) swap(o *db) {
+	d.keys, o.keys = o.keys, d.keys
+
+	first, second := d, o
+	if o.ix < d.ix {
+		first, second = o, d
+	}
+	first.expMu.Lock()
+	second.expMu.Lock()
+	d.exps, o.exps = o.exps, d.exps
+	second.expMu.Unlock()
+	first.expMu.Unlock()
+}
+
+// Keys returns the keys of the database, including the expired keys that
+// are not deleted yet.
+func (d *db) Keys() Keyspace {
 	return d.keys
 }
 
+// Key returns the key if it exists and is not expired. The DB must be
+// locked, and the key must not be locked by the caller.
+func (d *db) Key(name string) (Key, bool) {
+	k, ok := d.keys.Get(name)
+	if !ok || expired(k, time.Now()) {
+		return nil, false
+	}
+	return k, true
+}
+
+// SetKey adds the key, replacing any key with the same name, and schedules
+// its expiration if it has one. The DB must be exclusively locked.
+func (d *db) SetKey(k Key) {
+	name := k.Name()
+	d.Del(name)
+	d.keys.Set(k)
+
+	k.RLock()
+	t, ok := k.Deadline()
+	k.RUnlock()
+	if ok {
+		d.scheduleExpire(k, t)
+	}
+}
+
+// Rename renames the key src to dst, replacing any key named dst, and
+// keeps its expiration. It returns false if src does not exist. The DB
+// must be exclusively locked.
+func (d *db) Rename(src, dst string) bool {
+	k, ok := d.Key(src)
+	if !ok {
+		return false
+	}
+	if src == dst {
+		return true
+	}
+	nk := copyKey(k, dst, false)
+	d.Del(src)
+	d.SetKey(nk)
+	return true
+}
+
+// Move moves the key to the database to, and keeps its expiration. It
+// returns false if the key does not exist, or if it exists in to. Both
+// databases must be exclusively locked.
+func (d *db) Move(name string, to DB) bool {
+	k, ok := d.Key(name)
+	if !ok || to.Exists(name) {
+		return false
+	}
+	nk := copyKey(k, name, false)
+	d.Del(name)
+	to.SetKey(nk)
+	return true
+}
+
+// Copy copies the value and the expiration of the key src to the key dst
+// of the database to, which may be the same database. If replace is false,
+// it returns false if dst exists. It returns false if src does not exist.
+// Both databases must be exclusively locked.
+func (d *db) Copy(src string, to DB, dst string, replace bool) bool {
+	k, ok := d.Key(src)
+	if !ok || !replace && to.Exists(dst) {
+		return false
+	}
+	to.SetKey(copyKey(k, dst, true))
+	return true
+}
+
+// copyKey returns a new key named name, with the expiration of k, and its
+// value or a copy of its value if clone is true.
+func copyKey(k Key, name string, clone bool) Key {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if clone {
+		v = cloneValue(v)
+	}
+	nk := NewKey(name, v)
+	if t, ok := k.Deadline(); ok {
+		nk.Expire(t)
+	}
+	return nk
+}
+
 // DelKey deletes the specified key. It is assumed the caller has an exclusive lock
 // for both the DB and the key to delete.
 func (d *db) DelKey(name string) {
-	k, ok := d.keys[name]
+	k, ok := d.keys.Get(name)
 	if ok {
 		k.Abort()
-		delete(d.keys, name)
+		d.keys.Del(name)
+		d.deleted(name)
 	}
 }
 
@@ -290,7 +618,17 @@ func (d *db) lockGetKey(excl bool, name string, flag NoKeyFlag) (Key, func()) {
 		d.RLock()
 		ret = d.RUnlock
 	}
-	if k, ok := d.keys[name]; ok {
+	k, ok := d.keys.Get(name)
+	if ok && expired(k, time.Now()) {
+		if excl {
+			d.expire(name, true)
+		}
+		ok = false
+	}
+	if flag == NoKeyNone || flag == NoKeyDefaultVal {
+		countLookup(ok)
+	}
+	if ok {
 		return k, ret
 	}
 
@@ -309,13 +647,15 @@ func (d *db) lockGetKey(excl bool, name string, flag NoKeyFlag) (Key, func()) {
 		ret = d.Unlock
 
 		// Check if key now exists (added during the lock upgrade)
-		if k, ok := d.keys[name]; ok {
-			return k, ret
+		if k, ok := d.keys.Get(name); ok {
+			if !expired(k, time.Now()) {
+				return k, ret
+			}
+			d.expire(name, true)
 		}
 	}
 
 	// Still no chance, create as requested
-	var k Key
 	switch flag {
 	case NoKeyCreateString:
 		k = NewKey(name, types.NewIncString(""))
@@ -327,9 +667,11 @@ func (d *db) lockGetKey(excl bool, name string, flag NoKeyFlag) (Key, func()) {
 		k = NewKey(name, types.NewList())
 	case NoKeyCreateSet:
 		k = NewKey(name, types.NewSet())
+	case NoKeyCreateSortedSet:
+		k = NewKey(name, types.NewSortedSet())
 	default:
 		panic(fmt.Sprintf("db.Key NoKeyFlag not implemented: %d", flag))
 	}
-	d.keys[name] = k
+	d.keys.Set(k)
 	return k, ret
 }
@@ -10,73 +10,117 @@ var dv defVal
 
 var empty = []string{}
 
+var emptyScored = []types.ScoredMember{}
+
 var (
-	_ Key          = (*defKey)(nil)
-	_ types.String = (*defVal)(nil)
-	_ types.Hash   = (*defVal)(nil)
-	_ types.List   = (*defVal)(nil)
-	_ types.Set    = (*defVal)(nil)
+	_ Key             = (*defKey)(nil)
+	_ types.String    = (*defVal)(nil)
+	_ types.Hash      = (*defVal)(nil)
+	_ types.List      = (*defVal)(nil)
+	_ types.Set       = (*defVal)(nil)
+	_ types.SortedSet = (*defVal)(nil)
 )
 
 type defKey string
 
-func (d defKey) Lock()                                 {}
-func (d defKey) Unlock()                               {}
-func (d defKey) RLock()                                {}
-func (d defKey) RUnlock()                              {}
-func (d defKey) Expire(_ time.Duration, _ func()) bool { return true }
-func (d defKey) TTL() time.Duration                    { return 0 }
-func (d defKey) Abort() bool                           { return true }
-func (d defKey) Val() types.Value                      { return dv }
+func (d defKey) Lock()                       {}
+func (d defKey) Unlock()                     {}
+func (d defKey) RLock()                      {}
+func (d defKey) RUnlock()                    {}
+func (d defKey) Expire(_ time.Time)          {}
+func (d defKey) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (d defKey) TTL() time.Duration          { return 0 }
+func (d defKey) Abort() bool                 { return true }
+func (d defKey) Val() types.Value            { return dv }
+func (d defKey) Modify()                     {}
 
-func (d defKey) Name() string { return string(d) }
+func (d defKey) Name() string    { return string(d) }
+func (d defKey) Version() uint64 { return 0 }
 
 type defVal struct{}
 
 func (d defVal) Type() string { panic("Type called on defKey value") }
 
 // String implementation
-func (d defVal) Append(_ string) int64            { return 0 }
-func (d defVal) Get() string                      { return "" }
-func (d defVal) GetRange(_, _ int64) string       { return "" }
-func (d defVal) GetSet(_ string) string           { return "" }
-func (d defVal) Set(_ string)                     {}
-func (d defVal) SetRange(_ int64, _ string) int64 { return 0 }
-func (d defVal) StrLen() int64                    { return 0 }
+func (d defVal) Append(_ string) int64                 { return 0 }
+func (d defVal) BitCount(_, _ int64, _ bool) int64     { return 0 }
+func (d defVal) Get() string                           { return "" }
+func (d defVal) GetBit(_ int64) int                    { return 0 }
+func (d defVal) GetBits(_ int64, _ uint, _ bool) int64 { return 0 }
+func (d defVal) GetRange(_, _ int64) string            { return "" }
+func (d defVal) GetSet(_ string) string                { return "" }
+func (d defVal) Set(_ string)                          {}
+func (d defVal) SetBit(_ int64, _ int) int             { return 0 }
+func (d defVal) SetBits(_ int64, _ uint, _ int64)      {}
+func (d defVal) SetRange(_ int64, _ string) int64      { return 0 }
+func (d defVal) StrLen() int64                         { return 0 }
+
+// BitPos returns the position of the first bit in an empty string.
+func (d defVal) BitPos(bit int, _, _ int64, _, _ bool) int64 {
+	if bit == 1 {
+		return -1
+	}
+	return 0
+}
 
 // Hashes implementation
-func (d defVal) HDel(_ ...string) int64               { return 0 }
-func (d defVal) HExists(_ string) bool                { return false }
-func (d defVal) HGet(_ string) (string, bool)         { return "", false }
-func (d defVal) HGetAll() []string                    { return empty }
-func (d defVal) HKeys() []string                      { return empty }
-func (d defVal) HLen() int64                          { return 0 }
-func (d defVal) HMGet(fields ...string) []interface{} { return make([]interface{}, len(fields)) }
-func (d defVal) HMSet(_ ...string)                    {}
-func (d defVal) HSet(_, _ string) bool                { return false }
-func (d defVal) HSetNx(_, _ string) bool              { return false }
-func (d defVal) HVals() []string                      { return empty }
+func (d defVal) HDel(_ ...string) int64                        { return 0 }
+func (d defVal) HExists(_ string) bool                         { return false }
+func (d defVal) HGet(_ string) (string, bool)                  { return "", false }
+func (d defVal) HGetAll() []string                             { return empty }
+func (d defVal) HKeys() []string                               { return empty }
+func (d defVal) HLen() int64                                   { return 0 }
+func (d defVal) HMGet(fields ...string) []interface{}          { return make([]interface{}, len(fields)) }
+func (d defVal) HMSet(_ ...string)                             {}
+func (d defVal) HScan(_ uint64, _ func(string, string)) uint64 { return 0 }
+func (d defVal) HSet(_, _ string) bool                         { return false }
+func (d defVal) HSetNx(_, _ string) bool                       { return false }
+func (d defVal) HVals() []string                               { return empty }
 
 // Lists implementation
-func (d defVal) LIndex(_ int64) (string, bool)   { return "", false }
-func (d defVal) LInsertBefore(_, _ string) int64 { return 0 }
-func (d defVal) LInsertAfter(_, _ string) int64  { return 0 }
-func (d defVal) LLen() int64                     { return 0 }
-func (d defVal) LPop() (string, bool)            { return "", false }
-func (d defVal) LPush(_ ...string) int64         { return 0 }
-func (d defVal) LRange(_, _ int64) []string      { return empty }
-func (d defVal) LRem(_ int64, _ string) int64    { return 0 }
-func (d defVal) LSet(_ int64, _ string) bool     { return false }
-func (d defVal) LTrim(_, _ int64)                {}
-func (d defVal) RPop() (string, bool)            { return "", false }
-func (d defVal) RPush(_ ...string) int64         { return 0 }
+func (d defVal) LIndex(_ int64) (string, bool)        { return "", false }
+func (d defVal) LInsertBefore(_, _ string) int64      { return 0 }
+func (d defVal) LInsertAfter(_, _ string) int64       { return 0 }
+func (d defVal) LLen() int64                          { return 0 }
+func (d defVal) LPop() (string, bool)                 { return "", false }
+func (d defVal) LPos(_ string, _, _, _ int64) []int64 { return []int64{} }
+func (d defVal) LPush(_ ...string) int64              { return 0 }
+func (d defVal) LRange(_, _ int64) []string           { return empty }
+func (d defVal) LRem(_ int64, _ string) int64         { return 0 }
+func (d defVal) LSet(_ int64, _ string) bool          { return false }
+func (d defVal) LTrim(_, _ int64)                     {}
+func (d defVal) RPop() (string, bool)                 { return "", false }
+func (d defVal) RPush(_ ...string) int64              { return 0 }
 
 // Sets implementation
-func (d defVal) SAdd(_ ...string) int64         { return 0 }
-func (d defVal) SCard() int64                   { return 0 }
-func (d defVal) SDiff(_ ...types.Set) []string  { return empty }
-func (d defVal) SInter(_ ...types.Set) []string { return empty }
-func (d defVal) SIsMember(_ string) bool        { return false }
-func (d defVal) SMembers() []string             { return empty }
-func (d defVal) SRem(_ ...string) int64         { return 0 }
-func (d defVal) SUnion(_ ...types.Set) []string { return empty }
+func (d defVal) SAdd(_ ...string) int64                { return 0 }
+func (d defVal) SCard() int64                          { return 0 }
+func (d defVal) SDiff(_ ...types.Set) []string         { return empty }
+func (d defVal) SInter(_ ...types.Set) []string        { return empty }
+func (d defVal) SIsMember(_ string) bool               { return false }
+func (d defVal) SMembers() []string                    { return empty }
+func (d defVal) SPop(_ int64) []string                 { return empty }
+func (d defVal) SRandMember(_ int64) []string          { return empty }
+func (d defVal) SRem(_ ...string) int64                { return 0 }
+func (d defVal) SScan(_ uint64, _ func(string)) uint64 { return 0 }
+func (d defVal) SUnion(_ ...types.Set) []string        { return empty }
+
+// Sorted sets implementation
+func (d defVal) ZAdd(_ string, _ float64) bool               { return false }
+func (d defVal) ZCard() int64                                { return 0 }
+func (d defVal) ZCount(_, _ types.ScoreBound) int64          { return 0 }
+func (d defVal) ZIncrBy(_ string, _ float64) (float64, bool) { return 0, false }
+func (d defVal) ZRange(_, _ int64) []types.ScoredMember      { return emptyScored }
+func (d defVal) ZRangeByScore(_, _ types.ScoreBound, _, _ int64) []types.ScoredMember {
+	return emptyScored
+}
+func (d defVal) ZRank(_ string) (int64, bool)                 { return 0, false }
+func (d defVal) ZRem(_ ...string) int64                       { return 0 }
+func (d defVal) ZRemRangeByRank(_, _ int64) int64             { return 0 }
+func (d defVal) ZRemRangeByScore(_, _ types.ScoreBound) int64 { return 0 }
+func (d defVal) ZRevRange(_, _ int64) []types.ScoredMember    { return emptyScored }
+func (d defVal) ZRevRangeByScore(_, _ types.ScoreBound, _, _ int64) []types.ScoredMember {
+	return emptyScored
+}
+func (d defVal) ZRevRank(_ string) (int64, bool) { return 0, false }
+func (d defVal) ZScore(_ string) (float64, bool) { return 0, false }
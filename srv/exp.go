@@ -1,55 +1,47 @@
 package srv
 
-import "time"
+import (
+	"container/heap"
+	"sync/atomic"
+	"time"
+)
 
 // Expirer is the interface that defines the methods to manage expiration.
 type Expirer interface {
-	Expire(time.Duration, func()) bool
+	Expire(time.Time)
+	Deadline() (time.Time, bool)
 	TTL() time.Duration
 	Abort() bool
 }
 
-// expirer is the internal implementation of an Expirer for a Key.
+// expirer is the internal implementation of an Expirer for a Key. It only
+// records the expiration time, the keys are deleted by the database.
 type expirer struct {
-	tmr   *time.Timer
 	expAt time.Time
 }
 
-// Expire sets the key to expire at time t, at which point function fn will
-// be executed in its own goroutine. It returns true if expiration was
-// successfully set, false otherwise.
-func (e *expirer) Expire(dur time.Duration, fn func()) bool {
-	if e.tmr != nil {
-		set := e.tmr.Reset(dur)
-		if set {
-			e.expAt = time.Now().Add(dur)
-		}
-		return set
-	}
+// Expire sets the key to expire at time t.
+func (e *expirer) Expire(t time.Time) {
+	e.expAt = t
+}
 
-	e.tmr = time.AfterFunc(dur, fn)
-	e.expAt = time.Now().Add(dur)
-	return true
+// Deadline returns the expiration time of the key, and true if it has one.
+func (e *expirer) Deadline() (time.Time, bool) {
+	return e.expAt, !e.expAt.IsZero()
 }
 
-// Abort aborts execution of an expiration timer. It returns true if a timer
-// was successfully aborted, false otherwise.
+// Abort removes the expiration of the key. It returns true if the key had
+// an expiration, false otherwise.
 func (e *expirer) Abort() bool {
-	if e.tmr != nil {
-		ok := e.tmr.Stop()
-		if ok {
-			e.tmr = nil
-			e.expAt = time.Time{}
-		}
-		return ok
-	}
-	return false
+	ok := !e.expAt.IsZero()
+	e.expAt = time.Time{}
+	return ok
 }
 
 // TTL returns the time-to-live of the key before an expiration is triggered.
 // It returns -1 if there is no expiration associated with the key.
 func (e *expirer) TTL() time.Duration {
-	if e.tmr == nil {
+	if e.expAt.IsZero() {
 		return -1
 	}
 	dur := e.expAt.Sub(time.Now())
@@ -58,3 +50,206 @@ func (e *expirer) TTL() time.Duration {
 	}
 	return 0
 }
+
+// expired returns true if the key has an expiration time that is not after
+// now. The key must not be locked by the caller.
+func expired(k Key, now time.Time) bool {
+	k.RLock()
+	defer k.RUnlock()
+	t, ok := k.Deadline()
+	return ok && !t.After(now)
+}
+
+// ExpireStats holds the statistics of the expired keys.
+type ExpireStats struct {
+	// ExpiredKeys is the number of keys deleted because they expired.
+	ExpiredKeys int64
+
+	// LazyExpiredKeys is the number of expired keys that were deleted
+	// when accessed, before the expiration scheduler got to them. They
+	// are included in ExpiredKeys.
+	LazyExpiredKeys int64
+}
+
+// expiredKeys and lazyExpiredKeys are the counters of the ExpireStats.
+// They are accessed atomically.
+var expiredKeys, lazyExpiredKeys int64
+
+// expireBatch is the maximum number of keys deleted by the expiration
+// scheduler in a database before it releases the locks.
+const expireBatch = 1000
+
+// expEntry is an entry of the expiration index of a database, with the
+// expiration time in Unix nanoseconds.
+type expEntry struct {
+	at int64
+	k  Key
+}
+
+// expHeap is the expiration index of a database, a min-heap of the
+// expiration times. Entries are not removed when the expiration of a key
+// changes or when the key is deleted, they are checked against the key
+// when they are due instead.
+type expHeap []expEntry
+
+func (h expHeap) Len() int            { return len(h) }
+func (h expHeap) Less(i, j int) bool  { return h[i].at < h[j].at }
+func (h expHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expHeap) Push(x interface{}) { *h = append(*h, x.(expEntry)) }
+func (h *expHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	e := old[n]
+	old[n] = expEntry{}
+	*h = old[:n]
+	return e
+}
+
+// valid returns true if the entry is the current expiration of its key,
+// in the keys of a database.
+func (e expEntry) valid(keys Keyspace) bool {
+	if k, ok := keys.Get(e.k.Name()); !ok || k != e.k {
+		return false
+	}
+	e.k.RLock()
+	defer e.k.RUnlock()
+	t, ok := e.k.Deadline()
+	return ok && t.UnixNano() == e.at
+}
+
+// expireLoop is the expiration scheduler of the server. It deletes the
+// keys of all databases as they expire, sleeping until the next
+// expiration time, or until wake receives a value because an earlier
+// expiration was set.
+func (s *server) expireLoop(wake <-chan struct{}) {
+	tmr := time.NewTimer(0)
+	<-tmr.C
+	for {
+		next := s.expireCycle(time.Now())
+		if next.IsZero() {
+			<-wake
+			continue
+		}
+		dur := next.Sub(time.Now())
+		if dur <= 0 {
+			continue
+		}
+		tmr.Reset(dur)
+		select {
+		case <-tmr.C:
+		case <-wake:
+			if !tmr.Stop() {
+				<-tmr.C
+			}
+		}
+	}
+}
+
+// expireCycle deletes the keys that are expired at now, at most
+// expireBatch keys per database, and returns the next expiration time,
+// or the zero time if no key expires.
+//
+// Like a command, it holds the shared server lock, or the exclusive one
+// while the append-only file is enabled, so that the deletions are
+// appended in order.
+func (s *server) expireCycle(now time.Time) time.Time {
+	if s.AOFEnabled() {
+		s.Lock()
+		defer s.Unlock()
+	} else {
+		s.RLock()
+		defer s.RUnlock()
+	}
+
+	var next time.Time
+	for _, db := range s.databases() {
+		if db == nil {
+			continue
+		}
+		db.Lock()
+		t := db.ExpireKeys(now, expireBatch)
+		db.Unlock()
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// ExpireKeys deletes at most max keys that are expired at now, and returns
+// the next expiration time, or the zero time if no key expires. The
+// database must be exclusively locked.
+func (d *db) ExpireKeys(now time.Time, max int) time.Time {
+	d.expMu.Lock()
+	defer d.expMu.Unlock()
+
+	for n, ns := 0, now.UnixNano(); len(d.exps) > 0 && d.exps[0].at <= ns; {
+		if n == max {
+			return now
+		}
+		e := heap.Pop(&d.exps).(expEntry)
+		if e.valid(d.keys) {
+			d.expire(e.k.Name(), false)
+			n++
+		}
+	}
+
+	// Drop the entries of keys that were deleted or got a new expiration,
+	// when they make up most of the index.
+	if len(d.exps) > 2*d.keys.Len()+64 {
+		exps := d.exps[:0]
+		for _, e := range d.exps {
+			if e.valid(d.keys) {
+				exps = append(exps, e)
+			}
+		}
+		for i := len(exps); i < len(d.exps); i++ {
+			d.exps[i] = expEntry{}
+		}
+		d.exps = exps
+		heap.Init(&d.exps)
+	}
+
+	if len(d.exps) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, d.exps[0].at)
+}
+
+// scheduleExpire adds the expiration time of the key to the expiration
+// index, and wakes the expiration scheduler if it is the next one.
+func (d *db) scheduleExpire(k Key, at time.Time) {
+	ns := at.UnixNano()
+	d.expMu.Lock()
+	first := len(d.exps) == 0 || ns < d.exps[0].at
+	heap.Push(&d.exps, expEntry{at: ns, k: k})
+	d.expMu.Unlock()
+
+	if first && d.wake != nil {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// expire deletes the expired key, notifies the expired event and appends
+// the deletion to the append-only file. lazy is true if the key is deleted
+// because it was accessed. The database must be exclusively locked.
+func (d *db) expire(name string, lazy bool) {
+	d.DelKey(name)
+	d.Notify(NotifyExpired, "expired", name)
+	DefaultServer.Propagate(d.ix, "del", name)
+	atomic.AddInt64(&expiredKeys, 1)
+	if lazy {
+		atomic.AddInt64(&lazyExpiredKeys, 1)
+	}
+}
+
+// ExpireStats returns the statistics of the expired keys.
+func (s *server) ExpireStats() ExpireStats {
+	return ExpireStats{
+		ExpiredKeys:     atomic.LoadInt64(&expiredKeys),
+		LazyExpiredKeys: atomic.LoadInt64(&lazyExpiredKeys),
+	}
+}
@@ -0,0 +1,176 @@
+package srv
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func TestLazyExpire(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	for _, nm := range []string{"a", "b", "c", "d"} {
+		d.Keys().Set(NewKey(nm, types.NewIncString("1")))
+	}
+	past := time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond)
+	for _, nm := range []string{"a", "b", "c"} {
+		d.PExpireAt(nm, past)
+	}
+	d.Expire("d", 100)
+	before := DefaultServer.ExpireStats()
+
+	// Expired keys are not visible
+	if d.Exists("a") {
+		t.Errorf("expected expired key to not exist")
+	}
+	if ttl := d.TTL("a"); ttl != -2 {
+		t.Errorf("expected TTL -2, got %d", ttl)
+	}
+	if typ := d.Type("a"); typ != "none" {
+		t.Errorf("expected type none, got %s", typ)
+	}
+	if d.Expire("a", 10) || d.Persist("a") {
+		t.Errorf("expected expired key to not be updated")
+	}
+	if ttl := d.TTL("d"); ttl != 99 {
+		t.Errorf("expected TTL 99, got %d", ttl)
+	}
+
+	// Expired keys are deleted when accessed with the exclusive lock
+	if n := d.Del("a", "d"); n != 1 {
+		t.Errorf("expected 1 deleted key, got %d", n)
+	}
+	if _, ok := d.Keys().Get("a"); ok {
+		t.Errorf("expected expired key to be deleted")
+	}
+	d.Unlock()
+
+	// Or when the key is created
+	k, unl := d.XLockGetKey("b", NoKeyCreateStringInt)
+	if v := k.Val().(types.String).Get(); v != "0" {
+		t.Errorf("expected new key with value 0, got %s", v)
+	}
+	unl()
+	k, unl = d.LockGetKey("c", NoKeyCreateStringInt)
+	if v := k.Val().(types.String).Get(); v != "0" {
+		t.Errorf("expected new key with value 0, got %s", v)
+	}
+	unl()
+
+	after := DefaultServer.ExpireStats()
+	if n := after.LazyExpiredKeys - before.LazyExpiredKeys; n != 3 {
+		t.Errorf("expected 3 lazily expired keys, got %d", n)
+	}
+	if n := after.ExpiredKeys - before.ExpiredKeys; n != 3 {
+		t.Errorf("expected 3 expired keys, got %d", n)
+	}
+}
+
+func TestExpireKeys(t *testing.T) {
+	d := newDB(0, nil)
+	d.Lock()
+	defer d.Unlock()
+	for i := 0; i < 10; i++ {
+		nm := strconv.Itoa(i)
+		d.Keys().Set(NewKey(nm, types.NewString("v")))
+		d.PExpire(nm, 1)
+	}
+	// Stale entries of keys that were persisted, deleted or got a new
+	// expiration are ignored
+	d.Persist("0")
+	d.Del("1")
+	d.Expire("2", 100)
+	d.Keys().Set(NewKey("3", types.NewString("v")))
+
+	now := time.Now().Add(time.Second)
+	if next := d.ExpireKeys(now, 4); !next.Equal(now) {
+		t.Errorf("expected more keys to expire at %s, got %s", now, next)
+	}
+	if n := d.Keys().Len(); n != 5 {
+		t.Errorf("expected 5 keys, got %d", n)
+	}
+	next := d.ExpireKeys(now, 4)
+	if ttl := next.Sub(time.Now()); ttl < 99*time.Second || ttl > 100*time.Second {
+		t.Errorf("expected next expiration in 100s, got %s", ttl)
+	}
+	for _, nm := range []string{"0", "2", "3"} {
+		if _, ok := d.Keys().Get(nm); !ok {
+			t.Errorf("expected key %s to exist", nm)
+		}
+	}
+	if n := d.Keys().Len(); n != 3 {
+		t.Errorf("expected 3 keys, got %d", n)
+	}
+
+	d.FlushDB()
+	if next := d.ExpireKeys(now, 4); !next.IsZero() {
+		t.Errorf("expected no expiration, got %s", next)
+	}
+}
+
+func TestExpireScheduler(t *testing.T) {
+	db, _ := DefaultServer.GetDB(DefaultDatabases - 1)
+	db.Lock()
+	db.Keys().Set(NewKey("late", types.NewString("v")))
+	db.Expire("late", 3600)
+	db.Keys().Set(NewKey("early", types.NewString("v")))
+	db.PExpire("early", 10)
+	db.Unlock()
+	defer func() {
+		db.Lock()
+		db.FlushDB()
+		db.Unlock()
+	}()
+
+	// The scheduler is woken up for the earlier expiration
+	deadline := time.Now().Add(time.Second)
+	for {
+		db.RLock()
+		_, ok := db.Keys().Get("early")
+		db.RUnlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected key to be deleted by the scheduler")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	db.RLock()
+	defer db.RUnlock()
+	if !db.Exists("late") {
+		t.Errorf("expected key to exist")
+	}
+}
+
+func BenchmarkExpireMillionKeys(b *testing.B) {
+	const n = 1000000
+	db, _ := DefaultServer.GetDB(DefaultDatabases - 1)
+	names := make([]string, n)
+	for i := range names {
+		names[i] = "key_" + strconv.Itoa(i)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// Expirations spread over 100ms
+		db.Lock()
+		for j, nm := range names {
+			db.Keys().Set(NewKey(nm, types.NewString("v")))
+			db.PExpire(nm, int64(j%100)+1)
+		}
+		db.Unlock()
+
+		for {
+			db.RLock()
+			cnt := db.Keys().Len()
+			db.RUnlock()
+			if cnt == 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package srv
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/PuerkitoBio/gred/types"
 )
@@ -27,6 +28,24 @@ type Key interface {
 
 	// Name returns the name of the key
 	Name() string
+
+	// Version returns the version of the key, which changes each time
+	// the key is modified.
+	Version() uint64
+
+	// Modify must be called before the value is modified. Lock calls it,
+	// so it is only needed when the value is modified without locking
+	// the key, under an exclusive lock of the DB.
+	Modify()
+}
+
+// lastVersion is the last version assigned to a key. Versions are unique
+// across all keys and databases.
+var lastVersion uint64
+
+// nextVersion returns a new, unique version.
+func nextVersion() uint64 {
+	return atomic.AddUint64(&lastVersion, 1)
 }
 
 // key implements the Key interface.
@@ -36,6 +55,16 @@ type key struct {
 
 	v    types.Value
 	name string
+
+	// ver is the version of the key, accessed atomically.
+	ver uint64
+
+	// cow holds the entries of the snapshots in progress that still need
+	// a copy of the value, which is made before it is modified. It is
+	// protected by cowMu, which every writer takes before it modifies
+	// the value, even when the key itself is not locked.
+	cowMu sync.Mutex
+	cow   []*snapEntry
 }
 
 // NewKey creates a new Key with the specified name and value.
@@ -44,9 +73,37 @@ func NewKey(name string, v types.Value) Key {
 		expirer: &expirer{},
 		v:       v,
 		name:    name,
+		ver:     nextVersion(),
 	}
 }
 
+// Lock locks the key for writing. The value is copied to the snapshots
+// in progress that did not copy it yet, before the caller modifies it.
+func (k *key) Lock() {
+	k.RWMutex.Lock()
+	k.Modify()
+}
+
+// Modify copies the value to the snapshots in progress that did not copy
+// it yet. It waits for a snapshot that is copying the value.
+func (k *key) Modify() {
+	k.cowMu.Lock()
+	defer k.cowMu.Unlock()
+	if len(k.cow) > 0 {
+		v := cloneValue(k.v)
+		for _, e := range k.cow {
+			e.Value = v
+		}
+		k.cow = nil
+	}
+}
+
+// Version returns the version of the key.
+func (k *key) Version() uint64 { return atomic.LoadUint64(&k.ver) }
+
+// touch assigns a new version to the key, once it is modified.
+func (k *key) touch() { atomic.StoreUint64(&k.ver, nextVersion()) }
+
 // Name returns the name of the key.
 func (k *key) Name() string { return k.name }
 
@@ -0,0 +1,77 @@
+package srv
+
+import "github.com/PuerkitoBio/gred/dict"
+
+// Keyspace defines the methods required to hold the keys of a database.
+// Modifications require the database to be exclusively locked, lookups and
+// iterations require a shared lock.
+type Keyspace interface {
+	// Get returns the key with the specified name, if it exists.
+	Get(string) (Key, bool)
+
+	// Set adds the key, replacing any key with the same name.
+	Set(Key)
+
+	// Del deletes the key with the specified name, and returns true if it
+	// existed.
+	Del(string) bool
+
+	// Len returns the number of keys.
+	Len() int
+
+	// Range calls fn for each key, until fn returns false.
+	Range(fn func(Key) bool)
+
+	// Scan calls fn for some of the keys, starting at cursor, and returns
+	// the cursor to use for the next call, which is 0 once all keys have
+	// been visited. A full iteration starts and ends with a cursor of 0,
+	// and returns every key that is present for the whole iteration, even
+	// if keys are added and deleted between calls. Keys may be returned
+	// more than once.
+	Scan(cursor uint64, fn func(Key)) uint64
+}
+
+// Static check to make sure *keyspace implements the Keyspace interface.
+var _ Keyspace = (*keyspace)(nil)
+
+// keyspace holds the keys by name in a dict, which allows Scan to use the
+// Redis reverse binary cursor.
+type keyspace struct {
+	d *dict.Dict
+}
+
+// NewKeyspace creates a new, empty Keyspace.
+func NewKeyspace() Keyspace {
+	return &keyspace{dict.New()}
+}
+
+func (ks *keyspace) Get(name string) (Key, bool) {
+	if k, ok := ks.d.Get(name); ok {
+		return k.(Key), true
+	}
+	return nil, false
+}
+
+func (ks *keyspace) Set(k Key) {
+	ks.d.Set(k.Name(), k)
+}
+
+func (ks *keyspace) Del(name string) bool {
+	return ks.d.Del(name)
+}
+
+func (ks *keyspace) Len() int {
+	return ks.d.Len()
+}
+
+func (ks *keyspace) Range(fn func(Key) bool) {
+	ks.d.Each(func(_ string, k interface{}) bool {
+		return fn(k.(Key))
+	})
+}
+
+func (ks *keyspace) Scan(cursor uint64, fn func(Key)) uint64 {
+	return ks.d.Scan(cursor, func(_ string, k interface{}) {
+		fn(k.(Key))
+	})
+}
@@ -0,0 +1,109 @@
+package srv
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func TestKeyspace(t *testing.T) {
+	ks := NewKeyspace()
+	const n = 1000
+	for i := 0; i < n; i++ {
+		nm := strconv.Itoa(i)
+		ks.Set(NewKey(nm, types.NewString(nm)))
+	}
+	if l := ks.Len(); l != n {
+		t.Fatalf("expected %d keys, got %d", n, l)
+	}
+
+	// Replacing a key does not add one
+	k := NewKey("1", types.NewString("x"))
+	ks.Set(k)
+	if got, ok := ks.Get("1"); !ok || got != k {
+		t.Errorf("expected key to be replaced")
+	}
+	if l := ks.Len(); l != n {
+		t.Errorf("expected %d keys, got %d", n, l)
+	}
+
+	cnt := 0
+	ks.Range(func(k Key) bool {
+		cnt++
+		return true
+	})
+	if cnt != n {
+		t.Errorf("expected %d keys in range, got %d", n, cnt)
+	}
+
+	// Delete most keys, so that the table shrinks
+	for i := 0; i < n-10; i++ {
+		if !ks.Del(strconv.Itoa(i)) {
+			t.Fatalf("%d: expected key to be deleted", i)
+		}
+	}
+	if ks.Del("0") {
+		t.Errorf("expected deleted key to not exist")
+	}
+	for i := 0; i < n; i++ {
+		_, ok := ks.Get(strconv.Itoa(i))
+		if exp := i >= n-10; ok != exp {
+			t.Errorf("%d: expected key to exist: %v", i, exp)
+		}
+	}
+	if l := ks.Len(); l != 10 {
+		t.Errorf("expected 10 keys, got %d", l)
+	}
+}
+
+func TestKeyspaceScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for run := 0; run < 10; run++ {
+		ks := NewKeyspace()
+
+		// Keys that are present for the whole iteration, and keys that are
+		// added and deleted while iterating, so that the table grows and
+		// shrinks.
+		const n = 500
+		for i := 0; i < n; i++ {
+			nm := "stable" + strconv.Itoa(i)
+			ks.Set(NewKey(nm, types.NewString("")))
+		}
+		var temp []string
+		seen := make(map[string]bool)
+		var cursor uint64
+		for i := 0; ; i++ {
+			cursor = ks.Scan(cursor, func(k Key) {
+				seen[k.Name()] = true
+			})
+			if cursor == 0 {
+				break
+			}
+			if i > 100000 {
+				t.Fatal("scan does not terminate")
+			}
+
+			switch op := rnd.Intn(3); {
+			case len(temp) < 5000 && (op == 0 || op == 1 && run%2 == 0):
+				for j := 0; j < 50; j++ {
+					nm := "temp" + strconv.Itoa(rnd.Int())
+					ks.Set(NewKey(nm, types.NewString("")))
+					temp = append(temp, nm)
+				}
+			default:
+				for j := 0; j < 50 && len(temp) > 0; j++ {
+					ks.Del(temp[len(temp)-1])
+					temp = temp[:len(temp)-1]
+				}
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			if nm := "stable" + strconv.Itoa(i); !seen[nm] {
+				t.Fatalf("%d: key %s not returned by scan", run, nm)
+			}
+		}
+	}
+}
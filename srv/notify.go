@@ -0,0 +1,145 @@
+package srv
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// NotifyFlag is a set of classes of keyspace events, as configured
+// by the notify-keyspace-events string.
+type NotifyFlag int32
+
+const (
+	// NotifyKeyspace enables the __keyspace@<db>__ notifications (K).
+	NotifyKeyspace NotifyFlag = 1 << iota
+
+	// NotifyKeyevent enables the __keyevent@<db>__ notifications (E).
+	NotifyKeyevent
+
+	// NotifyGeneric is the class of generic commands such as DEL and EXPIRE (g).
+	NotifyGeneric
+
+	// NotifyString is the class of string commands ($).
+	NotifyString
+
+	// NotifyList is the class of list commands (l).
+	NotifyList
+
+	// NotifySet is the class of set commands (s).
+	NotifySet
+
+	// NotifyHash is the class of hash commands (h).
+	NotifyHash
+
+	// NotifySortedSet is the class of sorted set commands (z).
+	NotifySortedSet
+
+	// NotifyStream is the class of stream commands (t).
+	NotifyStream
+
+	// NotifyExpired is the class of key expiration events (x).
+	NotifyExpired
+
+	// NotifyEvicted is the class of key eviction events (e).
+	NotifyEvicted
+
+	// NotifyAll is the alias for all classes of events (A).
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet |
+		NotifyHash | NotifySortedSet | NotifyStream | NotifyExpired | NotifyEvicted
+)
+
+// notifyChars maps the characters of the notify-keyspace-events string
+// to the corresponding flags, in the order used to format the flags.
+var notifyChars = []struct {
+	c byte
+	f NotifyFlag
+}{
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyList},
+	{'s', NotifySet},
+	{'h', NotifyHash},
+	{'z', NotifySortedSet},
+	{'t', NotifyStream},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+}
+
+// ParseNotifyFlags parses a notify-keyspace-events string, e.g. "KEA" or
+// "Egx".
+func ParseNotifyFlags(s string) (NotifyFlag, error) {
+	var f NotifyFlag
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			f |= NotifyAll
+			continue
+		}
+		found := false
+		for _, nc := range notifyChars {
+			if nc.c == s[i] {
+				f |= nc.f
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid notify-keyspace-events class %q", s[i])
+		}
+	}
+	return f, nil
+}
+
+// String returns the notify-keyspace-events string for the flags.
+func (f NotifyFlag) String() string {
+	var buf []byte
+	all := f&NotifyAll == NotifyAll
+	if all {
+		buf = append(buf, 'A')
+	}
+	for _, nc := range notifyChars {
+		if all && nc.f&NotifyAll != 0 {
+			continue
+		}
+		if f&nc.f != 0 {
+			buf = append(buf, nc.c)
+		}
+	}
+	return string(buf)
+}
+
+// notifyFlags holds the enabled classes of keyspace events. Notifications
+// are disabled by default.
+var notifyFlags int32
+
+// SetNotifyFlags sets the classes of keyspace events that are notified.
+func SetNotifyFlags(f NotifyFlag) {
+	atomic.StoreInt32(&notifyFlags, int32(f))
+}
+
+// NotifyFlags returns the classes of keyspace events that are notified.
+func NotifyFlags() NotifyFlag {
+	return NotifyFlag(atomic.LoadInt32(&notifyFlags))
+}
+
+// Notify publishes the keyspace event of the specified class for the key
+// to the __keyspace@<db>__:<key> and __keyevent@<db>__:<event> channels,
+// as enabled by the notify flags. The commands notify each modification
+// of a key, so the key is also assigned a new version, which aborts the
+// transactions that watch it.
+func (d *db) Notify(class NotifyFlag, event, key string) {
+	d.touch(key)
+
+	f := NotifyFlags()
+	if f&class == 0 {
+		return
+	}
+	if f&NotifyKeyspace != 0 {
+		DefaultPubSub.Publish("__keyspace@"+strconv.Itoa(d.ix)+"__:"+key, event)
+	}
+	if f&NotifyKeyevent != 0 {
+		DefaultPubSub.Publish("__keyevent@"+strconv.Itoa(d.ix)+"__:"+event, key)
+	}
+}
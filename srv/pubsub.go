@@ -0,0 +1,169 @@
+package srv
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/PuerkitoBio/gred/glob"
+)
+
+// Subscriber defines the methods required to receive published messages.
+type Subscriber interface {
+	// Notify delivers a message to the subscriber. It is called while
+	// the PubSub is locked, so it must not block.
+	Notify([]string)
+}
+
+// PubSub defines the methods required to implement the Publish/Subscribe
+// messaging.
+type PubSub interface {
+	Subscribe(Subscriber, string) bool
+	Unsubscribe(Subscriber, string) bool
+	PSubscribe(Subscriber, string) bool
+	PUnsubscribe(Subscriber, string) bool
+	Publish(string, string) int64
+
+	Channels(string) []string
+	NumSub(string) int64
+	NumPat() int64
+}
+
+// Static check to make sure *pubSub implements the PubSub interface.
+var _ PubSub = (*pubSub)(nil)
+
+// The one and only publish/subscribe registry.
+var DefaultPubSub PubSub = NewPubSub()
+
+// subscribers is a set of subscribers.
+type subscribers map[Subscriber]struct{}
+
+// pubSub is the internal implementation of a PubSub.
+type pubSub struct {
+	sync.RWMutex
+
+	channels map[string]subscribers
+	patterns map[string]subscribers
+}
+
+// NewPubSub creates a new PubSub value.
+func NewPubSub() PubSub {
+	return &pubSub{
+		channels: make(map[string]subscribers),
+		patterns: make(map[string]subscribers),
+	}
+}
+
+// Subscribe subscribes s to the channel. It returns true if s was not
+// already subscribed to this channel.
+func (p *pubSub) Subscribe(s Subscriber, channel string) bool {
+	p.Lock()
+	defer p.Unlock()
+	return subscribe(p.channels, s, channel)
+}
+
+// Unsubscribe unsubscribes s from the channel. It returns true if s was
+// subscribed to this channel.
+func (p *pubSub) Unsubscribe(s Subscriber, channel string) bool {
+	p.Lock()
+	defer p.Unlock()
+	return unsubscribe(p.channels, s, channel)
+}
+
+// PSubscribe subscribes s to the channels matching the pattern. It returns
+// true if s was not already subscribed to this pattern.
+func (p *pubSub) PSubscribe(s Subscriber, pattern string) bool {
+	p.Lock()
+	defer p.Unlock()
+	return subscribe(p.patterns, s, pattern)
+}
+
+// PUnsubscribe unsubscribes s from the pattern. It returns true if s was
+// subscribed to this pattern.
+func (p *pubSub) PUnsubscribe(s Subscriber, pattern string) bool {
+	p.Lock()
+	defer p.Unlock()
+	return unsubscribe(p.patterns, s, pattern)
+}
+
+func subscribe(m map[string]subscribers, s Subscriber, name string) bool {
+	subs := m[name]
+	if subs == nil {
+		subs = make(subscribers)
+		m[name] = subs
+	}
+	if _, ok := subs[s]; ok {
+		return false
+	}
+	subs[s] = struct{}{}
+	return true
+}
+
+func unsubscribe(m map[string]subscribers, s Subscriber, name string) bool {
+	subs := m[name]
+	if _, ok := subs[s]; !ok {
+		return false
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(m, name)
+	}
+	return true
+}
+
+// Publish sends the message to the subscribers of the channel, and to the
+// subscribers of the patterns matching the channel. It returns the number
+// of subscribers that received the message.
+func (p *pubSub) Publish(channel, msg string) int64 {
+	p.RLock()
+	defer p.RUnlock()
+
+	var cnt int64
+	if subs := p.channels[channel]; len(subs) > 0 {
+		m := []string{"message", channel, msg}
+		for s := range subs {
+			s.Notify(m)
+			cnt++
+		}
+	}
+	for pat, subs := range p.patterns {
+		if glob.Match(pat, channel) {
+			m := []string{"pmessage", pat, channel, msg}
+			for s := range subs {
+				s.Notify(m)
+				cnt++
+			}
+		}
+	}
+	return cnt
+}
+
+// Channels returns the sorted list of channels with at least one subscriber
+// that match the pattern. If the pattern is empty, all channels are returned.
+func (p *pubSub) Channels(pattern string) []string {
+	p.RLock()
+	defer p.RUnlock()
+
+	ret := []string{}
+	for ch := range p.channels {
+		if pattern == "" || glob.Match(pattern, ch) {
+			ret = append(ret, ch)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// NumSub returns the number of subscribers of the channel, not counting
+// the pattern subscribers.
+func (p *pubSub) NumSub(channel string) int64 {
+	p.RLock()
+	defer p.RUnlock()
+	return int64(len(p.channels[channel]))
+}
+
+// NumPat returns the number of unique patterns subscribed to.
+func (p *pubSub) NumPat() int64 {
+	p.RLock()
+	defer p.RUnlock()
+	return int64(len(p.patterns))
+}
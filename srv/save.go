@@ -0,0 +1,356 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/gred/rdb"
+	"github.com/PuerkitoBio/gred/types"
+	"github.com/golang/glog"
+)
+
+// ErrSaveInProgress is returned when a save is requested while a background
+// save is in progress.
+var ErrSaveInProgress = errors.New("srv: background save already in progress")
+
+// DefaultRDBPath is the default path of the RDB file.
+const DefaultRDBPath = "dump.rdb"
+
+// rdbPath holds the path of the RDB file.
+var rdbPath atomic.Value
+
+// SetRDBPath sets the path of the RDB file where the databases are saved,
+// and loaded from.
+func SetRDBPath(path string) {
+	rdbPath.Store(path)
+}
+
+// RDBPath returns the path of the RDB file.
+func RDBPath() string {
+	if p, ok := rdbPath.Load().(string); ok {
+		return p
+	}
+	return DefaultRDBPath
+}
+
+// Save saves the databases to the RDB file.
+func (s *server) Save() error {
+	if !atomic.CompareAndSwapInt32(&s.saving, 0, 1) {
+		return ErrSaveInProgress
+	}
+	defer atomic.StoreInt32(&s.saving, 0)
+
+	changes := s.Changes()
+	return s.save(s.snapshot(), changes)
+}
+
+// BGSave saves the databases to the RDB file in the background. The
+// databases are locked only while a point-in-time snapshot of the keys is
+// taken, the snapshot is then written to disk in its own goroutine.
+func (s *server) BGSave() error {
+	if !atomic.CompareAndSwapInt32(&s.saving, 0, 1) {
+		return ErrSaveInProgress
+	}
+
+	changes := s.Changes()
+	snap := s.snapshot()
+	n := len(snap)
+	go func() {
+		defer atomic.StoreInt32(&s.saving, 0)
+		if err := s.save(snap, changes); err != nil {
+			glog.Errorf("background save: %s", err)
+			return
+		}
+		glog.V(1).Infof("background save: %d keys saved to %s", n, RDBPath())
+	}()
+	return nil
+}
+
+// LastSave returns the Unix time of the last successful save, or of the
+// start of the server if the databases were never saved.
+func (s *server) LastSave() int64 {
+	return atomic.LoadInt64(&s.lastSave)
+}
+
+// AddChanges adds n to the number of changes made to the databases since
+// the last save.
+func (s *server) AddChanges(n int64) {
+	atomic.AddInt64(&s.changes, n)
+}
+
+// Changes returns the number of changes made to the databases since the
+// last save.
+func (s *server) Changes() int64 {
+	return atomic.LoadInt64(&s.changes)
+}
+
+// SavePoint is a save point of the configuration: the databases are saved
+// in the background once Changes changes were made and Secs seconds have
+// elapsed since the last save.
+type SavePoint struct {
+	Secs    int64
+	Changes int64
+}
+
+// saveRetryDelay is the delay before a save triggered by the save points
+// is retried, if it failed.
+const saveRetryDelay = 5 * time.Second
+
+// autoSave saves the databases in the background if one of the save points
+// is reached at now.
+func (s *server) autoSave(now time.Time, points []SavePoint) {
+	if atomic.LoadInt32(&s.saveFailed) == 1 && now.Sub(s.saveTry) < saveRetryDelay {
+		return
+	}
+	changes, elapsed := s.Changes(), now.Unix()-s.LastSave()
+	for _, p := range points {
+		if changes < p.Changes || elapsed < p.Secs {
+			continue
+		}
+		s.saveTry = now
+		if err := s.BGSave(); err == nil {
+			glog.V(1).Infof("%d changes in %d seconds, saving", p.Changes, p.Secs)
+		}
+		return
+	}
+}
+
+// Load loads the keys saved in the RDB file in the databases. It is not
+// an error if the file does not exist. Keys that are already expired are
+// not loaded.
+func (s *server) Load() error {
+	f, err := os.Open(RDBPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	now := unixMs(time.Now())
+	return rdb.Decode(f, func(ent *rdb.Entry) error {
+		db, ok := s.GetDB(ent.DB)
+		if !ok {
+			return fmt.Errorf("srv: invalid database index %d", ent.DB)
+		}
+		if ent.ExpireAt > 0 && ent.ExpireAt <= now {
+			return nil
+		}
+
+		db.Lock()
+		defer db.Unlock()
+		db.Del(ent.Key)
+		db.Keys().Set(NewKey(ent.Key, ent.Value))
+		if ent.ExpireAt > 0 {
+			db.PExpireAt(ent.Key, ent.ExpireAt)
+		}
+		return nil
+	})
+}
+
+// snapshot is a point-in-time copy of the keys of all databases.
+type snapshot []*snapEntry
+
+// snapEntry is an entry of a snapshot. The value is copied from the key
+// when the key is first locked for writing, or when the entry is visited.
+type snapEntry struct {
+	rdb.Entry
+	k *key
+}
+
+// snapshot returns a copy of the keys of all databases, except the expired
+// keys. All databases are locked while the keys are registered in the
+// snapshot, so that it is consistent, which takes time proportional to the
+// number of keys. The values are copied on write: a value is copied when
+// its key is first modified after the snapshot, or when the snapshot visits
+// it, so that the copy does not block the clients.
+func (s *server) snapshot() snapshot {
+	dbs := s.databases()
+	for _, db := range dbs {
+		if db != nil {
+			db.Lock()
+			defer db.Unlock()
+		}
+	}
+
+	now := time.Now()
+	var snap snapshot
+	for ix, db := range dbs {
+		if db == nil {
+			continue
+		}
+		db.Keys().Range(func(k Key) bool {
+			if expired(k, now) {
+				return true
+			}
+			e := &snapEntry{Entry: rdb.Entry{DB: ix, Key: k.Name()}}
+			if ttl := k.TTL(); ttl >= 0 {
+				e.ExpireAt = unixMs(now.Add(ttl))
+			}
+			if kk, ok := k.(*key); ok {
+				e.k = kk
+				kk.cowMu.Lock()
+				kk.cow = append(kk.cow, e)
+				kk.cowMu.Unlock()
+			} else {
+				k.RLock()
+				e.Value = cloneValue(k.Val())
+				k.RUnlock()
+			}
+			snap = append(snap, e)
+			return true
+		})
+	}
+	return snap
+}
+
+// each calls fn with the entries of the snapshot, in order, until fn
+// returns an error, which is returned. The remaining entries are released.
+func (snap snapshot) each(fn func(*rdb.Entry) error) error {
+	for i, e := range snap {
+		err := fn(e.load())
+		// The copy is not needed anymore
+		snap[i] = nil
+		if err != nil {
+			snap.release()
+			return err
+		}
+	}
+	return nil
+}
+
+// release releases the entries of the snapshot that were not visited, so
+// that their values are not copied anymore.
+func (snap snapshot) release() {
+	for i, e := range snap {
+		if e != nil {
+			e.release()
+			snap[i] = nil
+		}
+	}
+}
+
+// load returns the entry, with a copy of the value of its key if it was
+// not modified since the snapshot.
+func (e *snapEntry) load() *rdb.Entry {
+	if e.k != nil {
+		e.k.cowMu.Lock()
+		if e.Value == nil {
+			e.Value = cloneValue(e.k.v)
+			e.k.removeCow(e)
+		}
+		e.k.cowMu.Unlock()
+	}
+	return &e.Entry
+}
+
+// release unregisters the entry from its key, if the value was not copied.
+func (e *snapEntry) release() {
+	if e.k != nil {
+		e.k.cowMu.Lock()
+		if e.Value == nil {
+			e.k.removeCow(e)
+		}
+		e.k.cowMu.Unlock()
+	}
+}
+
+// removeCow removes the snapshot entry e from the entries that need a copy
+// of the value. The cowMu lock of the key must be held.
+func (k *key) removeCow(e *snapEntry) {
+	for i, ce := range k.cow {
+		if ce == e {
+			k.cow = append(k.cow[:i], k.cow[i+1:]...)
+			break
+		}
+	}
+	if len(k.cow) == 0 {
+		k.cow = nil
+	}
+}
+
+// save writes the snapshot to the RDB file, and updates the time of the
+// last save and the number of changes since then, which were changes when
+// the snapshot was taken. The data is written to a temporary file that
+// replaces the RDB file once complete, so that the RDB file is never left
+// truncated.
+func (s *server) save(snap snapshot, changes int64) error {
+	path := RDBPath()
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	f, err := os.Create(tmp)
+	if err != nil {
+		snap.release()
+		return err
+	}
+
+	enc := rdb.NewEncoder(f)
+	err = snap.each(enc.Encode)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		atomic.StoreInt32(&s.saveFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt64(&s.lastSave, time.Now().Unix())
+	atomic.AddInt64(&s.changes, -changes)
+	atomic.StoreInt32(&s.saveFailed, 0)
+	return nil
+}
+
+// cloneValue returns a copy of the value v, of the same type.
+func cloneValue(v types.Value) types.Value {
+	switch v := v.(type) {
+	case types.IncString:
+		return types.NewIncString(v.Get())
+	case types.String:
+		return types.NewString(v.Get())
+	case types.IncHash:
+		h := types.NewIncHash()
+		h.HMSet(v.HGetAll()...)
+		return h
+	case types.Hash:
+		h := types.NewHash()
+		h.HMSet(v.HGetAll()...)
+		return h
+	case types.List:
+		l := types.NewList()
+		l.RPush(v.LRange(0, -1)...)
+		return l
+	case types.Set:
+		set := types.NewSet()
+		set.SAdd(v.SMembers()...)
+		return set
+	case types.Stream:
+		return v.Clone()
+	case types.SortedSet:
+		z := types.NewSortedSet()
+		for _, sm := range v.ZRange(0, -1) {
+			z.ZAdd(sm.Member, sm.Score)
+		}
+		return z
+	default:
+		panic(fmt.Sprintf("unsupported value type: %T", v))
+	}
+}
+
+// unixMs returns the Unix time t in milliseconds.
+func unixMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
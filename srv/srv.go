@@ -3,20 +3,59 @@
 package srv
 
 import (
+	"errors"
+	"sort"
 	"sync"
 	"time"
 )
 
 // Server defines the methods required to implement a Server.
+//
+// Its RWLocker is the server-wide lock: connections hold a shared lock
+// while a command executes, and an exclusive lock while a transaction
+// executes, so that it runs atomically with regards to other connections.
 type Server interface {
 	RWLocker
 
 	FlushAll()
 	GetDB(int) (DB, bool)
+	Databases() int
+	SetDatabases(int) error
+	LockDBs(...int) ([]DB, func(), bool)
+	SwapDB(int, int) bool
 	Time() (int64, int64)
+	ExpireStats() ExpireStats
+
+	// Statistics
+	Stats() Stats
+	ResetStats()
+	CountConn()
+	CountCmd(string, time.Duration)
+	CountLookup(bool)
+
+	// Persistence
+	Save() error
+	BGSave() error
+	LastSave() int64
+	Load() error
+	AddChanges(int64)
+	Changes() int64
+
+	// Append-only file
+	OpenAOF(FsyncPolicy) error
+	CloseAOF() error
+	AOFEnabled() bool
+	Propagate(int, ...string)
+	BGRewriteAOF() error
 }
 
-const maxDBs = 16 // TODO : Should be read from configuration
+// DefaultDatabases is the default number of databases.
+const DefaultDatabases = 16
+
+// ErrDecreaseDatabases is returned when the number of databases is
+// decreased while the removed databases are selected by clients, or hold
+// keys.
+var ErrDecreaseDatabases = errors.New("srv: can't decrease the number of databases while the removed databases are selected or hold keys")
 
 // Static check to make sure *server implements the Server interface.
 var _ Server = (*server)(nil)
@@ -27,35 +66,179 @@ var DefaultServer Server
 // server is the internal implementation of a Server.
 type server struct {
 	sync.RWMutex
-	dbs []DB
+
+	// the databases, the slice is protected by dbsMu
+	dbsMu sync.RWMutex
+	dbs   []DB
+
+	// saving is 1 while a save is in progress, lastSave is the Unix time
+	// of the last successful save, changes is the number of changes since
+	// then, and saveFailed is 1 if the last save failed. They are accessed
+	// atomically.
+	saving     int32
+	lastSave   int64
+	changes    int64
+	saveFailed int32
+
+	// saveTry is the time of the last save triggered by the save points,
+	// it is only accessed by the cron loop.
+	saveTry time.Time
+
+	// the append-only file
+	aof aofState
+
+	// wake wakes the expiration scheduler
+	wake chan struct{}
 }
 
 func init() {
-	// TODO : Read configuration
-	DefaultServer = &server{
-		dbs: make([]DB, maxDBs),
+	wake := make(chan struct{}, 1)
+	dbs := make([]DB, DefaultDatabases)
+	for i := range dbs {
+		dbs[i] = newDB(i, wake)
 	}
+	s := &server{
+		dbs:      dbs,
+		lastSave: time.Now().Unix(),
+		wake:     wake,
+	}
+	go s.expireLoop(wake)
+	go s.cronLoop()
+	DefaultServer = s
 }
 
-// FlushAll clears the keys from all databases.
+// FlushAll clears the keys from all databases. Each database is locked
+// in turn, so that it is safe to call while other connections execute
+// commands.
 func (s *server) FlushAll() {
-	s.dbs = make([]DB, maxDBs)
+	for _, db := range s.databases() {
+		if db != nil {
+			db.Lock()
+			db.FlushDB()
+			db.Unlock()
+		}
+	}
 }
 
 // GetDB returns the database identified by its index.
 func (s *server) GetDB(ix int) (DB, bool) {
-	if ix < 0 || ix >= maxDBs {
+	s.dbsMu.RLock()
+	if ix < 0 || ix >= len(s.dbs) {
+		s.dbsMu.RUnlock()
 		return nil, false
 	}
-
 	db := s.dbs[ix]
-	if db == nil {
-		db = NewDB(ix)
+	s.dbsMu.RUnlock()
+	if db != nil {
+		return db, true
+	}
+
+	s.dbsMu.Lock()
+	defer s.dbsMu.Unlock()
+	if db = s.dbs[ix]; db == nil {
+		db = newDB(ix, s.wake)
 		s.dbs[ix] = db
 	}
 	return db, true
 }
 
+// databases returns the databases, some of which may not be created yet.
+func (s *server) databases() []DB {
+	s.dbsMu.RLock()
+	defer s.dbsMu.RUnlock()
+	return s.dbs
+}
+
+// Databases returns the number of databases.
+func (s *server) Databases() int {
+	return len(s.databases())
+}
+
+// SetDatabases sets the number of databases. The number of databases can
+// only be decreased if no client selected the removed databases, and if
+// they hold no key. The caller must hold the exclusive server lock, so
+// that no command uses the removed databases meanwhile.
+func (s *server) SetDatabases(n int) error {
+	s.dbsMu.Lock()
+	defer s.dbsMu.Unlock()
+
+	if n < len(s.dbs) {
+		for _, c := range DefaultClients.List() {
+			if c.Info().DB >= n {
+				return ErrDecreaseDatabases
+			}
+		}
+		for _, db := range s.dbs[n:] {
+			if db == nil {
+				continue
+			}
+			db.RLock()
+			l := db.Keys().Len()
+			db.RUnlock()
+			if l > 0 {
+				return ErrDecreaseDatabases
+			}
+		}
+		// Limit the capacity, so that growing the slice again does not
+		// overwrite the databases seen by the callers of databases.
+		s.dbs = s.dbs[:n:n]
+		return nil
+	}
+	for ix := len(s.dbs); ix < n; ix++ {
+		s.dbs = append(s.dbs, newDB(ix, s.wake))
+	}
+	return nil
+}
+
+// LockDBs exclusively locks the databases identified by their indices, and
+// returns them in the same order, with the function that unlocks them. The
+// databases are locked in index order, so that concurrent calls do not
+// deadlock, and an index may be repeated. It returns false if an index is
+// invalid.
+func (s *server) LockDBs(ixs ...int) ([]DB, func(), bool) {
+	dbs := make([]DB, len(ixs))
+	for i, ix := range ixs {
+		db, ok := s.GetDB(ix)
+		if !ok {
+			return nil, nil, false
+		}
+		dbs[i] = db
+	}
+
+	order := append([]int(nil), ixs...)
+	sort.Ints(order)
+	all := s.databases()
+	var locked []DB
+	for i, ix := range order {
+		if i > 0 && ix == order[i-1] {
+			continue
+		}
+		all[ix].Lock()
+		locked = append(locked, all[ix])
+	}
+	return dbs, func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].Unlock()
+		}
+	}, true
+}
+
+// SwapDB swaps the keys of the databases identified by their indices.
+// Connections keep using the same database index, so they see the keys of
+// the other database. It returns false if an index is invalid.
+func (s *server) SwapDB(i, j int) bool {
+	dbs, unl, ok := s.LockDBs(i, j)
+	if !ok {
+		return false
+	}
+	defer unl()
+
+	if i != j {
+		dbs[0].(*db).swap(dbs[1].(*db))
+	}
+	return true
+}
+
 func (s *server) Time() (int64, int64) {
 	t := time.Now()
 	return t.Unix(), int64(time.Duration(t.Nanosecond()) / time.Microsecond)
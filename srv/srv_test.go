@@ -1,13 +1,25 @@
 package srv
 
 import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/PuerkitoBio/gred/rdb"
+	"github.com/PuerkitoBio/gred/resp"
 	"github.com/PuerkitoBio/gred/types"
 )
 
 func TestSrvGetDB(t *testing.T) {
-	s := &server{dbs: make([]DB, maxDBs)}
+	s := &server{dbs: make([]DB, DefaultDatabases)}
 	// Getting DB 0 should return a non-nil DB
 	d0, _ := s.GetDB(0)
 	if d0 == nil {
@@ -15,7 +27,7 @@ func TestSrvGetDB(t *testing.T) {
 	}
 
 	// Set a key on d0
-	d0.Keys()["a"] = NewKey("a", types.NewString("1"))
+	d0.Keys().Set(NewKey("a", types.NewString("1")))
 
 	// Get DB 1
 	d1, _ := s.GetDB(1)
@@ -26,8 +38,510 @@ func TestSrvGetDB(t *testing.T) {
 	}
 
 	// d1 should not have key "a"
-	_, ok := d1.Keys()["a"]
+	_, ok := d1.Keys().Get("a")
 	if ok {
 		t.Fatalf("DB 1 has key 'a'")
 	}
 }
+
+func TestSrvLockDBs(t *testing.T) {
+	s := &server{dbs: make([]DB, DefaultDatabases)}
+	if _, _, ok := s.LockDBs(0, DefaultDatabases); ok {
+		t.Fatalf("expected invalid index to fail")
+	}
+
+	// Concurrent calls in any order do not deadlock
+	s.GetDB(0)
+	s.GetDB(1)
+	done := make(chan bool)
+	for _, ixs := range [][]int{{0, 1}, {1, 0}, {1, 1}} {
+		go func(ixs []int) {
+			for i := 0; i < 1000; i++ {
+				dbs, unl, _ := s.LockDBs(ixs...)
+				if dbs[0].Index() != ixs[0] || dbs[1].Index() != ixs[1] {
+					t.Errorf("expected databases %v, got %d and %d", ixs, dbs[0].Index(), dbs[1].Index())
+				}
+				unl()
+			}
+			done <- true
+		}(ixs)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlock")
+		}
+	}
+}
+
+func TestSrvSwapDB(t *testing.T) {
+	s := &server{dbs: make([]DB, DefaultDatabases)}
+	d0, _ := s.GetDB(0)
+	d1, _ := s.GetDB(1)
+	d0.Keys().Set(NewKey("a", types.NewString("1")))
+	d0.PExpire("a", 1)
+	d1.Keys().Set(NewKey("b", types.NewString("2")))
+	ver := d1.Watch("a")
+
+	if !s.SwapDB(0, 1) {
+		t.Fatalf("expected swap to succeed")
+	}
+	if s.SwapDB(0, -1) {
+		t.Fatalf("expected invalid index to fail")
+	}
+	// The databases keep their index, with the keys of the other one
+	if d0.Index() != 0 || !d0.Exists("b") || d0.Keys().Len() != 1 {
+		t.Errorf("expected database 0 to hold key b")
+	}
+	if d1.Version("a") == ver {
+		t.Errorf("expected the version of the watched key to change")
+	}
+
+	// The expiration index follows the keys
+	d1.ExpireKeys(time.Now().Add(time.Second), 10)
+	if d1.Keys().Len() != 0 {
+		t.Errorf("expected key a to be expired in database 1")
+	}
+}
+
+func TestDBRenameMoveCopy(t *testing.T) {
+	d0, d1 := NewDB(0), NewDB(1)
+	d0.Keys().Set(NewKey("a", types.NewIncString("1")))
+	d0.Expire("a", 100)
+
+	if !d0.Rename("a", "b") || d0.Exists("a") {
+		t.Fatalf("expected key a to be renamed")
+	}
+	if ttl := d0.TTL("b"); ttl != 99 {
+		t.Errorf("expected TTL 99, got %d", ttl)
+	}
+	if d0.Rename("a", "b") {
+		t.Errorf("expected rename of non-existing key to fail")
+	}
+
+	// The copy has its own value, of the same type
+	if !d0.Copy("b", d0, "c", false) || d0.Copy("b", d0, "c", false) {
+		t.Fatalf("expected copy to succeed once without replace")
+	}
+	c, _ := d0.Key("c")
+	if n, _ := c.Val().(types.IncString).Incr(); n != 2 {
+		t.Errorf("expected copy to be incremented to 2, got %d", n)
+	}
+	b, _ := d0.Key("b")
+	if v := b.Val().(types.String).Get(); v != "1" {
+		t.Errorf("expected source to be unchanged, got %s", v)
+	}
+	if ttl := d0.TTL("c"); ttl != 99 {
+		t.Errorf("expected TTL 99, got %d", ttl)
+	}
+
+	if !d0.Move("b", d1) || d0.Exists("b") || !d1.Exists("b") {
+		t.Fatalf("expected key b to be moved")
+	}
+	d0.Keys().Set(NewKey("b", types.NewString("x")))
+	if d0.Move("b", d1) {
+		t.Errorf("expected move to an existing key to fail")
+	}
+	if ttl := d1.TTL("b"); ttl != 99 {
+		t.Errorf("expected TTL 99, got %d", ttl)
+	}
+}
+
+func TestDBWatchVersion(t *testing.T) {
+	d := NewDB(0)
+
+	// Watch a non-existing key
+	v0 := d.Watch("a")
+	if v := d.Version("a"); v != v0 {
+		t.Fatalf("expected version %d, got %d", v0, v)
+	}
+
+	// Create it, then delete it
+	k, unl := d.XLockGetKey("a", NoKeyCreateString)
+	unl()
+	if v := d.Version("a"); v == v0 {
+		t.Fatalf("expected version to change on create")
+	}
+	d.Del("a")
+	v1 := d.Version("a")
+	if v1 == v0 {
+		t.Fatalf("expected version to change on create and delete")
+	}
+
+	// Write to a new key
+	k, unl = d.XLockGetKey("a", NoKeyCreateString)
+	unl()
+	v2 := d.Version("a")
+	k.RLock()
+	k.RUnlock()
+	if v := d.Version("a"); v != v2 {
+		t.Fatalf("expected version to stay the same on read")
+	}
+	k.Lock()
+	k.Unlock()
+	if v := d.Version("a"); v != v2 {
+		t.Fatalf("expected version to stay the same on write lock")
+	}
+	d.Notify(NotifyString, "set", "a")
+	if v := d.Version("a"); v == v2 {
+		t.Fatalf("expected version to change on write")
+	}
+
+	// Flush the DB
+	v3 := d.Version("a")
+	d.FlushDB()
+	if v := d.Version("a"); v == v3 || v == 0 {
+		t.Fatalf("expected version to change on flush, got %d", v)
+	}
+
+	// Once unwatched, deleted keys are forgotten
+	d.Unwatch("a")
+	if v := d.Version("a"); v != 0 {
+		t.Fatalf("expected version 0 for unwatched non-existing key, got %d", v)
+	}
+}
+
+func TestParseNotifyFlags(t *testing.T) {
+	cases := []struct {
+		in  string
+		f   NotifyFlag
+		out string
+		err bool
+	}{
+		0: {"", 0, "", false},
+		1: {"KEA", NotifyKeyspace | NotifyKeyevent | NotifyAll, "AKE", false},
+		2: {"Egx", NotifyKeyevent | NotifyGeneric | NotifyExpired, "gxE", false},
+		3: {"K$lshztxeg", NotifyKeyspace | NotifyAll, "AK", false},
+		4: {"Kq", 0, "", true},
+	}
+	for i, c := range cases {
+		f, err := ParseNotifyFlags(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+			continue
+		}
+		if f != c.f {
+			t.Errorf("%d: expected flags %b, got %b", i, c.f, f)
+		}
+		if s := f.String(); s != c.out {
+			t.Errorf("%d: expected string %q, got %q", i, c.out, s)
+		}
+	}
+}
+
+// events is a Subscriber that records the notified messages.
+type events [][]string
+
+func (e *events) Notify(m []string) {
+	*e = append(*e, m)
+}
+
+func TestDBNotify(t *testing.T) {
+	defer SetNotifyFlags(NotifyFlags())
+
+	var evs events
+	DefaultPubSub.PSubscribe(&evs, "__key*@3__:*")
+	defer DefaultPubSub.PUnsubscribe(&evs, "__key*@3__:*")
+
+	d := NewDB(3)
+	SetNotifyFlags(0)
+	d.Notify(NotifyGeneric, "del", "a")
+	if len(evs) != 0 {
+		t.Fatalf("expected no event, got %v", evs)
+	}
+
+	SetNotifyFlags(NotifyKeyevent | NotifyGeneric)
+	d.Notify(NotifyString, "set", "a")
+	d.Notify(NotifyGeneric, "del", "a")
+	exp := events{{"pmessage", "__key*@3__:*", "__keyevent@3__:del", "a"}}
+	if !reflect.DeepEqual(evs, exp) {
+		t.Fatalf("expected %v, got %v", exp, evs)
+	}
+
+	evs = nil
+	SetNotifyFlags(NotifyKeyspace | NotifyKeyevent | NotifyAll)
+	d.Notify(NotifyString, "set", "a")
+	exp = events{
+		{"pmessage", "__key*@3__:*", "__keyspace@3__:a", "set"},
+		{"pmessage", "__key*@3__:*", "__keyevent@3__:set", "a"},
+	}
+	if !reflect.DeepEqual(evs, exp) {
+		t.Errorf("expected %v, got %v", exp, evs)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetRDBPath(RDBPath())
+	SetRDBPath(filepath.Join(dir, "dump.rdb"))
+
+	s := &server{dbs: make([]DB, DefaultDatabases)}
+	d0, _ := s.GetDB(0)
+	d0.Keys().Set(NewKey("a", types.NewString("1")))
+	d0.Keys().Set(NewKey("b", types.NewString("2")))
+	d0.PExpire("b", 60000)
+	d0.Keys().Set(NewKey("c", types.NewString("3")))
+	d0.PExpire("c", 1)
+	d5, _ := s.GetDB(5)
+	l := types.NewList()
+	l.RPush("x", "y")
+	d5.Keys().Set(NewKey("l", l))
+
+	if err := s.BGSave(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	// The snapshot is taken before BGSave returns
+	k, _ := d0.Keys().Get("a")
+	k.Lock()
+	k.Val().(types.String).Set("changed")
+	k.Unlock()
+	for atomic.LoadInt32(&s.saving) != 0 {
+		runtime.Gosched()
+	}
+	if s.LastSave() == 0 {
+		t.Fatalf("expected last save to be set")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	s2 := &server{dbs: make([]DB, DefaultDatabases)}
+	if err := s2.Load(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	d0, _ = s2.GetDB(0)
+	k, _ = d0.Keys().Get("a")
+	if v := k.Val().(types.IncString).Get(); v != "1" {
+		t.Errorf("expected a=1, got %s", v)
+	}
+	if ttl := d0.PTTL("b"); ttl <= 0 || ttl > 60000 {
+		t.Errorf("expected b to expire, got ttl %d", ttl)
+	}
+	if d0.Exists("c") {
+		t.Errorf("expected expired key c to be skipped")
+	}
+	d5, _ = s2.GetDB(5)
+	k, _ = d5.Keys().Get("l")
+	if v := k.Val().(types.List).LRange(0, -1); !reflect.DeepEqual(v, []string{"x", "y"}) {
+		t.Errorf("expected [x y], got %v", v)
+	}
+
+	// Loading a missing file is not an error
+	SetRDBPath(filepath.Join(dir, "missing.rdb"))
+	if err := s2.Load(); err != nil {
+		t.Errorf("expected no error for missing file, got %s", err)
+	}
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	s := &server{dbs: make([]DB, DefaultDatabases)}
+	d0, _ := s.GetDB(0)
+	for _, nm := range []string{"a", "b", "c"} {
+		d0.Keys().Set(NewKey(nm, types.NewString(nm)))
+	}
+
+	snap := s.snapshot()
+	if len(snap) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(snap))
+	}
+	// Modify a key and delete another once the snapshot is taken
+	ka, _ := d0.Keys().Get("a")
+	ka.Lock()
+	ka.Val().(types.String).Set("changed")
+	ka.Unlock()
+	d0.Lock()
+	d0.Del("b")
+	d0.Unlock()
+
+	got := make(map[string]string)
+	if err := snap.each(func(ent *rdb.Entry) error {
+		got[ent.Key] = ent.Value.(types.String).Get()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "a", "b": "b", "c": "c"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	if v := ka.Val().(types.String).Get(); v != "changed" {
+		t.Errorf("expected a=changed, got %s", v)
+	}
+
+	// Values modified under the exclusive DB lock are copied too
+	snap = s.snapshot()
+	d0.Lock()
+	ka, _ = d0.Keys().Get("a")
+	ka.Modify()
+	ka.Val().(types.String).Set("modified")
+	d0.Unlock()
+
+	got = make(map[string]string)
+	if err := snap.each(func(ent *rdb.Entry) error {
+		got[ent.Key] = ent.Value.(types.String).Get()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "changed", "c": "c"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+
+	// The entries are released once visited, or when the visit fails
+	snap = s.snapshot()
+	snap.each(func(ent *rdb.Entry) error { return io.EOF })
+	d0.Keys().Range(func(k Key) bool {
+		if k := k.(*key); k.cow != nil {
+			t.Errorf("%s: expected no snapshot entry, got %d", k.Name(), len(k.cow))
+		}
+		return true
+	})
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	cases := []struct {
+		in  string
+		p   FsyncPolicy
+		err bool
+	}{
+		0: {"always", FsyncAlways, false},
+		1: {"EverySec", FsyncEverySec, false},
+		2: {"no", FsyncNo, false},
+		3: {"sometimes", 0, true},
+	}
+	for i, c := range cases {
+		p, err := ParseFsyncPolicy(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+			continue
+		}
+		if p != c.p {
+			t.Errorf("%d: expected %s, got %s", i, c.p, p)
+		}
+	}
+}
+
+// readCmds returns the commands of the append-only file at path.
+func readCmds(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var cmds [][]string
+	br := bufio.NewReader(f)
+	for {
+		ar, err := resp.DecodeRequest(br)
+		if err == io.EOF {
+			return cmds
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, ar)
+	}
+}
+
+func TestAOFRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetAOFPath(AOFPath())
+	SetAOFPath(filepath.Join(dir, "appendonly.aof"))
+
+	s := &server{dbs: make([]DB, DefaultDatabases)}
+	if err := s.OpenAOF(FsyncNo); err != nil {
+		t.Fatal(err)
+	}
+	defer s.CloseAOF()
+	if !s.AOFEnabled() {
+		t.Fatal("expected append-only file to be enabled")
+	}
+
+	d0, _ := s.GetDB(0)
+	l := types.NewList()
+	var items []string
+	for i := 0; i < aofItemsPerCmd+6; i++ {
+		items = append(items, strconv.Itoa(i))
+	}
+	l.RPush(items...)
+	d0.Keys().Set(NewKey("l", l))
+	d1, _ := s.GetDB(1)
+	d1.Keys().Set(NewKey("s", types.NewString("v")))
+	d1.PExpire("s", 60000)
+	s.Propagate(1, "set", "s", "v")
+
+	if err := s.BGRewriteAOF(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	for atomic.LoadInt32(&s.aof.rewriting) != 0 {
+		runtime.Gosched()
+	}
+	s.Propagate(0, "del", "l")
+	if err := s.CloseAOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := readCmds(t, AOFPath())
+	exp := [][]string{
+		{"select", "0"},
+		append([]string{"rpush", "l"}, items[:aofItemsPerCmd]...),
+		append([]string{"rpush", "l"}, items[aofItemsPerCmd:]...),
+		{"select", "1"},
+		{"set", "s", "v"},
+		{"pexpireat", "s", ""},
+		{"select", "0"},
+		{"del", "l"},
+	}
+	if len(cmds) != len(exp) {
+		t.Fatalf("expected %d commands, got %d: %v", len(exp), len(cmds), cmds)
+	}
+	// The expiration time varies, check that it is in the future
+	ms, _ := strconv.ParseInt(cmds[5][2], 10, 64)
+	if ms <= unixMs(time.Now()) {
+		t.Errorf("expected expiration in the future, got %d", ms)
+	}
+	cmds[5][2] = ""
+	if !reflect.DeepEqual(cmds, exp) {
+		t.Errorf("expected %v, got %v", exp, cmds)
+	}
+}
+
+func TestStreamCmds(t *testing.T) {
+	v := types.NewStream()
+	v.XAdd(types.StreamID{Ms: 1, Seq: 1}, "f", "v")
+	v.XAdd(types.StreamID{Ms: 2}, "g", "w")
+	v.SetLastID(types.StreamID{Ms: 3})
+	v.CreateGroup("grp", types.StreamID{Ms: 1, Seq: 1})
+	g, _ := v.Group("grp")
+	now := time.Unix(1, 0)
+	g.CreateConsumer("c2", now)
+	g.Read("c1", 1, false, now)
+
+	exp := [][]string{
+		{"xadd", "s", "1-1", "f", "v"},
+		{"xadd", "s", "2-0", "g", "w"},
+		{"xsetid", "s", "3-0"},
+		{"xgroup", "create", "s", "grp", "2-0"},
+		{"xgroup", "createconsumer", "s", "grp", "c1"},
+		{"xgroup", "createconsumer", "s", "grp", "c2"},
+		{"xclaim", "s", "grp", "c1", "0", "2-0", "time", "1000", "retrycount", "1", "force", "justid"},
+	}
+	if cmds := streamCmds("s", v); !reflect.DeepEqual(cmds, exp) {
+		t.Errorf("expected %v, got %v", exp, cmds)
+	}
+
+	// An empty stream is created by trimming its only entry
+	exp = [][]string{
+		{"xadd", "e", "maxlen", "0", "0-1", "x", "y"},
+		{"xsetid", "e", "0-0"},
+	}
+	if cmds := streamCmds("e", types.NewStream()); !reflect.DeepEqual(cmds, exp) {
+		t.Errorf("expected %v, got %v", exp, cmds)
+	}
+}
@@ -0,0 +1,130 @@
+package srv
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RedisVersion is the version of Redis reported by INFO, whose commands
+// the server implements.
+const RedisVersion = "7.0.0"
+
+// Stats holds the statistics of the server reported by INFO.
+type Stats struct {
+	// Start is the time the server started.
+	Start time.Time
+
+	// Connections is the number of connections received, and Commands
+	// the number of commands processed.
+	Connections int64
+	Commands    int64
+
+	// KeyspaceHits and KeyspaceMisses are the number of lookups of
+	// existing and missing keys by the commands that do not create the
+	// key.
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+
+	// Cmds holds the statistics of the commands that were called, sorted
+	// by name.
+	Cmds []CmdStats
+}
+
+// CmdStats holds the statistics of a command.
+type CmdStats struct {
+	Name     string
+	Calls    int64
+	Duration time.Duration
+}
+
+// The counters of the Stats, they are accessed atomically.
+var connections, commands, keyspaceHits, keyspaceMisses int64
+
+// startTime is the time the server started.
+var startTime = time.Now()
+
+// cmdCounter holds the counters of a command, accessed atomically.
+type cmdCounter struct {
+	calls int64
+	ns    int64
+}
+
+// cmdCounters holds the *cmdCounter of the commands, by name.
+var cmdCounters sync.Map
+
+// CountConn counts a connection received.
+func (s *server) CountConn() {
+	atomic.AddInt64(&connections, 1)
+}
+
+// CountCmd counts a command processed, that executed in d. The name is
+// empty if the command is unknown, in which case it is only counted in
+// the total.
+func (s *server) CountCmd(name string, d time.Duration) {
+	atomic.AddInt64(&commands, 1)
+	if name == "" {
+		return
+	}
+	v, ok := cmdCounters.Load(name)
+	if !ok {
+		v, _ = cmdCounters.LoadOrStore(name, &cmdCounter{})
+	}
+	c := v.(*cmdCounter)
+	atomic.AddInt64(&c.calls, 1)
+	atomic.AddInt64(&c.ns, int64(d))
+}
+
+// CountLookup counts a lookup of a key by a command that does not create
+// the key, which is a hit if the key exists.
+func (s *server) CountLookup(hit bool) {
+	countLookup(hit)
+}
+
+func countLookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&keyspaceHits, 1)
+	} else {
+		atomic.AddInt64(&keyspaceMisses, 1)
+	}
+}
+
+// Stats returns the statistics of the server.
+func (s *server) Stats() Stats {
+	st := Stats{
+		Start:          startTime,
+		Connections:    atomic.LoadInt64(&connections),
+		Commands:       atomic.LoadInt64(&commands),
+		KeyspaceHits:   atomic.LoadInt64(&keyspaceHits),
+		KeyspaceMisses: atomic.LoadInt64(&keyspaceMisses),
+	}
+	cmdCounters.Range(func(k, v interface{}) bool {
+		c := v.(*cmdCounter)
+		if n := atomic.LoadInt64(&c.calls); n > 0 {
+			st.Cmds = append(st.Cmds, CmdStats{
+				Name:     k.(string),
+				Calls:    n,
+				Duration: time.Duration(atomic.LoadInt64(&c.ns)),
+			})
+		}
+		return true
+	})
+	sort.Slice(st.Cmds, func(i, j int) bool { return st.Cmds[i].Name < st.Cmds[j].Name })
+	return st
+}
+
+// ResetStats resets the statistics of the server, and the statistics of
+// the expired keys.
+func (s *server) ResetStats() {
+	for _, p := range []*int64{&connections, &commands, &keyspaceHits, &keyspaceMisses,
+		&expiredKeys, &lazyExpiredKeys} {
+		atomic.StoreInt64(p, 0)
+	}
+	cmdCounters.Range(func(k, v interface{}) bool {
+		c := v.(*cmdCounter)
+		atomic.StoreInt64(&c.calls, 0)
+		atomic.StoreInt64(&c.ns, 0)
+		return true
+	})
+}
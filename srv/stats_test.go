@@ -0,0 +1,41 @@
+package srv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	DefaultServer.ResetStats()
+	DefaultServer.CountConn()
+	DefaultServer.CountCmd("get", 2*time.Millisecond)
+	DefaultServer.CountCmd("get", time.Millisecond)
+	DefaultServer.CountCmd("set", time.Millisecond)
+	DefaultServer.CountCmd("", 0)
+	DefaultServer.CountLookup(true)
+	DefaultServer.CountLookup(false)
+	DefaultServer.CountLookup(false)
+
+	st := DefaultServer.Stats()
+	if st.Connections != 1 || st.Commands != 4 || st.KeyspaceHits != 1 || st.KeyspaceMisses != 2 {
+		t.Errorf("unexpected statistics: %+v", st)
+	}
+	exp := []CmdStats{{"get", 2, 3 * time.Millisecond}, {"set", 1, time.Millisecond}}
+	if len(st.Cmds) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, st.Cmds)
+	}
+	for i, c := range st.Cmds {
+		if c != exp[i] {
+			t.Errorf("%d: expected %v, got %v", i, exp[i], c)
+		}
+	}
+
+	DefaultServer.ResetStats()
+	st = DefaultServer.Stats()
+	if st.Connections != 0 || st.Commands != 0 || st.KeyspaceHits != 0 || st.KeyspaceMisses != 0 || len(st.Cmds) != 0 {
+		t.Errorf("expected the statistics to be reset, got %+v", st)
+	}
+	if st.Start.IsZero() {
+		t.Error("expected the start time to be kept")
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "github.com/PuerkitoBio/gred/dict"
+
 // Hash defines the methods required to implement the Hash Redis type.
 type Hash interface {
 	Value
@@ -15,17 +17,20 @@ type Hash interface {
 	HSet(string, string) bool
 	HSetNx(string, string) bool
 	HVals() []string
+	HScan(uint64, func(string, string)) uint64
 }
 
 // Static check to make sure hash implements Hash.
-var _ Hash = hash(nil)
+var _ Hash = hash{}
 
 // hash is the internal implementation of the Hash interface.
-type hash map[string]string
+type hash struct {
+	d *dict.Dict
+}
 
 // NewHash creates a new Hash value.
 func NewHash() Hash {
-	return make(hash)
+	return hash{dict.New()}
 }
 
 // Type returns the type of the value, which is "hash".
@@ -38,9 +43,8 @@ func (h hash) Type() string {
 func (h hash) HDel(fields ...string) int64 {
 	var cnt int64
 	for _, f := range fields {
-		if _, ok := h[f]; ok {
+		if h.d.Del(f) {
 			cnt++
-			delete(h, f)
 		}
 	}
 	return cnt
@@ -48,49 +52,49 @@ func (h hash) HDel(fields ...string) int64 {
 
 // HExists returns true if the specified field exists in the hash.
 func (h hash) HExists(field string) bool {
-	_, ok := h[field]
+	_, ok := h.d.Get(field)
 	return ok
 }
 
 // HGet returns the value of the specified field. The second return value
 // indicates if the field exists in the hash.
 func (h hash) HGet(field string) (string, bool) {
-	v, ok := h[field]
-	return v, ok
+	v, ok := h.d.Get(field)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
 }
 
 // HGetAll returns the list of all key-value pairs in the hash.
 func (h hash) HGetAll() []string {
-	if len(h) == 0 {
+	if h.d.Len() == 0 {
 		return empty
 	}
-	vals := make([]string, 2*len(h))
-	i := 0
-	for k, v := range h {
-		vals[i] = k
-		vals[i+1] = v
-		i += 2
-	}
+	vals := make([]string, 0, 2*h.d.Len())
+	h.d.Each(func(k string, v interface{}) bool {
+		vals = append(vals, k, v.(string))
+		return true
+	})
 	return vals
 }
 
 // HKeys returns the list of keys in the hash.
 func (h hash) HKeys() []string {
-	if len(h) == 0 {
+	if h.d.Len() == 0 {
 		return empty
 	}
-	keys := make([]string, len(h))
-	i := 0
-	for k := range h {
-		keys[i] = k
-		i++
-	}
+	keys := make([]string, 0, h.d.Len())
+	h.d.Each(func(k string, _ interface{}) bool {
+		keys = append(keys, k)
+		return true
+	})
 	return keys
 }
 
 // HLen returns the number of fields in the hash.
 func (h hash) HLen() int64 {
-	return int64(len(h))
+	return int64(h.d.Len())
 }
 
 // HMGet returns the list of values for all requested fields, in the
@@ -99,8 +103,8 @@ func (h hash) HLen() int64 {
 func (h hash) HMGet(fields ...string) []interface{} {
 	ret := make([]interface{}, len(fields))
 	for i, f := range fields {
-		if v, ok := h[f]; ok {
-			ret[i] = v
+		if v, ok := h.d.Get(f); ok {
+			ret[i] = v.(string)
 		}
 	}
 	return ret
@@ -109,7 +113,7 @@ func (h hash) HMGet(fields ...string) []interface{} {
 // HMSet sets the values for all key-value tuples as received as argument.
 func (h hash) HMSet(tuples ...string) {
 	for i := 0; i < len(tuples); {
-		h[tuples[i]] = tuples[i+1]
+		h.d.Set(tuples[i], tuples[i+1])
 		i += 2
 	}
 }
@@ -117,31 +121,38 @@ func (h hash) HMSet(tuples ...string) {
 // HSet sets the value of field to val, and returns true if the field had to be
 // created.
 func (h hash) HSet(field, val string) bool {
-	_, ok := h[field]
-	h[field] = val
-	return !ok
+	return h.d.Set(field, val)
 }
 
 // HSetNx sets the value of field to val only if the field does not already exists
 // in the hash. It returns true if it did create and set the field.
 func (h hash) HSetNx(field, val string) bool {
-	if _, ok := h[field]; !ok {
-		h[field] = val
-		return true
+	if _, ok := h.d.Get(field); !ok {
+		return h.d.Set(field, val)
 	}
 	return false
 }
 
 // HVals returns the list of values in the hash.
 func (h hash) HVals() []string {
-	if len(h) == 0 {
+	if h.d.Len() == 0 {
 		return empty
 	}
-	vals := make([]string, len(h))
-	i := 0
-	for _, v := range h {
-		vals[i] = v
-		i++
-	}
+	vals := make([]string, 0, h.d.Len())
+	h.d.Each(func(_ string, v interface{}) bool {
+		vals = append(vals, v.(string))
+		return true
+	})
 	return vals
 }
+
+// HScan calls fn for some of the fields of the hash and their value,
+// starting at cursor, and returns the cursor to use for the next call,
+// which is 0 once all fields have been visited. A full iteration returns
+// every field that is present for the whole iteration, even if the hash
+// is modified between calls. Fields may be returned more than once.
+func (h hash) HScan(cursor uint64, fn func(field, val string)) uint64 {
+	return h.d.Scan(cursor, func(k string, v interface{}) {
+		fn(k, v.(string))
+	})
+}
@@ -2,23 +2,45 @@ package types
 
 import (
 	"reflect"
+	"sort"
 	"testing"
 )
 
-var hcase = hash{
-	"a": "v1",
-	"b": "v2",
-	"c": "v3",
+// sorted returns the sorted values, for comparisons that do not depend on
+// the order of iteration.
+func sorted(vals []string) []string {
+	sort.Strings(vals)
+	return vals
 }
 
-var hempty = hash{}
-
-func cloneHash(h hash) hash {
-	h2 := hash{}
-	for k, v := range h {
-		h2[k] = v
+// sortedPairs returns the field-value pairs sorted by field.
+func sortedPairs(pairs []string) []string {
+	fields := make([]string, 0, len(pairs)/2)
+	vals := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		fields = append(fields, pairs[i])
+		vals[pairs[i]] = pairs[i+1]
+	}
+	sort.Strings(fields)
+	ret := make([]string, 0, len(pairs))
+	for _, f := range fields {
+		ret = append(ret, f, vals[f])
 	}
-	return h2
+	return ret
+}
+
+var hcase = hashOf("a", "v1", "b", "v2", "c", "v3")
+
+var hempty = NewHash()
+
+func hashOf(tuples ...string) Hash {
+	h := NewHash()
+	h.HMSet(tuples...)
+	return h
+}
+
+func cloneHash(h Hash) Hash {
+	return hashOf(h.HGetAll()...)
 }
 
 func TestHashType(t *testing.T) {
@@ -103,7 +125,7 @@ func TestHashGetAll(t *testing.T) {
 		1: {hempty, []string{}},
 	}
 	for i, c := range cases {
-		got := c.h.HGetAll()
+		got := sortedPairs(c.h.HGetAll())
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
@@ -119,7 +141,7 @@ func TestHashHKeys(t *testing.T) {
 		1: {hempty, []string{}},
 	}
 	for i, c := range cases {
-		got := c.h.HKeys()
+		got := sorted(c.h.HKeys())
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
@@ -252,7 +274,7 @@ func TestHashHVals(t *testing.T) {
 		1: {hempty, []string{}},
 	}
 	for i, c := range cases {
-		got := c.h.HVals()
+		got := sorted(c.h.HVals())
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
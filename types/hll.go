@@ -0,0 +1,334 @@
+package types
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// The parameters and the representation of the HyperLogLogs are those of
+// Redis, so that they can be exchanged with Redis as string values. See
+// http://antirez.com/news/75 and the hyperloglog.c file of Redis.
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllMaxVal    = 1<<hllBits - 1
+	hllAlphaInf  = 0.721347520444481703680
+	hllSeed      = 0xadc83b19
+
+	// The header holds the "HYLL" magic string, the encoding, 3 unused bytes
+	// and the cached cardinality, in little-endian order, whose most
+	// significant bit is set if it is not valid.
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllDense     = 0
+	hllSparse    = 1
+	hllMagic     = "HYLL"
+
+	// The sparse encoding is used until a register is greater than
+	// hllSparseMaxVal or the encoded string would be longer than
+	// hllSparseMaxBytes.
+	hllSparseMaxVal   = 32
+	hllSparseMaxBytes = 3000
+	hllSparseMaxZero  = 64
+	hllSparseMaxXZero = hllRegisters
+	hllSparseMaxRun   = 4
+)
+
+// HLL is a HyperLogLog, which estimates the number of distinct elements
+// added to it. It is stored as a string value using the dense and sparse
+// representations of Redis.
+type HLL struct {
+	regs   [hllRegisters]uint8
+	dense  bool
+	card   uint64
+	cached bool
+}
+
+// NewHLL creates a new empty HLL, which uses the sparse representation.
+func NewHLL() *HLL {
+	return &HLL{cached: true}
+}
+
+// ParseHLL parses the string representation of a HLL. It returns false
+// if s is not a valid HLL.
+func ParseHLL(s string) (*HLL, bool) {
+	if len(s) < hllHdrSize || s[:len(hllMagic)] != hllMagic {
+		return nil, false
+	}
+
+	h := &HLL{}
+	card := binary.LittleEndian.Uint64([]byte(s[8:hllHdrSize]))
+	if card&(1<<63) == 0 {
+		h.card, h.cached = card, true
+	}
+	switch s[4] {
+	case hllDense:
+		if len(s) != hllDenseSize {
+			return nil, false
+		}
+		h.dense = true
+		for i := range h.regs {
+			h.regs[i] = denseGet(s[hllHdrSize:], i)
+		}
+	case hllSparse:
+		if !h.parseSparse(s[hllHdrSize:]) {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return h, true
+}
+
+// denseGet returns the register at index i of the dense registers p.
+func denseGet(p string, i int) uint8 {
+	b, fb := i*hllBits/8, uint(i*hllBits&7)
+	v := p[b] >> fb
+	if b+1 < len(p) {
+		v |= p[b+1] << (8 - fb)
+	}
+	return v & hllMaxVal
+}
+
+// parseSparse decodes the sparse registers p, and returns false if they
+// are not valid. There are 3 opcodes:
+//
+//	00xxxxxx: ZERO, a run of xxxxxx+1 0 registers.
+//	01xxxxxx yyyyyyyy: XZERO, a run of xxxxxxyyyyyyyy+1 0 registers.
+//	1vvvvvxx: VAL, a run of xx+1 registers of value vvvvv+1.
+func (h *HLL) parseSparse(p string) bool {
+	var i int
+	for j := 0; j < len(p); j++ {
+		op := p[j]
+		switch {
+		case op&0xc0 == 0:
+			i += int(op) + 1
+		case op&0xc0 == 0x40:
+			if j++; j >= len(p) {
+				return false
+			}
+			i += int(op&0x3f)<<8 | int(p[j]) + 1
+		default:
+			n := int(op&0x3) + 1
+			if i+n > hllRegisters {
+				return false
+			}
+			v := (op>>2)&0x1f + 1
+			for ; n > 0; n-- {
+				h.regs[i] = v
+				i++
+			}
+		}
+		if i > hllRegisters {
+			return false
+		}
+	}
+	return i == hllRegisters
+}
+
+// String returns the string representation of the HLL, which is sparse
+// unless it does not fit the sparse representation or the HLL is already
+// dense.
+func (h *HLL) String() string {
+	var p []byte
+	if !h.dense {
+		p = h.sparse()
+		h.dense = p == nil
+	}
+	if h.dense {
+		p = make([]byte, hllDenseSize)
+		copy(p, hllMagic)
+		p[4] = hllDense
+		regs := p[hllHdrSize:]
+		for i, v := range h.regs {
+			b, fb := i*hllBits/8, uint(i*hllBits&7)
+			regs[b] |= v << fb
+			if b+1 < len(regs) {
+				regs[b+1] |= v >> (8 - fb)
+			}
+		}
+	}
+
+	card := h.card
+	if !h.cached {
+		card = 1 << 63
+	}
+	binary.LittleEndian.PutUint64(p[8:hllHdrSize], card)
+	return string(p)
+}
+
+// sparse returns the sparse representation of the HLL, with an empty
+// cached cardinality, or nil if the HLL does not fit.
+func (h *HLL) sparse() []byte {
+	p := make([]byte, hllHdrSize, 64)
+	copy(p, hllMagic)
+	p[4] = hllSparse
+	for i := 0; i < hllRegisters; {
+		v := h.regs[i]
+		if v > hllSparseMaxVal {
+			return nil
+		}
+		n := 1
+		for i+n < hllRegisters && h.regs[i+n] == v {
+			n++
+		}
+		i += n
+
+		for n > 0 {
+			switch {
+			case v > 0:
+				run := n
+				if run > hllSparseMaxRun {
+					run = hllSparseMaxRun
+				}
+				p = append(p, 0x80|(v-1)<<2|byte(run-1))
+				n -= run
+			case n <= hllSparseMaxZero:
+				p = append(p, byte(n-1))
+				n = 0
+			default:
+				run := n
+				if run > hllSparseMaxXZero {
+					run = hllSparseMaxXZero
+				}
+				p = append(p, 0x40|byte((run-1)>>8), byte(run-1))
+				n -= run
+			}
+		}
+		if len(p) > hllSparseMaxBytes {
+			return nil
+		}
+	}
+	return p
+}
+
+// Add adds the elements to the HLL. It returns true if the HLL was
+// modified.
+func (h *HLL) Add(elems ...string) bool {
+	var changed bool
+	for _, e := range elems {
+		x := murmurHash64A(e, hllSeed)
+		i := x & (hllRegisters - 1)
+		x = x>>hllP | 1<<hllQ
+		v := uint8(bits.TrailingZeros64(x) + 1)
+		if v > h.regs[i] {
+			h.regs[i] = v
+			changed = true
+		}
+	}
+	if changed {
+		h.cached = false
+	}
+	return changed
+}
+
+// Merge sets the registers of the HLL to the maximum of its registers and
+// those of the other HLLs. The HLL becomes dense if one of the others is.
+func (h *HLL) Merge(others ...*HLL) {
+	for _, o := range others {
+		for i, v := range o.regs {
+			if v > h.regs[i] {
+				h.regs[i] = v
+			}
+		}
+		if o.dense {
+			h.dense = true
+		}
+	}
+	h.cached = false
+}
+
+// Count returns the estimated number of distinct elements added to the
+// HLL. The cardinality is cached until the HLL is modified.
+func (h *HLL) Count() int64 {
+	if !h.cached {
+		h.card = uint64(h.estimate())
+		h.cached = true
+	}
+	return int64(h.card)
+}
+
+// estimate returns the cardinality estimate of the registers, computed
+// using the improved estimator of Otmar Ertl, like Redis.
+func (h *HLL) estimate() int64 {
+	var histo [hllQ + 2]int
+	for _, v := range h.regs {
+		histo[v]++
+	}
+
+	const m = float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return int64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// hllSigma computes the sigma function of the estimator for x in [0, 1].
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zp := z
+		z += x * y
+		y += y
+		if zp == z {
+			return z
+		}
+	}
+}
+
+// hllTau computes the tau function of the estimator for x in [0, 1].
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zp := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zp == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64-bit MurmurHash2 by Austin Appleby, used by Redis
+// to hash the elements of HLLs.
+func murmurHash64A(s string, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+
+	h := seed ^ uint64(len(s))*m
+	n := len(s) - len(s)&7
+	for i := 0; i < n; i += 8 {
+		k := binary.LittleEndian.Uint64([]byte(s[i : i+8]))
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if rem := s[n:]; len(rem) > 0 {
+		for i := len(rem) - 1; i >= 0; i-- {
+			h ^= uint64(rem[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,122 @@
+package types
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHLLEmpty(t *testing.T) {
+	h := NewHLL()
+	if c := h.Count(); c != 0 {
+		t.Errorf("expected count 0, got %d", c)
+	}
+
+	// XZERO opcode for all registers
+	exp := "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"
+	if s := h.String(); s != exp {
+		t.Errorf("expected %q, got %q", exp, s)
+	}
+}
+
+func TestHLLAdd(t *testing.T) {
+	h := NewHLL()
+	if !h.Add("a", "b", "c", "d", "e", "f", "g") {
+		t.Errorf("expected HLL to be modified")
+	}
+	if h.Add("a", "c") {
+		t.Errorf("expected HLL to not be modified")
+	}
+	if c := h.Count(); c != 7 {
+		t.Errorf("expected count 7, got %d", c)
+	}
+}
+
+func TestHLLCount(t *testing.T) {
+	cases := []int{10, 100, 1000, 10000, 100000, 1000000}
+	h := NewHLL()
+	var n int
+	for _, c := range cases {
+		for ; n < c; n++ {
+			h.Add(strconv.Itoa(n))
+		}
+		got := h.Count()
+		if err := math.Abs(float64(got)-float64(c)) / float64(c); err > 0.02 {
+			t.Errorf("%d: got %d, error %f", c, got, err)
+		}
+	}
+}
+
+func TestHLLParse(t *testing.T) {
+	cases := []struct {
+		n     int
+		dense bool
+	}{
+		{0, false},
+		{10, false},
+		{1000, false},
+		{10000, true},
+	}
+	for i, c := range cases {
+		h := NewHLL()
+		for j := 0; j < c.n; j++ {
+			h.Add("e" + strconv.Itoa(j))
+		}
+		cnt := h.Count()
+		s := h.String()
+		if dense := s[4] == hllDense; dense != c.dense {
+			t.Errorf("%d: expected dense %t, got %t", i, c.dense, dense)
+		}
+
+		h2, ok := ParseHLL(s)
+		if !ok {
+			t.Fatalf("%d: expected valid HLL", i)
+		}
+		if h2.regs != h.regs {
+			t.Errorf("%d: registers differ", i)
+		}
+		if !h2.cached || h2.Count() != cnt {
+			t.Errorf("%d: expected cached count %d, got %d", i, cnt, h2.Count())
+		}
+		if s2 := h2.String(); s2 != s {
+			t.Errorf("%d: representations differ", i)
+		}
+	}
+
+	for i, s := range []string{
+		"",
+		"HYLL",
+		"HYLX\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff",
+		"HYLL\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff",
+		"HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff",
+		"HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xfe",
+		"HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff\x00",
+		"HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f",
+	} {
+		if _, ok := ParseHLL(s); ok {
+			t.Errorf("%d: expected invalid HLL", i)
+		}
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	h1, h2 := NewHLL(), NewHLL()
+	for i := 0; i < 1000; i++ {
+		h1.Add(strconv.Itoa(i))
+		h2.Add(strconv.Itoa(i + 500))
+	}
+	h1.Merge(h2)
+	if c := h1.Count(); math.Abs(float64(c)-1500) > 30 {
+		t.Errorf("expected count near 1500, got %d", c)
+	}
+	if h1.dense {
+		t.Errorf("expected sparse HLL")
+	}
+
+	h3 := NewHLL()
+	h3.dense = true
+	h1.Merge(h3)
+	if !h1.dense {
+		t.Errorf("expected dense HLL")
+	}
+}
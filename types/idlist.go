@@ -0,0 +1,142 @@
+package types
+
+// idNode is a node of an idList.
+type idNode struct {
+	id       StreamID
+	val      interface{}
+	backward *idNode
+	forward  []*idNode
+}
+
+// next returns the next node, or nil.
+func (n *idNode) next() *idNode {
+	return n.forward[0]
+}
+
+// idList is a skip list of values ordered by stream ID, like the radix tree
+// of Redis streams: the values are found, inserted and deleted in O(log n),
+// and iterated in order of their IDs in both directions.
+type idList struct {
+	header *idNode
+	tail   *idNode
+	length int64
+	level  int
+}
+
+func newIDList() *idList {
+	return &idList{
+		header: &idNode{forward: make([]*idNode, zslMaxLevel)},
+		level:  1,
+	}
+}
+
+// first returns the first node, or nil if the list is empty.
+func (l *idList) first() *idNode {
+	return l.header.forward[0]
+}
+
+// seek returns the first node with an ID greater than or equal to id, or
+// nil. If update is not nil, it is filled with the nodes preceding that
+// node at each level.
+func (l *idList) seek(id StreamID, update []*idNode) *idNode {
+	x := l.header
+	for i := l.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && x.forward[i].id.Less(id) {
+			x = x.forward[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.forward[0]
+}
+
+// seekLast returns the last node with an ID smaller than or equal to id,
+// or nil.
+func (l *idList) seekLast(id StreamID) *idNode {
+	x := l.header
+	for i := l.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && !id.Less(x.forward[i].id) {
+			x = x.forward[i]
+		}
+	}
+	if x == l.header {
+		return nil
+	}
+	return x
+}
+
+// get returns the node with the ID, or nil.
+func (l *idList) get(id StreamID) *idNode {
+	if x := l.seek(id, nil); x != nil && x.id == id {
+		return x
+	}
+	return nil
+}
+
+// insert inserts the value with the ID, which must not already be in the
+// list, and returns its node.
+func (l *idList) insert(id StreamID, val interface{}) *idNode {
+	var update [zslMaxLevel]*idNode
+	l.seek(id, update[:])
+
+	lvl := randomLevel()
+	if lvl > l.level {
+		for i := l.level; i < lvl; i++ {
+			update[i] = l.header
+		}
+		l.level = lvl
+	}
+
+	x := &idNode{id: id, val: val, forward: make([]*idNode, lvl)}
+	for i := 0; i < lvl; i++ {
+		x.forward[i] = update[i].forward[i]
+		update[i].forward[i] = x
+	}
+	if update[0] != l.header {
+		x.backward = update[0]
+	}
+	if x.forward[0] != nil {
+		x.forward[0].backward = x
+	} else {
+		l.tail = x
+	}
+	l.length++
+	return x
+}
+
+// delete removes the node with the ID, and returns it, or nil if there is
+// no such node.
+func (l *idList) delete(id StreamID) *idNode {
+	var update [zslMaxLevel]*idNode
+	x := l.seek(id, update[:])
+	if x == nil || x.id != id {
+		return nil
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].forward[i] == x {
+			update[i].forward[i] = x.forward[i]
+		}
+	}
+	if x.forward[0] != nil {
+		x.forward[0].backward = x.backward
+	} else {
+		l.tail = x.backward
+	}
+	for l.level > 1 && l.header.forward[l.level-1] == nil {
+		l.level--
+	}
+	l.length--
+	return x
+}
+
+// deleteFirst removes the first n nodes, and returns the number of nodes
+// removed.
+func (l *idList) deleteFirst(n int64) int64 {
+	var cnt int64
+	for ; cnt < n && l.length > 0; cnt++ {
+		l.delete(l.first().id)
+	}
+	return cnt
+}
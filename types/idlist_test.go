@@ -0,0 +1,86 @@
+package types
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// checkIDList checks that the nodes of the list are the IDs ids, in order,
+// in both directions.
+func checkIDList(t *testing.T, l *idList, ids []StreamID) {
+	if l.length != int64(len(ids)) {
+		t.Fatalf("expected %d nodes, got %d", len(ids), l.length)
+	}
+	i := 0
+	for n := l.first(); n != nil; n = n.next() {
+		if n.id != ids[i] {
+			t.Fatalf("%d: expected %v, got %v", i, ids[i], n.id)
+		}
+		i++
+	}
+	for n := l.tail; n != nil; n = n.backward {
+		i--
+		if n.id != ids[i] {
+			t.Fatalf("%d: expected %v backward, got %v", i, ids[i], n.id)
+		}
+	}
+}
+
+func TestIDList(t *testing.T) {
+	l := newIDList()
+	var ids []StreamID
+	for _, i := range rand.Perm(1000) {
+		id := StreamID{uint64(i / 10), uint64(i % 10)}
+		l.insert(id, i)
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	checkIDList(t, l, ids)
+
+	if n := l.get(StreamID{12, 3}); n == nil || n.val.(int) != 123 {
+		t.Errorf("expected node 12-3 with value 123, got %v", n)
+	}
+	if n := l.get(StreamID{12, 10}); n != nil {
+		t.Errorf("expected no node 12-10, got %v", n.id)
+	}
+	if n := l.seek(StreamID{12, 10}, nil); n == nil || n.id != (StreamID{13, 0}) {
+		t.Errorf("expected seek to 13-0, got %v", n)
+	}
+	if n := l.seekLast(StreamID{12, 10}); n == nil || n.id != (StreamID{12, 9}) {
+		t.Errorf("expected seek last to 12-9, got %v", n)
+	}
+	if n := l.seek(StreamID{100, 0}, nil); n != nil {
+		t.Errorf("expected no node after 100-0, got %v", n.id)
+	}
+	if n := l.seekLast(StreamID{}); n == nil || n.id != (StreamID{}) {
+		t.Errorf("expected seek last to 0-0, got %v", n)
+	}
+
+	// Delete every other node, then the first ones
+	var kept []StreamID
+	for i, id := range ids {
+		if i%2 == 0 {
+			kept = append(kept, id)
+			continue
+		}
+		if l.delete(id) == nil {
+			t.Fatalf("expected %v to be deleted", id)
+		}
+	}
+	if l.delete(ids[1]) != nil {
+		t.Errorf("expected %v to be already deleted", ids[1])
+	}
+	checkIDList(t, l, kept)
+	if n := l.deleteFirst(10); n != 10 {
+		t.Errorf("expected 10 nodes deleted, got %d", n)
+	}
+	checkIDList(t, l, kept[10:])
+	if n := l.deleteFirst(1000); n != int64(len(kept)-10) {
+		t.Errorf("expected %d nodes deleted, got %d", len(kept)-10, n)
+	}
+	checkIDList(t, l, nil)
+	if l.first() != nil || l.tail != nil || l.level != 1 {
+		t.Errorf("expected an empty list")
+	}
+}
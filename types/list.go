@@ -9,6 +9,7 @@ type List interface {
 	LInsertAfter(string, string) int64
 	LLen() int64
 	LPop() (string, bool)
+	LPos(string, int64, int64, int64) []int64
 	LPush(...string) int64
 	LRange(int64, int64) []string
 	LRem(int64, string) int64
@@ -18,38 +19,91 @@ type List interface {
 	RPush(...string) int64
 }
 
-// initListCap is the initial capacity of the list.
-const initListCap int = 10
+// listNodeSize is the maximum number of values held by a node of the list.
+const listNodeSize = 128
+
+// listNodeMinSize is the number of values the storage of a node can hold
+// when it is first allocated. It grows up to listNodeSize values.
+const listNodeMinSize = 8
+
+// listNodeMerge is the number of values that neighbouring nodes may hold
+// together, at most, to be merged once values are removed or inserted.
+const listNodeMerge = listNodeSize * 3 / 4
 
 // Static type check to validate that *list implements List.
 var _ List = (*list)(nil)
 
-// list is the internal type that implements List.
-type list []string
+// listNode is a node of the list. Its values are stored in vals[start:end],
+// so that values can be pushed and popped at both ends of the node without
+// moving the others. The storage is allocated as values are added.
+type listNode struct {
+	prev, next *listNode
+	start, end int
+	vals       []string
+}
+
+// len returns the number of values in the node.
+func (n *listNode) len() int {
+	return n.end - n.start
+}
+
+// reserve makes room for k values at the start of the node if front is
+// true, or at its end otherwise. The node must hold at most listNodeSize-k
+// values. The storage doubles until it holds listNodeSize values, so that
+// it is at most half full when the values get moved to its other end.
+func (n *listNode) reserve(k int, front bool) {
+	if front && n.start >= k || !front && len(n.vals)-n.end >= k {
+		return
+	}
+	ln, need := n.len(), 2*(n.len()+k)
+	vals := n.vals
+	grow := len(vals) < listNodeSize && len(vals) < need
+	if grow {
+		size := listNodeMinSize
+		for size < need && size < listNodeSize {
+			size *= 2
+		}
+		vals = make([]string, size)
+	}
+	off := 0
+	if front {
+		off = len(vals) - ln
+	}
+	copy(vals[off:off+ln], n.vals[n.start:n.end])
+	if !grow {
+		clearVals(vals[:off])
+		clearVals(vals[off+ln:])
+	}
+	n.vals, n.start, n.end = vals, off, off+ln
+}
+
+// list is the internal type that implements List. It is a doubly-linked
+// list of nodes that each hold a chunk of the values, so that values are
+// pushed and popped at both ends in constant time.
+type list struct {
+	head, tail *listNode
+	ln         int64
+}
 
 // NewList creates a new List.
 func NewList() List {
-	l := make(list, 0, initListCap)
-	return &l
+	return &list{}
 }
 
-// TODO : Must set zero value to elements of a slice before slicing
-// them out (i.e. before `sl = sl[1:]`). Will leak memory otherwise.
-
 // Type returns the type of this value, which is "list".
-func (l list) Type() string {
+func (l *list) Type() string {
 	return "list"
 }
 
 // LIndex returns the value at index ix. It returns false as second
 // return value if index is out of bounds.
 func (l *list) LIndex(ix int64) (string, bool) {
-	ln := int64(len(*l))
 	if ix < 0 {
-		ix += ln
+		ix += l.ln
 	}
-	if ix >= 0 && ix < ln {
-		return (*l)[ix], true
+	if ix >= 0 && ix < l.ln {
+		n, i := l.locate(ix)
+		return n.vals[i], true
 	}
 	return "", false
 }
@@ -57,15 +111,12 @@ func (l *list) LIndex(ix int64) (string, bool) {
 // LInsertBefore inserts val in the list before the pivot value. It returns
 // the new length of the list, or -1 if the pivot value was not found.
 func (l *list) LInsertBefore(pivot, val string) int64 {
-	for i := 0; i < len(*l); i++ {
-		if (*l)[i] == pivot {
-			// Append a dummy value so that there's enough room in the slice
-			*l = append(*l, "")
-			// Copy all elements starting at pivot, one element to the right
-			copy((*l)[i+1:], (*l)[i:])
-			// Insert the new element at i
-			(*l)[i] = val
-			return int64(len(*l))
+	for n := l.head; n != nil; n = n.next {
+		for i := n.start; i < n.end; i++ {
+			if n.vals[i] == pivot {
+				l.insert(n, i, val)
+				return l.ln
+			}
 		}
 	}
 	return -1
@@ -74,15 +125,12 @@ func (l *list) LInsertBefore(pivot, val string) int64 {
 // LInsertAfter inserts val in the list after the pivot value. It returns
 // the new length of the list, or -1 if the pivot value was not found.
 func (l *list) LInsertAfter(pivot, val string) int64 {
-	for i := 0; i < len(*l); i++ {
-		if (*l)[i] == pivot {
-			// Append a dummy value so that there's enough room in the slice
-			*l = append(*l, "")
-			// Copy all elements starting at pivot, one element to the right
-			copy((*l)[i+2:], (*l)[i+1:])
-			// Insert the new element at i+1
-			(*l)[i+1] = val
-			return int64(len(*l))
+	for n := l.head; n != nil; n = n.next {
+		for i := n.start; i < n.end; i++ {
+			if n.vals[i] == pivot {
+				l.insert(n, i+1, val)
+				return l.ln
+			}
 		}
 	}
 	return -1
@@ -90,30 +138,69 @@ func (l *list) LInsertAfter(pivot, val string) int64 {
 
 // LLen returns the length of the list.
 func (l *list) LLen() int64 {
-	return int64(len(*l))
+	return l.ln
 }
 
 // LPop pops a value from the head of the list and returns it. It returns false
 // as second value if it could not return a value.
 func (l *list) LPop() (string, bool) {
-	if len(*l) == 0 {
+	n := l.head
+	if n == nil {
 		return "", false
 	}
-	val := (*l)[0]
-	(*l)[0] = ""
-	*l = (*l)[1:]
+	val := n.vals[n.start]
+	n.vals[n.start] = ""
+	n.start++
+	l.ln--
+	if n.len() == 0 {
+		l.unlink(n)
+	}
 	return val, true
 }
 
+// LPos returns the indices of at most count occurrences of val, or all of
+// them if count is 0, starting at the rank-th occurrence. If rank is
+// negative, the list is scanned from its tail. At most maxLen values are
+// compared, or all of them if maxLen is 0.
+func (l *list) LPos(val string, rank, count, maxLen int64) []int64 {
+	ret := []int64{}
+	reverse := rank < 0
+	if reverse {
+		rank = -rank
+	}
+	var cmp int64
+	l.walk(reverse, func(v string, ix int64) bool {
+		if maxLen > 0 && cmp >= maxLen {
+			return false
+		}
+		cmp++
+		if v != val {
+			return true
+		}
+		if rank--; rank > 0 {
+			return true
+		}
+		ret = append(ret, ix)
+		return count == 0 || int64(len(ret)) < count
+	})
+	return ret
+}
+
 // LPush pushes the provided values on the head of the list. It returns the new
 // length of the list.
 func (l *list) LPush(vals ...string) int64 {
-	// Reverse vals, then append
-	for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
-		vals[i], vals[j] = vals[j], vals[i]
+	for _, val := range vals {
+		n := l.head
+		if n == nil || n.len() == listNodeSize {
+			n = &listNode{}
+			l.link(nil, n)
+		}
+		n.reserve(1, true)
+		n.start--
+		n.vals[n.start] = val
 	}
-	*l = append(vals, *l...)
-	return int64(len(*l))
+	l.ln += int64(len(vals))
+	return l.ln
 }
 
 // LRange returns the values in the list between start and stop.
@@ -122,96 +209,172 @@ func (l *list) LRange(start, stop int64) []string {
 	if stop-start < 0 {
 		return empty
 	}
-	return (*l)[start : stop+1]
+	ret := make([]string, 0, stop-start+1)
+	n, i := l.locate(start)
+	for len(ret) < cap(ret) {
+		end := n.end
+		if rest := cap(ret) - len(ret); end-i > rest {
+			end = i + rest
+		}
+		ret = append(ret, n.vals[i:end]...)
+		if n = n.next; n != nil {
+			i = n.start
+		}
+	}
+	return ret
 }
 
 // LRem removes up to cnt occurrences of val from the list. If cnt is
 // negative, it starts from the tail of the list. If cnt is 0, all
 // occurrences of val are removed. It returns the number of occurrences
-// that were removed.
+// that were removed. The nodes left under-filled are merged with their
+// neighbours.
 func (l *list) LRem(cnt int64, val string) int64 {
-	var n int64
-	ln := len(*l)
+	var rem int64
 	if cnt >= 0 {
-		for i := 0; i < ln; i++ {
-			if (*l)[i] == val {
-				l.del(i)
-				i--
-				ln--
-				n++
-				if cnt > 0 && n >= cnt {
-					break
+		n := l.head
+		for n != nil && (cnt == 0 || rem < cnt) {
+			next := n.next
+			// Compact the remaining values towards the start of the node
+			w := n.start
+			for r := n.start; r < n.end; r++ {
+				if n.vals[r] == val && (cnt == 0 || rem < cnt) {
+					rem++
+					continue
 				}
+				n.vals[w] = n.vals[r]
+				w++
+			}
+			clearVals(n.vals[w:n.end])
+			n.end = w
+			if n.len() == 0 {
+				l.unlink(n)
+			} else if n.prev != nil {
+				l.merge(n.prev, n)
 			}
+			n = next
+		}
+		if n != nil && n.prev != nil {
+			l.merge(n.prev, n)
 		}
 	} else {
 		cnt *= -1
-		for i := ln - 1; i >= 0; i-- {
-			if (*l)[i] == val {
-				l.del(i)
-				i--
-				n++
-				if n >= cnt {
-					break
+		n := l.tail
+		for n != nil && rem < cnt {
+			prev := n.prev
+			// Compact the remaining values towards the end of the node
+			w := n.end
+			for r := n.end - 1; r >= n.start; r-- {
+				if n.vals[r] == val && rem < cnt {
+					rem++
+					continue
 				}
+				w--
+				n.vals[w] = n.vals[r]
 			}
+			clearVals(n.vals[n.start:w])
+			n.start = w
+			if n.len() == 0 {
+				l.unlink(n)
+			} else if n.next != nil {
+				l.merge(n, n.next)
+			}
+			n = prev
+		}
+		if n != nil && n.next != nil {
+			l.merge(n, n.next)
 		}
 	}
-	return n
-}
-
-func (l *list) del(ix int) {
-	copy((*l)[ix:], (*l)[ix+1:])
-	(*l)[len(*l)-1] = ""
-	*l = (*l)[:len(*l)-1]
+	l.ln -= rem
+	return rem
 }
 
 // LSet sets the value at index ix to val. It returns false if the index
 // is out of bounds.
 func (l *list) LSet(ix int64, val string) bool {
-	ln := int64(len(*l))
 	if ix < 0 {
-		ix += ln
+		ix += l.ln
 	}
-	if ix >= 0 && ix < ln {
-		(*l)[ix] = val
+	if ix >= 0 && ix < l.ln {
+		n, i := l.locate(ix)
+		n.vals[i] = val
 		return true
 	}
 	return false
 }
 
-// LTrim returns the number of elements remaining in the list, so that
-// the containing key can know if it should be deleted.
+// LTrim trims the list so that it only contains the values between start
+// and stop.
 func (l *list) LTrim(start, stop int64) {
 	start, stop = l.normalizeStartStop(start, stop)
 	if stop-start < 0 {
-		*l = (*l)[:0]
+		l.head, l.tail, l.ln = nil, nil, 0
 		return
 	}
-	*l = (*l)[start : stop+1]
+
+	// Drop the values before start, whole nodes at once
+	for cnt := start; cnt > 0; {
+		n := l.head
+		if int64(n.len()) <= cnt {
+			cnt -= int64(n.len())
+			l.unlink(n)
+			continue
+		}
+		clearVals(n.vals[n.start : n.start+int(cnt)])
+		n.start += int(cnt)
+		cnt = 0
+	}
+	// Drop the values after stop
+	for cnt := l.ln - 1 - stop; cnt > 0; {
+		n := l.tail
+		if int64(n.len()) <= cnt {
+			cnt -= int64(n.len())
+			l.unlink(n)
+			continue
+		}
+		clearVals(n.vals[n.end-int(cnt) : n.end])
+		n.end -= int(cnt)
+		cnt = 0
+	}
+	l.ln = stop - start + 1
 }
 
 // RPop pops a value from the tail of the list and returns it. It returns false
 // as second value if it could not return a value.
 func (l *list) RPop() (string, bool) {
-	if len(*l) == 0 {
+	n := l.tail
+	if n == nil {
 		return "", false
 	}
-	val := (*l)[len(*l)-1]
-	(*l)[len(*l)-1] = ""
-	*l = (*l)[:len(*l)-1]
+	n.end--
+	val := n.vals[n.end]
+	n.vals[n.end] = ""
+	l.ln--
+	if n.len() == 0 {
+		l.unlink(n)
+	}
 	return val, true
 }
 
 // RPush pushes the provided values on the tail of the list. It returns the new
 // length of the list.
 func (l *list) RPush(vals ...string) int64 {
-	*l = append(*l, vals...)
-	return int64(len(*l))
+	for _, val := range vals {
+		n := l.tail
+		if n == nil || n.len() == listNodeSize {
+			n = &listNode{}
+			l.link(l.tail, n)
+		}
+		n.reserve(1, false)
+		n.vals[n.end] = val
+		n.end++
+	}
+	l.ln += int64(len(vals))
+	return l.ln
 }
 
 func (l *list) normalizeStartStop(start, stop int64) (int64, int64) {
-	ln := int64(len(*l))
+	ln := l.ln
 	if start < 0 {
 		start += ln
 	}
@@ -226,3 +389,145 @@ func (l *list) normalizeStartStop(start, stop int64) (int64, int64) {
 	}
 	return start, stop
 }
+
+// locate returns the node and the offset in this node of the value at index
+// ix, which must be in bounds. The nodes are scanned from the nearest end
+// of the list.
+func (l *list) locate(ix int64) (*listNode, int) {
+	if ix < l.ln/2 {
+		n := l.head
+		for ix >= int64(n.len()) {
+			ix -= int64(n.len())
+			n = n.next
+		}
+		return n, n.start + int(ix)
+	}
+	n := l.tail
+	for ix = l.ln - 1 - ix; ix >= int64(n.len()); n = n.prev {
+		ix -= int64(n.len())
+	}
+	return n, n.end - 1 - int(ix)
+}
+
+// walk calls fn with each value of the list and its index, from the head of
+// the list, or from its tail if reverse is true, until fn returns false.
+func (l *list) walk(reverse bool, fn func(string, int64) bool) {
+	if reverse {
+		ix := l.ln - 1
+		for n := l.tail; n != nil; n = n.prev {
+			for i := n.end - 1; i >= n.start; i, ix = i-1, ix-1 {
+				if !fn(n.vals[i], ix) {
+					return
+				}
+			}
+		}
+		return
+	}
+	var ix int64
+	for n := l.head; n != nil; n = n.next {
+		for i := n.start; i < n.end; i, ix = i+1, ix+1 {
+			if !fn(n.vals[i], ix) {
+				return
+			}
+		}
+	}
+}
+
+// insert inserts val in the node n at offset i, moving the values from
+// offset i one position to the right. A full node is split in two halves,
+// which are merged with their neighbours if they are under-filled.
+func (l *list) insert(n *listNode, i int, val string) {
+	l.ln++
+	if n.len() < listNodeSize {
+		if n.end == len(n.vals) && n.start > 0 {
+			// No room at the end, move the values before i to the left
+			copy(n.vals[n.start-1:i-1], n.vals[n.start:i])
+			n.start--
+			n.vals[i-1] = val
+			return
+		}
+		i -= n.start
+		n.reserve(1, false)
+		i += n.start
+		copy(n.vals[i+1:n.end+1], n.vals[i:n.end])
+		n.vals[i] = val
+		n.end++
+		return
+	}
+
+	// Move the second half of the values to a new node
+	mid := listNodeSize / 2
+	m := &listNode{vals: make([]string, listNodeSize), end: listNodeSize - mid}
+	copy(m.vals, n.vals[mid:])
+	clearVals(n.vals[mid:])
+	n.end = mid
+	l.link(n, m)
+	dst := n
+	if i > mid {
+		dst, i = m, i-mid
+	}
+	copy(dst.vals[i+1:dst.end+1], dst.vals[i:dst.end])
+	dst.vals[i] = val
+	dst.end++
+
+	if m.next != nil {
+		l.merge(m, m.next)
+	}
+	if n.prev != nil {
+		l.merge(n.prev, n)
+	}
+}
+
+// merge moves the values of the node n to its previous node prev, and
+// removes n from the list, if they hold at most listNodeMerge values
+// together.
+func (l *list) merge(prev, n *listNode) {
+	if prev.len()+n.len() > listNodeMerge {
+		return
+	}
+	prev.reserve(n.len(), false)
+	copy(prev.vals[prev.end:], n.vals[n.start:n.end])
+	prev.end += n.len()
+	l.unlink(n)
+}
+
+// link inserts the node n after the node prev, or at the head of the list
+// if prev is nil.
+func (l *list) link(prev, n *listNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+}
+
+// unlink removes the node n from the list.
+func (l *list) unlink(n *listNode) {
+	if n.prev == nil {
+		l.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// clearVals sets the values to the zero value, so that the strings they
+// hold can be garbage-collected.
+func clearVals(vals []string) {
+	for i := range vals {
+		vals[i] = ""
+	}
+}
@@ -1,10 +1,28 @@
 package types
 
 import (
+	"math/rand"
 	"reflect"
+	"strconv"
 	"testing"
 )
 
+// newTestList creates a list that holds the values vals.
+func newTestList(vals []string) *list {
+	l := &list{}
+	l.RPush(vals...)
+	return l
+}
+
+// equalVals returns true if both slices hold the same values, a nil slice
+// being equal to an empty one.
+func equalVals(got, exp []string) bool {
+	if len(got) == 0 && len(exp) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(got, exp)
+}
+
 func TestListLIndex(t *testing.T) {
 	cases := []struct {
 		l   []string
@@ -24,7 +42,7 @@ func TestListLIndex(t *testing.T) {
 		9: {[]string{"a", "b", "c"}, -4, "", false},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got, ok := l.LIndex(c.ix)
 		if got != c.exp {
 			t.Errorf("%d: expected %q, got %q", i, c.exp, got)
@@ -46,7 +64,7 @@ func TestListLLen(t *testing.T) {
 		3: {[]string{"a", "b", "c"}, 3},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.LLen()
 		if got != c.exp {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
@@ -68,13 +86,13 @@ func TestListLPush(t *testing.T) {
 		5: {[]string{}, []string{"c", "b", "a"}, []string{"a", "b", "c"}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.LPush(c.vals...)
 		if got != int64(len(c.exp)) {
 			t.Errorf("%d: expected length of %d, got %d", i, len(c.exp), got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if got := l.LRange(0, -1); !equalVals(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
 }
@@ -95,14 +113,14 @@ func TestListLInsertBefore(t *testing.T) {
 		7: {[]string{"e", "d", "a", "c", "b", "a"}, "a", "z", 7, 2},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.LInsertBefore(c.piv, c.val)
 		if got != c.exp {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
 		if c.at >= 0 {
-			if l[c.at] != c.val {
-				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, l[c.at])
+			if v, _ := l.LIndex(c.at); v != c.val {
+				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, v)
 			}
 		}
 	}
@@ -123,14 +141,14 @@ func TestListLInsertAfter(t *testing.T) {
 		6: {[]string{"a", "b", "c"}, "c", "z", 4, 3},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.LInsertAfter(c.piv, c.val)
 		if got != c.exp {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
 		if c.at >= 0 {
-			if l[c.at] != c.val {
-				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, l[c.at])
+			if v, _ := l.LIndex(c.at); v != c.val {
+				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, v)
 			}
 		}
 	}
@@ -148,7 +166,7 @@ func TestListLPop(t *testing.T) {
 		3: {[]string{"a", "b", "c"}, "a", true},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		orilen := len(c.l)
 		got, ok := l.LPop()
 		if got != c.exp {
@@ -165,6 +183,31 @@ func TestListLPop(t *testing.T) {
 	}
 }
 
+func TestListLPos(t *testing.T) {
+	l := newTestList([]string{"a", "b", "c", "a", "b", "a"})
+	cases := []struct {
+		val                 string
+		rank, count, maxLen int64
+		exp                 []int64
+	}{
+		0: {"a", 1, 1, 0, []int64{0}},
+		1: {"a", 1, 0, 0, []int64{0, 3, 5}},
+		2: {"a", 2, 0, 0, []int64{3, 5}},
+		3: {"a", -1, 2, 0, []int64{5, 3}},
+		4: {"a", -3, 0, 0, []int64{0}},
+		5: {"a", 4, 0, 0, []int64{}},
+		6: {"b", 1, 0, 4, []int64{1}},
+		7: {"b", -1, 0, 2, []int64{4}},
+		8: {"z", 1, 0, 0, []int64{}},
+	}
+	for i, c := range cases {
+		got := l.LPos(c.val, c.rank, c.count, c.maxLen)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
 func TestListLRange(t *testing.T) {
 	cases := []struct {
 		l           []string
@@ -183,7 +226,7 @@ func TestListLRange(t *testing.T) {
 		9: {[]string{"a", "b", "c"}, 17, -18, []string{}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.LRange(c.start, c.stop)
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
@@ -211,13 +254,13 @@ func TestListLRem(t *testing.T) {
 		10: {[]string{"a", "z", "c", "z"}, "a", -4, 1, []string{"z", "c", "z"}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.LRem(c.cnt, c.val)
 		if got != c.n {
 			t.Errorf("%d: expected %d elements removed, got %d", i, c.n, got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if got := l.LRange(0, -1); !equalVals(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
 }
@@ -243,13 +286,13 @@ func TestListLSet(t *testing.T) {
 		10: {[]string{"a", "b", "c"}, "z", -4, []string{"a", "b", "c"}, false},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.LSet(c.ix, c.val)
 		if got != c.res {
 			t.Errorf("%d: expected %v, got %v", i, c.res, got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if got := l.LRange(0, -1); !equalVals(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
 }
@@ -275,10 +318,10 @@ func TestListLTrim(t *testing.T) {
 		12: {[]string{"a", "b", "c"}, -15, -13, []string{}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		l.LTrim(c.start, c.stop)
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if got := l.LRange(0, -1); !equalVals(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
 }
@@ -297,13 +340,13 @@ func TestListRPush(t *testing.T) {
 		5: {[]string{}, []string{"c", "b", "a"}, []string{"c", "b", "a"}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		got := l.RPush(c.vals...)
 		if got != int64(len(c.exp)) {
 			t.Errorf("%d: expected length of %d, got %d", i, len(c.exp), got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if got := l.LRange(0, -1); !equalVals(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
 }
@@ -320,7 +363,7 @@ func TestListRPop(t *testing.T) {
 		3: {[]string{"a", "b", "c"}, "c", true},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := newTestList(c.l)
 		orilen := len(c.l)
 		got, ok := l.RPop()
 		if got != c.exp {
@@ -344,3 +387,157 @@ func TestListType(t *testing.T) {
 		t.Errorf("expected %q, got %q", "list", tp)
 	}
 }
+
+func TestListNodes(t *testing.T) {
+	// Compare the list with a slice through operations that span many nodes
+	rnd := rand.New(rand.NewSource(1))
+	l := newTestList(nil)
+	var exp []string
+	for i := 0; i < 20000; i++ {
+		val := strconv.Itoa(rnd.Intn(1000))
+		switch op := rnd.Intn(10); op {
+		case 0, 1:
+			l.LPush(val)
+			exp = append([]string{val}, exp...)
+		case 2, 3:
+			l.RPush(val, val)
+			exp = append(exp, val, val)
+		case 4:
+			got, _ := l.LPop()
+			if len(exp) > 0 {
+				if got != exp[0] {
+					t.Fatalf("%d: lpop: expected %q, got %q", i, exp[0], got)
+				}
+				exp = exp[1:]
+			}
+		case 5:
+			got, _ := l.RPop()
+			if len(exp) > 0 {
+				if got != exp[len(exp)-1] {
+					t.Fatalf("%d: rpop: expected %q, got %q", i, exp[len(exp)-1], got)
+				}
+				exp = exp[:len(exp)-1]
+			}
+		case 6:
+			if len(exp) > 0 {
+				ix := rnd.Intn(len(exp))
+				l.LInsertBefore(exp[ix], val)
+				for j := range exp {
+					if exp[j] == exp[ix] {
+						exp = append(exp[:j], append([]string{val}, exp[j:]...)...)
+						break
+					}
+				}
+			}
+		case 7:
+			cnt := int64(rnd.Intn(5) - 2)
+			l.LRem(cnt, val)
+			exp = remVals(exp, cnt, val)
+		case 8:
+			if len(exp) > 0 {
+				ix := rnd.Intn(len(exp))
+				if got, _ := l.LIndex(int64(ix)); got != exp[ix] {
+					t.Fatalf("%d: lindex %d: expected %q, got %q", i, ix, exp[ix], got)
+				}
+				l.LSet(int64(-ix-1), val)
+				exp[len(exp)-ix-1] = val
+			}
+		case 9:
+			if rnd.Intn(20) == 0 {
+				start := int64(rnd.Intn(200))
+				l.LTrim(start, -start-1)
+				if int(2*start) >= len(exp) {
+					exp = nil
+				} else {
+					exp = append([]string(nil), exp[start:int64(len(exp))-start]...)
+				}
+			}
+		}
+
+		if l.LLen() != int64(len(exp)) {
+			t.Fatalf("%d: expected length %d, got %d", i, len(exp), l.LLen())
+		}
+		if i%100 == 0 {
+			var ln int64
+			for n := l.head; n != nil; n = n.next {
+				if n.len() == 0 || len(n.vals) > listNodeSize {
+					t.Fatalf("%d: invalid node of %d values, storage of %d", i, n.len(), len(n.vals))
+				}
+				ln += int64(n.len())
+			}
+			if ln != l.LLen() {
+				t.Fatalf("%d: expected %d values in nodes, got %d", i, l.LLen(), ln)
+			}
+			if got := l.LRange(0, -1); !equalVals(got, exp) {
+				t.Fatalf("%d: expected %v, got %v", i, exp, got)
+			}
+			if len(exp) > 2 {
+				start, stop := int64(len(exp)/3), int64(-len(exp)/3)
+				if got := l.LRange(start, stop); !equalVals(got, exp[start:int64(len(exp))+stop+1]) {
+					t.Fatalf("%d: lrange %d %d: expected %v, got %v", i, start, stop, exp[start:int64(len(exp))+stop+1], got)
+				}
+			}
+		}
+	}
+}
+
+func TestListNodesMerge(t *testing.T) {
+	// The storage of a node grows as values are added
+	l := newTestList([]string{"a", "b"})
+	if n := len(l.head.vals); n != listNodeMinSize {
+		t.Errorf("expected storage of %d values, got %d", listNodeMinSize, n)
+	}
+	l.LPush(make([]string, listNodeSize)...)
+	for n := l.head; n != nil; n = n.next {
+		if len(n.vals) > listNodeSize {
+			t.Errorf("expected storage of at most %d values, got %d", listNodeSize, len(n.vals))
+		}
+	}
+
+	// Removing values merges the neighbouring nodes that fit in one
+	vals := make([]string, 10*listNodeSize)
+	for i := range vals {
+		vals[i] = strconv.Itoa(i % 10)
+	}
+	l = newTestList(vals)
+	for i := 1; i < 10; i++ {
+		l.LRem(0, strconv.Itoa(i))
+		for n := l.head; n.next != nil; n = n.next {
+			if n.len()+n.next.len() <= listNodeMerge {
+				t.Fatalf("%d: expected nodes of %d and %d values to be merged", i, n.len(), n.next.len())
+			}
+		}
+	}
+	exp := make([]string, len(vals)/10)
+	for i := range exp {
+		exp[i] = "0"
+	}
+	if got := l.LRange(0, -1); !equalVals(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+// remVals removes up to cnt occurrences of val from vals, as LRem does.
+func remVals(vals []string, cnt int64, val string) []string {
+	var n int64
+	if cnt >= 0 {
+		ret := vals[:0]
+		for _, v := range vals {
+			if v == val && (cnt == 0 || n < cnt) {
+				n++
+				continue
+			}
+			ret = append(ret, v)
+		}
+		return ret
+	}
+	var ret []string
+	for i := len(vals) - 1; i >= 0; i-- {
+		if vals[i] == val && n < -cnt {
+			n++
+			continue
+		}
+		ret = append([]string{vals[i]}, ret...)
+	}
+	return ret
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"math/rand"
+
+	"github.com/PuerkitoBio/gred/dict"
+)
+
 // Set defines the methods required to implement a Set.
 type Set interface {
 	Value
@@ -10,19 +16,24 @@ type Set interface {
 	SInter(...Set) []string
 	SIsMember(string) bool
 	SMembers() []string
+	SPop(int64) []string
+	SRandMember(int64) []string
 	SRem(...string) int64
 	SUnion(...Set) []string
+	SScan(uint64, func(string)) uint64
 }
 
-// Static type check to validate that *set implements Set.
-var _ Set = (*set)(nil)
+// Static type check to validate that set implements Set.
+var _ Set = set{}
 
 // set is the internal implementation of a Set.
-type set map[string]struct{}
+type set struct {
+	d *dict.Dict
+}
 
 // NewSet creates a new Set.
 func NewSet() Set {
-	return make(set)
+	return set{dict.New()}
 }
 
 // Type returns the type of the value, which is "set".
@@ -36,8 +47,7 @@ func (s set) SAdd(vals ...string) int64 {
 	var cnt int64
 
 	for _, v := range vals {
-		if _, ok := s[v]; !ok {
-			s[v] = struct{}{}
+		if s.d.Set(v, nil) {
 			cnt++
 		}
 	}
@@ -46,63 +56,99 @@ func (s set) SAdd(vals ...string) int64 {
 
 // SCard returns the number of elements in the set.
 func (s set) SCard() int64 {
-	return int64(len(s))
+	return int64(s.d.Len())
 }
 
 // SDiff returns the elements found in the set that are not
 // found in the other sets specified by vals.
 func (s set) SDiff(vals ...Set) []string {
-	var ok bool
-
 	ret := []string{}
-	for k := range s {
-		ok = true
+	s.d.Each(func(k string, _ interface{}) bool {
 		for _, other := range vals {
-			if ex := other.SIsMember(k); ex {
-				ok = false
-				break
+			if other.SIsMember(k) {
+				return true
 			}
 		}
-		if ok {
-			ret = append(ret, k)
-		}
-	}
+		ret = append(ret, k)
+		return true
+	})
 	return ret
 }
 
 // SInter returns the intersection of the sets.
 func (s set) SInter(vals ...Set) []string {
-	var ok bool
-
 	ret := []string{}
-	for k := range s {
-		ok = true
+	s.d.Each(func(k string, _ interface{}) bool {
 		for _, other := range vals {
-			if ex := other.SIsMember(k); !ex {
-				ok = false
-				break
+			if !other.SIsMember(k) {
+				return true
 			}
 		}
-		if ok {
-			ret = append(ret, k)
-		}
-	}
+		ret = append(ret, k)
+		return true
+	})
 	return ret
 }
 
 // SIsMember returns true if the value val is in the set.
 func (s set) SIsMember(val string) bool {
-	_, ok := s[val]
+	_, ok := s.d.Get(val)
 	return ok
 }
 
 // SMembers returns the list of all members of the set.
 func (s set) SMembers() []string {
-	ret := make([]string, len(s))
-	i := 0
-	for k := range s {
-		ret[i] = k
-		i++
+	ret := make([]string, 0, s.d.Len())
+	s.d.Each(func(k string, _ interface{}) bool {
+		ret = append(ret, k)
+		return true
+	})
+	return ret
+}
+
+// SPop removes and returns count random members of the set, or all
+// members if the set has fewer than count members.
+func (s set) SPop(count int64) []string {
+	ret := s.SRandMember(count)
+	s.SRem(ret...)
+	return ret
+}
+
+// SRandMember returns count random members of the set. If count is
+// positive, the members are distinct, and all members are returned if the
+// set has fewer than count members. If it is negative, -count members are
+// returned, and the same member may be returned more than once.
+func (s set) SRandMember(count int64) []string {
+	n := int64(s.d.Len())
+	switch {
+	case count == 0 || n == 0:
+		return empty
+	case count < 0:
+		ret := make([]string, -count)
+		for i := range ret {
+			ret[i] = s.d.Random()
+		}
+		return ret
+	case count >= n:
+		return s.SMembers()
+	case count*3 > n:
+		// When most members are returned, it is faster to shuffle them than to
+		// pick distinct random members.
+		ret := s.SMembers()
+		for i := int64(0); i < count; i++ {
+			j := i + rand.Int63n(n-i)
+			ret[i], ret[j] = ret[j], ret[i]
+		}
+		return ret[:count]
+	}
+
+	ret := make([]string, 0, count)
+	seen := make(map[string]bool, count)
+	for int64(len(ret)) < count {
+		if m := s.d.Random(); !seen[m] {
+			seen[m] = true
+			ret = append(ret, m)
+		}
 	}
 	return ret
 }
@@ -112,8 +158,7 @@ func (s set) SMembers() []string {
 func (s set) SRem(vals ...string) int64 {
 	var cnt int64
 	for _, v := range vals {
-		if _, ok := s[v]; ok {
-			delete(s, v)
+		if s.d.Del(v) {
 			cnt++
 		}
 	}
@@ -122,15 +167,29 @@ func (s set) SRem(vals ...string) int64 {
 
 // SUnion returns the union of all sets.
 func (s set) SUnion(sets ...Set) []string {
-	ret := make(set, len(s))
-	for k := range s {
-		ret[k] = struct{}{}
+	ret := s.SMembers()
+	seen := make(map[string]bool, len(ret))
+	for _, k := range ret {
+		seen[k] = true
 	}
 	for _, otherSet := range sets {
-		m := otherSet.SMembers()
-		for _, k := range m {
-			ret[k] = struct{}{}
+		for _, k := range otherSet.SMembers() {
+			if !seen[k] {
+				seen[k] = true
+				ret = append(ret, k)
+			}
 		}
 	}
-	return ret.SMembers()
+	return ret
+}
+
+// SScan calls fn for some of the members of the set, starting at cursor,
+// and returns the cursor to use for the next call, which is 0 once all
+// members have been visited. A full iteration returns every member that
+// is present for the whole iteration, even if the set is modified between
+// calls. Members may be returned more than once.
+func (s set) SScan(cursor uint64, fn func(string)) uint64 {
+	return s.d.Scan(cursor, func(k string, _ interface{}) {
+		fn(k)
+	})
 }
@@ -2,6 +2,7 @@ package types
 
 import (
 	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -102,7 +103,7 @@ func TestSetSDiff(t *testing.T) {
 		for j, vals := range c.diffs {
 			sets[j] = setFromStrings(vals)
 		}
-		got := c.s.SDiff(sets...)
+		got := sorted(c.s.SDiff(sets...))
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
@@ -142,7 +143,7 @@ func TestSetSInter(t *testing.T) {
 		for j, vals := range c.inters {
 			sets[j] = setFromStrings(vals)
 		}
-		got := c.s.SInter(sets...)
+		got := sorted(c.s.SInter(sets...))
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
@@ -178,13 +179,86 @@ func TestSetSMembers(t *testing.T) {
 		1: {setcase, []string{"a", "b", "c"}},
 	}
 	for i, c := range cases {
-		got := c.s.SMembers()
+		got := sorted(c.s.SMembers())
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
 }
 
+func TestSetSPop(t *testing.T) {
+	set := NewSet()
+	for i := 0; i < 100; i++ {
+		set.SAdd(strconv.Itoa(i))
+	}
+	popped := make(map[string]bool)
+	for _, cnt := range []int64{0, 1, 10, 50, 100} {
+		n := set.SCard()
+		got := set.SPop(cnt)
+		exp := cnt
+		if exp > n {
+			exp = n
+		}
+		if int64(len(got)) != exp {
+			t.Errorf("%d: expected %d members, got %d", cnt, exp, len(got))
+		}
+		for _, m := range got {
+			if popped[m] {
+				t.Errorf("%d: member %s popped twice", cnt, m)
+			}
+			popped[m] = true
+			if set.SIsMember(m) {
+				t.Errorf("%d: expected %s to be removed", cnt, m)
+			}
+		}
+	}
+	if len(popped) != 100 || set.SCard() != 0 {
+		t.Errorf("expected all 100 members to be popped, got %d", len(popped))
+	}
+}
+
+func TestSetSRandMember(t *testing.T) {
+	set := NewSet()
+	for i := 0; i < 100; i++ {
+		set.SAdd(strconv.Itoa(i))
+	}
+	cases := []struct {
+		cnt      int64
+		exp      int
+		distinct bool
+	}{
+		0: {0, 0, true},
+		1: {1, 1, true},
+		2: {10, 10, true},
+		3: {50, 50, true},
+		4: {200, 100, true},
+		5: {-1, 1, false},
+		6: {-200, 200, false},
+	}
+	for i, c := range cases {
+		got := set.SRandMember(c.cnt)
+		if len(got) != c.exp {
+			t.Errorf("%d: expected %d members, got %d", i, c.exp, len(got))
+		}
+		seen := make(map[string]bool)
+		for _, m := range got {
+			if !set.SIsMember(m) {
+				t.Errorf("%d: %s is not a member", i, m)
+			}
+			if seen[m] && c.distinct {
+				t.Errorf("%d: member %s returned twice", i, m)
+			}
+			seen[m] = true
+		}
+	}
+	if n := set.SCard(); n != 100 {
+		t.Errorf("expected 100 members, got %d", n)
+	}
+	if got := setempty.SRandMember(-3); len(got) != 0 {
+		t.Errorf("expected no member, got %v", got)
+	}
+}
+
 func TestSetSRem(t *testing.T) {
 	empty := cloneSet(setempty)
 	set := cloneSet(setcase)
@@ -204,7 +278,7 @@ func TestSetSRem(t *testing.T) {
 		if got != c.exp {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
-		vals := c.s.SMembers()
+		vals := sorted(c.s.SMembers())
 		if !reflect.DeepEqual(vals, c.res) {
 			t.Errorf("%d: expected %v, got %v", i, c.res, vals)
 		}
@@ -233,7 +307,7 @@ func TestSetSUnion(t *testing.T) {
 		4: {setcase, [][]string{
 			{"e"},
 			{"d"},
-		}, []string{"a", "b", "c", "e", "d"}},
+		}, []string{"a", "b", "c", "d", "e"}},
 		5: {setcase, [][]string{
 			{"a", "b"},
 			{"b", "c"},
@@ -244,7 +318,7 @@ func TestSetSUnion(t *testing.T) {
 		for j, vals := range c.unions {
 			sets[j] = setFromStrings(vals)
 		}
-		got := c.s.SUnion(sets...)
+		got := sorted(c.s.SUnion(sets...))
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
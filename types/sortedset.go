@@ -0,0 +1,561 @@
+package types
+
+import (
+	"math"
+	"math/rand"
+)
+
+// ScoredMember is a member of a SortedSet along with its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreBound is a minimum or maximum score of a range. It is inclusive
+// unless Exclusive is true.
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// SortedSet defines the methods required to implement a SortedSet.
+type SortedSet interface {
+	Value
+
+	ZAdd(string, float64) bool
+	ZCard() int64
+	ZCount(ScoreBound, ScoreBound) int64
+	ZIncrBy(string, float64) (float64, bool)
+	ZRange(int64, int64) []ScoredMember
+	ZRangeByScore(ScoreBound, ScoreBound, int64, int64) []ScoredMember
+	ZRank(string) (int64, bool)
+	ZRem(...string) int64
+	ZRemRangeByRank(int64, int64) int64
+	ZRemRangeByScore(ScoreBound, ScoreBound) int64
+	ZRevRange(int64, int64) []ScoredMember
+	ZRevRangeByScore(ScoreBound, ScoreBound, int64, int64) []ScoredMember
+	ZRevRank(string) (int64, bool)
+	ZScore(string) (float64, bool)
+}
+
+// Static type check to validate that *sortedSet implements SortedSet.
+var _ SortedSet = (*sortedSet)(nil)
+
+// sortedSet is the internal implementation of a SortedSet. The members
+// are stored in a map for direct score lookups, and in a skip list ordered
+// by score and member for range and rank operations.
+type sortedSet struct {
+	dict map[string]float64
+	zsl  *skipList
+}
+
+// NewSortedSet creates a new SortedSet.
+func NewSortedSet() SortedSet {
+	return &sortedSet{
+		dict: make(map[string]float64),
+		zsl:  newSkipList(),
+	}
+}
+
+// Type returns the type of the value, which is "zset".
+func (z *sortedSet) Type() string {
+	return "zset"
+}
+
+// ZAdd adds the member with the specified score, or updates its score if
+// it is already in the sorted set. It returns true if the member was added.
+func (z *sortedSet) ZAdd(member string, score float64) bool {
+	cur, ok := z.dict[member]
+	if ok {
+		if cur != score {
+			z.zsl.delete(cur, member)
+			z.zsl.insert(score, member)
+			z.dict[member] = score
+		}
+		return false
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return true
+}
+
+// ZCard returns the number of members in the sorted set.
+func (z *sortedSet) ZCard() int64 {
+	return z.zsl.length
+}
+
+// ZCount returns the number of members with a score between min and max.
+func (z *sortedSet) ZCount(min, max ScoreBound) int64 {
+	first := z.zsl.firstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.lastInRange(min, max)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// ZIncrBy increments the score of the member by incr, adding the member
+// if it doesn't exist. It returns the new score, or false if the result
+// is not a number, in which case the sorted set is left unchanged.
+func (z *sortedSet) ZIncrBy(member string, incr float64) (float64, bool) {
+	score := z.dict[member] + incr
+	if math.IsNaN(score) {
+		return 0, false
+	}
+	z.ZAdd(member, score)
+	return score, true
+}
+
+// ZRange returns the members between the start and stop ranks, inclusive,
+// ordered from the lowest to the highest score. Negative ranks are relative
+// to the end of the sorted set.
+func (z *sortedSet) ZRange(start, stop int64) []ScoredMember {
+	return z.zrange(start, stop, false)
+}
+
+// ZRevRange returns the members between the start and stop ranks, inclusive,
+// ordered from the highest to the lowest score. Negative ranks are relative
+// to the end of the sorted set.
+func (z *sortedSet) ZRevRange(start, stop int64) []ScoredMember {
+	return z.zrange(start, stop, true)
+}
+
+func (z *sortedSet) zrange(start, stop int64, rev bool) []ScoredMember {
+	start, stop, ok := z.rankRange(start, stop)
+	if !ok {
+		return []ScoredMember{}
+	}
+
+	ret := make([]ScoredMember, 0, stop-start+1)
+	var n *zslNode
+	if rev {
+		n = z.zsl.byRank(z.zsl.length - start)
+	} else {
+		n = z.zsl.byRank(start + 1)
+	}
+	for i := start; i <= stop; i++ {
+		ret = append(ret, ScoredMember{n.member, n.score})
+		if rev {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+	return ret
+}
+
+// rankRange converts the start and stop ranks to positive ranks within
+// the bounds of the sorted set. It returns false if the range is empty.
+func (z *sortedSet) rankRange(start, stop int64) (int64, int64, bool) {
+	ln := z.zsl.length
+	if start < 0 {
+		start += ln
+	}
+	if stop < 0 {
+		stop += ln
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= ln {
+		return 0, 0, false
+	}
+	if stop >= ln {
+		stop = ln - 1
+	}
+	return start, stop, true
+}
+
+// ZRangeByScore returns the members with a score between min and max,
+// ordered from the lowest to the highest score. The first offset members
+// are skipped, and at most count members are returned, unless count is
+// negative.
+func (z *sortedSet) ZRangeByScore(min, max ScoreBound, offset, count int64) []ScoredMember {
+	ret := []ScoredMember{}
+	n := z.zsl.firstInRange(min, max)
+	for ; n != nil && offset > 0; offset-- {
+		n = n.level[0].forward
+	}
+	for ; n != nil && count != 0 && lteMax(n.score, max); count-- {
+		ret = append(ret, ScoredMember{n.member, n.score})
+		n = n.level[0].forward
+	}
+	return ret
+}
+
+// ZRevRangeByScore returns the members with a score between min and max,
+// ordered from the highest to the lowest score. The first offset members
+// are skipped, and at most count members are returned, unless count is
+// negative.
+func (z *sortedSet) ZRevRangeByScore(max, min ScoreBound, offset, count int64) []ScoredMember {
+	ret := []ScoredMember{}
+	n := z.zsl.lastInRange(min, max)
+	for ; n != nil && offset > 0; offset-- {
+		n = n.backward
+	}
+	for ; n != nil && count != 0 && gteMin(n.score, min); count-- {
+		ret = append(ret, ScoredMember{n.member, n.score})
+		n = n.backward
+	}
+	return ret
+}
+
+// ZRank returns the 0-based rank of the member, ordered from the lowest
+// to the highest score. It returns false if the member does not exist.
+func (z *sortedSet) ZRank(member string) (int64, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	return z.zsl.rank(score, member) - 1, true
+}
+
+// ZRevRank returns the 0-based rank of the member, ordered from the highest
+// to the lowest score. It returns false if the member does not exist.
+func (z *sortedSet) ZRevRank(member string) (int64, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	return z.zsl.length - z.zsl.rank(score, member), true
+}
+
+// ZRem removes the members from the sorted set. It returns the number
+// of members that were actually removed.
+func (z *sortedSet) ZRem(members ...string) int64 {
+	var cnt int64
+	for _, m := range members {
+		if score, ok := z.dict[m]; ok {
+			z.zsl.delete(score, m)
+			delete(z.dict, m)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// ZRemRangeByRank removes the members between the start and stop ranks,
+// inclusive. It returns the number of members removed.
+func (z *sortedSet) ZRemRangeByRank(start, stop int64) int64 {
+	start, stop, ok := z.rankRange(start, stop)
+	if !ok {
+		return 0
+	}
+	return z.zsl.deleteRangeByRank(start+1, stop+1, z.dict)
+}
+
+// ZRemRangeByScore removes the members with a score between min and max.
+// It returns the number of members removed.
+func (z *sortedSet) ZRemRangeByScore(min, max ScoreBound) int64 {
+	return z.zsl.deleteRangeByScore(min, max, z.dict)
+}
+
+// ZScore returns the score of the member. It returns false if the member
+// does not exist.
+func (z *sortedSet) ZScore(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+const (
+	// zslMaxLevel is the maximum number of levels of the skip list,
+	// enough for 2^64 elements.
+	zslMaxLevel = 32
+
+	// zslP is the probability for a node to have an additional level.
+	zslP = 0.25
+)
+
+// zslNode is a node of the skip list.
+type zslNode struct {
+	member   string
+	score    float64
+	backward *zslNode
+	level    []zslLevel
+}
+
+// zslLevel is a level of a skip list node. The span is the number of
+// nodes between the node and the forward node, used to compute ranks.
+type zslLevel struct {
+	forward *zslNode
+	span    int64
+}
+
+// skipList is a skip list ordered by score, then by member, with the
+// rank of each node computable in O(log n).
+type skipList struct {
+	header *zslNode
+	tail   *zslNode
+	length int64
+	level  int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		header: &zslNode{level: make([]zslLevel, zslMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < zslMaxLevel && rand.Float64() < zslP {
+		lvl++
+	}
+	return lvl
+}
+
+// less returns true if the node sorts before the score and member.
+func (n *zslNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// greater returns true if the node sorts after the score and member.
+func (n *zslNode) greater(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+func gteMin(v float64, min ScoreBound) bool {
+	if min.Exclusive {
+		return v > min.Value
+	}
+	return v >= min.Value
+}
+
+func lteMax(v float64, max ScoreBound) bool {
+	if max.Exclusive {
+		return v < max.Value
+	}
+	return v <= max.Value
+}
+
+// insert inserts a new node. The member must not already be in the list.
+func (zsl *skipList) insert(score float64, member string) *zslNode {
+	var update [zslMaxLevel]*zslNode
+	var rank [zslMaxLevel]int64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	lvl := randomLevel()
+	if lvl > zsl.level {
+		for i := zsl.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = lvl
+	}
+
+	x = &zslNode{member: member, score: score, level: make([]zslLevel, lvl)}
+	for i := 0; i < lvl; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// Increment the span of the untouched levels
+	for i := lvl; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// deleteNode unlinks the node x, update holds the nodes preceding x
+// at each level.
+func (zsl *skipList) deleteNode(x *zslNode, update []*zslNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// delete removes the node with the score and member. It returns true if
+// the node was found.
+func (zsl *skipList) delete(score float64, member string) bool {
+	var update [zslMaxLevel]*zslNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x != nil && x.score == score && x.member == member {
+		zsl.deleteNode(x, update[:])
+		return true
+	}
+	return false
+}
+
+// rank returns the 1-based rank of the node with the score and member,
+// or 0 if it is not found.
+func (zsl *skipList) rank(score float64, member string) int64 {
+	var rank int64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.greater(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank, or nil if it is out of
+// range.
+func (zsl *skipList) byRank(rank int64) *zslNode {
+	var traversed int64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// isInRange returns true if some part of the list is in the score range.
+func (zsl *skipList) isInRange(min, max ScoreBound) bool {
+	if min.Value > max.Value || (min.Value == max.Value && (min.Exclusive || max.Exclusive)) {
+		return false
+	}
+	if zsl.tail == nil || !gteMin(zsl.tail.score, min) {
+		return false
+	}
+	return lteMax(zsl.header.level[0].forward.score, max)
+}
+
+// firstInRange returns the first node in the score range, or nil.
+func (zsl *skipList) firstInRange(min, max ScoreBound) *zslNode {
+	if !zsl.isInRange(min, max) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !gteMin(x.level[i].forward.score, min) {
+			x = x.level[i].forward
+		}
+	}
+	// This is an inner range, so the next node cannot be nil
+	x = x.level[0].forward
+	if !lteMax(x.score, max) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last node in the score range, or nil.
+func (zsl *skipList) lastInRange(min, max ScoreBound) *zslNode {
+	if !zsl.isInRange(min, max) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && lteMax(x.level[i].forward.score, max) {
+			x = x.level[i].forward
+		}
+	}
+	// This is an inner range, so this node cannot be the header
+	if !gteMin(x.score, min) {
+		return nil
+	}
+	return x
+}
+
+// deleteRangeByScore removes the nodes in the score range, and their
+// members from dict. It returns the number of nodes removed.
+func (zsl *skipList) deleteRangeByScore(min, max ScoreBound, dict map[string]float64) int64 {
+	var update [zslMaxLevel]*zslNode
+	var removed int64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !gteMin(x.level[i].forward.score, min) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	for x != nil && lteMax(x.score, max) {
+		next := x.level[0].forward
+		zsl.deleteNode(x, update[:])
+		delete(dict, x.member)
+		removed++
+		x = next
+	}
+	return removed
+}
+
+// deleteRangeByRank removes the nodes between the 1-based start and stop
+// ranks, inclusive, and their members from dict. It returns the number of
+// nodes removed.
+func (zsl *skipList) deleteRangeByRank(start, stop int64, dict map[string]float64) int64 {
+	var update [zslMaxLevel]*zslNode
+	var traversed, removed int64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	traversed++
+	x = x.level[0].forward
+	for x != nil && traversed <= stop {
+		next := x.level[0].forward
+		zsl.deleteNode(x, update[:])
+		delete(dict, x.member)
+		removed++
+		traversed++
+		x = next
+	}
+	return removed
+}
@@ -0,0 +1,275 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var (
+	inf    = ScoreBound{Value: math.Inf(1)}
+	negInf = ScoreBound{Value: math.Inf(-1)}
+)
+
+func incl(v float64) ScoreBound { return ScoreBound{Value: v} }
+func excl(v float64) ScoreBound { return ScoreBound{Value: v, Exclusive: true} }
+
+// zsetcase holds a=1, b=2, c=2, d=3, e=5.
+func newZSetCase() SortedSet {
+	z := NewSortedSet()
+	z.ZAdd("c", 2)
+	z.ZAdd("e", 5)
+	z.ZAdd("a", 1)
+	z.ZAdd("d", 3)
+	z.ZAdd("b", 2)
+	return z
+}
+
+func members(sms []ScoredMember) []string {
+	ret := make([]string, len(sms))
+	for i, sm := range sms {
+		ret[i] = sm.Member
+	}
+	return ret
+}
+
+func TestSortedSetType(t *testing.T) {
+	tp := NewSortedSet().Type()
+	if tp != "zset" {
+		t.Errorf("expected %q, got %q", "zset", tp)
+	}
+}
+
+func TestSortedSetZAdd(t *testing.T) {
+	z := NewSortedSet()
+	cases := []struct {
+		member string
+		score  float64
+		exp    bool
+		card   int64
+	}{
+		0: {"a", 1, true, 1},
+		1: {"b", 1, true, 2},
+		2: {"a", 1, false, 2},
+		3: {"a", 3, false, 2},
+		4: {"c", -1, true, 3},
+	}
+	for i, c := range cases {
+		got := z.ZAdd(c.member, c.score)
+		if got != c.exp {
+			t.Errorf("%d: expected %t, got %t", i, c.exp, got)
+		}
+		if card := z.ZCard(); card != c.card {
+			t.Errorf("%d: expected cardinality %d, got %d", i, c.card, card)
+		}
+		if sc, _ := z.ZScore(c.member); sc != c.score {
+			t.Errorf("%d: expected score %f, got %f", i, c.score, sc)
+		}
+	}
+	if got := members(z.ZRange(0, -1)); !reflect.DeepEqual(got, []string{"c", "b", "a"}) {
+		t.Errorf("expected [c b a], got %v", got)
+	}
+}
+
+func TestSortedSetZCount(t *testing.T) {
+	z := newZSetCase()
+	cases := []struct {
+		min, max ScoreBound
+		exp      int64
+	}{
+		0: {negInf, inf, 5},
+		1: {incl(2), incl(2), 2},
+		2: {excl(2), incl(2), 0},
+		3: {incl(2), excl(5), 3},
+		4: {excl(1), excl(5), 3},
+		5: {incl(6), inf, 0},
+		6: {negInf, excl(1), 0},
+		7: {incl(4), incl(3), 0},
+	}
+	for i, c := range cases {
+		got := z.ZCount(c.min, c.max)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestSortedSetZIncrBy(t *testing.T) {
+	z := newZSetCase()
+	if v, ok := z.ZIncrBy("a", 2.5); !ok || v != 3.5 {
+		t.Errorf("expected 3.5, got %f (%t)", v, ok)
+	}
+	if v, ok := z.ZIncrBy("z", -1); !ok || v != -1 {
+		t.Errorf("expected -1, got %f (%t)", v, ok)
+	}
+	z.ZAdd("i", math.Inf(1))
+	if _, ok := z.ZIncrBy("i", math.Inf(-1)); ok {
+		t.Errorf("expected NaN result to fail")
+	}
+	if v, _ := z.ZScore("i"); !math.IsInf(v, 1) {
+		t.Errorf("expected score to be unchanged, got %f", v)
+	}
+	exp := []string{"z", "b", "c", "d", "a", "e", "i"}
+	if got := members(z.ZRange(0, -1)); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestSortedSetZRange(t *testing.T) {
+	z := newZSetCase()
+	cases := []struct {
+		start, stop int64
+		exp, rev    []string
+	}{
+		0: {0, -1, []string{"a", "b", "c", "d", "e"}, []string{"e", "d", "c", "b", "a"}},
+		1: {1, 2, []string{"b", "c"}, []string{"d", "c"}},
+		2: {-2, -1, []string{"d", "e"}, []string{"b", "a"}},
+		3: {3, 10, []string{"d", "e"}, []string{"b", "a"}},
+		4: {-10, 0, []string{"a"}, []string{"e"}},
+		5: {5, 10, []string{}, []string{}},
+		6: {2, 1, []string{}, []string{}},
+	}
+	for i, c := range cases {
+		got := members(z.ZRange(c.start, c.stop))
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+		got = members(z.ZRevRange(c.start, c.stop))
+		if !reflect.DeepEqual(got, c.rev) {
+			t.Errorf("%d: expected reverse %v, got %v", i, c.rev, got)
+		}
+	}
+	if got := NewSortedSet().ZRange(0, -1); len(got) != 0 {
+		t.Errorf("expected empty range, got %v", got)
+	}
+}
+
+func TestSortedSetZRangeByScore(t *testing.T) {
+	z := newZSetCase()
+	cases := []struct {
+		min, max      ScoreBound
+		offset, count int64
+		exp, rev      []string
+	}{
+		0: {negInf, inf, 0, -1, []string{"a", "b", "c", "d", "e"}, []string{"e", "d", "c", "b", "a"}},
+		1: {incl(2), incl(3), 0, -1, []string{"b", "c", "d"}, []string{"d", "c", "b"}},
+		2: {excl(2), incl(5), 0, -1, []string{"d", "e"}, []string{"e", "d"}},
+		3: {negInf, inf, 1, 2, []string{"b", "c"}, []string{"d", "c"}},
+		4: {negInf, inf, 4, 10, []string{"e"}, []string{"a"}},
+		5: {negInf, inf, 5, 10, []string{}, []string{}},
+		6: {incl(4), incl(3), 0, -1, []string{}, []string{}},
+		7: {incl(2), incl(3), 0, 0, []string{}, []string{}},
+	}
+	for i, c := range cases {
+		got := members(z.ZRangeByScore(c.min, c.max, c.offset, c.count))
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+		got = members(z.ZRevRangeByScore(c.max, c.min, c.offset, c.count))
+		if !reflect.DeepEqual(got, c.rev) {
+			t.Errorf("%d: expected reverse %v, got %v", i, c.rev, got)
+		}
+	}
+}
+
+func TestSortedSetZRank(t *testing.T) {
+	z := newZSetCase()
+	for i, m := range []string{"a", "b", "c", "d", "e"} {
+		if r, ok := z.ZRank(m); !ok || r != int64(i) {
+			t.Errorf("%s: expected rank %d, got %d (%t)", m, i, r, ok)
+		}
+		if r, ok := z.ZRevRank(m); !ok || r != int64(4-i) {
+			t.Errorf("%s: expected reverse rank %d, got %d (%t)", m, 4-i, r, ok)
+		}
+	}
+	if _, ok := z.ZRank("z"); ok {
+		t.Errorf("expected no rank for non-existing member")
+	}
+}
+
+func TestSortedSetZRem(t *testing.T) {
+	z := newZSetCase()
+	if n := z.ZRem("a", "z", "c", "a"); n != 2 {
+		t.Errorf("expected 2, got %d", n)
+	}
+	if got := members(z.ZRange(0, -1)); !reflect.DeepEqual(got, []string{"b", "d", "e"}) {
+		t.Errorf("expected [b d e], got %v", got)
+	}
+	if _, ok := z.ZScore("a"); ok {
+		t.Errorf("expected a to be removed")
+	}
+}
+
+func TestSortedSetZRemRange(t *testing.T) {
+	z := newZSetCase()
+	if n := z.ZRemRangeByRank(1, -2); n != 3 {
+		t.Errorf("expected 3, got %d", n)
+	}
+	if got := members(z.ZRange(0, -1)); !reflect.DeepEqual(got, []string{"a", "e"}) {
+		t.Errorf("expected [a e], got %v", got)
+	}
+	if n := z.ZRemRangeByRank(5, 10); n != 0 {
+		t.Errorf("expected 0, got %d", n)
+	}
+
+	z = newZSetCase()
+	if n := z.ZRemRangeByScore(excl(1), incl(3)); n != 3 {
+		t.Errorf("expected 3, got %d", n)
+	}
+	if got := members(z.ZRange(0, -1)); !reflect.DeepEqual(got, []string{"a", "e"}) {
+		t.Errorf("expected [a e], got %v", got)
+	}
+	if n := z.ZRemRangeByScore(negInf, inf); n != 2 || z.ZCard() != 0 {
+		t.Errorf("expected 2 and an empty set, got %d and %d", n, z.ZCard())
+	}
+}
+
+func TestSortedSetRandom(t *testing.T) {
+	// Compare the skip list with a sorted slice
+	rnd := rand.New(rand.NewSource(1))
+	z := NewSortedSet()
+	ref := make(map[string]float64)
+	for i := 0; i < 2000; i++ {
+		m := fmt.Sprintf("m%d", rnd.Intn(500))
+		if rnd.Intn(4) == 0 {
+			z.ZRem(m)
+			delete(ref, m)
+		} else {
+			sc := float64(rnd.Intn(100))
+			z.ZAdd(m, sc)
+			ref[m] = sc
+		}
+	}
+
+	exp := make([]ScoredMember, 0, len(ref))
+	for m, sc := range ref {
+		exp = append(exp, ScoredMember{m, sc})
+	}
+	sort.Slice(exp, func(i, j int) bool {
+		if exp[i].Score != exp[j].Score {
+			return exp[i].Score < exp[j].Score
+		}
+		return exp[i].Member < exp[j].Member
+	})
+
+	if got := z.ZRange(0, -1); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("range does not match the reference")
+	}
+	for i, sm := range exp {
+		if r, ok := z.ZRank(sm.Member); !ok || r != int64(i) {
+			t.Fatalf("%s: expected rank %d, got %d", sm.Member, i, r)
+		}
+	}
+	var cnt int64
+	for _, sm := range exp {
+		if sm.Score >= 10 && sm.Score < 50 {
+			cnt++
+		}
+	}
+	if n := z.ZCount(incl(10), excl(50)); n != cnt {
+		t.Errorf("expected count %d, got %d", cnt, n)
+	}
+}
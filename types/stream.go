@@ -0,0 +1,666 @@
+package types
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamID is the ID of a stream entry, made of a Unix time in milliseconds
+// and a sequence number.
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+// MaxStreamID is the greatest stream ID.
+var MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+// ParseStreamID parses a stream ID of the form ms-seq, or ms, in which case
+// the sequence number is seq. It returns false if s is not a valid ID.
+func ParseStreamID(s string, seq uint64) (StreamID, bool) {
+	ms := s
+	i := strings.IndexByte(s, '-')
+	if i >= 0 {
+		ms = s[:i]
+	}
+	m, err := strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	if i >= 0 {
+		if seq, err = strconv.ParseUint(s[i+1:], 10, 64); err != nil {
+			return StreamID{}, false
+		}
+	}
+	return StreamID{m, seq}, true
+}
+
+// String returns the ms-seq representation of the ID.
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less returns true if the ID is smaller than other.
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || id.Ms == other.Ms && id.Seq < other.Seq
+}
+
+// Next returns the smallest ID greater than the ID, and false if the ID
+// is the greatest one.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Prev returns the greatest ID smaller than the ID, and false if the ID is
+// 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// StreamEntry is an entry of a stream, with its field-value pairs. The
+// entries read from the pending entries of a consumer that were deleted
+// from the stream have no fields.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// StreamPending is a pending entry of a consumer group, which was
+// delivered to a consumer but not acknowledged yet.
+type StreamPending struct {
+	ID        StreamID
+	Consumer  string
+	Delivered time.Time
+	Count     int64
+}
+
+// StreamConsumer is a consumer of a consumer group, with the time it was
+// last seen and its number of pending entries.
+type StreamConsumer struct {
+	Name    string
+	Seen    time.Time
+	Pending int64
+}
+
+// StreamClaim holds the options of the claim of pending entries.
+type StreamClaim struct {
+	// MinIdle is the minimum time since the last delivery of the entries.
+	MinIdle time.Duration
+
+	// Delivered is the time of delivery to set, now if it is zero.
+	Delivered time.Time
+
+	// RetryCount is the delivery count to set, or if it is negative, the
+	// count is incremented unless JustID is set.
+	RetryCount int64
+
+	// Force creates the pending entries of the entries that are not
+	// pending.
+	Force bool
+
+	// JustID returns the IDs of the claimed entries, without their fields.
+	JustID bool
+}
+
+// Stream defines the methods required to implement a Stream.
+type Stream interface {
+	Value
+
+	LastID() StreamID
+	SetLastID(StreamID) bool
+	XAdd(StreamID, ...string) bool
+	XDel(...StreamID) int64
+	XLen() int64
+	XRange(StreamID, StreamID, int64) []StreamEntry
+	XRevRange(StreamID, StreamID, int64) []StreamEntry
+	XTrimMaxLen(int64, int64) int64
+	XTrimMinID(StreamID, int64) int64
+
+	// Consumer groups
+	CreateGroup(string, StreamID) bool
+	DestroyGroup(string) bool
+	Group(string) (StreamGroup, bool)
+	Groups() []StreamGroup
+
+	Clone() Stream
+}
+
+// StreamGroup defines the methods required to implement a consumer group
+// of a Stream.
+type StreamGroup interface {
+	Name() string
+	LastID() StreamID
+	SetLastID(StreamID)
+
+	Consumers() []StreamConsumer
+	CreateConsumer(string, time.Time) bool
+	DelConsumer(string) int64
+
+	Read(string, int64, bool, time.Time) []StreamEntry
+	ReadPending(string, StreamID, int64, time.Time) []StreamEntry
+	Ack(...StreamID) int64
+	Pending(StreamID, StreamID, int64, string, time.Duration, time.Time) []StreamPending
+	SetPending(StreamPending)
+	Claim(string, []StreamID, StreamClaim, time.Time) []StreamEntry
+	AutoClaim(string, StreamID, int64, StreamClaim, time.Time) (StreamID, []StreamEntry, []StreamID)
+}
+
+// Static type checks to validate that *stream implements Stream, and
+// *streamGroup implements StreamGroup.
+var (
+	_ Stream      = (*stream)(nil)
+	_ StreamGroup = (*streamGroup)(nil)
+)
+
+// stream is the internal implementation of a Stream. The fields of the
+// entries are kept in a skip list ordered by ID.
+type stream struct {
+	entries *idList
+	last    StreamID
+	groups  map[string]*streamGroup
+}
+
+// NewStream creates a new, empty Stream.
+func NewStream() Stream {
+	return &stream{
+		entries: newIDList(),
+		groups:  make(map[string]*streamGroup),
+	}
+}
+
+// Type returns the type of the value, which is "stream".
+func (s *stream) Type() string {
+	return "stream"
+}
+
+// streamEntry returns the entry of the node of the entries of a stream.
+func streamEntry(n *idNode) StreamEntry {
+	return StreamEntry{n.id, n.val.([]string)}
+}
+
+// entry returns the entry with the specified ID, and false if it does not
+// exist.
+func (s *stream) entry(id StreamID) (StreamEntry, bool) {
+	if n := s.entries.get(id); n != nil {
+		return streamEntry(n), true
+	}
+	return StreamEntry{}, false
+}
+
+// LastID returns the greatest ID ever added to the stream, even if the
+// entry was deleted since.
+func (s *stream) LastID() StreamID {
+	return s.last
+}
+
+// SetLastID sets the last ID of the stream. It returns false if id is
+// smaller than the ID of the last entry.
+func (s *stream) SetLastID(id StreamID) bool {
+	if t := s.entries.tail; t != nil && id.Less(t.id) {
+		return false
+	}
+	s.last = id
+	return true
+}
+
+// XAdd adds an entry with the specified ID and field-value pairs. It
+// returns false if the ID is not greater than the last ID of the stream.
+func (s *stream) XAdd(id StreamID, fields ...string) bool {
+	if !s.last.Less(id) {
+		return false
+	}
+	s.entries.insert(id, fields)
+	s.last = id
+	return true
+}
+
+// XDel deletes the entries with the specified IDs, and returns the number
+// of entries actually deleted.
+func (s *stream) XDel(ids ...StreamID) int64 {
+	var cnt int64
+	for _, id := range ids {
+		if s.entries.delete(id) != nil {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// XLen returns the number of entries.
+func (s *stream) XLen() int64 {
+	return s.entries.length
+}
+
+// XRange returns at most count entries with IDs from start to end, in
+// increasing order, or all of them if count is negative.
+func (s *stream) XRange(start, end StreamID, count int64) []StreamEntry {
+	ret := []StreamEntry{}
+	for n := s.entries.seek(start, nil); n != nil && !end.Less(n.id); n = n.next() {
+		if count >= 0 && int64(len(ret)) >= count {
+			break
+		}
+		ret = append(ret, streamEntry(n))
+	}
+	return ret
+}
+
+// XRevRange returns at most count entries with IDs from end to start, in
+// decreasing order, or all of them if count is negative.
+func (s *stream) XRevRange(end, start StreamID, count int64) []StreamEntry {
+	ret := []StreamEntry{}
+	for n := s.entries.seekLast(end); n != nil && !n.id.Less(start); n = n.backward {
+		if count >= 0 && int64(len(ret)) >= count {
+			break
+		}
+		ret = append(ret, streamEntry(n))
+	}
+	return ret
+}
+
+// XTrimMaxLen removes the oldest entries so that the stream has at most
+// maxLen entries, removing at most limit entries if limit is positive. It
+// returns the number of entries removed.
+func (s *stream) XTrimMaxLen(maxLen, limit int64) int64 {
+	return s.trim(s.entries.length-maxLen, limit)
+}
+
+// XTrimMinID removes the entries with an ID smaller than minID, removing
+// at most limit entries if limit is positive. It returns the number of
+// entries removed.
+func (s *stream) XTrimMinID(minID StreamID, limit int64) int64 {
+	var cnt int64
+	for n := s.entries.first(); n != nil && n.id.Less(minID); n = n.next() {
+		if limit > 0 && cnt >= limit {
+			break
+		}
+		cnt++
+	}
+	return s.trim(cnt, limit)
+}
+
+// trim removes the n oldest entries, at most limit if it is positive.
+func (s *stream) trim(n, limit int64) int64 {
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	if n <= 0 {
+		return 0
+	}
+	return s.entries.deleteFirst(n)
+}
+
+// CreateGroup creates a consumer group whose last delivered ID is last.
+// It returns false if the group already exists.
+func (s *stream) CreateGroup(name string, last StreamID) bool {
+	if _, ok := s.groups[name]; ok {
+		return false
+	}
+	s.groups[name] = newStreamGroup(s, name, last)
+	return true
+}
+
+// DestroyGroup deletes the consumer group, and returns false if it does
+// not exist.
+func (s *stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Group returns the consumer group with the specified name, and false if
+// it does not exist.
+func (s *stream) Group(name string) (StreamGroup, bool) {
+	g, ok := s.groups[name]
+	return g, ok
+}
+
+// Groups returns the consumer groups, sorted by name.
+func (s *stream) Groups() []StreamGroup {
+	names := make([]string, 0, len(s.groups))
+	for nm := range s.groups {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	ret := make([]StreamGroup, len(names))
+	for i, nm := range names {
+		ret[i] = s.groups[nm]
+	}
+	return ret
+}
+
+// Clone returns a copy of the stream and its consumer groups.
+func (s *stream) Clone() Stream {
+	c := &stream{
+		entries: newIDList(),
+		last:    s.last,
+		groups:  make(map[string]*streamGroup, len(s.groups)),
+	}
+	// The fields of the entries are never modified, they are shared
+	for n := s.entries.first(); n != nil; n = n.next() {
+		c.entries.insert(n.id, n.val)
+	}
+	for nm, g := range s.groups {
+		cg := newStreamGroup(c, nm, g.last)
+		for cnm, cons := range g.consumers {
+			cc := *cons
+			cg.consumers[cnm] = &cc
+		}
+		for n := g.pel.first(); n != nil; n = n.next() {
+			cp := *n.val.(*StreamPending)
+			cg.pel.insert(n.id, &cp)
+		}
+		c.groups[nm] = cg
+	}
+	return c
+}
+
+// streamGroup is the internal implementation of a StreamGroup. The
+// pending entries are kept in a skip list ordered by ID.
+type streamGroup struct {
+	s         *stream
+	name      string
+	last      StreamID
+	consumers map[string]*StreamConsumer
+	pel       *idList
+}
+
+// newStreamGroup creates a consumer group of the stream s.
+func newStreamGroup(s *stream, name string, last StreamID) *streamGroup {
+	return &streamGroup{
+		s:         s,
+		name:      name,
+		last:      last,
+		consumers: make(map[string]*StreamConsumer),
+		pel:       newIDList(),
+	}
+}
+
+// Name returns the name of the group.
+func (g *streamGroup) Name() string {
+	return g.name
+}
+
+// LastID returns the ID of the last entry delivered to the group.
+func (g *streamGroup) LastID() StreamID {
+	return g.last
+}
+
+// SetLastID sets the ID of the last entry delivered to the group.
+func (g *streamGroup) SetLastID(id StreamID) {
+	g.last = id
+}
+
+// Consumers returns the consumers of the group, sorted by name.
+func (g *streamGroup) Consumers() []StreamConsumer {
+	ret := make([]StreamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		ret = append(ret, *c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// CreateConsumer creates the consumer, and returns false if it already
+// exists.
+func (g *streamGroup) CreateConsumer(name string, now time.Time) bool {
+	if _, ok := g.consumers[name]; ok {
+		return false
+	}
+	g.consumers[name] = &StreamConsumer{Name: name, Seen: now}
+	return true
+}
+
+// consumer returns the consumer, which is created if it does not exist,
+// and marks it as seen at now.
+func (g *streamGroup) consumer(name string, now time.Time) *StreamConsumer {
+	g.CreateConsumer(name, now)
+	c := g.consumers[name]
+	c.Seen = now
+	return c
+}
+
+// DelConsumer deletes the consumer and its pending entries, and returns
+// the number of pending entries deleted.
+func (g *streamGroup) DelConsumer(name string) int64 {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	for n := g.pel.first(); n != nil; {
+		next := n.next()
+		if n.val.(*StreamPending).Consumer == name {
+			g.pel.delete(n.id)
+		}
+		n = next
+	}
+	delete(g.consumers, name)
+	return c.Pending
+}
+
+// SetPending sets the pending entry with the ID of p, and assigns it to
+// its consumer, which is created if it does not exist. It restores the
+// pending entries as they were saved, whether or not their entry still
+// exists in the stream.
+func (g *streamGroup) SetPending(p StreamPending) {
+	g.CreateConsumer(p.Consumer, p.Delivered)
+	sp := g.deliver(p.ID)
+	g.assign(sp, g.consumers[p.Consumer])
+	sp.Delivered = p.Delivered
+	sp.Count = p.Count
+}
+
+// pending returns the pending entry with the specified ID, or nil.
+func (g *streamGroup) pending(id StreamID) *StreamPending {
+	if n := g.pel.get(id); n != nil {
+		return n.val.(*StreamPending)
+	}
+	return nil
+}
+
+// removePending removes the pending entry with the specified ID, and
+// returns false if it does not exist.
+func (g *streamGroup) removePending(id StreamID) bool {
+	n := g.pel.delete(id)
+	if n == nil {
+		return false
+	}
+	if c, ok := g.consumers[n.val.(*StreamPending).Consumer]; ok {
+		c.Pending--
+	}
+	return true
+}
+
+// assign assigns the pending entry p to the consumer c.
+func (g *streamGroup) assign(p *StreamPending, c *StreamConsumer) {
+	if old, ok := g.consumers[p.Consumer]; ok && p.Consumer != "" {
+		old.Pending--
+	}
+	p.Consumer = c.Name
+	c.Pending++
+}
+
+// deliver returns the pending entry for the entry id, which is created if
+// it does not exist.
+func (g *streamGroup) deliver(id StreamID) *StreamPending {
+	if p := g.pending(id); p != nil {
+		return p
+	}
+	p := &StreamPending{ID: id}
+	g.pel.insert(id, p)
+	return p
+}
+
+// Read returns at most count entries never delivered to the group, or all
+// of them if count is negative, and delivers them to the consumer, which
+// is created if it does not exist. The entries are added to the pending
+// entries of the consumer, unless noack is true.
+func (g *streamGroup) Read(consumer string, count int64, noack bool, now time.Time) []StreamEntry {
+	c := g.consumer(consumer, now)
+	start, ok := g.last.Next()
+	if !ok {
+		return []StreamEntry{}
+	}
+	ents := g.s.XRange(start, MaxStreamID, count)
+	for _, e := range ents {
+		g.last = e.ID
+		if noack {
+			continue
+		}
+		p := g.deliver(e.ID)
+		g.assign(p, c)
+		p.Delivered = now
+		p.Count = 1
+	}
+	return ents
+}
+
+// ReadPending returns at most count pending entries of the consumer, or
+// all of them if count is negative, with IDs greater than after. The
+// entries deleted from the stream have no fields.
+func (g *streamGroup) ReadPending(consumer string, after StreamID, count int64, now time.Time) []StreamEntry {
+	g.consumer(consumer, now)
+	ret := []StreamEntry{}
+	start, ok := after.Next()
+	if !ok {
+		return ret
+	}
+	for n := g.pel.seek(start, nil); n != nil; n = n.next() {
+		if count >= 0 && int64(len(ret)) >= count {
+			break
+		}
+		if p := n.val.(*StreamPending); p.Consumer == consumer {
+			e, _ := g.s.entry(p.ID)
+			e.ID = p.ID
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// Ack removes the entries from the pending entries of the group, and
+// returns the number of entries actually removed.
+func (g *streamGroup) Ack(ids ...StreamID) int64 {
+	var cnt int64
+	for _, id := range ids {
+		if g.removePending(id) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Pending returns at most count pending entries with IDs from start to end,
+// or all of them if count is negative. If consumer is not empty, only its
+// entries are returned, and only the entries idle for at least minIdle are
+// returned.
+func (g *streamGroup) Pending(start, end StreamID, count int64, consumer string, minIdle time.Duration, now time.Time) []StreamPending {
+	ret := []StreamPending{}
+	for n := g.pel.seek(start, nil); n != nil && !end.Less(n.id); n = n.next() {
+		if count >= 0 && int64(len(ret)) >= count {
+			break
+		}
+		p := n.val.(*StreamPending)
+		if consumer != "" && p.Consumer != consumer || minIdle > 0 && now.Sub(p.Delivered) < minIdle {
+			continue
+		}
+		ret = append(ret, *p)
+	}
+	return ret
+}
+
+// claim claims the pending entry p for the consumer c, with the options
+// opts. It returns the claimed entry.
+func (g *streamGroup) claim(p *StreamPending, e StreamEntry, c *StreamConsumer, opts StreamClaim, now time.Time) StreamEntry {
+	g.assign(p, c)
+	p.Delivered = now
+	if !opts.Delivered.IsZero() {
+		p.Delivered = opts.Delivered
+	}
+	switch {
+	case opts.RetryCount >= 0:
+		p.Count = opts.RetryCount
+	case !opts.JustID:
+		p.Count++
+	}
+	if opts.JustID {
+		e.Fields = nil
+	}
+	return e
+}
+
+// Claim assigns the pending entries with the specified IDs to the consumer,
+// which is created if it does not exist, if they were delivered at least
+// opts.MinIdle ago. The pending entries of deleted entries are removed. It
+// returns the claimed entries.
+func (g *streamGroup) Claim(consumer string, ids []StreamID, opts StreamClaim, now time.Time) []StreamEntry {
+	c := g.consumer(consumer, now)
+	ret := []StreamEntry{}
+	for _, id := range ids {
+		e, ok := g.s.entry(id)
+		p := g.pending(id)
+		if p == nil {
+			if !opts.Force || !ok {
+				continue
+			}
+			p = g.deliver(id)
+		}
+		if !ok {
+			g.Ack(id)
+			continue
+		}
+		if opts.MinIdle > 0 && now.Sub(p.Delivered) < opts.MinIdle {
+			continue
+		}
+		ret = append(ret, g.claim(p, e, c, opts, now))
+	}
+	return ret
+}
+
+// AutoClaim claims at most count pending entries with IDs greater than or
+// equal to start, like Claim, scanning at most 10 times count pending
+// entries. It returns the ID to start the next call from, which is 0-0
+// once all pending entries have been scanned, the claimed entries, and the
+// IDs of the deleted entries whose pending entries were removed.
+func (g *streamGroup) AutoClaim(consumer string, start StreamID, count int64, opts StreamClaim, now time.Time) (StreamID, []StreamEntry, []StreamID) {
+	c := g.consumer(consumer, now)
+	claimed, deleted := []StreamEntry{}, []StreamID{}
+	n := g.pel.seek(start, nil)
+	for attempts := count * 10; n != nil && attempts > 0 && int64(len(claimed)) < count; attempts-- {
+		p := n.val.(*StreamPending)
+		n = n.next()
+		e, ok := g.s.entry(p.ID)
+		if !ok {
+			deleted = append(deleted, p.ID)
+			g.removePending(p.ID)
+			continue
+		}
+		if opts.MinIdle > 0 && now.Sub(p.Delivered) < opts.MinIdle {
+			continue
+		}
+		claimed = append(claimed, g.claim(p, e, c, opts, now))
+	}
+
+	var next StreamID
+	if n != nil {
+		next = n.id
+	}
+	return next, claimed, deleted
+}
@@ -0,0 +1,297 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// streamIDs returns the IDs of the entries.
+func streamIDs(ents []StreamEntry) []StreamID {
+	ids := make([]StreamID, len(ents))
+	for i, e := range ents {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+// newStreamCase returns a stream with the entries 1-0 to n-0.
+func newStreamCase(n uint64) Stream {
+	s := NewStream()
+	for i := uint64(1); i <= n; i++ {
+		s.XAdd(StreamID{i, 0}, "f", "v")
+	}
+	return s
+}
+
+func TestStreamID(t *testing.T) {
+	cases := []struct {
+		s   string
+		seq uint64
+		exp StreamID
+		ok  bool
+	}{
+		0: {"1-2", 0, StreamID{1, 2}, true},
+		1: {"1", 5, StreamID{1, 5}, true},
+		2: {"18446744073709551615-18446744073709551615", 0, MaxStreamID, true},
+		3: {"", 0, StreamID{}, false},
+		4: {"1-", 0, StreamID{}, false},
+		5: {"-1", 0, StreamID{}, false},
+		6: {"1-2-3", 0, StreamID{}, false},
+		7: {"a-1", 0, StreamID{}, false},
+		8: {"18446744073709551616", 0, StreamID{}, false},
+	}
+	for i, c := range cases {
+		got, ok := ParseStreamID(c.s, c.seq)
+		if ok != c.ok || got != c.exp {
+			t.Errorf("%d: expected %v %t, got %v %t", i, c.exp, c.ok, got, ok)
+		}
+		if ok && c.seq == 0 && got.String() != c.s {
+			t.Errorf("%d: expected %s, got %s", i, c.s, got)
+		}
+	}
+
+	if id, ok := (StreamID{1, MaxStreamID.Seq}).Next(); !ok || id != (StreamID{2, 0}) {
+		t.Errorf("expected 2-0, got %v", id)
+	}
+	if _, ok := MaxStreamID.Next(); ok {
+		t.Errorf("expected no ID after the greatest one")
+	}
+	if id, ok := (StreamID{2, 0}).Prev(); !ok || id != (StreamID{1, MaxStreamID.Seq}) {
+		t.Errorf("expected 1-max, got %v", id)
+	}
+	if _, ok := (StreamID{}).Prev(); ok {
+		t.Errorf("expected no ID before 0-0")
+	}
+}
+
+func TestStreamXAddXDel(t *testing.T) {
+	s := NewStream()
+	if !s.XAdd(StreamID{1, 1}, "a", "1") {
+		t.Fatal("expected entry to be added")
+	}
+	if s.XAdd(StreamID{1, 1}, "a", "2") || s.XAdd(StreamID{1, 0}, "a", "2") {
+		t.Errorf("expected IDs not greater than the last one to be rejected")
+	}
+	s.XAdd(StreamID{2, 0}, "b", "2")
+	s.XAdd(StreamID{3, 0}, "c", "3")
+
+	if n := s.XDel(StreamID{2, 0}, StreamID{2, 0}, StreamID{9, 0}); n != 1 {
+		t.Errorf("expected 1 deleted entry, got %d", n)
+	}
+	if n := s.XDel(StreamID{1, 1}); n != 1 {
+		t.Errorf("expected 1 deleted entry, got %d", n)
+	}
+	if n := s.XLen(); n != 1 {
+		t.Errorf("expected 1 entry, got %d", n)
+	}
+	s.XDel(StreamID{3, 0})
+	if last := s.LastID(); last != (StreamID{3, 0}) {
+		t.Errorf("expected last ID 3-0, got %v", last)
+	}
+	if s.XAdd(StreamID{3, 0}) {
+		t.Errorf("expected the ID of a deleted entry to be rejected")
+	}
+	if !s.SetLastID(StreamID{1, 0}) || s.LastID() != (StreamID{1, 0}) {
+		t.Errorf("expected last ID to be set on empty stream")
+	}
+	s.XAdd(StreamID{5, 0})
+	if s.SetLastID(StreamID{4, 0}) {
+		t.Errorf("expected last ID smaller than the last entry to be rejected")
+	}
+}
+
+func TestStreamXRange(t *testing.T) {
+	s := newStreamCase(5)
+	cases := []struct {
+		start, end StreamID
+		count      int64
+		rev        bool
+		exp        []uint64
+	}{
+		0: {StreamID{}, MaxStreamID, -1, false, []uint64{1, 2, 3, 4, 5}},
+		1: {StreamID{2, 0}, StreamID{4, 0}, -1, false, []uint64{2, 3, 4}},
+		2: {StreamID{2, 1}, StreamID{4, 0}, 1, false, []uint64{3}},
+		3: {StreamID{}, MaxStreamID, 2, true, []uint64{5, 4}},
+		4: {StreamID{2, 0}, StreamID{4, 0}, -1, true, []uint64{4, 3, 2}},
+		5: {StreamID{2, 0}, StreamID{3, 5}, -1, true, []uint64{3, 2}},
+		6: {StreamID{4, 0}, StreamID{2, 0}, -1, false, []uint64{}},
+		7: {StreamID{6, 0}, MaxStreamID, -1, true, []uint64{}},
+		8: {StreamID{}, MaxStreamID, 0, false, []uint64{}},
+	}
+	for i, c := range cases {
+		var got []StreamEntry
+		if c.rev {
+			got = s.XRevRange(c.end, c.start, c.count)
+		} else {
+			got = s.XRange(c.start, c.end, c.count)
+		}
+		exp := make([]StreamID, len(c.exp))
+		for j, ms := range c.exp {
+			exp[j] = StreamID{ms, 0}
+		}
+		if ids := streamIDs(got); !reflect.DeepEqual(ids, exp) {
+			t.Errorf("%d: expected %v, got %v", i, exp, ids)
+		}
+	}
+}
+
+func TestStreamXTrim(t *testing.T) {
+	s := newStreamCase(10)
+	if n := s.XTrimMaxLen(8, 0); n != 2 {
+		t.Errorf("expected 2 entries trimmed, got %d", n)
+	}
+	if n := s.XTrimMaxLen(2, 3); n != 3 {
+		t.Errorf("expected 3 entries trimmed, got %d", n)
+	}
+	if n := s.XTrimMaxLen(10, 0); n != 0 {
+		t.Errorf("expected no entry trimmed, got %d", n)
+	}
+	if n := s.XTrimMinID(StreamID{8, 0}, 0); n != 2 {
+		t.Errorf("expected 2 entries trimmed, got %d", n)
+	}
+	if ids := streamIDs(s.XRange(StreamID{}, MaxStreamID, -1)); !reflect.DeepEqual(ids, []StreamID{{8, 0}, {9, 0}, {10, 0}}) {
+		t.Errorf("unexpected entries %v", ids)
+	}
+	if n := s.XTrimMaxLen(0, 0); n != 3 || s.XLen() != 0 {
+		t.Errorf("expected all entries trimmed, got %d", n)
+	}
+}
+
+func TestStreamGroupRead(t *testing.T) {
+	now := time.Now()
+	s := newStreamCase(5)
+	if !s.CreateGroup("g", StreamID{2, 0}) || s.CreateGroup("g", StreamID{}) {
+		t.Fatal("expected group to be created once")
+	}
+	g, ok := s.Group("g")
+	if !ok {
+		t.Fatal("expected group to exist")
+	}
+
+	if ids := streamIDs(g.Read("c1", 2, false, now)); !reflect.DeepEqual(ids, []StreamID{{3, 0}, {4, 0}}) {
+		t.Errorf("unexpected entries %v", ids)
+	}
+	if ids := streamIDs(g.Read("c2", -1, false, now)); !reflect.DeepEqual(ids, []StreamID{{5, 0}}) {
+		t.Errorf("unexpected entries %v", ids)
+	}
+	if ents := g.Read("c2", -1, false, now); len(ents) != 0 {
+		t.Errorf("expected no new entry, got %v", ents)
+	}
+	s.XAdd(StreamID{6, 0}, "f", "v")
+	if ids := streamIDs(g.Read("c3", -1, true, now)); !reflect.DeepEqual(ids, []StreamID{{6, 0}}) {
+		t.Errorf("unexpected entries %v", ids)
+	}
+	if last := g.LastID(); last != (StreamID{6, 0}) {
+		t.Errorf("expected last delivered ID 6-0, got %v", last)
+	}
+
+	// Pending entries of c1, including a deleted one
+	s.XDel(StreamID{3, 0})
+	got := g.ReadPending("c1", StreamID{}, -1, now)
+	exp := []StreamEntry{{StreamID{3, 0}, nil}, {StreamID{4, 0}, []string{"f", "v"}}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	if ids := streamIDs(g.ReadPending("c1", StreamID{3, 0}, -1, now)); !reflect.DeepEqual(ids, []StreamID{{4, 0}}) {
+		t.Errorf("unexpected entries %v", ids)
+	}
+
+	cons := g.Consumers()
+	if len(cons) != 3 || cons[0].Name != "c1" || cons[0].Pending != 2 || cons[1].Pending != 1 || cons[2].Pending != 0 {
+		t.Errorf("unexpected consumers %v", cons)
+	}
+	if n := g.Ack(StreamID{3, 0}, StreamID{5, 0}, StreamID{6, 0}); n != 2 {
+		t.Errorf("expected 2 entries acknowledged, got %d", n)
+	}
+	if n := g.DelConsumer("c1"); n != 1 {
+		t.Errorf("expected 1 pending entry deleted, got %d", n)
+	}
+	if ps := g.Pending(StreamID{}, MaxStreamID, -1, "", 0, now); len(ps) != 0 {
+		t.Errorf("expected no pending entry, got %v", ps)
+	}
+
+	if !s.DestroyGroup("g") || s.DestroyGroup("g") {
+		t.Errorf("expected group to be destroyed once")
+	}
+}
+
+func TestStreamGroupClaim(t *testing.T) {
+	now := time.Now()
+	s := newStreamCase(5)
+	s.CreateGroup("g", StreamID{})
+	g, _ := s.Group("g")
+	g.Read("c1", 3, false, now.Add(-time.Minute))
+	g.Read("c2", -1, false, now)
+
+	ps := g.Pending(StreamID{}, MaxStreamID, -1, "", 30*time.Second, now)
+	if len(ps) != 3 || ps[0].Consumer != "c1" || ps[0].Count != 1 {
+		t.Errorf("unexpected pending entries %v", ps)
+	}
+	if ps := g.Pending(StreamID{2, 0}, MaxStreamID, 2, "c2", 0, now); len(ps) != 2 || ps[0].ID != (StreamID{4, 0}) {
+		t.Errorf("unexpected pending entries %v", ps)
+	}
+
+	// Only the idle entries are claimed
+	opts := StreamClaim{MinIdle: 30 * time.Second, RetryCount: -1}
+	got := g.Claim("c3", []StreamID{{1, 0}, {4, 0}, {9, 0}}, opts, now)
+	if ids := streamIDs(got); !reflect.DeepEqual(ids, []StreamID{{1, 0}}) {
+		t.Errorf("unexpected claimed entries %v", ids)
+	}
+	if ps := g.Pending(StreamID{1, 0}, StreamID{1, 0}, -1, "", 0, now); len(ps) != 1 || ps[0].Consumer != "c3" || ps[0].Count != 2 {
+		t.Errorf("unexpected pending entries %v", ps)
+	}
+
+	// Forced claim of an acknowledged entry, and claim of a deleted one
+	g.Ack(StreamID{5, 0})
+	s.XDel(StreamID{4, 0})
+	opts = StreamClaim{RetryCount: 7, Force: true, JustID: true}
+	got = g.Claim("c3", []StreamID{{5, 0}, {4, 0}}, opts, now)
+	if !reflect.DeepEqual(got, []StreamEntry{{StreamID{5, 0}, nil}}) {
+		t.Errorf("unexpected claimed entries %v", got)
+	}
+	if ps := g.Pending(StreamID{}, MaxStreamID, -1, "", 0, now); len(ps) != 4 || ps[3].Count != 7 {
+		t.Errorf("unexpected pending entries %v", ps)
+	}
+
+	// Auto-claim from the start, one entry at a time: 1-0 is not idle, and
+	// the deleted 2-0 is removed.
+	s.XDel(StreamID{2, 0})
+	opts = StreamClaim{MinIdle: 30 * time.Second, RetryCount: -1}
+	next, got, deleted := g.AutoClaim("c4", StreamID{}, 1, opts, now)
+	if next != (StreamID{5, 0}) || len(got) != 1 || got[0].ID != (StreamID{3, 0}) || !reflect.DeepEqual(deleted, []StreamID{{2, 0}}) {
+		t.Errorf("unexpected auto-claim %v %v %v", next, got, deleted)
+	}
+	next, got, deleted = g.AutoClaim("c4", next, 1, opts, now)
+	if next != (StreamID{}) || len(got) != 0 || len(deleted) != 0 {
+		t.Errorf("unexpected auto-claim %v %v %v", next, got, deleted)
+	}
+	if ps := g.Pending(StreamID{}, MaxStreamID, -1, "c4", 0, now); len(ps) != 1 || ps[0].Count != 2 {
+		t.Errorf("unexpected pending entries %v", ps)
+	}
+}
+
+func TestStreamClone(t *testing.T) {
+	now := time.Now()
+	s := newStreamCase(3)
+	s.CreateGroup("g", StreamID{})
+	g, _ := s.Group("g")
+	g.Read("c", 1, false, now)
+
+	c := s.Clone()
+	s.XAdd(StreamID{4, 0})
+	g.Read("c", -1, false, now)
+	if n := c.XLen(); n != 3 {
+		t.Errorf("expected 3 entries, got %d", n)
+	}
+	cg, ok := c.Group("g")
+	if !ok {
+		t.Fatal("expected group to be cloned")
+	}
+	if ps := cg.Pending(StreamID{}, MaxStreamID, -1, "", 0, now); len(ps) != 1 {
+		t.Errorf("expected 1 pending entry, got %v", ps)
+	}
+	if ids := streamIDs(cg.Read("c", -1, false, now)); !reflect.DeepEqual(ids, []StreamID{{2, 0}, {3, 0}}) {
+		t.Errorf("unexpected entries %v", ids)
+	}
+}
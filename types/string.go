@@ -1,5 +1,7 @@
 package types
 
+import "math/bits"
+
 // String defines the methods required to implement a String.
 type String interface {
 	Value
@@ -11,15 +13,29 @@ type String interface {
 	Set(string)
 	SetRange(int64, string) int64
 	StrLen() int64
+
+	// Bit-level access, with bit 0 being the most significant bit of the
+	// first byte. Bits beyond the end of the string are 0.
+	BitCount(int64, int64, bool) int64
+	BitPos(int, int64, int64, bool, bool) int64
+	GetBit(int64) int
+	GetBits(int64, uint, bool) int64
+	SetBit(int64, int) int
+	SetBits(int64, uint, int64)
 }
 
-// stringval is the internal representation of a String.
-type stringval string
+// stringval is the internal representation of a String. The value is
+// held as a string, or as bytes once it is modified in place, so that the
+// bit-level and range setters do not copy the whole value.
+type stringval struct {
+	s   string
+	b   []byte
+	mut bool // the value is held in b
+}
 
 // NewString creates a new String holding the specified initial value.
 func NewString(initval string) String {
-	s := stringval(initval)
-	return &s
+	return &stringval{s: initval}
 }
 
 // Type returns the type of the value, which is "string".
@@ -27,21 +43,48 @@ func (s *stringval) Type() string {
 	return "string"
 }
 
+// len returns the length of the string.
+func (s *stringval) len() int64 {
+	if s.mut {
+		return int64(len(s.b))
+	}
+	return int64(len(s.s))
+}
+
+// at returns the byte of the string at index i.
+func (s *stringval) at(i int64) byte {
+	if s.mut {
+		return s.b[i]
+	}
+	return s.s[i]
+}
+
+// bytes returns the bytes of the string, which may be modified in place.
+func (s *stringval) bytes() []byte {
+	if !s.mut {
+		s.b, s.s, s.mut = []byte(s.s), "", true
+	}
+	return s.b
+}
+
 // Append appends the value v to the current string value.
 // It returns the new length of the string.
 func (s *stringval) Append(v string) int64 {
-	*s += stringval(v)
-	return int64(len(*s))
+	s.b = append(s.bytes(), v...)
+	return s.len()
 }
 
 // Get returns the current string value.
 func (s *stringval) Get() string {
-	return string(*s)
+	if s.mut {
+		return string(s.b)
+	}
+	return s.s
 }
 
 // GetRange returns the value of the string from start to end.
 func (s *stringval) GetRange(start, end int64) string {
-	l := int64(len(*s))
+	l := s.len()
 	if start < 0 {
 		start = l + start
 		if start < 0 {
@@ -63,19 +106,22 @@ func (s *stringval) GetRange(start, end int64) string {
 	if end >= l {
 		end = l - 1
 	}
-	return string((*s)[start : end+1])
+	if s.mut {
+		return string(s.b[start : end+1])
+	}
+	return s.s[start : end+1]
 }
 
 // GetSet sets the value to v and returns the previous value.
 func (s *stringval) GetSet(v string) string {
-	old := *s
-	*s = stringval(v)
-	return string(old)
+	old := s.Get()
+	s.Set(v)
+	return old
 }
 
 // Set sets the value to v.
 func (s *stringval) Set(v string) {
-	*s = stringval(v)
+	s.s, s.b, s.mut = v, nil, false
 }
 
 // SetRange sets a substring of the current value to v, starting
@@ -83,24 +129,171 @@ func (s *stringval) Set(v string) {
 func (s *stringval) SetRange(ofs int64, v string) int64 {
 	// Fast path if there's no value to set
 	if len(v) == 0 {
-		return int64(len(*s))
-	}
-
-	// Pad with 0 bytes if required
-	pad := int(ofs) + len(v) - len(*s)
-	b := []byte(*s)
-	if pad > 0 {
-		b = append(b, make([]byte, pad)...)
+		return s.len()
 	}
 
-	// Set the new value in place
+	// Pad with 0 bytes if required, and set the new value in place
+	b := s.grow(ofs + int64(len(v)))
 	copy(b[ofs:], v)
-	*s = stringval(b)
-
-	return int64(len(*s))
+	return s.len()
 }
 
 // StrLen returns the length of the string.
 func (s *stringval) StrLen() int64 {
-	return int64(len(*s))
+	return s.len()
+}
+
+// BitCount returns the number of bits set to 1 from start to end, which
+// are byte indices, or bit indices if bit is true. Negative indices count
+// from the end of the string.
+func (s *stringval) BitCount(start, end int64, bit bool) int64 {
+	start, end, ok := s.bitRange(start, end, bit)
+	if !ok {
+		return 0
+	}
+
+	var cnt int
+	fb, lb := start/8, end/8
+	for i := fb; i <= lb; i++ {
+		m := byte(0xff)
+		if i == fb {
+			m &= 0xff >> uint(start%8)
+		}
+		if i == lb {
+			m &= 0xff << uint(7-end%8)
+		}
+		cnt += bits.OnesCount8(s.at(i) & m)
+	}
+	return int64(cnt)
+}
+
+// BitPos returns the position of the first bit set to bit from start to
+// end, which are byte indices, or bit indices if bitIdx is true. Negative
+// indices count from the end of the string. It returns -1 if there is no
+// such bit, except when looking for a 0 bit without an explicit end, in
+// which case the string is considered padded with 0 bits.
+func (s *stringval) BitPos(bit int, start, end int64, hasEnd, bitIdx bool) int64 {
+	if s.len() == 0 {
+		if bit == 1 {
+			return -1
+		}
+		return 0
+	}
+	start, end, ok := s.bitRange(start, end, bitIdx)
+	if !ok {
+		return -1
+	}
+
+	// Skip the whole bytes that do not have the bit
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for p := start; p <= end; {
+		if p%8 == 0 && p+7 <= end && s.at(p/8) == skip {
+			p += 8
+			continue
+		}
+		if s.GetBit(p) == bit {
+			return p
+		}
+		p++
+	}
+	if bit == 0 && !hasEnd {
+		return end + 1
+	}
+	return -1
+}
+
+// bitRange returns the range of bit indices from start to end, which are
+// byte indices, or bit indices if bit is true, and false if the range is
+// empty.
+func (s *stringval) bitRange(start, end int64, bit bool) (int64, int64, bool) {
+	n := s.len()
+	if bit {
+		n *= 8
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	if !bit {
+		start, end = start*8, end*8+7
+	}
+	return start, end, true
+}
+
+// GetBit returns the bit at offset ofs.
+func (s *stringval) GetBit(ofs int64) int {
+	if ofs/8 >= s.len() {
+		return 0
+	}
+	return int(s.at(ofs/8)>>uint(7-ofs%8)) & 1
+}
+
+// GetBits returns the integer stored in the width bits starting at offset
+// ofs, most significant bit first. If signed is true, it is a two's
+// complement signed integer.
+func (s *stringval) GetBits(ofs int64, width uint, signed bool) int64 {
+	var v uint64
+	for i := int64(0); i < int64(width); i++ {
+		v = v<<1 | uint64(s.GetBit(ofs+i))
+	}
+	if signed && width < 64 && v&(1<<(width-1)) != 0 {
+		v |= ^uint64(0) << width
+	}
+	return int64(v)
+}
+
+// SetBit sets the bit at offset ofs to bit, growing the string with 0
+// bytes if required. It returns the previous bit.
+func (s *stringval) SetBit(ofs int64, bit int) int {
+	old := s.GetBit(ofs)
+	b := s.grow(ofs/8 + 1)
+	if bit == 1 {
+		b[ofs/8] |= 0x80 >> uint(ofs%8)
+	} else {
+		b[ofs/8] &^= 0x80 >> uint(ofs%8)
+	}
+	return old
+}
+
+// SetBits stores the width least significant bits of v in the bits
+// starting at offset ofs, most significant bit first, growing the string
+// with 0 bytes if required.
+func (s *stringval) SetBits(ofs int64, width uint, v int64) {
+	b := s.grow((ofs+int64(width)-1)/8 + 1)
+	for i := int64(0); i < int64(width); i++ {
+		p := ofs + i
+		m := byte(0x80) >> uint(p%8)
+		if uint64(v)>>(width-1-uint(i))&1 == 1 {
+			b[p/8] |= m
+		} else {
+			b[p/8] &^= m
+		}
+	}
+}
+
+// grow pads the string with 0 bytes to at least n bytes, and returns its
+// bytes, which may be modified in place.
+func (s *stringval) grow(n int64) []byte {
+	b := s.bytes()
+	if pad := n - int64(len(b)); pad > 0 {
+		s.b = append(b, make([]byte, pad)...)
+	}
+	return s.b
 }
@@ -172,3 +172,147 @@ func TestStringStrLen(t *testing.T) {
 		}
 	}
 }
+
+func TestStringBitCount(t *testing.T) {
+	cases := []struct {
+		s          string
+		start, end int64
+		bit        bool
+		exp        int64
+	}{
+		0: {"", 0, -1, false, 0},
+		1: {"foobar", 0, -1, false, 26},
+		2: {"foobar", 0, 0, false, 4},
+		3: {"foobar", 1, 1, false, 6},
+		4: {"foobar", 5, 30, true, 17},
+		5: {"foobar", -2, -1, false, 7},
+		6: {"foobar", 3, 1, false, 0},
+		7: {"foobar", -100, 100, false, 26},
+		8: {"\xff", 1, 1, true, 1},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		if got := s.BitCount(c.start, c.end, c.bit); got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestStringBitPos(t *testing.T) {
+	cases := []struct {
+		s           string
+		bit         int
+		start, end  int64
+		hasEnd, idx bool
+		exp         int64
+	}{
+		0:  {"", 0, 0, -1, false, false, 0},
+		1:  {"", 1, 0, -1, false, false, -1},
+		2:  {"\xff\xf0\x00", 0, 0, -1, false, false, 12},
+		3:  {"\x00\xff\xf0", 1, 0, -1, false, false, 8},
+		4:  {"\x00\xff\xf0", 1, 2, -1, false, false, 16},
+		5:  {"\x00\xff\xf0", 1, 7, 15, true, true, 8},
+		6:  {"\x00\xff\xf0", 1, 7, -3, true, true, 8},
+		7:  {"\x00\x00\x00", 1, 0, -1, false, false, -1},
+		8:  {"\xff\xff\xff", 0, 0, -1, false, false, 24},
+		9:  {"\xff\xff\xff", 0, 0, -1, true, false, -1},
+		10: {"\xff\xff\xff", 0, 5, 10, false, false, -1},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		if got := s.BitPos(c.bit, c.start, c.end, c.hasEnd, c.idx); got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestStringBits(t *testing.T) {
+	s := NewString("")
+	if old := s.SetBit(7, 1); old != 0 {
+		t.Errorf("expected old bit 0, got %d", old)
+	}
+	if old := s.SetBit(14, 1); old != 0 {
+		t.Errorf("expected old bit 0, got %d", old)
+	}
+	if v := s.Get(); v != "\x01\x02" {
+		t.Errorf("expected %q, got %q", "\x01\x02", v)
+	}
+	if old := s.SetBit(7, 0); old != 1 {
+		t.Errorf("expected old bit 1, got %d", old)
+	}
+	if b := s.GetBit(14); b != 1 {
+		t.Errorf("expected bit 1, got %d", b)
+	}
+	if b := s.GetBit(100); b != 0 {
+		t.Errorf("expected bit 0, got %d", b)
+	}
+
+	cases := []struct {
+		s      string
+		ofs    int64
+		width  uint
+		signed bool
+		exp    int64
+	}{
+		0: {"\xff", 0, 4, false, 15},
+		1: {"\xff", 0, 4, true, -1},
+		2: {"\x0f\xf0", 4, 8, false, 255},
+		3: {"\x0f\xf0", 4, 8, true, -1},
+		4: {"\x7f", 0, 8, true, 127},
+		5: {"\x80", 0, 16, false, 0x8000},
+		6: {"\xff\xff\xff\xff\xff\xff\xff\xff", 0, 64, true, -1},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		if got := s.GetBits(c.ofs, c.width, c.signed); got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+		// Setting the value back does not change the string
+		s.SetBits(c.ofs, c.width, c.exp)
+		if got := s.Get(); got[:len(c.s)] != c.s {
+			t.Errorf("%d: expected %q, got %q", i, c.s, got)
+		}
+	}
+	s = NewString("")
+	s.SetBits(4, 8, -1)
+	if v := s.Get(); v != "\x0f\xf0" {
+		t.Errorf("expected %q, got %q", "\x0f\xf0", v)
+	}
+}
+
+func TestStringInPlace(t *testing.T) {
+	s := NewString("a")
+	before := s.Get()
+
+	// The bit-level and range setters modify the value in place, the
+	// values returned by Get are not modified.
+	s.SetBit(7, 0)
+	s.SetBits(8, 8, 'b')
+	s.SetRange(3, "d")
+	s.Append("e")
+	if before != "a" {
+		t.Errorf("expected %q to be kept, got %q", "a", before)
+	}
+	got := s.Get()
+	if exp := "`b\x00de"; got != exp {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+	s.SetBit(0, 1)
+	if exp := "`b\x00de"; got != exp {
+		t.Errorf("expected %q to be kept, got %q", exp, got)
+	}
+	if v := s.GetRange(0, 1); v != "\xe0b" {
+		t.Errorf("expected %q, got %q", "\xe0b", v)
+	}
+	if n := s.StrLen(); n != 5 {
+		t.Errorf("expected length 5, got %d", n)
+	}
+
+	s.Set("xyz")
+	if v := s.GetSet("w"); v != "xyz" {
+		t.Errorf("expected %q, got %q", "xyz", v)
+	}
+	if n := s.BitCount(0, -1, false); n != 6 {
+		t.Errorf("expected 6 bits set in %q, got %d", s.Get(), n)
+	}
+}